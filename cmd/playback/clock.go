@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// clock tracks how far playback has got through the recording, which passes at speed times real time unless it's paused.
+type clock struct {
+	mu      sync.Mutex
+	pos     time.Duration // Position in the recording at anchor
+	anchor  time.Time
+	speed   float64
+	paused  bool
+	changed chan struct{} // Closed and replaced whenever speed or paused changes
+}
+
+func newClock(speed float64) *clock {
+	return &clock{anchor: time.Now(), speed: speed, changed: make(chan struct{})}
+}
+
+// position returns how far playback has got. c.mu must be held.
+func (c *clock) position() time.Duration {
+	if c.paused {
+		return c.pos
+	}
+	return c.pos + time.Duration(float64(time.Since(c.anchor))*c.speed)
+}
+
+// change calls f, which changes speed or paused, keeping the position where it was.
+func (c *clock) change(f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pos, c.anchor = c.position(), time.Now()
+	f()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Seek moves playback to pos.
+func (c *clock) Seek(pos time.Duration) {
+	c.change(func() { c.pos = pos })
+}
+
+// TogglePause pauses playback, or resumes it, returning whether it's now paused.
+func (c *clock) TogglePause() bool {
+	var paused bool
+	c.change(func() {
+		c.paused = !c.paused
+		paused = c.paused
+	})
+	return paused
+}
+
+// Scale multiplies the speed by factor, returning the new speed.
+func (c *clock) Scale(factor float64) float64 {
+	var speed float64
+	c.change(func() {
+		c.speed *= factor
+		speed = c.speed
+	})
+	return speed
+}
+
+// Wait returns once playback reaches pos, or done is closed.
+func (c *clock) Wait(pos time.Duration, done <-chan struct{}) {
+	for {
+		c.mu.Lock()
+		now, speed, paused, changed := c.position(), c.speed, c.paused, c.changed
+		c.mu.Unlock()
+		if now >= pos {
+			return
+		}
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !paused {
+			timer = time.NewTimer(time.Duration(float64(pos-now) / speed))
+			timeout = timer.C
+		}
+		select {
+		case <-timeout:
+		case <-changed:
+		case <-done:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if isDone(done) {
+			return
+		}
+	}
+}
+
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/fbs"
+	"github.com/alltom/vncfreethumb/rfbclient"
+	"github.com/alltom/vncfreethumb/video"
+	"image"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// export renders the recording at path to a video at out, as fast as it can be decoded, showing each update when it arrived, sped up by speed. As with playback, the time that the recorded client spent in the handshake is skipped.
+func export(path, out string, pixelFormat rfb.PixelFormat, speed, fps float64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := fbs.NewReader(f)
+	if err != nil {
+		return err
+	}
+	w, err := video.Create(out, &video.Options{FPS: fps})
+	if err != nil {
+		return err
+	}
+
+	server, conn := net.Pipe()
+	go io.Copy(ioutil.Discard, server)
+	var (
+		mu      sync.Mutex
+		at      time.Duration // When the block being fed arrived, in the video
+		end     time.Duration
+		frames  int
+		written = make(chan error, 1)
+	)
+	handshook := make(chan struct{})
+	fed := make(chan error, 1)
+	go func() {
+		// net.Pipe's writes return once they've been read, so each update is decoded while the block that completes it is being fed.
+		fed <- func() error {
+			defer server.Close()
+			var start time.Duration
+			started := false
+			for {
+				block, err := r.ReadBlock()
+				if errors.Is(err, io.EOF) {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if isDone(handshook) {
+					if !started {
+						start, started = block.Timestamp, true
+					}
+					mu.Lock()
+					at = time.Duration(float64(block.Timestamp-start) / speed)
+					end = at
+					mu.Unlock()
+				}
+				if _, err := server.Write(block.Data); err != nil {
+					// The client stopped decoding, and Run says why.
+					return nil
+				}
+			}
+		}()
+	}()
+
+	var client *rfbclient.Client
+	client, err = rfbclient.NewClient(context.Background(), conn, &rfbclient.Options{
+		Password:    func() (string, error) { return "", nil },
+		Shared:      true,
+		PixelFormat: &pixelFormat,
+		OnUpdate: func([]image.Rectangle) {
+			mu.Lock()
+			frameAt := at
+			mu.Unlock()
+			if err := w.WriteFrame(client.Image(), frameAt); err != nil {
+				select {
+				case written <- err:
+				default:
+				}
+				client.Close()
+			}
+			frames++
+		},
+	})
+	close(handshook)
+	// A video that isn't finished isn't worth keeping.
+	fail := func(err error) error {
+		w.Close(0)
+		os.Remove(out)
+		return err
+	}
+	if err != nil {
+		conn.Close()
+		if ferr := <-fed; ferr != nil {
+			return fail(fmt.Errorf("read recording: %w", ferr))
+		}
+		return fail(fmt.Errorf("decode handshake: %w", err))
+	}
+	runErr := client.Run(context.Background())
+	client.Close()
+	if ferr := <-fed; ferr != nil {
+		return fail(fmt.Errorf("read recording: %w", ferr))
+	}
+	select {
+	case err := <-written:
+		return fail(fmt.Errorf("write video: %w", err))
+	default:
+	}
+	if runErr != nil && !errors.Is(runErr, io.EOF) && !errors.Is(runErr, io.ErrClosedPipe) {
+		return fail(fmt.Errorf("decode recording: %w", runErr))
+	}
+	if frames == 0 {
+		return fail(errors.New("recording has no updates"))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return w.Close(end)
+}
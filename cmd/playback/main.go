@@ -0,0 +1,105 @@
+// Command playback serves an FBS recording, such as one made with the server's -record flag, to any number of viewers, with its original timing. Viewers control playback with the keyboard: space pauses and resumes, + and - double and halve the speed, and Home starts again from the beginning.
+//
+// With -export, the recording is instead rendered to a video, such as for sharing a review session, as fast as it can be decoded.
+//
+// The recording is decoded and encoded afresh for each viewer, so viewers may ask for any pixel format and encoding. Since FBS files only hold what the server sent, the recorded client is assumed to have asked for -pixel_format, and to have chosen the security type that package rfbclient would.
+package main
+
+import (
+	"flag"
+	"github.com/alltom/vncfreethumb/extension"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/vncserver"
+	"log"
+	"net"
+)
+
+var (
+	addr        = flag.String("addr", "127.0.0.1:5900", "Address to listen for connections on.")
+	speed       = flag.Float64("speed", 1, "How many times faster than real time to play the recording, to begin with.")
+	loop        = flag.Bool("loop", false, "If true, plays the recording again from the beginning whenever it ends.")
+	pixelFormat = flag.String("pixel_format", "rgba8888", "Pixel format that the recorded client asked for: rgba8888, rgb565, or bgr233.")
+	fps         = flag.Float64("max_fps", 30, "Most updates per second to send each viewer. If 0, updates are sent as fast as viewers ask for them.")
+	exportPath  = flag.String("export", "", "If set, renders the recording to this file instead of serving it: as animated PNG if it ends in .png or .apng, or with ffmpeg, as for .mp4, otherwise. -speed applies.")
+	exportFPS   = flag.Float64("export_fps", 10, "With -export, the frame rate of videos written with ffmpeg. Animated PNG shows each update for exactly as long as it was shown.")
+)
+
+var pixelFormats = map[string]rfb.PixelFormat{
+	// Little- and big-endian RGBA8888 have the same bytes in memory, so either decodes recordings of both.
+	"rgba8888": rfb.PixelFormatRGBA8888LittleEndian,
+	"rgb565":   rfb.PixelFormatRGB565,
+	"bgr233":   rfb.PixelFormatBGR233,
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatalf("expected one arg, the FBS file to play, but got %d", flag.NArg())
+	}
+	if *speed <= 0 {
+		log.Fatalf("-speed must be positive, but was %v", *speed)
+	}
+	pf, ok := pixelFormats[*pixelFormat]
+	if !ok {
+		log.Fatalf("-pixel_format must be rgba8888, rgb565, or bgr233, but was %q", *pixelFormat)
+	}
+
+	if *exportPath != "" {
+		if *exportFPS <= 0 {
+			log.Fatalf("-export_fps must be positive, but was %v", *exportFPS)
+		}
+		if err := export(flag.Arg(0), *exportPath, pf, *speed, *exportFPS); err != nil {
+			log.Fatalf("couldn't export recording: %v", err)
+		}
+		log.Printf("wrote %s", *exportPath)
+		return
+	}
+
+	p := newPlayer(flag.Arg(0), pf, *speed)
+	// Decode the handshake before serving, so that the desktop has a size and a name.
+	pass, err := p.open()
+	if err != nil {
+		log.Fatalf("couldn't open recording: %v", err)
+	}
+	p.frame, p.pass = pass.client.Image(), pass
+	p.srv = vncserver.NewServer(p, &vncserver.Options{
+		Name: pass.client.Name(),
+		Encoders: map[uint32]func() extension.Encoder{
+			rfb.EncodingTypeTight:   func() extension.Encoder { return &tightEncoder{} },
+			rfb.EncodingTypeHextile: func() extension.Encoder { return hextileEncoder{} },
+		},
+		MaxFPS: *fps,
+		// Viewers only control playback, which they all share.
+		SharePolicy: vncserver.AlwaysShare,
+	})
+	go p.play(pass, *loop)
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("couldn't listen: %v", err)
+	}
+	log.Printf("playing %s on %s", flag.Arg(0), ln.Addr())
+	if err := p.srv.Serve(ln); err != nil {
+		log.Fatalf("couldn't serve: %v", err)
+	}
+}
+
+type tightEncoder struct{ rfb.TightEncoder }
+
+func (*tightEncoder) EncodingType() uint32 {
+	return rfb.EncodingTypeTight
+}
+
+type hextileEncoder struct{}
+
+func (hextileEncoder) EncodingType() uint32 {
+	return rfb.EncodingTypeHextile
+}
+
+func (hextileEncoder) Encode(img *rfb.PixelFormatImage) ([]*rfb.FramebufferUpdateRect, error) {
+	r := img.Bounds()
+	return []*rfb.FramebufferUpdateRect{{
+		X: uint16(r.Min.X), Y: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+		EncodingType: rfb.EncodingTypeHextile, PixelData: rfb.EncodeHextile(img),
+	}}, nil
+}
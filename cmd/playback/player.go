@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/fbs"
+	"github.com/alltom/vncfreethumb/rfb/keysym"
+	"github.com/alltom/vncfreethumb/rfbclient"
+	"github.com/alltom/vncfreethumb/vncserver"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+)
+
+// player is a vncserver.Desktop that shows the recording as it plays.
+type player struct {
+	path        string
+	pixelFormat rfb.PixelFormat
+	clock       *clock
+	srv         *vncserver.Server
+	restart     chan struct{} // Sent to when a viewer presses Home
+
+	// Changed with srv.Update, once srv is set.
+	frame  *image.RGBA
+	damage []image.Rectangle
+	cursor *vncserver.Cursor
+	pass   *pass
+}
+
+func newPlayer(path string, pixelFormat rfb.PixelFormat, speed float64) *player {
+	return &player{path: path, pixelFormat: pixelFormat, clock: newClock(speed), restart: make(chan struct{}, 1)}
+}
+
+// pass is one time through the recording, which is fed to client as if it came from a server.
+type pass struct {
+	client *rfbclient.Client
+	done   chan struct{} // Closed to stop feeding
+	fed    chan error    // Receives why feeding stopped, or nil at the end of the recording
+}
+
+// open starts a pass through the recording, returning once client has decoded the handshake.
+func (p *player) open() (*pass, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := fbs.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	server, conn := net.Pipe()
+	// The recording already holds the server's answers to whatever the client sends.
+	go io.Copy(ioutil.Discard, server)
+	pass := &pass{done: make(chan struct{}), fed: make(chan error, 1)}
+	handshook := make(chan struct{})
+	go func() {
+		err := p.feed(r, server, handshook, pass.done)
+		server.Close()
+		f.Close()
+		pass.fed <- err
+	}()
+
+	pass.client, err = rfbclient.NewClient(context.Background(), conn, &rfbclient.Options{
+		// The recording has the server's verdict, whatever the password.
+		Password:    func() (string, error) { return "", nil },
+		Shared:      true,
+		PixelFormat: &p.pixelFormat,
+		OnUpdate: func(rects []image.Rectangle) {
+			img := pass.client.Image()
+			p.srv.Update(func() {
+				if p.frame.Rect != img.Rect {
+					rects = []image.Rectangle{img.Rect}
+				}
+				p.frame = img
+				p.damage = append(p.damage, rects...)
+			})
+		},
+		OnCursor: func(img *image.NRGBA, hotspot image.Point) {
+			p.srv.Update(func() { p.cursor = &vncserver.Cursor{Image: img, Hotspot: hotspot} })
+		},
+	})
+	close(handshook)
+	if err != nil {
+		conn.Close()
+		close(pass.done)
+		if ferr := <-pass.fed; ferr != nil {
+			return nil, ferr
+		}
+		return nil, fmt.Errorf("decode handshake: %w", err)
+	}
+	return pass, nil
+}
+
+// feed writes the recording to w with its original timing, except that the handshake is written at once and the time that the recorded client spent in it, such as at a password prompt, is skipped.
+func (p *player) feed(r *fbs.Reader, w io.Writer, handshook, done <-chan struct{}) error {
+	started := false
+	for {
+		block, err := r.ReadBlock()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if isDone(handshook) {
+			if !started {
+				p.clock.Seek(block.Timestamp)
+				started = true
+			}
+			p.clock.Wait(block.Timestamp, done)
+		}
+		if isDone(done) {
+			return nil
+		}
+		if _, err := w.Write(block.Data); err != nil {
+			// The client stopped decoding, and Run says why.
+			return nil
+		}
+	}
+}
+
+// play decodes pass, then passes after it, as long as the recording loops or viewers restart it.
+func (p *player) play(pass *pass, loop bool) {
+	for {
+		p.srv.Update(func() { p.pass = pass })
+		err := pass.client.Run(context.Background())
+		pass.client.Close()
+		close(pass.done)
+		if ferr := <-pass.fed; ferr != nil {
+			log.Printf("couldn't read recording: %v", ferr)
+		} else if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+			log.Printf("couldn't decode recording: %v", err)
+		}
+
+		select {
+		case <-p.restart:
+		default:
+			if !loop {
+				log.Print("recording ended; press Home to play it again")
+				<-p.restart
+			}
+		}
+		for {
+			var err error
+			if pass, err = p.open(); err == nil {
+				break
+			}
+			log.Printf("couldn't open recording: %v; press Home to try again", err)
+			<-p.restart
+		}
+	}
+}
+
+func (p *player) Size() (width, height int) {
+	return p.frame.Rect.Dx(), p.frame.Rect.Dy()
+}
+
+func (p *player) Render(r image.Rectangle) image.Image {
+	return p.frame
+}
+
+func (p *player) HandleKey(event rfb.KeyEventMessage) {
+	if !event.Pressed {
+		return
+	}
+	switch event.KeySym {
+	case keysym.Space:
+		if p.clock.TogglePause() {
+			log.Print("paused")
+		} else {
+			log.Print("resumed")
+		}
+	case keysym.Plus, keysym.Equal, keysym.KPAdd:
+		log.Printf("playing at %gx", p.clock.Scale(2))
+	case keysym.Minus, keysym.KPSubtract:
+		log.Printf("playing at %gx", p.clock.Scale(0.5))
+	case keysym.Home:
+		select {
+		case p.restart <- struct{}{}:
+		default:
+		}
+		p.pass.client.Close()
+	}
+}
+
+// HandlePointer does nothing, since the recording can't respond.
+func (p *player) HandlePointer(event rfb.PointerEventMessage) {}
+
+// HandleCutText does nothing, since the recording can't respond.
+func (p *player) HandleCutText(text string) {}
+
+func (p *player) Damage() []image.Rectangle {
+	damage := p.damage
+	p.damage = nil
+	return damage
+}
+
+func (p *player) Cursor() *vncserver.Cursor {
+	return p.cursor
+}
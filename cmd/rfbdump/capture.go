@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Link types, from https://www.tcpdump.org/linktypes.html.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+// frame is a captured link-layer frame.
+type frame struct {
+	time     time.Time
+	linkType uint32
+	data     []byte
+}
+
+// readCapture reads every frame of a pcap or pcapng file.
+func readCapture(r io.Reader) ([]frame, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("read magic number: %w", err)
+	}
+	switch binary.BigEndian.Uint32(magic) {
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		return readPcap(br)
+	case 0x0a0d0d0a:
+		return readPcapng(br)
+	}
+	return nil, fmt.Errorf("not a pcap or pcapng file: starts with %x", magic)
+}
+
+func readPcap(r io.Reader) ([]frame, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	var bo binary.ByteOrder = binary.LittleEndian
+	if header[0] == 0xa1 {
+		bo = binary.BigEndian
+	}
+	// The fraction of each timestamp is in nanoseconds, rather than microseconds, after this magic number.
+	nanos := bo.Uint32(header[0:]) == 0xa1b23c4d
+	linkType := bo.Uint32(header[20:]) & 0xffff
+
+	var frames []frame
+	for {
+		var record [16]byte
+		if _, err := io.ReadFull(r, record[:]); errors.Is(err, io.EOF) {
+			return frames, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read record header: %w", err)
+		}
+		sec, frac, length := bo.Uint32(record[0:]), bo.Uint32(record[4:]), bo.Uint32(record[8:])
+		if length > maxFrameLength {
+			return nil, fmt.Errorf("record of %d bytes is too long", length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("read record: %w", err)
+		}
+		if !nanos {
+			frac *= 1000
+		}
+		frames = append(frames, frame{time: time.Unix(int64(sec), int64(frac)), linkType: linkType, data: data})
+	}
+}
+
+// maxFrameLength bounds each frame, and each pcapng block.
+const maxFrameLength = 16 << 20
+
+// pcapngInterface is what frames need from an Interface Description Block.
+type pcapngInterface struct {
+	linkType uint32
+	perSec   uint64 // Ticks of timestamps per second
+}
+
+func readPcapng(r io.Reader) ([]frame, error) {
+	var bo binary.ByteOrder = binary.LittleEndian
+	var interfaces []pcapngInterface
+	var frames []frame
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); errors.Is(err, io.EOF) {
+			return frames, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read block header: %w", err)
+		}
+		blockType := bo.Uint32(header[0:])
+		if binary.BigEndian.Uint32(header[0:]) == 0x0a0d0d0a {
+			// A new section, which sets the byte order with its magic number, and starts over with interfaces.
+			var magic [4]byte
+			if _, err := io.ReadFull(r, magic[:]); err != nil {
+				return nil, fmt.Errorf("read section header: %w", err)
+			}
+			bo = binary.LittleEndian
+			if magic[0] == 0x1a {
+				bo = binary.BigEndian
+			}
+			blockType = 0x0a0d0d0a
+			interfaces = nil
+		}
+		length := bo.Uint32(header[4:])
+		bodyLength := int(length) - 12
+		if blockType == 0x0a0d0d0a {
+			bodyLength -= 4
+		}
+		if length%4 != 0 || bodyLength < 0 || length > maxFrameLength {
+			return nil, fmt.Errorf("block of %d bytes is malformed", length)
+		}
+		body := make([]byte, bodyLength+4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, fmt.Errorf("read block: %w", err)
+		}
+		body = body[:bodyLength]
+
+		switch blockType {
+		case 1: // Interface Description Block
+			if len(body) < 8 {
+				return nil, errors.New("interface description block is too short")
+			}
+			iface := pcapngInterface{linkType: uint32(bo.Uint16(body[0:])), perSec: 1e6}
+			if resolution, ok := pcapngOption(body[8:], bo, 9); ok && len(resolution) > 0 {
+				iface.perSec = tsresol(resolution[0])
+			}
+			interfaces = append(interfaces, iface)
+		case 6: // Enhanced Packet Block
+			if len(body) < 20 {
+				return nil, errors.New("enhanced packet block is too short")
+			}
+			id, captured := bo.Uint32(body[0:]), bo.Uint32(body[12:])
+			if int(id) >= len(interfaces) {
+				return nil, fmt.Errorf("packet on undescribed interface %d", id)
+			}
+			if int(captured) > len(body)-20 {
+				return nil, errors.New("enhanced packet block is shorter than its packet")
+			}
+			iface := interfaces[id]
+			ticks := uint64(bo.Uint32(body[4:]))<<32 | uint64(bo.Uint32(body[8:]))
+			frames = append(frames, frame{time: iface.time(ticks), linkType: iface.linkType, data: body[20 : 20+captured]})
+		case 3: // Simple Packet Block, which has no timestamp
+			if len(interfaces) == 0 || len(body) < 4 {
+				return nil, errors.New("simple packet block is malformed")
+			}
+			captured := len(body) - 4
+			if original := int(bo.Uint32(body[0:])); original < captured {
+				captured = original
+			}
+			frames = append(frames, frame{linkType: interfaces[0].linkType, data: body[4 : 4+captured]})
+		}
+	}
+}
+
+// pcapngOption returns the value of the first option with code in options.
+func pcapngOption(options []byte, bo binary.ByteOrder, code uint16) ([]byte, bool) {
+	for len(options) >= 4 {
+		c, length := bo.Uint16(options[0:]), int(bo.Uint16(options[2:]))
+		if c == 0 || 4+length > len(options) {
+			break
+		}
+		if c == code {
+			return options[4 : 4+length], true
+		}
+		// Values are padded to 32 bits.
+		next := 4 + (length+3)&^3
+		if next > len(options) {
+			break
+		}
+		options = options[next:]
+	}
+	return nil, false
+}
+
+// tsresol interprets an if_tsresol option, returning ticks per second: a power of 10, or of 2 if the top bit is set.
+func tsresol(resolution byte) uint64 {
+	base := uint64(10)
+	if resolution&0x80 != 0 {
+		base = 2
+	}
+	perSec := uint64(1)
+	for i := 0; i < int(resolution&0x7f) && perSec < 1<<60; i++ {
+		perSec *= base
+	}
+	return perSec
+}
+
+func (iface pcapngInterface) time(ticks uint64) time.Time {
+	sec, rem := ticks/iface.perSec, ticks%iface.perSec
+	if iface.perSec > 1e9 {
+		return time.Unix(int64(sec), int64(rem/(iface.perSec/1e9)))
+	}
+	return time.Unix(int64(sec), int64(rem*1e9/iface.perSec))
+}
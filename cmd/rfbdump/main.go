@@ -0,0 +1,132 @@
+// Command rfbdump prints the messages of RFB sessions in a packet capture, decoded, for diagnosing failures that were reported with nothing more. It reads pcap and pcapng files, as written by tcpdump and Wireshark, reassembles each TCP connection that starts with an RFB handshake, and prints what each side sent, in the order that the capture saw it, stamped with how long into the connection it arrived. Messages are decoded after SecurityTypeNone and SecurityTypeVNC, as with the server's -trace flag.
+//
+// With -raw, it instead reads two files that hold the bytes that the server and the client sent, such as those that Wireshark's Follow TCP Stream saves. Their interleaving is lost, so every client message is printed before the server's that follow the handshake, and each update is decoded with the last pixel format that the client asked for.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/alltom/vncfreethumb/rfb"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	port = flag.Int("port", 0, "If set, only connections with a side on this TCP port are decoded, and that side is the server. Otherwise, the side that opened each connection is the client.")
+	raw  = flag.Bool("raw", false, "If true, the args are two files that hold the bytes that the server and the client sent, rather than a capture.")
+)
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+	if *raw {
+		if flag.NArg() != 2 {
+			log.Fatalf("expected two args with -raw, the server's bytes and the client's, but got %d", flag.NArg())
+		}
+		server, err := ioutil.ReadFile(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("couldn't read server's bytes: %v", err)
+		}
+		client, err := ioutil.ReadFile(flag.Arg(1))
+		if err != nil {
+			log.Fatalf("couldn't read client's bytes: %v", err)
+		}
+		// Servers speak first, and that's how the tracer tells which side is which.
+		start := time.Unix(0, 0)
+		decode([]event{{time: start, fromServer: true, data: server}, {time: start, data: client}}, start, log.New(os.Stdout, "", 0))
+		return
+	}
+
+	if flag.NArg() != 1 {
+		log.Fatalf("expected one arg, the capture to decode, or - for standard input, but got %d", flag.NArg())
+	}
+	if *port < 0 || *port > 0xffff {
+		log.Fatalf("-port must be a TCP port, but was %d", *port)
+	}
+	var r io.Reader = os.Stdin
+	if flag.Arg(0) != "-" {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("couldn't open capture: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	frames, err := readCapture(r)
+	if err != nil {
+		log.Fatalf("couldn't read capture: %v", err)
+	}
+	var segments []*segment
+	for _, f := range frames {
+		if seg, ok := parseFrame(f); ok {
+			segments = append(segments, seg)
+		}
+	}
+
+	found := 0
+	for _, c := range reassemble(segments, uint16(*port)) {
+		// The tracer has to start at the beginning of a session.
+		if len(c.events) == 0 || !c.events[0].fromServer || !bytes.HasPrefix(c.events[0].data, []byte("RFB ")) {
+			continue
+		}
+		found++
+		logger := log.New(os.Stdout, c.client+" ", 0)
+		logger.Printf("connected to %s at %s", c.server, c.start.Format("2006-01-02 15:04:05.000000 MST"))
+		decode(c.events, c.start, logger)
+		for _, side := range []string{"server", "client"} {
+			if gap := c.gap(side); gap != "" {
+				logger.Print(gap)
+			}
+		}
+		if c.end != "" {
+			logger.Print(c.end)
+		}
+	}
+	if found == 0 {
+		log.Fatalf("found no RFB sessions that start in %s; the capture must include each session's handshake", flag.Arg(0))
+	}
+}
+
+// decode logs the messages in events, stamping them with how long after start they arrived.
+func decode(events []event, start time.Time, logger *log.Logger) {
+	// The tracer sees the session as a client would, reading what the server sent.
+	r := &replay{}
+	tracer := rfb.Trace(r, logger)
+	now := start.UnixNano()
+	tracer.SetClock(func() time.Time { return time.Unix(0, atomic.LoadInt64(&now)) })
+	for _, e := range events {
+		atomic.StoreInt64(&now, e.time.UnixNano())
+		if e.fromServer {
+			r.next = e.data
+			tracer.Read(make([]byte, len(e.data)))
+		} else {
+			tracer.Write(e.data)
+		}
+	}
+	// Close waits for the last messages to be logged.
+	if err := tracer.Close(); err != nil {
+		logger.Printf("couldn't finish decoding: %v", err)
+	}
+}
+
+// replay is the connection that decode traces. Reads return the data that the server sent next, and writes are discarded.
+type replay struct {
+	next []byte
+}
+
+func (r *replay) Read(p []byte) (int, error) {
+	if len(r.next) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.next)
+	r.next = r.next[n:]
+	return n, nil
+}
+
+func (r *replay) Write(p []byte) (int, error) {
+	return len(p), nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// segment is a TCP segment from a frame.
+type segment struct {
+	time               time.Time
+	src, dst           string // Endpoints, as host:port
+	srcPort, dstPort   uint16
+	seq                uint32
+	syn, ack, fin, rst bool
+	payload            []byte
+}
+
+// parseFrame returns the TCP segment in f, or ok=false if it doesn't hold a whole one.
+func parseFrame(f frame) (seg *segment, ok bool) {
+	b := f.data
+	switch f.linkType {
+	case linkTypeNull, linkTypeLoop:
+		// The address family is 4 bytes in either byte order; the IP version tells what follows.
+		if len(b) < 4 {
+			return nil, false
+		}
+		b = b[4:]
+	case linkTypeEthernet:
+		if len(b) < 14 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(b[12:])
+		b = b[14:]
+		// VLAN tags.
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(b) >= 4 {
+			etherType = binary.BigEndian.Uint16(b[2:])
+			b = b[4:]
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return nil, false
+		}
+	case linkTypeLinuxSLL:
+		if len(b) < 16 {
+			return nil, false
+		}
+		b = b[16:]
+	case linkTypeSLL2:
+		if len(b) < 20 {
+			return nil, false
+		}
+		b = b[20:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+	default:
+		return nil, false
+	}
+
+	var src, dst net.IP
+	if len(b) < 1 {
+		return nil, false
+	}
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return nil, false
+		}
+		headerLength, totalLength := int(b[0]&0xf)*4, int(binary.BigEndian.Uint16(b[2:]))
+		// Fragments can't be told apart from segments without reassembling them, which sessions on the same network rarely need.
+		fragmented := binary.BigEndian.Uint16(b[6:])&0x3fff != 0
+		if b[9] != 6 || fragmented || headerLength < 20 || totalLength < headerLength || totalLength > len(b) {
+			return nil, false
+		}
+		src, dst = net.IP(b[12:16]), net.IP(b[16:20])
+		// Ethernet pads short frames past the end of the packet.
+		b = b[headerLength:totalLength]
+	case 6:
+		if len(b) < 40 {
+			return nil, false
+		}
+		next, payloadLength := b[6], int(binary.BigEndian.Uint16(b[4:]))
+		if 40+payloadLength > len(b) {
+			return nil, false
+		}
+		src, dst = net.IP(b[8:24]), net.IP(b[24:40])
+		b = b[40 : 40+payloadLength]
+		// Skip hop-by-hop, routing, and destination options headers.
+		for next == 0 || next == 43 || next == 60 {
+			if len(b) < 8 || len(b) < (int(b[1])+1)*8 {
+				return nil, false
+			}
+			next, b = b[0], b[(int(b[1])+1)*8:]
+		}
+		if next != 6 {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	if len(b) < 20 {
+		return nil, false
+	}
+	headerLength := int(b[12]>>4) * 4
+	if headerLength < 20 || headerLength > len(b) {
+		return nil, false
+	}
+	flags := b[13]
+	seg = &segment{
+		time:    f.time,
+		srcPort: binary.BigEndian.Uint16(b[0:]),
+		dstPort: binary.BigEndian.Uint16(b[2:]),
+		seq:     binary.BigEndian.Uint32(b[4:]),
+		fin:     flags&0x01 != 0,
+		syn:     flags&0x02 != 0,
+		rst:     flags&0x04 != 0,
+		ack:     flags&0x10 != 0,
+		payload: b[headerLength:],
+	}
+	seg.src = net.JoinHostPort(src.String(), strconv.Itoa(int(seg.srcPort)))
+	seg.dst = net.JoinHostPort(dst.String(), strconv.Itoa(int(seg.dstPort)))
+	return seg, true
+}
+
+// event is data that one side of a conversation sent, in the order that it can be read.
+type event struct {
+	time       time.Time
+	fromServer bool
+	data       []byte
+}
+
+// conversation is a TCP connection, reassembled.
+type conversation struct {
+	client, server string
+	start          time.Time
+	events         []event
+	streams        map[string]*stream // By the endpoint that sent them
+	end            string             // How the connection ended, if it did
+}
+
+// stream reassembles the data that one side of a conversation sent.
+type stream struct {
+	started bool
+	next    uint32 // Sequence number of the next byte to read
+	read    int    // Bytes read so far
+	pending []*segment
+	fin     bool
+	end     uint32 // Sequence number after the last byte, once fin
+}
+
+// reassemble sorts segments into conversations, in the order that they started. If port isn't 0, only conversations with a side on it are kept, and that side is the server's; otherwise, the side that opened the connection is the client, or if that wasn't captured, the one that sent data second.
+func reassemble(segments []*segment, port uint16) []*conversation {
+	var conversations []*conversation
+	byKey := map[string]*conversation{}
+	for _, seg := range segments {
+		if port != 0 && seg.srcPort != port && seg.dstPort != port {
+			continue
+		}
+		key := seg.src + " " + seg.dst
+		if seg.dst < seg.src {
+			key = seg.dst + " " + seg.src
+		}
+		c := byKey[key]
+		// A connection opened again with the same ports is a new conversation.
+		if c == nil || seg.syn && !seg.ack && c.end != "" {
+			c = &conversation{start: seg.time, streams: map[string]*stream{}}
+			byKey[key] = c
+			conversations = append(conversations, c)
+		}
+		if c.server == "" {
+			switch {
+			case port != 0:
+				c.client, c.server = seg.src, seg.dst
+				if seg.srcPort == port {
+					c.client, c.server = seg.dst, seg.src
+				}
+			case seg.syn:
+				c.client, c.server = seg.src, seg.dst
+				if seg.ack {
+					c.client, c.server = seg.dst, seg.src
+				}
+			case len(seg.payload) > 0:
+				// Servers speak first.
+				c.client, c.server = seg.dst, seg.src
+			default:
+				continue
+			}
+		}
+		c.add(seg)
+	}
+	return conversations
+}
+
+func (c *conversation) add(seg *segment) {
+	s := c.streams[seg.src]
+	if s == nil {
+		s = &stream{}
+		c.streams[seg.src] = s
+	}
+	if seg.rst || seg.fin {
+		if c.end == "" {
+			sender := "client"
+			if seg.src == c.server {
+				sender = "server"
+			}
+			verb := "closed"
+			if seg.rst {
+				verb = "reset"
+			}
+			c.end = fmt.Sprintf("%s %s the connection at +%s", sender, verb, seg.time.Sub(c.start).Round(time.Millisecond))
+		}
+	}
+	if seg.fin {
+		s.fin, s.end = true, seg.seq+uint32(len(seg.payload))
+	}
+	if seg.syn {
+		s.started, s.next = true, seg.seq+1
+		return
+	}
+	if len(seg.payload) == 0 {
+		return
+	}
+	if !s.started {
+		s.started, s.next = true, seg.seq
+	}
+	s.pending = append(s.pending, seg)
+	// Read whatever's next, as long as something is, which may fill the gap before segments that arrived out of order.
+	for progress := true; progress; {
+		progress = false
+		remaining := s.pending[:0]
+		for _, p := range s.pending {
+			ahead := int32(p.seq - s.next)
+			switch {
+			case ahead > 0:
+				remaining = append(remaining, p)
+			case int(-ahead) < len(p.payload):
+				// Retransmissions may overlap what was already read.
+				data := p.payload[-ahead:]
+				c.events = append(c.events, event{time: seg.time, fromServer: seg.src == c.server, data: data})
+				s.next += uint32(len(data))
+				s.read += len(data)
+				progress = true
+			}
+		}
+		s.pending = remaining
+	}
+}
+
+// gap describes the data missing from the capture of side's stream, if any is, after which it can't be decoded.
+func (c *conversation) gap(side string) string {
+	addr := c.client
+	if side == "server" {
+		addr = c.server
+	}
+	s := c.streams[addr]
+	if s == nil {
+		return ""
+	}
+	// Sent data is missing if it's followed by data that was captured, or by the end of the stream.
+	var missing int32
+	if s.fin {
+		missing = int32(s.end - s.next)
+	}
+	for _, p := range s.pending {
+		if m := int32(p.seq - s.next); missing <= 0 || m < missing {
+			missing = m
+		}
+	}
+	if missing <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("the capture is missing %d bytes that the %s sent after its first %d, so the rest wasn't decoded", missing, side, s.read)
+}
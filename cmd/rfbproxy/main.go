@@ -0,0 +1,120 @@
+// Command rfbproxy sits between VNC viewers and a server, logging every message that either side sends, decoded, for debugging interoperability. Point a viewer at -addr and it's connected to -server.
+//
+// By default, the proxy passes bytes through untouched, so any security type works, though messages are only decoded after SecurityTypeNone and SecurityTypeVNC. With -pixel_format, the proxy also asks the server for that pixel format, whatever the viewer asks for, and converts each update to the viewer's format, so that either side's handling of pixel formats can be tried with any peer. To do that it runs the handshake with each side itself: viewers connect without authentication, and the proxy authenticates to the server with -password.
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/alltom/vncfreethumb/rfb"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	addr         = flag.String("addr", "127.0.0.1:5901", "Address to listen for viewers on.")
+	serverAddr   = flag.String("server", "127.0.0.1:5900", "Address of the VNC server to connect viewers to.")
+	pixelFormat  = flag.String("pixel_format", "", "If set, asks the server for this pixel format, converting updates to the viewer's: rgba8888le, rgba8888be, rgb565, or bgr233.")
+	password     = flag.String("password", "", "With -pixel_format, the password to authenticate to the server with, if it requires VNC authentication.")
+	passwordFile = flag.String("password_file", "", "If set, reads -password from the first line of this file instead.")
+)
+
+var pixelFormats = map[string]rfb.PixelFormat{
+	"rgba8888le": rfb.PixelFormatRGBA8888LittleEndian,
+	"rgba8888be": rfb.PixelFormatRGBA8888BigEndian,
+	"rgb565":     rfb.PixelFormatRGB565,
+	"bgr233":     rfb.PixelFormatBGR233,
+}
+
+// dialTimeout is how long to wait for the server to answer each connection.
+const dialTimeout = 30 * time.Second
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		log.Fatalf("expected no args, but got %d", flag.NArg())
+	}
+	var rewriteFormat *rfb.PixelFormat
+	if *pixelFormat != "" {
+		pf, ok := pixelFormats[*pixelFormat]
+		if !ok {
+			log.Fatalf("-pixel_format must be rgba8888le, rgba8888be, rgb565, or bgr233, but was %q", *pixelFormat)
+		}
+		rewriteFormat = &pf
+	}
+	if *passwordFile != "" {
+		if *password != "" {
+			log.Fatal("-password and -password_file are mutually exclusive")
+		}
+		contents, err := ioutil.ReadFile(*passwordFile)
+		if err != nil {
+			log.Fatalf("couldn't read password file: %v", err)
+		}
+		*password = strings.TrimRight(strings.SplitN(string(contents), "\n", 2)[0], "\r")
+	}
+	if *password != "" && rewriteFormat == nil {
+		log.Fatal("-password only applies with -pixel_format, since otherwise the viewer authenticates")
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("couldn't listen: %v", err)
+	}
+	log.Printf("proxying %s to %s", ln.Addr(), *serverAddr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatalf("couldn't accept connection: %v", err)
+		}
+		go proxy(conn, rewriteFormat)
+	}
+}
+
+// proxy connects viewer to the server and forwards messages between them until either disconnects.
+func proxy(viewer net.Conn, rewriteFormat *rfb.PixelFormat) {
+	defer viewer.Close()
+	remote := viewer.RemoteAddr().String()
+	logger := log.New(log.Writer(), remote+" ", log.Flags())
+	server, err := net.DialTimeout("tcp", *serverAddr, dialTimeout)
+	if err != nil {
+		logger.Printf("couldn't connect to server: %v", err)
+		return
+	}
+	defer server.Close()
+	logger.Printf("connected to %s", server.RemoteAddr())
+
+	if rewriteFormat != nil {
+		// Messages differ on each side, so log both.
+		viewer = rfb.TraceConn(viewer, log.New(log.Writer(), remote+" viewer side: ", log.Flags()))
+		server = rfb.TraceConn(server, log.New(log.Writer(), remote+" server side: ", log.Flags()))
+		err = rewrite(context.Background(), viewer, server, *rewriteFormat, *password)
+	} else {
+		err = forward(rfb.TraceConn(viewer, logger), server)
+	}
+	if err != nil {
+		logger.Printf("disconnected: %v", err)
+	} else {
+		logger.Print("disconnected")
+	}
+}
+
+// forward copies bytes in both directions until either side ends, returning why, or nil if it was closed normally.
+func forward(viewer, server net.Conn) error {
+	errs := make(chan error, 2)
+	pipe := func(dst, src net.Conn) {
+		_, err := io.Copy(dst, src)
+		errs <- err
+	}
+	go pipe(server, viewer)
+	go pipe(viewer, server)
+	err := <-errs
+	// Unblock the other direction.
+	viewer.Close()
+	server.Close()
+	<-errs
+	return err
+}
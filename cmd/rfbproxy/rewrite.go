@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"image"
+	"io"
+	"net"
+	"sync"
+)
+
+// readable are the encoding types whose rectangles rewriters can read, and so may pass on from viewers' SetEncodings.
+var readable = map[uint32]bool{
+	rfb.EncodingTypeRaw:                 true,
+	rfb.EncodingTypeCopyRectangle:       true,
+	rfb.EncodingTypeRRE:                 true,
+	rfb.EncodingTypeCoRRE:               true,
+	rfb.EncodingTypeHextile:             true,
+	rfb.EncodingTypeTight:               true,
+	rfb.EncodingTypeCursor:              true,
+	rfb.EncodingTypeXCursor:             true,
+	rfb.EncodingTypeExtendedDesktopSize: true,
+	rfb.EncodingTypeContinuousUpdates:   true,
+	rfb.EncodingTypeFence:               true,
+	rfb.EncodingTypeXVP:                 true,
+	rfb.EncodingTypeExtendedClipboard:   true,
+}
+
+// rewriter forwards messages between a viewer and a server that it finished the handshake with, converting updates from the pixel format that it asked the server for to the one that the viewer asked for.
+type rewriter struct {
+	viewer, server net.Conn
+	serverFormat   rfb.PixelFormat
+	tight          rfb.TightDecoder
+
+	mu           sync.Mutex
+	viewerFormat rfb.PixelFormat
+}
+
+// rewrite runs the handshake with both sides of a session, authenticating to the server with password, then asks the server for pixelFormat and forwards messages until either side disconnects.
+func rewrite(ctx context.Context, viewer, server net.Conn, pixelFormat rfb.PixelFormat, password string) error {
+	handshake, err := rfb.ServerHandshake(ctx, viewer, rfb.ServerHandshakeOptions{
+		// The server's ServerInitialisation is only known once the viewer has said whether to share it.
+		Init: func(clientInit rfb.ClientInitialisationMessage) (rfb.ServerInitialisationMessage, error) {
+			result, err := rfb.ClientHandshake(ctx, server, rfb.ClientHandshakeOptions{
+				Password: func() (string, error) { return password, nil },
+				Shared:   clientInit.Shared,
+			})
+			if err != nil {
+				return rfb.ServerInitialisationMessage{}, fmt.Errorf("handshake with server: %w", err)
+			}
+			return result.ServerInit, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	r := &rewriter{viewer: handshake.Conn, server: server, serverFormat: pixelFormat, viewerFormat: handshake.ServerInit.PixelFormat}
+	w := bufio.NewWriter(server)
+	if err := writeMessage(w, &rfb.SetPixelFormatMessage{PixelFormat: pixelFormat}); err != nil {
+		return err
+	}
+	errs := make(chan error, 2)
+	go func() { errs <- r.fromViewer(w) }()
+	go func() { errs <- r.fromServer() }()
+	err = <-errs
+	// Unblock the other direction.
+	r.viewer.Close()
+	r.server.Close()
+	<-errs
+	return err
+}
+
+// fromViewer forwards messages from the viewer to the server, keeping the viewer's pixel format to itself and leaving out encodings that fromServer can't read.
+func (r *rewriter) fromViewer(w *bufio.Writer) error {
+	br := bufio.NewReader(r.viewer)
+	for {
+		m, err := rfb.ReadClientMessage(br, binary.BigEndian)
+		if err != nil {
+			return err
+		}
+		switch msg := m.(type) {
+		case *rfb.SetPixelFormatMessage:
+			if !msg.PixelFormat.TrueColor {
+				return errors.New("viewer asked for a colour-mapped pixel format, which updates can't be converted to")
+			}
+			r.mu.Lock()
+			r.viewerFormat = msg.PixelFormat
+			r.mu.Unlock()
+			continue
+		case *rfb.SetEncodingsMessage:
+			var encodingTypes []uint32
+			for _, t := range msg.EncodingTypes {
+				if readable[t] || t >= rfb.EncodingTypeCompressLevel0 && t <= rfb.EncodingTypeCompressLevel9 || t >= rfb.EncodingTypeQualityLevel0 && t <= rfb.EncodingTypeQualityLevel9 {
+					encodingTypes = append(encodingTypes, t)
+				}
+			}
+			m = &rfb.SetEncodingsMessage{EncodingTypes: encodingTypes}
+		}
+		if err := writeMessage(w, m); err != nil {
+			return err
+		}
+	}
+}
+
+// fromServer forwards messages from the server to the viewer, converting the pixels in updates to the viewer's pixel format.
+func (r *rewriter) fromServer() error {
+	br := bufio.NewReader(r.server)
+	w := bufio.NewWriter(r.viewer)
+	for {
+		m, err := rfb.ReadServerMessage(br, binary.BigEndian, r.serverFormat, nil)
+		if err != nil {
+			return err
+		}
+		if update, ok := m.(*rfb.FramebufferUpdateMessage); ok {
+			converted, err := r.convert(update)
+			update.Release()
+			if err != nil {
+				return err
+			}
+			m = converted
+		}
+		if err := writeMessage(w, m); err != nil {
+			return err
+		}
+	}
+}
+
+// convert returns a copy of m with each rectangle in the viewer's pixel format, as Raw, except for CopyRect and pseudo-encodings that have no pixels.
+func (r *rewriter) convert(m *rfb.FramebufferUpdateMessage) (*rfb.FramebufferUpdateMessage, error) {
+	r.mu.Lock()
+	viewerFormat := r.viewerFormat
+	r.mu.Unlock()
+
+	converted := &rfb.FramebufferUpdateMessage{}
+	for _, rect := range m.Rectangles {
+		switch rect.EncodingType {
+		case rfb.EncodingTypeCopyRectangle, rfb.EncodingTypeXCursor, rfb.EncodingTypeExtendedDesktopSize:
+			// PixelData may be in a pooled buffer, which m.Release reuses.
+			dup := *rect
+			dup.PixelData = append([]byte(nil), rect.PixelData...)
+			converted.Rectangles = append(converted.Rectangles, &dup)
+		case rfb.EncodingTypeCursor:
+			img, hotspot, err := rect.Cursor(r.serverFormat)
+			if err != nil {
+				return nil, fmt.Errorf("decode cursor: %w", err)
+			}
+			cursor, err := rfb.NewCursorRect(img, hotspot, viewerFormat)
+			if err != nil {
+				return nil, fmt.Errorf("encode cursor: %w", err)
+			}
+			converted.Rectangles = append(converted.Rectangles, cursor)
+		default:
+			var src *rfb.PixelFormatImage
+			var err error
+			if rect.EncodingType == rfb.EncodingTypeTight {
+				src, err = r.tight.Decode(rect, r.serverFormat)
+			} else {
+				src, err = rect.Decode(r.serverFormat, nil)
+			}
+			if err != nil {
+				return nil, err
+			}
+			raw, err := reformat(src, viewerFormat)
+			src.Release()
+			if err != nil {
+				return nil, err
+			}
+			converted.Rectangles = append(converted.Rectangles, raw)
+		}
+	}
+	return converted, nil
+}
+
+// reformat returns a Raw rectangle of src in pixelFormat.
+func reformat(src *rfb.PixelFormatImage, pixelFormat rfb.PixelFormat) (*rfb.FramebufferUpdateRect, error) {
+	rgba := image.NewRGBA(src.Bounds())
+	if err := src.CopyToRGBA(rgba); err != nil {
+		return nil, err
+	}
+	dst, err := rfb.NewPixelFormatImage(pixelFormat, src.Bounds())
+	if err != nil {
+		return nil, err
+	}
+	if err := dst.CopyFromRGBA(rgba); err != nil {
+		return nil, err
+	}
+	return rfb.DefaultEncodings.Encode(rfb.EncodingTypeRaw, dst)
+}
+
+// writeMessage writes m and flushes w.
+func writeMessage(w *bufio.Writer, m interface{ MessageType() uint8 }) error {
+	var err error
+	switch m := m.(type) {
+	case interface {
+		Write(io.Writer, binary.ByteOrder) error
+	}:
+		err = m.Write(w, binary.BigEndian)
+	case interface{ Write(io.Writer) error }:
+		err = m.Write(w)
+	default:
+		return fmt.Errorf("can't forward %s", rfb.MessageName(m))
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", rfb.MessageName(m), err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// gridMargin is the space around each window in a grid, in logical pixels, and around a cascade.
+	gridMargin = 8
+
+	// cascadeStep is how far each window in a cascade is from the one behind it, across and down, in logical pixels.
+	cascadeStep = 24
+)
+
+// arrangeGrid lays the windows out in a grid of equal cells that fills the screen, in rows from the back window to the front, or in the sort order if sorted. It picks the number of columns that shows the most of the images, which it scales to fit their cells, but not past full size. Undo puts the windows back.
+func (ui *UI) arrangeGrid(sorted bool) {
+	windows := append([]*Window(nil), ui.windows...)
+	if len(windows) == 0 {
+		return
+	}
+	if sorted {
+		ui.sortWindows(windows)
+	}
+	ui.undoLayout()
+
+	width, height := float64(ui.Width)/ui.PixelRatio, float64(ui.Height)/ui.PixelRatio
+	columns, shown := 1, -1.0
+	for c := 1; c <= len(windows); c++ {
+		rows := (len(windows) + c - 1) / c
+		area := 0.0
+		for _, win := range windows {
+			s := gridScale(win, width/float64(c), height/float64(rows))
+			area += s * s * float64(win.crop.Dx()*win.crop.Dy())
+		}
+		if area > shown {
+			columns, shown = c, area
+		}
+	}
+
+	rows := (len(windows) + columns - 1) / columns
+	cellWidth, cellHeight := width/float64(columns), height/float64(rows)
+	for i, win := range windows {
+		if s := gridScale(win, cellWidth, cellHeight); s != win.scale {
+			win.scale = s
+			win.Render(ui.PixelRatio)
+		}
+		cell := image.Pt(int(float64(i%columns)*cellWidth), int(float64(i/columns)*cellHeight))
+		size := win.ScreenRect().Size()
+		win.pos = cell.Add(image.Pt((int(cellWidth)-size.X)/2, (int(cellHeight)-size.Y)/2))
+	}
+}
+
+// gridScale returns the scale at which win's crop fits a cell of the grid, up to full size, but never so small that it disappears.
+func gridScale(win *Window, cellWidth, cellHeight float64) float64 {
+	w, h := float64(win.crop.Dx()), float64(win.crop.Dy())
+	s := math.Min(math.Min((cellWidth-2*gridMargin)/w, (cellHeight-2*gridMargin)/h), 1)
+	return math.Max(s, 1/math.Min(w, h))
+}
+
+// arrangeCascade stacks the windows from the top left of the screen, each a step down and to the right of the one behind it, at their scales, so that the top left corner of every image shows. A cascade that would run off the screen starts again at the top, a step to the right of where it last started. Undo puts the windows back.
+func (ui *UI) arrangeCascade() {
+	if len(ui.windows) == 0 {
+		return
+	}
+	ui.undoLayout()
+
+	width, height := int(float64(ui.Width)/ui.PixelRatio), int(float64(ui.Height)/ui.PixelRatio)
+	start := image.Pt(gridMargin, gridMargin)
+	pos := start
+	for _, win := range ui.windows {
+		size := win.ScreenRect().Size()
+		if pos != start && (pos.X+size.X > width-gridMargin || pos.Y+size.Y > height-gridMargin) {
+			start.X += cascadeStep
+			if start.X+size.X > width-gridMargin {
+				start.X = gridMargin
+			}
+			pos = start
+		}
+		win.pos = pos
+		pos = pos.Add(image.Pt(cascadeStep, cascadeStep))
+	}
+}
+
+// undoLayout makes Undo restore the layout as it is now.
+func (ui *UI) undoLayout() {
+	l := ui.Layout()
+	ui.undo = func() { ui.SetLayout(l) }
+}
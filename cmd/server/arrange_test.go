@@ -0,0 +1,58 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestArrangeGrid(t *testing.T) {
+	ui, err := NewUI(NewFiles("testdata/fixtures", true, "", ""), 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b.png goes to the back, so a.png comes first only when sorted.
+	ui.moveToFront(0)
+	var before layout
+	for _, sorted := range []bool{false, true} {
+		before = ui.Layout()
+		ui.arrangeGrid(sorted)
+		screen := image.Rect(0, 0, windowWidth, windowHeight)
+		a, b := ui.windows[1].ScreenRect(), ui.windows[0].ScreenRect()
+		if !a.In(screen) || !b.In(screen) || a.Overlaps(b) {
+			t.Errorf("sorted %v: expected a.png at %v and b.png at %v to be apart, on the screen", sorted, a, b)
+		}
+		// Windows in the same row overlap vertically, since they're centered in their cells.
+		sameRow := a.Min.Y < b.Max.Y && b.Min.Y < a.Max.Y
+		if first := a.Max.Y <= b.Min.Y || sameRow && a.Max.X <= b.Min.X; first != sorted {
+			t.Errorf("sorted %v: expected a.png first %v, but it's at %v and b.png at %v", sorted, sorted, a, b)
+		}
+		for _, win := range ui.windows {
+			if win.scale > 1 {
+				t.Errorf("sorted %v: expected %s at full size at most, but its scale is %v", sorted, win.name, win.scale)
+			}
+		}
+	}
+
+	// Undo puts the windows back as they were before the last arrangement.
+	ui.undo()
+	if got := ui.Layout(); !reflect.DeepEqual(got, before) {
+		t.Errorf("expected undo to restore %+v, but got %+v", before, got)
+	}
+}
+
+func TestArrangeCascade(t *testing.T) {
+	ui, err := NewUI(NewFiles("testdata/fixtures", true, "", ""), 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui.moveToFront(0)
+	ui.arrangeCascade()
+	// The stack keeps its order, b.png at the back.
+	if ui.windows[0].name != "b.png" || ui.windows[1].name != "a.png" {
+		t.Fatalf("expected b.png behind a.png, but got %+v", ui.Layout())
+	}
+	if got, want := ui.windows[1].pos, ui.windows[0].pos.Add(image.Pt(cascadeStep, cascadeStep)); got != want {
+		t.Errorf("expected a.png a step from b.png at %v, at %v, but it's at %v", ui.windows[0].pos, want, got)
+	}
+}
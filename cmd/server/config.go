@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// config is a -config file, in the subset of TOML that settings need: key = value pairs with string, number, boolean, or array values, in sections. Top-level keys are flags, without the dash, and dir, the image directory. The [keys] and [colors] sections set the UI's Keys and Colors, as in:
+//
+//	addr = "0.0.0.0:5900"
+//	max_fps = 30
+//	theme = "dark"
+//	plugin = ["grayscale.so", "rotate.so"]
+//	dir = "/home/me/Pictures"
+//
+//	[keys]
+//	crop_top = "i"
+//
+//	[colors]
+//	background = "#202020"
+type config struct {
+	path     string
+	settings []configSetting
+}
+
+type configSetting struct {
+	section, key string
+	values       []string // one, unless the value was an array
+	line         int
+}
+
+func loadConfig(path string) (*config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := &config{path: path}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("%s:%d: unterminated section header", path, line)
+			}
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			continue
+		}
+		idx := strings.Index(text, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, line)
+		}
+		key := strings.TrimSpace(text[:idx])
+		values, err := parseConfigValue(strings.TrimSpace(text[idx+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		cfg.settings = append(cfg.settings, configSetting{section, key, values, line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// stripComment removes a # comment from line, unless it's in a string.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for idx, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:idx]
+		}
+	}
+	return line
+}
+
+// parseConfigValue returns the strings in a value, or in each element of an array.
+func parseConfigValue(value string) ([]string, error) {
+	if strings.HasPrefix(value, "[") {
+		if !strings.HasSuffix(value, "]") {
+			return nil, fmt.Errorf("unterminated array %s", value)
+		}
+		var values []string
+		rest := strings.TrimSpace(value[1 : len(value)-1])
+		for rest != "" {
+			var elem string
+			if rest[0] == '"' || rest[0] == '\'' {
+				end := closingQuote(rest)
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated string %s", rest)
+				}
+				elem, rest = rest[:end+1], rest[end+1:]
+			} else {
+				end := strings.Index(rest, ",")
+				if end < 0 {
+					end = len(rest)
+				}
+				elem, rest = rest[:end], rest[end:]
+			}
+			v, err := parseConfigScalar(strings.TrimSpace(elem))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			rest = strings.TrimSpace(rest)
+			if rest != "" {
+				if rest[0] != ',' {
+					return nil, fmt.Errorf("expected , between array elements, but got %s", rest)
+				}
+				rest = strings.TrimSpace(rest[1:])
+			}
+		}
+		return values, nil
+	}
+	v, err := parseConfigScalar(value)
+	if err != nil {
+		return nil, err
+	}
+	return []string{v}, nil
+}
+
+// closingQuote returns the index of the quote that ends the string at the start of s, or -1 if there isn't one.
+func closingQuote(s string) int {
+	for idx := 1; idx < len(s); idx++ {
+		switch {
+		case s[0] == '"' && s[idx] == '\\':
+			idx++
+		case s[idx] == s[0]:
+			return idx
+		}
+	}
+	return -1
+}
+
+// parseConfigScalar returns the contents of a string, or the text of a number or boolean, which flag.Value can parse itself.
+func parseConfigScalar(value string) (string, error) {
+	switch {
+	case value == "":
+		return "", errors.New("missing value")
+	case value[0] == '"':
+		if closingQuote(value) != len(value)-1 {
+			return "", fmt.Errorf("malformed string %s", value)
+		}
+		return strconv.Unquote(value)
+	case value[0] == '\'':
+		if closingQuote(value) != len(value)-1 {
+			return "", fmt.Errorf("malformed string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	}
+	return value, nil
+}
+
+// applyFlags sets the flags in cfg that weren't set on the command line, and returns the image directory, if it's set.
+func (cfg *config) applyFlags() (string, error) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	dir := ""
+	for _, setting := range cfg.settings {
+		if setting.section != "" {
+			continue
+		}
+		if setting.key == "dir" {
+			if len(setting.values) != 1 {
+				return "", cfg.errorf(setting, "dir must be a string")
+			}
+			dir = setting.values[0]
+			continue
+		}
+		f := flag.Lookup(setting.key)
+		if f == nil || setting.key == "config" {
+			return "", cfg.errorf(setting, "unknown setting %q", setting.key)
+		}
+		if set[f.Name] {
+			continue
+		}
+		if _, ok := f.Value.(*stringsFlag); !ok && len(setting.values) != 1 {
+			return "", cfg.errorf(setting, "%s takes one value", setting.key)
+		}
+		for _, value := range setting.values {
+			if err := f.Value.Set(value); err != nil {
+				return "", cfg.errorf(setting, "invalid value %q for %s: %v", value, setting.key, err)
+			}
+		}
+	}
+	return dir, nil
+}
+
+// applyUI sets the Keys and Colors in cfg.
+func (cfg *config) applyUI(ui *UI) error {
+	keys := map[string]*rune{
+		"crop_top":    &ui.Keys.CropTop,
+		"crop_left":   &ui.Keys.CropLeft,
+		"crop_bottom": &ui.Keys.CropBottom,
+		"crop_right":  &ui.Keys.CropRight,
+
+		"flip_horizontal": &ui.Keys.FlipHorizontal,
+		"flip_vertical":   &ui.Keys.FlipVertical,
+		"export":          &ui.Keys.Export,
+		"send_to_back":    &ui.Keys.SendToBack,
+		"lower":           &ui.Keys.Lower,
+		"raise":           &ui.Keys.Raise,
+		"reset_crops":     &ui.Keys.ResetCrops,
+		"undo":            &ui.Keys.Undo,
+		"grid":            &ui.Keys.Grid,
+		"cascade":         &ui.Keys.Cascade,
+		"sort":            &ui.Keys.Sort,
+		"labels":          &ui.Keys.Labels,
+		"exif":            &ui.Keys.Exif,
+	}
+	colors := colorSettings(ui)
+	for _, setting := range cfg.settings {
+		if setting.section != "keys" && setting.section != "colors" {
+			if setting.section != "" {
+				return cfg.errorf(setting, "unknown section [%s]", setting.section)
+			}
+			continue
+		}
+		if len(setting.values) != 1 {
+			return cfg.errorf(setting, "%s takes one value", setting.key)
+		}
+		value := setting.values[0]
+		switch setting.section {
+		case "keys":
+			key, ok := keys[setting.key]
+			if !ok {
+				return cfg.errorf(setting, "unknown key binding %q", setting.key)
+			}
+			r, size := utf8.DecodeRuneInString(value)
+			if size == 0 || size != len(value) {
+				return cfg.errorf(setting, "%s must be one character, but was %q", setting.key, value)
+			}
+			*key = unicode.ToLower(r)
+		case "colors":
+			c, ok := colors[setting.key]
+			if !ok {
+				return cfg.errorf(setting, "unknown color %q", setting.key)
+			}
+			parsed, err := parseColor(value)
+			if err != nil {
+				return cfg.errorf(setting, "%v", err)
+			}
+			*c = parsed
+		}
+	}
+	return nil
+}
+
+// colorSettings returns the UI's Colors by the names that [colors] and -color use.
+func colorSettings(ui *UI) map[string]*color.Color {
+	return map[string]*color.Color{
+		"background": &ui.Colors.Background,
+		"fold":       &ui.Colors.Fold,
+		"selection":  &ui.Colors.Selection,
+		"highlight":  &ui.Colors.Highlight,
+		"label":      &ui.Colors.Label,
+	}
+}
+
+// applyColor sets one of the UI's Colors from a -color flag, written as name=#rrggbb.
+func applyColor(ui *UI, setting string) error {
+	idx := strings.Index(setting, "=")
+	if idx < 0 {
+		return fmt.Errorf("expected name=#rrggbb, but got %q", setting)
+	}
+	c, ok := colorSettings(ui)[setting[:idx]]
+	if !ok {
+		return fmt.Errorf("unknown color %q", setting[:idx])
+	}
+	parsed, err := parseColor(setting[idx+1:])
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+func (cfg *config) errorf(setting configSetting, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", cfg.path, setting.line, fmt.Sprintf(format, args...))
+}
+
+// parseColor parses colors written as #rrggbb, or #rrggbbaa with non-premultiplied alpha.
+func parseColor(s string) (color.Color, error) {
+	if !strings.HasPrefix(s, "#") || (len(s) != 7 && len(s) != 9) {
+		return nil, fmt.Errorf("expected a color as #rrggbb or #rrggbbaa, but got %q", s)
+	}
+	n, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("expected a color as #rrggbb or #rrggbbaa, but got %q", s)
+	}
+	if len(s) == 7 {
+		n = n<<8 | 0xff
+	}
+	return color.NRGBA{uint8(n >> 24), uint8(n >> 16), uint8(n >> 8), uint8(n)}, nil
+}
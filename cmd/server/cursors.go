@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/alltom/vncfreethumb/vncserver"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"strings"
+)
+
+var arrowCursor = parseCursor(image.Pt(0, 0), `
+X
+XX
+X.X
+X..X
+X...X
+X....X
+X.....X
+X......X
+X.......X
+X........X
+X.....XXXXX
+X..X..X
+X.X X..X
+XX  X..X
+X    X..X
+     X..X
+      X..X
+      X..X
+       XX
+`)
+
+var crosshairCursor = parseCursor(image.Pt(7, 7), `
+      .X.
+      .X.
+      .X.
+      .X.
+      .X.
+      .X.
+.......X.......
+XXXXXXXXXXXXXXX
+.......X.......
+      .X.
+      .X.
+      .X.
+      .X.
+      .X.
+      .X.
+`)
+
+// parseCursor draws a cursor from rows of text in which X is black, . is white, and anything else is transparent.
+func parseCursor(hotspot image.Point, art string) *vncserver.Cursor {
+	rows := strings.Split(strings.Trim(art, "\n"), "\n")
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			switch c {
+			case 'X':
+				img.Set(x, y, color.Black)
+			case '.':
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+	return &vncserver.Cursor{Image: img, Hotspot: hotspot}
+}
+
+// scaleCursor returns c enlarged for a screen with pixelRatio framebuffer pixels per logical pixel.
+func scaleCursor(c *vncserver.Cursor, pixelRatio float64) *vncserver.Cursor {
+	if pixelRatio == 1 {
+		return c
+	}
+	r := rmulf(c.Image.Bounds(), pixelRatio)
+	return &vncserver.Cursor{Image: resize.Resize(uint(r.Dx()), uint(r.Dy()), c.Image, resize.NearestNeighbor), Hotspot: pmulf(c.Hotspot, pixelRatio)}
+}
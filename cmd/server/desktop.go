@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/vncserver"
+	"image"
+)
+
+// maxStale is how many stale rectangles the frame may have before it's drawn again from scratch.
+const maxStale = 64
+
+// desktop serves a UI with package vncserver. All clients share it.
+type desktop struct {
+	ui *UI
+
+	// The UI's event handlers see the latest of each kind of event, from whichever client sent it, whenever either kind arrives.
+	keyEvent     rfb.KeyEventMessage
+	pointerEvent rfb.PointerEventMessage
+
+	// frame is the whole screen, which is only drawn again where the UI reported damage, in stale.
+	frame *image.RGBA
+	stale []image.Rectangle
+}
+
+func (d *desktop) Size() (width, height int) {
+	return d.ui.Width, d.ui.Height
+}
+
+// Render returns the whole frame, bringing it up to date first, since update requests mostly cover what changed anyway.
+func (d *desktop) Render(r image.Rectangle) image.Image {
+	screen := image.Rect(0, 0, d.ui.Width, d.ui.Height)
+	if d.frame == nil || d.frame.Rect != screen {
+		d.frame = image.NewRGBA(screen)
+		d.stale = []image.Rectangle{screen}
+	}
+	for _, rect := range d.stale {
+		if rect = rect.Intersect(screen); !rect.Empty() {
+			d.ui.Draw(d.frame.SubImage(rect).(*image.RGBA))
+		}
+	}
+	d.stale = nil
+	return d.frame
+}
+
+func (d *desktop) HandleKey(event rfb.KeyEventMessage) {
+	d.keyEvent = event
+	d.ui.HandleEvent(&d.keyEvent, &d.pointerEvent)
+}
+
+func (d *desktop) HandlePointer(event rfb.PointerEventMessage) {
+	d.pointerEvent = event
+	d.ui.HandleEvent(&d.keyEvent, &d.pointerEvent)
+}
+
+// HandleCutText does nothing, since the UI has no use for text.
+func (d *desktop) HandleCutText(text string) {}
+
+func (d *desktop) Damage() []image.Rectangle {
+	damage := d.ui.Damage()
+	d.stale = append(d.stale, damage...)
+	if len(d.stale) > maxStale {
+		d.stale = []image.Rectangle{image.Rect(0, 0, d.ui.Width, d.ui.Height)}
+	}
+	return damage
+}
+
+func (d *desktop) NewMoveTracker() vncserver.MoveTracker {
+	return d.ui.NewMoveTracker()
+}
+
+func (d *desktop) Resize(width, height int) {
+	d.ui.Resize(width, height)
+}
+
+func (d *desktop) Cursor() *vncserver.Cursor {
+	return d.ui.Cursor()
+}
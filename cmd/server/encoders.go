@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/alltom/vncfreethumb/extension"
+	"github.com/alltom/vncfreethumb/rfb"
+)
+
+// registerBuiltinEncoders adds the encoders implemented by package rfb, so that plugins registered later can replace them.
+func registerBuiltinEncoders(registry *extension.Registry, compressionLevel, jpegQuality int) {
+	registry.RegisterEncoder(rfb.EncodingTypeRRE, func() extension.Encoder { return &rreEncoder{} })
+	registry.RegisterEncoder(rfb.EncodingTypeCoRRE, func() extension.Encoder { return coRREEncoder{} })
+	registry.RegisterEncoder(rfb.EncodingTypeHextile, func() extension.Encoder { return hextileEncoder{} })
+	registry.RegisterEncoder(rfb.EncodingTypeTight, func() extension.Encoder {
+		return &tightEncoder{
+			TightEncoder:     rfb.TightEncoder{CompressionLevel: compressionLevel, JPEGQuality: jpegQuality},
+			compressionLevel: compressionLevel,
+			jpegQuality:      jpegQuality,
+		}
+	})
+}
+
+// rreEncoder only suits images that RRE makes smaller than raw. It keeps the encoding from Suits for Encode.
+type rreEncoder struct {
+	img  *rfb.PixelFormatImage
+	data []byte
+}
+
+func (*rreEncoder) EncodingType() uint32 {
+	return rfb.EncodingTypeRRE
+}
+
+func (e *rreEncoder) Suits(img *rfb.PixelFormatImage) bool {
+	e.img, e.data = img, rfb.EncodeRRE(img)
+	return len(e.data) < len(img.Pix)
+}
+
+func (e *rreEncoder) Encode(img *rfb.PixelFormatImage) ([]*rfb.FramebufferUpdateRect, error) {
+	data := e.data
+	if img != e.img {
+		data = rfb.EncodeRRE(img)
+	}
+	e.img, e.data = nil, nil
+	r := img.Bounds()
+	return []*rfb.FramebufferUpdateRect{{
+		X: uint16(r.Min.X), Y: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+		EncodingType: rfb.EncodingTypeRRE, PixelData: data,
+	}}, nil
+}
+
+type coRREEncoder struct{}
+
+func (coRREEncoder) EncodingType() uint32 {
+	return rfb.EncodingTypeCoRRE
+}
+
+func (coRREEncoder) Encode(img *rfb.PixelFormatImage) ([]*rfb.FramebufferUpdateRect, error) {
+	return rfb.EncodeCoRRE(img), nil
+}
+
+type hextileEncoder struct{}
+
+func (hextileEncoder) EncodingType() uint32 {
+	return rfb.EncodingTypeHextile
+}
+
+func (hextileEncoder) Encode(img *rfb.PixelFormatImage) ([]*rfb.FramebufferUpdateRect, error) {
+	r := img.Bounds()
+	return []*rfb.FramebufferUpdateRect{{
+		X: uint16(r.Min.X), Y: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+		EncodingType: rfb.EncodingTypeHextile, PixelData: rfb.EncodeHextile(img),
+	}}, nil
+}
+
+type tightEncoder struct {
+	rfb.TightEncoder
+
+	// The server's defaults, for clients that don't say what they want.
+	compressionLevel, jpegQuality int
+}
+
+func (*tightEncoder) EncodingType() uint32 {
+	return rfb.EncodingTypeTight
+}
+
+func (e *tightEncoder) Configure(settings rfb.EncodingSettings) {
+	level := e.compressionLevel
+	if settings.CompressionLevel >= 0 {
+		level = settings.CompressionLevel
+	}
+	e.SetCompressionLevel(level)
+	e.JPEGQuality = e.jpegQuality
+	if settings.QualityLevel >= 0 {
+		e.JPEGQuality = settings.JPEGQuality()
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rwcarlsen/goexif/exif"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+// exifLines returns what the EXIF overlay says about the named file: the camera, the exposure, when it was taken, and where, as far as its EXIF data says.
+func exifLines(files *Files, name string) []string {
+	lines := []string{name}
+	x, err := readExif(files, name)
+	if err != nil {
+		return append(lines, "no EXIF data")
+	}
+
+	camera := []string{exifString(x, exif.Make), exifString(x, exif.Model)}
+	// Models often repeat the make, as in "Canon" and "Canon EOS 5D".
+	if camera[0] != "" && strings.HasPrefix(camera[1], camera[0]) {
+		camera = camera[1:]
+	}
+	if s := strings.TrimSpace(strings.Join(camera, " ")); s != "" {
+		lines = append(lines, "camera "+s)
+	}
+
+	var exposure []string
+	if tag, err := x.Get(exif.ExposureTime); err == nil {
+		if r, err := tag.Rat(0); err == nil && r.Sign() > 0 {
+			exposure = append(exposure, r.RatString()+" s")
+		}
+	}
+	if f, ok := exifFloat(x, exif.FNumber); ok {
+		exposure = append(exposure, fmt.Sprintf("f/%g", f))
+	}
+	if tag, err := x.Get(exif.ISOSpeedRatings); err == nil {
+		if iso, err := tag.Int(0); err == nil {
+			exposure = append(exposure, fmt.Sprintf("ISO %d", iso))
+		}
+	}
+	if f, ok := exifFloat(x, exif.FocalLength); ok {
+		exposure = append(exposure, fmt.Sprintf("%g mm", f))
+	}
+	if len(exposure) > 0 {
+		lines = append(lines, "exposure "+strings.Join(exposure, ", "))
+	}
+
+	if t, err := x.DateTime(); err == nil {
+		lines = append(lines, "taken "+t.Format("2006-01-02 15:04:05"))
+	}
+	if lat, long, err := x.LatLong(); err == nil {
+		lines = append(lines, fmt.Sprintf("GPS %.5f, %.5f", lat, long))
+	}
+	if len(lines) == 1 {
+		lines = append(lines, "no EXIF data")
+	}
+	return lines
+}
+
+// readExif reads the EXIF data of the named file.
+func readExif(files *Files, name string) (*exif.Exif, error) {
+	f, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return exif.Decode(f)
+}
+
+// exifString returns the value of the ASCII tag, or "" if x doesn't have it.
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+// exifFloat returns the value of the rational tag, if x has it.
+func exifFloat(x *exif.Exif, name exif.FieldName) (float64, bool) {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0, false
+	}
+	r, err := tag.Rat(0)
+	if err != nil || r.Sign() <= 0 {
+		return 0, false
+	}
+	f, _ := r.Float64()
+	return math.Round(f*100) / 100, true
+}
+
+// toggleExif shows the window's EXIF overlay, or hides it if it's showing.
+func (ui *UI) toggleExif(win *Window) {
+	if win.exif != nil {
+		win.exif = nil
+		return
+	}
+	win.exif = textMask(exifLines(ui.files, win.name), ui.PixelRatio)
+}
+
+// exifRect returns where the window's EXIF overlay is, in framebuffer pixels, at its top left corner, or the empty rectangle if it's hidden.
+func (ui *UI) exifRect(win *Window) image.Rectangle {
+	if win.exif == nil {
+		return image.ZR
+	}
+	return win.exif.Rect.Add(rmulf(win.ScreenRect(), ui.PixelRatio).Min)
+}
+
+// drawExif draws the window's EXIF overlay, if it's showing.
+func (ui *UI) drawExif(img draw.Image, win *Window) {
+	if r := ui.exifRect(win); !r.Empty() {
+		ui.drawTextBox(img, r, win.exif)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/keysym"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// ifdEntry is a tag of a TIFF image file directory, with its value encoded in little-endian order.
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+func asciiEntry(tag uint16, s string) ifdEntry {
+	return ifdEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func rationalEntry(tag uint16, fractions ...uint32) ifdEntry {
+	value := make([]byte, 4*len(fractions))
+	for i, n := range fractions {
+		binary.LittleEndian.PutUint32(value[4*i:], n)
+	}
+	return ifdEntry{tag, 5, uint32(len(fractions) / 2), value}
+}
+
+func longEntry(tag uint16, n uint32) ifdEntry {
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, n)
+	return ifdEntry{tag, 4, 1, value}
+}
+
+// encodeIFD encodes the entries as a directory at offset in the TIFF data, with the values that don't fit in the entries after it.
+func encodeIFD(offset int, entries []ifdEntry) []byte {
+	var ifd, values bytes.Buffer
+	valuesOffset := offset + 2 + 12*len(entries) + 4
+	binary.Write(&ifd, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&ifd, binary.LittleEndian, e.tag)
+		binary.Write(&ifd, binary.LittleEndian, e.typ)
+		binary.Write(&ifd, binary.LittleEndian, e.count)
+		if len(e.value) <= 4 {
+			ifd.Write(append(e.value, make([]byte, 4-len(e.value))...))
+			continue
+		}
+		binary.Write(&ifd, binary.LittleEndian, uint32(valuesOffset+values.Len()))
+		values.Write(e.value)
+	}
+	ifd.Write(make([]byte, 4))
+	return append(ifd.Bytes(), values.Bytes()...)
+}
+
+// exifJPEG returns a JPEG with EXIF data, as a camera would write it.
+func exifJPEG(t *testing.T) []byte {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 6)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ifd0 := func(exifOffset, gpsOffset int) []ifdEntry {
+		return []ifdEntry{
+			asciiEntry(0x010f, "Canon"),
+			asciiEntry(0x0110, "Canon EOS 5D"),
+			longEntry(0x8769, uint32(exifOffset)),
+			longEntry(0x8825, uint32(gpsOffset)),
+		}
+	}
+	exifIFD := []ifdEntry{
+		rationalEntry(0x829a, 1, 125),
+		rationalEntry(0x829d, 28, 10),
+		{0x8827, 3, 1, []byte{200, 0}},
+		asciiEntry(0x9003, "2004:01:11 22:45:15"),
+		rationalEntry(0x920a, 50, 1),
+	}
+	gpsIFD := []ifdEntry{
+		asciiEntry(0x0001, "N"),
+		rationalEntry(0x0002, 37, 1, 46, 1, 2964, 100),
+		asciiEntry(0x0003, "W"),
+		rationalEntry(0x0004, 122, 1, 25, 1, 984, 100),
+	}
+	exifOffset := 8 + len(encodeIFD(8, ifd0(0, 0)))
+	gpsOffset := exifOffset + len(encodeIFD(exifOffset, exifIFD))
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = append(tiff, encodeIFD(8, ifd0(exifOffset, gpsOffset))...)
+	tiff = append(tiff, encodeIFD(exifOffset, exifIFD)...)
+	tiff = append(tiff, encodeIFD(gpsOffset, gpsIFD)...)
+
+	// The EXIF data goes in an APP1 segment, right after the start of the image.
+	var data bytes.Buffer
+	data.Write(img.Bytes()[:2])
+	data.Write([]byte{0xff, 0xe1})
+	binary.Write(&data, binary.BigEndian, uint16(2+6+len(tiff)))
+	data.WriteString("Exif\x00\x00")
+	data.Write(tiff)
+	data.Write(img.Bytes()[2:])
+	return data.Bytes()
+}
+
+func TestExif(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), exifJPEG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePNG(filepath.Join(dir, "b.png"), image.NewGray(image.Rect(0, 0, 8, 6))); err != nil {
+		t.Fatal(err)
+	}
+	files := NewFiles(dir, true, "", "")
+
+	want := []string{
+		"a.jpg",
+		"camera Canon EOS 5D",
+		"exposure 1/125 s, f/2.8, ISO 200, 50 mm",
+		"taken 2004-01-11 22:45:15",
+		"GPS 37.77490, -122.41940",
+	}
+	if got := exifLines(files, "a.jpg"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, but got %q", want, got)
+	}
+	if got, want := exifLines(files, "b.png"), []string{"b.png", "no EXIF data"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, but got %q", want, got)
+	}
+
+	ui, err := NewUI(files, 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	win := ui.windows[0]
+	win.pos = image.Pt(10, 20)
+	ui.Damage()
+	press := func() []image.Rectangle {
+		for _, pressed := range []bool{true, false} {
+			ui.HandleEvent(&rfb.KeyEventMessage{Pressed: pressed, KeySym: keysym.RuneToKeysym('i')}, &rfb.PointerEventMessage{X: 22, Y: 42})
+		}
+		return ui.Damage()
+	}
+	damage := press()
+	if win.exif == nil {
+		t.Fatal("expected the EXIF overlay to show")
+	}
+	r := ui.exifRect(win)
+	if r.Min != image.Pt(20, 40) || r.Dy() != 2*(len(want)*13+4) {
+		t.Errorf("expected the overlay at the window's corner, in framebuffer pixels, but it's at %v", r)
+	}
+	if len(damage) == 0 || !r.In(damage[len(damage)-1]) {
+		t.Errorf("expected the overlay to be damaged, but got %v", damage)
+	}
+	if press(); win.exif != nil {
+		t.Error("expected the EXIF overlay to hide")
+	}
+}
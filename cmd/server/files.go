@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errReadOnly = errors.New("writes are disabled in read-only mode")
+
+// Files mediates all of the server's filesystem access, so that read-only mode is enforced in one place.
+//
+// Reads come from Dir. Writes go to the output directory if one is set, or to Dir otherwise. Session recordings go to the record directory. In read-only mode, writes fail unless an output directory is set, and recordings always fail.
+type Files struct {
+	Dir string
+
+	readOnly  bool
+	outputDir string
+	recordDir string
+}
+
+func NewFiles(dir string, readOnly bool, outputDir, recordDir string) *Files {
+	return &Files{Dir: dir, readOnly: readOnly, outputDir: outputDir, recordDir: recordDir}
+}
+
+// ReadDir lists the files in Dir.
+func (f *Files) ReadDir() ([]os.FileInfo, error) {
+	return ioutil.ReadDir(f.Dir)
+}
+
+// Stat describes the named file in Dir.
+func (f *Files) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(filepath.Join(f.Dir, name))
+}
+
+// Open opens the named file in Dir for reading.
+func (f *Files) Open(name string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(f.Dir, name), os.O_RDONLY, 0)
+}
+
+// Writable reports whether Create can succeed.
+func (f *Files) Writable() bool {
+	return !f.readOnly || f.outputDir != ""
+}
+
+// Create creates or truncates the named file for writing. name must not contain a directory.
+func (f *Files) Create(name string) (*os.File, error) {
+	return f.create(name, os.O_TRUNC)
+}
+
+// CreateNew is like Create, but fails if the file already exists.
+func (f *Files) CreateNew(name string) (*os.File, error) {
+	return f.create(name, os.O_EXCL)
+}
+
+func (f *Files) create(name string, flag int) (*os.File, error) {
+	if !f.Writable() {
+		return nil, errReadOnly
+	}
+	if name != filepath.Base(name) {
+		return nil, fmt.Errorf("file name %q must not contain a directory", name)
+	}
+	return os.OpenFile(f.OutputPath(name), os.O_RDWR|os.O_CREATE|flag, 0666)
+}
+
+// OutputPath returns where a write to name goes: name itself if it's absolute, or in the output directory, or Dir, otherwise.
+func (f *Files) OutputPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	dir := f.Dir
+	if f.outputDir != "" {
+		dir = f.outputDir
+	}
+	return filepath.Join(dir, name)
+}
+
+// CanWrite reports whether WriteFile can write the named file: anywhere unless in read-only mode, and then only inside the output directory, so that a name can't reach the protected files.
+func (f *Files) CanWrite(name string) bool {
+	if !f.readOnly {
+		return true
+	}
+	if f.outputDir == "" {
+		return false
+	}
+	dir, err := filepath.Abs(f.outputDir)
+	if err != nil {
+		return false
+	}
+	path, err := filepath.Abs(f.OutputPath(name))
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// WriteFile writes data to the named file, which may be in a subdirectory, at OutputPath, if CanWrite allows it. It writes by way of a temporary file beside it, so that the file is never left half written.
+func (f *Files) WriteFile(name string, data []byte) error {
+	if !f.CanWrite(name) {
+		return errReadOnly
+	}
+	path := f.OutputPath(name)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// CreateRecording creates the named file in the record directory for writing. name must not contain a directory.
+func (f *Files) CreateRecording(name string) (*os.File, error) {
+	if f.readOnly {
+		return nil, errReadOnly
+	}
+	if f.recordDir == "" {
+		return nil, errors.New("no record directory is set")
+	}
+	if name != filepath.Base(name) {
+		return nil, fmt.Errorf("file name %q must not contain a directory", name)
+	}
+	return os.OpenFile(filepath.Join(f.recordDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+}
+
+// fileTransferHandler offers Files to clients through file transfer.
+type fileTransferHandler struct {
+	files *Files
+}
+
+func (h fileTransferHandler) ReadDir() ([]os.FileInfo, error) {
+	return h.files.ReadDir()
+}
+
+func (h fileTransferHandler) Stat(name string) (os.FileInfo, error) {
+	return h.files.Stat(name)
+}
+
+func (h fileTransferHandler) Open(name string) (io.ReadCloser, error) {
+	return h.files.Open(name)
+}
+
+func (h fileTransferHandler) Create(name string) (io.WriteCloser, error) {
+	return h.files.CreateNew(name)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/keysym"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The golden tests script a session against the images in testdata/fixtures, a.png at the back and b.png in front, which start in the top left corner at half size: b covers (0,0)-(120,80) and a the rest of (0,0)-(200,150). Each frame is compared with testdata/golden/<name>.png. After a change that's meant to alter how the UI looks, rewrite them with:
+//
+//	go test ./cmd/server -run TestGolden -update
+//
+// and look over the new frames before committing them.
+
+var updateGolden = flag.Bool("update", false, "If true, TestGolden rewrites the golden frames instead of comparing with them.")
+
+// goldenTolerance is how far each channel of a pixel may be from the golden frame, to allow for rounding that differs between platforms when images are scaled.
+const goldenTolerance = 2
+
+// event is a key or pointer event, in logical pixels, as a client would send it.
+type event struct {
+	key     *rfb.KeyEventMessage
+	pointer *rfb.PointerEventMessage
+}
+
+func pointer(x, y int, buttonMask uint8) event {
+	return event{pointer: &rfb.PointerEventMessage{ButtonMask: buttonMask, X: uint16(x), Y: uint16(y)}}
+}
+
+// tap presses and releases the key for r.
+func tap(r rune) []event {
+	k := keysym.RuneToKeysym(r)
+	return []event{{key: &rfb.KeyEventMessage{Pressed: true, KeySym: k}}, {key: &rfb.KeyEventMessage{KeySym: k}}}
+}
+
+// drag holds down the buttons in buttonMask at from, moves to to, and releases them there.
+func drag(from, to image.Point, buttonMask uint8) []event {
+	mid := from.Add(to).Div(2)
+	return []event{pointer(from.X, from.Y, buttonMask), pointer(mid.X, mid.Y, buttonMask), pointer(to.X, to.Y, buttonMask), pointer(to.X, to.Y, 0)}
+}
+
+func script(steps ...interface{}) []event {
+	var events []event
+	for _, step := range steps {
+		switch s := step.(type) {
+		case event:
+			events = append(events, s)
+		case []event:
+			events = append(events, s...)
+		default:
+			panic(fmt.Sprintf("unexpected step %T", step))
+		}
+	}
+	return events
+}
+
+const (
+	leftButton  = 0b1
+	rightButton = 0b100
+)
+
+var cropKeys = script(pointer(150, 100, 0), tap('w'), pointer(170, 120, 0), tap('d'))
+var cropSelect = drag(image.Pt(130, 20), image.Pt(190, 120), rightButton)
+
+var goldenTests = []struct {
+	name       string
+	pixelRatio float64
+	events     []event
+}{
+	{"initial", 1, nil},
+	{"drag", 1, drag(image.Pt(60, 40), image.Pt(460, 240), leftButton)},
+	// While a cropped window is dragged, a shadow shows the rest of its image.
+	{"dragging", 1, script(cropSelect, pointer(150, 60, leftButton), pointer(250, 160, leftButton))},
+	{"crop_keys", 1, cropKeys},
+	// Pressing a crop key again uncrops that edge.
+	{"uncrop_key", 1, script(cropKeys, pointer(160, 110, 0), tap('w'))},
+	{"selecting", 1, cropSelect[:3]},
+	{"crop_select", 1, cropSelect},
+	// A right click without a drag toggles between the crop and the whole image.
+	{"crop_toggle", 1, script(cropSelect, drag(image.Pt(150, 50), image.Pt(150, 50), rightButton))},
+	// Flipping mirrors the crop along with the image, so the folds move to the other edges.
+	{"flip", 1, script(cropKeys, pointer(160, 110, 0), tap('h'), tap('v'))},
+	// Windows in a grid are scaled to fit their cells without overlapping.
+	{"grid", 1, script(cropKeys, tap('g'))},
+	{"cascade", 1, script(drag(image.Pt(60, 40), image.Pt(460, 240), leftButton), tap('c'))},
+	// Dragging on the background selects the windows entirely inside the band, which are highlighted as it's dragged out.
+	{"banding", 1, script(cropKeys, pointer(300, 300, leftButton), pointer(150, 200, leftButton), pointer(0, 90, leftButton))},
+	// Dragging a selected window drags the rest of the selection along.
+	{"band_drag", 1, script(drag(image.Pt(60, 40), image.Pt(460, 240), leftButton), drag(image.Pt(700, 500), image.Pt(0, 0), leftButton), drag(image.Pt(500, 250), image.Pt(600, 350), leftButton))},
+	// Labels go under the windows, clear of the folds.
+	{"labels", 1, script(cropKeys, tap('l'))},
+	{"labels_hidpi", 2, script(cropKeys, tap('l'))},
+	{"hidpi", 2, script(cropKeys, drag(image.Pt(60, 40), image.Pt(460, 240), leftButton))},
+}
+
+func TestGolden(t *testing.T) {
+	for _, tt := range goldenTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui, err := NewUI(NewFiles("testdata/fixtures", true, "", ""), tt.pixelRatio, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Events go through desktop, as they do from vncserver, so that the frame is only drawn again where the UI reports damage.
+			d := &desktop{ui: ui}
+			d.Render(image.Rect(0, 0, ui.Width, ui.Height))
+			k := ui.PixelRatio
+			for _, e := range tt.events {
+				if e.key != nil {
+					d.HandleKey(*e.key)
+				}
+				if e.pointer != nil {
+					p := *e.pointer
+					p.X, p.Y = uint16(float64(p.X)*k), uint16(float64(p.Y)*k)
+					d.HandlePointer(p)
+				}
+				d.Damage()
+			}
+			frame := d.Render(image.Rect(0, 0, ui.Width, ui.Height)).(*image.RGBA)
+
+			full := image.NewRGBA(frame.Rect)
+			ui.Draw(full)
+			if x, y, ok := firstDifference(frame, full, 0); ok {
+				t.Errorf("the frame, drawn where damaged, differs from one drawn whole, first at (%d, %d): %v instead of %v", x, y, frame.RGBAAt(x, y), full.RGBAAt(x, y))
+			}
+
+			compareGolden(t, filepath.Join("testdata", "golden", tt.name+".png"), full)
+		})
+	}
+}
+
+// TestGoldenDark draws a session with folds, a selection, and labels in the dark theme, with a color of its own.
+func TestGoldenDark(t *testing.T) {
+	ui, err := NewUI(NewFiles("testdata/fixtures", true, "", ""), 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui.Colors = themes["dark"]
+	if err := applyColor(ui, "fold=#ff8800"); err != nil {
+		t.Fatal(err)
+	}
+	playEvents(&desktop{ui: ui}, script(cropKeys, tap('l'), drag(image.Pt(600, 400), image.Pt(0, 90), leftButton), drag(image.Pt(20, 110), image.Pt(120, 140), rightButton)[:3]))
+	frame := image.NewRGBA(image.Rect(0, 0, ui.Width, ui.Height))
+	ui.Draw(frame)
+	compareGolden(t, filepath.Join("testdata", "golden", "dark.png"), frame)
+}
+
+// compareGolden fails t unless frame matches the golden frame at path, within goldenTolerance, or writes frame there with -update. On a mismatch it saves frame to a temporary file, to compare by eye.
+func compareGolden(t *testing.T, path string, frame *image.RGBA) {
+	t.Helper()
+	if *updateGolden {
+		if err := writePNG(path, frame); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("couldn't open golden frame; create it with -update: %v", err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("couldn't decode golden frame: %v", err)
+	}
+	golden := image.NewRGBA(img.Bounds())
+	for y := golden.Rect.Min.Y; y < golden.Rect.Max.Y; y++ {
+		for x := golden.Rect.Min.X; x < golden.Rect.Max.X; x++ {
+			golden.Set(x, y, img.At(x, y))
+		}
+	}
+
+	if golden.Rect != frame.Rect {
+		t.Errorf("expected a %v frame, but got %v", golden.Rect, frame.Rect)
+	} else if x, y, ok := firstDifference(frame, golden, goldenTolerance); ok {
+		t.Errorf("frame differs from %s, first at (%d, %d): %v instead of %v", path, x, y, frame.RGBAAt(x, y), golden.RGBAAt(x, y))
+	} else {
+		return
+	}
+	actual := filepath.Join(os.TempDir(), "golden-"+filepath.Base(path))
+	if err := writePNG(actual, frame); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("wrote the frame to %s", actual)
+}
+
+// firstDifference returns the first pixel at which a and b, which are the same size, differ by more than tolerance in any channel.
+func firstDifference(a, b *image.RGBA, tolerance uint8) (x, y int, ok bool) {
+	far := func(p, q uint8) bool { return p-q > tolerance && q-p > tolerance }
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		for x := a.Rect.Min.X; x < a.Rect.Max.X; x++ {
+			p, q := a.RGBAAt(x, y), b.RGBAAt(x, y)
+			if far(p.R, q.R) || far(p.G, q.G) || far(p.B, q.B) || far(p.A, q.A) {
+				return x, y, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
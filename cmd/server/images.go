@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nfnt/resize"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// imageExtensions are the extensions of the formats that the UI opens, besides any that plugins register, which tell images that it fails to decode from the other files in the directory.
+var imageExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".tif": true, ".tiff": true, ".bmp": true,
+}
+
+// isImageName reports whether name has the extension of a format that the UI opens.
+func isImageName(name string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// decodeImage decodes the named file, saying which format it failed to decode as, if it could tell.
+func decodeImage(files *Files, name string) (image.Image, error) {
+	f, err := files.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+	// The header says what the file claims to be, which makes for clearer errors than the decoder's alone.
+	_, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode as %s: %w", format, err)
+	}
+	return img, nil
+}
+
+// exportCrop writes the part of win's image that it shows, at full resolution and as flipped, to files, naming it after the image with -crop before the extension: as JPEG if the image is one, and PNG otherwise. It overwrites an earlier export, and returns the name it wrote.
+func exportCrop(files *Files, win *Window) (string, error) {
+	if win.loadErr != nil {
+		return "", errors.New("the image didn't load")
+	}
+	ext := strings.ToLower(filepath.Ext(win.name))
+	if ext != ".jpg" && ext != ".jpeg" {
+		ext = ".png"
+	}
+	name := strings.TrimSuffix(win.name, filepath.Ext(win.name)) + "-crop" + ext
+	crop := image.NewRGBA(image.Rectangle{Max: win.crop.Size()})
+	draw.Draw(crop, crop.Rect, win.img, win.crop.Min, draw.Src)
+
+	f, err := files.Create(name)
+	if err != nil {
+		return "", fmt.Errorf("create: %w", err)
+	}
+	if ext == ".png" {
+		err = png.Encode(f, crop)
+	} else {
+		err = jpeg.Encode(f, crop, &jpeg.Options{Quality: 95})
+	}
+	if err != nil {
+		f.Close()
+		return "", fmt.Errorf("encode: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("write: %w", err)
+	}
+	return name, nil
+}
+
+// labelMargin is the space around the text of a label or tooltip, in logical pixels.
+const labelMargin = 2
+
+// labelMask returns the text of the label for the named file, for a window width logical pixels wide, at pixelRatio. Names too long to fit are cut short, but keep a few characters.
+func labelMask(name string, width int, pixelRatio float64) *image.Alpha {
+	runes := []rune(name)
+	columns := (width - 2*labelMargin) / basicfont.Face7x13.Advance
+	if columns < 4 {
+		columns = 4
+	}
+	if len(runes) > columns {
+		runes = append(runes[:columns-3], []rune("...")...)
+	}
+	return textMask([]string{string(runes)}, pixelRatio)
+}
+
+// textMask returns lines of text, with labelMargin around them, at pixelRatio.
+func textMask(lines []string, pixelRatio float64) *image.Alpha {
+	face := basicfont.Face7x13
+	width := 0
+	for _, line := range lines {
+		if w := font.MeasureString(face, line).Ceil(); w > width {
+			width = w
+		}
+	}
+	small := image.NewAlpha(image.Rect(0, 0, width+2*labelMargin, len(lines)*face.Height+2*labelMargin))
+	d := font.Drawer{Dst: small, Src: image.Opaque, Face: face}
+	for i, line := range lines {
+		d.Dot = fixed.P(labelMargin, labelMargin+i*face.Height+face.Ascent)
+		d.DrawString(line)
+	}
+
+	// The font is a bitmap, so it's scaled up without smoothing, to stay crisp.
+	r := image.Rect(0, 0, int(math.Round(float64(small.Rect.Dx())*pixelRatio)), int(math.Round(float64(small.Rect.Dy())*pixelRatio)))
+	mask := image.NewAlpha(r)
+	for y := 0; y < r.Max.Y; y++ {
+		for x := 0; x < r.Max.X; x++ {
+			mask.SetAlpha(x, y, small.AlphaAt(int(float64(x)/pixelRatio), int(float64(y)/pixelRatio)))
+		}
+	}
+	return mask
+}
+
+const (
+	errorImageColumns = 48
+	errorImageLines   = 6
+)
+
+// errorImage returns a placeholder for the named file, which couldn't be opened, that says why. It's drawn at twice the size of its text, so that it's legible at the scale that windows start at.
+func errorImage(name string, err error) image.Image {
+	lines := []string{name}
+	for text := err.Error(); text != "" && len(lines) < errorImageLines; {
+		line := text
+		if len(line) > errorImageColumns {
+			line = line[:errorImageColumns]
+			if i := strings.LastIndex(line, " "); i > 0 {
+				line = line[:i+1]
+			}
+		}
+		lines = append(lines, strings.TrimSpace(line))
+		text = text[len(line):]
+	}
+
+	face := basicfont.Face7x13
+	const margin = 6
+	width := 0
+	for _, line := range lines {
+		if w := font.MeasureString(face, line).Ceil(); w > width {
+			width = w
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width+2*margin, len(lines)*face.Height+2*margin))
+	red := color.RGBA{0xc0, 0, 0, 0xff}
+	draw.Draw(img, img.Rect, image.NewUniform(red), image.ZP, draw.Src)
+	draw.Draw(img, img.Rect.Inset(1), image.White, image.ZP, draw.Src)
+	d := font.Drawer{Dst: img, Src: image.NewUniform(red), Face: face}
+	for i, line := range lines {
+		if i == 1 {
+			d.Src = image.Black
+		}
+		d.Dot = fixed.P(margin, margin+i*face.Height+face.Ascent)
+		d.DrawString(line)
+	}
+	return resize.Resize(uint(2*img.Rect.Dx()), uint(2*img.Rect.Dy()), img, resize.NearestNeighbor)
+}
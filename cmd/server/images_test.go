@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/keysym"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewUIFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for name, encode := range map[string]func(io.Writer, image.Image) error{
+		"a.png":  png.Encode,
+		"b.jpg":  func(w io.Writer, m image.Image) error { return jpeg.Encode(w, m, nil) },
+		"c.gif":  func(w io.Writer, m image.Image) error { return gif.Encode(w, m, nil) },
+		"d.bmp":  bmp.Encode,
+		"e.tiff": func(w io.Writer, m image.Image) error { return tiff.Encode(w, m, nil) },
+	} {
+		var buf bytes.Buffer
+		if err := encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var truncated bytes.Buffer
+	png.Encode(&truncated, img)
+	for name, data := range map[string][]byte{
+		"f.png":     truncated.Bytes()[:truncated.Len()/2],
+		"g.webp":    []byte("not really"),
+		"notes.txt": []byte("not an image"),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "more"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ui, err := NewUI(NewFiles(dir, true, "", ""), 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files that aren't images are skipped, but images that fail to load are shown with why.
+	if len(ui.windows) != 7 {
+		t.Fatalf("expected 7 windows, but got %d", len(ui.windows))
+	}
+	for i, win := range ui.windows[:5] {
+		if win.loadErr != nil {
+			t.Errorf("window %d: %v", i, win.loadErr)
+		} else if got := color.RGBAModel.Convert(win.img.At(3, 3)).(color.RGBA); got.R < 0xf0 || got.G < 0xf0 || got.B < 0xf0 {
+			t.Errorf("window %d: expected white, but got %v", i, got)
+		}
+	}
+	for i, want := range map[int]string{5: "decode as png", 6: "unknown format"} {
+		if err := ui.windows[i].loadErr; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("window %d: expected an error with %q, but got %v", i, want, err)
+		}
+	}
+}
+
+func TestExportCrop(t *testing.T) {
+	dir, outputDir := t.TempDir(), t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(img, image.Rect(0, 0, 20, 30), image.NewUniform(color.RGBA{0xff, 0, 0, 0xff}), image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(20, 0, 40, 30), image.NewUniform(color.RGBA{0, 0, 0xff, 0xff}), image.ZP, draw.Src)
+	if err := writePNG(filepath.Join(dir, "a.gif.png"), img); err != nil {
+		t.Fatal(err)
+	}
+
+	ui, err := NewUI(NewFiles(dir, true, outputDir, ""), 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The export is of the crop as the window shows it, flipped, rather than as the file has it.
+	win := ui.windows[0]
+	win.crop = image.Rect(20, 0, 40, 15)
+	win.Flip(true, ui.PixelRatio)
+	for _, pressed := range []bool{true, false} {
+		ui.HandleEvent(&rfb.KeyEventMessage{Pressed: pressed, KeySym: keysym.RuneToKeysym('e')}, &rfb.PointerEventMessage{X: 5, Y: 5})
+	}
+
+	f, err := os.Open(filepath.Join(outputDir, "a.gif-crop.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	exported, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := exported.Bounds(), image.Rect(0, 0, 20, 15); got != want {
+		t.Errorf("expected bounds %v, but got %v", want, got)
+	}
+	if got := color.RGBAModel.Convert(exported.At(0, 0)).(color.RGBA); got != (color.RGBA{0, 0, 0xff, 0xff}) {
+		t.Errorf("expected blue, but got %v", got)
+	}
+
+	if _, err := exportCrop(NewFiles(dir, true, "", ""), win); !errors.Is(err, errReadOnly) {
+		t.Errorf("expected an export in read-only mode to fail with %v, but got %v", errReadOnly, err)
+	}
+}
+
+func TestLabelMask(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		width      int
+		pixelRatio float64
+		want       image.Rectangle
+	}{
+		{"a.png", 100, 1, image.Rect(0, 0, 5*7+4, 17)},
+		{"a.png", 100, 2, image.Rect(0, 0, 2*(5*7+4), 34)},
+		// Too long for the window, so it's cut to 8 characters, ending in "...".
+		{"a-rather-long-name.png", 60, 1, image.Rect(0, 0, 8*7+4, 17)},
+		// At least 4 characters show, however small the window.
+		{"a-rather-long-name.png", 1, 1, image.Rect(0, 0, 4*7+4, 17)},
+	} {
+		if got := labelMask(tt.name, tt.width, tt.pixelRatio).Rect; got != tt.want {
+			t.Errorf("labelMask(%q, %d, %v) is %v, but expected %v", tt.name, tt.width, tt.pixelRatio, got, tt.want)
+		}
+	}
+}
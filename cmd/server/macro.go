@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/keysym"
+	"github.com/alltom/vncfreethumb/vncserver"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// macroStep is a step of a -macro file, which scripts input to play into the UI at startup, one step per line, with # comments:
+//
+//	# Crop the top of the image under (150, 100), then drag it aside.
+//	pointer 150 100
+//	key w
+//	wait 500ms
+//	pointer 60 40 1
+//	pointer 460 240 1
+//	pointer 460 240
+//
+// pointer x y [buttons] moves the pointer to x, y, in logical pixels, with the buttons in the mask held down: 1 for the left button, 2 for the middle, and 4 for the right. key name [down|up] presses or releases a key, or taps it if neither is given; name is a character, one of macroKeys, or a keysym in hex, such as 0xff0d, or 0x23 for #. wait duration pauses for a time in the notation of time.ParseDuration.
+type macroStep struct {
+	wait    time.Duration
+	key     *rfb.KeyEventMessage
+	pointer *rfb.PointerEventMessage
+}
+
+// macroKeys are the names of keys that don't type a character.
+var macroKeys = map[string]uint32{
+	"space": keysym.Space, "Return": keysym.Return, "Tab": keysym.Tab, "Escape": keysym.Escape, "BackSpace": keysym.BackSpace, "Delete": keysym.Delete,
+	"Left": keysym.Left, "Up": keysym.Up, "Right": keysym.Right, "Down": keysym.Down, "Page_Up": keysym.PageUp, "Page_Down": keysym.PageDown,
+	"Shift_L": keysym.ShiftL, "Shift_R": keysym.ShiftR, "Control_L": keysym.ControlL, "Control_R": keysym.ControlR, "Alt_L": keysym.AltL, "Alt_R": keysym.AltR,
+}
+
+func loadMacro(path string) ([]macroStep, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var steps []macroStep
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if idx := strings.Index(text, "#"); idx >= 0 {
+			text = text[:idx]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		parsed, err := parseMacroStep(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		steps = append(steps, parsed...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// parseMacroStep parses the fields of a line, which may be more than one step, as when a key is tapped.
+func parseMacroStep(fields []string) ([]macroStep, error) {
+	args := fields[1:]
+	switch fields[0] {
+	case "wait":
+		if len(args) != 1 {
+			return nil, errors.New("expected wait duration")
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("expected a duration, such as 500ms, but got %q", args[0])
+		}
+		return []macroStep{{wait: d}}, nil
+	case "pointer":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, errors.New("expected pointer x y [buttons]")
+		}
+		var n [3]uint64
+		for i, arg := range args {
+			var err error
+			bits := 16
+			if i == 2 {
+				bits = 8
+			}
+			if n[i], err = strconv.ParseUint(arg, 0, bits); err != nil {
+				return nil, fmt.Errorf("expected pointer x y [buttons], but got %q", arg)
+			}
+		}
+		return []macroStep{{pointer: &rfb.PointerEventMessage{X: uint16(n[0]), Y: uint16(n[1]), ButtonMask: uint8(n[2])}}}, nil
+	case "key":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, errors.New("expected key name [down|up]")
+		}
+		k, err := parseMacroKey(args[0])
+		if err != nil {
+			return nil, err
+		}
+		down := macroStep{key: &rfb.KeyEventMessage{Pressed: true, KeySym: k}}
+		up := macroStep{key: &rfb.KeyEventMessage{KeySym: k}}
+		if len(args) == 1 {
+			return []macroStep{down, up}, nil
+		}
+		switch args[1] {
+		case "down":
+			return []macroStep{down}, nil
+		case "up":
+			return []macroStep{up}, nil
+		}
+		return nil, fmt.Errorf("expected down or up, but got %q", args[1])
+	}
+	return nil, fmt.Errorf("unknown step %q", fields[0])
+}
+
+func parseMacroKey(name string) (uint32, error) {
+	if k, ok := macroKeys[name]; ok {
+		return k, nil
+	}
+	if r, size := utf8.DecodeRuneInString(name); size == len(name) {
+		if k := keysym.RuneToKeysym(r); k != keysym.NoSymbol {
+			return k, nil
+		}
+	}
+	if strings.HasPrefix(name, "0x") {
+		if k, err := strconv.ParseUint(name[2:], 16, 32); err == nil {
+			return uint32(k), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key %q", name)
+}
+
+// playMacro plays steps into d, which server serves, with Update, so that clients see each step as it happens.
+func playMacro(server *vncserver.Server, d *desktop, steps []macroStep) {
+	for _, step := range steps {
+		if step.wait > 0 {
+			time.Sleep(step.wait)
+			continue
+		}
+		server.Update(func() {
+			if step.key != nil {
+				d.HandleKey(*step.key)
+			}
+			if step.pointer != nil {
+				// Macros are in logical pixels, like the layout, so they play the same at any -pixel_ratio.
+				p := *step.pointer
+				p.X = uint16(float64(p.X) * d.ui.PixelRatio)
+				p.Y = uint16(float64(p.Y) * d.ui.PixelRatio)
+				d.HandlePointer(p)
+			}
+		})
+	}
+	log.Print("finished playing macro")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/keysym"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeMacro(t *testing.T, text string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "macro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadMacro(t *testing.T) {
+	path := writeMacro(t, `
+# Crop, then drag.
+pointer 150 100
+key w
+wait 500ms
+pointer 60 40 1  # grab
+key Shift_L down
+key 0x23 up
+`)
+	defer os.Remove(path)
+	steps, err := loadMacro(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []macroStep{
+		{pointer: &rfb.PointerEventMessage{X: 150, Y: 100}},
+		{key: &rfb.KeyEventMessage{Pressed: true, KeySym: 'w'}},
+		{key: &rfb.KeyEventMessage{KeySym: 'w'}},
+		{wait: 500 * time.Millisecond},
+		{pointer: &rfb.PointerEventMessage{ButtonMask: 1, X: 60, Y: 40}},
+		{key: &rfb.KeyEventMessage{Pressed: true, KeySym: keysym.ShiftL}},
+		{key: &rfb.KeyEventMessage{KeySym: '#'}},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("expected %+v, but got %+v", want, steps)
+	}
+}
+
+func TestLoadMacroErrors(t *testing.T) {
+	for _, text := range []string{
+		"pointer 1",
+		"pointer 1 2 256",
+		"key",
+		"key Hyper_Q",
+		"key w sideways",
+		"wait soon",
+		"click 1 2",
+	} {
+		path := writeMacro(t, "wait 1s\n"+text+"\n")
+		_, err := loadMacro(path)
+		os.Remove(path)
+		if err == nil || !strings.Contains(err.Error(), ":2: ") {
+			t.Errorf("%q: expected an error on line 2, but got %v", text, err)
+		}
+	}
+}
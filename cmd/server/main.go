@@ -1,206 +1,552 @@
 package main
 
 import (
-	"bufio"
-	"encoding/binary"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
+	"github.com/alltom/vncfreethumb/extension"
 	"github.com/alltom/vncfreethumb/rfb"
-	"image"
+	"github.com/alltom/vncfreethumb/rfb/otelhooks"
+	"github.com/alltom/vncfreethumb/rfb/websocket"
+	"github.com/alltom/vncfreethumb/vncserver"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"io"
+	"io/ioutil"
 	"log"
+	"math"
 	"net"
+	"net/http"
+	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// connectTimeout is how long -connect waits for the viewer to answer.
+const connectTimeout = 30 * time.Second
+
+// shutdownTimeout is how long clients have to take their last updates when the server is interrupted.
+const shutdownTimeout = 10 * time.Second
+
+// maxFPS is the default -max_fps, which is plenty for dragging windows around.
 const maxFPS = 20
 
 var (
-	addr    = flag.String("addr", "127.0.0.1:5900", "Address to listen for connections on.")
-	runOnce = flag.Bool("run_once", false, "If true, quits after the first disconnect.")
+	configFile   = flag.String("config", "", "If set, reads settings from this file, which flags override. See config.go for the format.")
+	addr         = flag.String("addr", "127.0.0.1:5900", "Address to listen for connections on.")
+	runOnce      = flag.Bool("run_once", false, "If true, quits after the first disconnect.")
+	connect      = flag.String("connect", "", "If set, connects to a viewer listening at this host:port, as for a reverse connection, instead of listening on -addr, and quits when it disconnects.")
+	readOnly     = flag.Bool("read_only", false, "If true, never writes to the image directory or anywhere else, except -output_dir if set.")
+	outputDir    = flag.String("output_dir", "", "Directory to write files such as exports to. Defaults to the image directory.")
+	recordDir    = flag.String("record", "", "If set, records everything the server sends each client to an FBS file in this directory, with timestamps, for replaying sessions. What's sent after the handshake is encrypted with -tls_security, but not with -tls_cert.")
+	width        = flag.Int("width", windowWidth, "Width of the screen, in logical pixels, until a viewer resizes it. $FREETHUMB_WIDTH overrides -config, and the flag overrides both.")
+	height       = flag.Int("height", windowHeight, "Height of the screen, in logical pixels, until a viewer resizes it. $FREETHUMB_HEIGHT overrides -config, and the flag overrides both.")
+	pixelRatio   = flag.Float64("pixel_ratio", 1, "Framebuffer pixels per logical pixel. Use 2 for crisp rendering on HiDPI displays.")
+	password     = flag.String("password", "", "If set, clients must authenticate with this password. With VNC authentication, only the first 8 bytes are significant.")
+	passwordFile = flag.String("password_file", "", "If set, clients must authenticate with the password in the first line of this file.")
+	viewOnly     = flag.Bool("view_only", false, "If true, clients can watch but not control the UI.")
+	viewPassword = flag.String("view_only_password", "", "If set, clients that authenticate with this password instead of -password can watch but not control the UI. Without -password, no client can control it.")
+	rsaKey       = flag.String("rsa_key", "", "PEM file with the RSA private key for the RSA-AES security types (RA2, RA2ne, and their 256-bit variants), which TigerVNC's viewer uses by default. If unset, a key is generated at startup, so viewers that remember the key warn that it changed after every restart.")
+	msLogonFile  = flag.String("mslogon_credentials_file", "", "If set, offers RFB 3.7+ clients UltraVNC's MS-Logon II security type, accepting the username:password pairs on each line of this file.")
+	tlsCert      = flag.String("tls_cert", "", "If set, with -tls_key, wraps every connection in TLS with the certificate in this PEM file, for viewers that connect through stunnel or the like. Independent of -tls_security.")
+	tlsKey       = flag.String("tls_key", "", "PEM file with the private key for -tls_cert.")
+	tlsClientCA  = flag.String("tls_client_ca", "", "If set, with -tls_cert, clients must present a TLS certificate signed by one of the CAs in this PEM file.")
+	tlsSecurity  = flag.Bool("tls_security", false, "If true, offers RFB 3.7+ clients only the TLS security type (18), anonymous TLS as vino and gtk-vnc expect, negotiating authentication inside the tunnel. Clients that offer no anonymous cipher suites are given a self-signed certificate instead.")
+	compression  = flag.Int("compression_level", 6, "zlib compression level, from 0 to 9, for encodings that use it, unless the client asks for another.")
+	jpegQuality  = flag.Int("jpeg_quality", 0, "JPEG quality, from 1 to 100, for Tight encoding of photographic regions, unless the client asks for another. If 0, encoding is lossless.")
+	plugins      stringsFlag
+	theme        = flag.String("theme", "light", "Colors to draw the UI with: \"light\" or \"dark\". The [colors] section of -config and -color change them from there.")
+	colorFlags   stringsFlag
+	fileTransfer = flag.Bool("file_transfer", false, "If true, lets clients download the files in the image directory with UltraVNC file transfer, and upload files unless writes are disabled.")
+	metricsAddr  = flag.String("metrics_addr", "", "If set, serves Prometheus metrics over HTTP at /metrics on this address, such as localhost:9100.")
+	wsAddr       = flag.String("websocket_addr", "", "If set, also accepts connections from browser clients such as noVNC over WebSocket, at /websockify on this HTTP address, such as localhost:6080, and serves a built-in viewer there, so that opening the address in a browser shows the board.")
+	novncDir     = flag.String("novnc_dir", "", "If set, with -websocket_addr, serves the noVNC client in this directory, such as /usr/share/novnc, instead of the built-in viewer.")
+	previewAddr  = flag.String("preview_addr", "", "If set, serves a read-only view of the screen over HTTP on this address, such as localhost:8080: an MJPEG stream at /preview, which browsers show as it changes, and a snapshot at /preview.jpg. It doesn't ask for a password.")
+	debugAddr    = flag.String("debug_addr", "", "If set, serves net/http/pprof profiles at /debug/pprof/ and expvar variables at /debug/vars over HTTP on this address, such as localhost:6060. Don't expose it beyond localhost.")
+	otelStderr   = flag.Bool("otel_stderr", false, "If true, writes OpenTelemetry traces of handshakes, messages, and frames to stderr.")
+	trace        = flag.Bool("trace", false, "If true, logs every message sent and received, with byte counts and timing.")
+	strict       = flag.Bool("strict", false, "If true, disconnects clients that send any message longer than 1 MiB.")
+	hsTimeout    = flag.Duration("handshake_timeout", vncserver.DefaultHandshakeTimeout, "How long clients have to finish the handshake, including any password prompt.")
+	idleTimeout  = flag.Duration("idle_timeout", 0, "If set, disconnects clients that send nothing for this long. Viewers with continuous updates may send nothing while the user is away.")
+	keepAlive    = flag.Duration("tcp_keepalive", 15*time.Second, "How often to probe idle connections, so that those to clients that vanished, such as suspended laptops, are closed. If negative, probes are disabled.")
+	sortOrder    = flag.String("sort", "name", "Order to stack the windows in at startup, from the back: by file \"name\", by \"mtime\", oldest first, by \"size\", smallest first, or \"random\". A layout restored from -session overrides it, and a key cycles through them.")
+	pageSize     = flag.Int("page_size", defaultPageSize, "Most windows to show at a time. Larger directories are shown in pages, which Page Up and Page Down turn, and only the images on the page showing are loaded. If 0, every image is loaded and shown at once.")
+	sessionFile  = flag.String("session", "freethumb-session.json", "File that keeps the layout of the windows, which is restored from it at startup and saved to it as it changes, so that a board survives restarts. Relative to the working directory. Disabled if empty. With -read_only, the layout is restored but only saved if the file is inside -output_dir.")
+	macro        = flag.String("macro", "", "If set, plays the key and pointer events in this file into the UI at startup, for demos, setting up a layout, or reproducing bugs. See macro.go for the format.")
+	fps          = flag.Float64("max_fps", maxFPS, "Most updates per second to send each client. If 0, updates are sent as fast as clients ask for them.")
+	mdns         = flag.Bool("mdns", false, "If true, advertises the server on the local network with mDNS as _rfb._tcp, so viewers such as macOS Finder discover it.")
+	sharing      = flag.String("sharing", "disconnect", "What to do when a client asks for exclusive access: \"disconnect\" the other clients, \"refuse\" the client while others are connected, or \"share\" anyway.")
 )
 
+func init() {
+	flag.Var(&plugins, "plugin", "Path to a Go plugin that extends the server (see package extension). May be repeated.")
+	flag.Var(&colorFlags, "color", "A color to draw the UI with, as name=#rrggbb or name=#rrggbbaa, where name is background, fold, selection, highlight, or label. Overrides -theme and -config. May be repeated.")
+}
+
+// envOverride sets the named flag from the environment variable env, if it's set, unless the flag was given on the command line.
+func envOverride(name, env string) error {
+	value, ok := os.LookupEnv(env)
+	if !ok {
+		return nil
+	}
+	set := false
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	if set {
+		return nil
+	}
+	if err := flag.Set(name, value); err != nil {
+		return fmt.Errorf("invalid value %q for -%s: %v", value, name, err)
+	}
+	return nil
+}
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func main() {
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	var cfg *config
+	dir := ""
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile); err != nil {
+			log.Fatalf("couldn't read config: %v", err)
+		}
+		if dir, err = cfg.applyFlags(); err != nil {
+			log.Fatalf("couldn't apply config: %v", err)
+		}
+	}
+	switch {
+	case flag.NArg() == 1:
+		dir = flag.Arg(0)
+	case flag.NArg() != 0 || dir == "":
 		log.Fatalf("expected one arg, the directory to use, but got %d", flag.NArg())
 	}
-
-	ln, err := net.Listen("tcp", *addr)
-	if err != nil {
-		log.Fatalf("couldn't listen: %v", err)
+	if *pixelRatio <= 0 {
+		log.Fatalf("-pixel_ratio must be positive, but was %v", *pixelRatio)
 	}
-	log.Print("listening…")
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Fatalf("couldn't accept connection: %v", err)
+	for name, env := range map[string]string{"width": "FREETHUMB_WIDTH", "height": "FREETHUMB_HEIGHT"} {
+		if err := envOverride(name, env); err != nil {
+			log.Fatalf("couldn't apply $%s: %v", env, err)
 		}
-		log.Print("accepted connection")
-		go func(conn net.Conn) {
-			if err := rfbServe(conn, flag.Arg(0)); err != nil {
-				log.Printf("serve failed: %v", err)
-			}
-			if err := conn.Close(); err != nil {
-				log.Printf("couldn't close connection: %v", err)
-			}
-		}(conn)
 	}
-}
-
-func rfbServe(conn io.ReadWriter, wdir string) error {
-	var bo = binary.BigEndian
-	var pixelFormat = rfb.PixelFormat{
-		BitsPerPixel: 32,
-		BitDepth:     24,
-		BigEndian:    true,
-		TrueColor:    true,
-
-		RedMax:     255,
-		GreenMax:   255,
-		BlueMax:    255,
-		RedShift:   24,
-		GreenShift: 16,
-		BlueShift:  8,
+	fbWidth, fbHeight := int(math.Round(float64(*width)**pixelRatio)), int(math.Round(float64(*height)**pixelRatio))
+	if *width <= 0 || *height <= 0 || fbWidth > math.MaxUint16 || fbHeight > math.MaxUint16 {
+		log.Fatalf("-width and -height must be positive, and at most %d framebuffer pixels, but were %d and %d", math.MaxUint16, *width, *height)
 	}
-	protocolVersion := rfb.ProtocolVersionMessage{3, 3}
-	authScheme := rfb.AuthenticationSchemeMessageRFB33{rfb.AuthenticationSchemeVNC}
-	var authChallenge rfb.VNCAuthenticationChallengeMessage
-	var authResponse rfb.VNCAuthenticationResponseMessage
-	authResult := rfb.VNCAuthenticationResultMessage{rfb.VNCAuthenticationResultOK}
-	var clientInit rfb.ClientInitialisationMessage
-	var serverInit rfb.ServerInitialisationMessage
-	var keyEvent rfb.KeyEventMessage
-	var pointerEvent rfb.PointerEventMessage
-
-	if err := protocolVersion.Write(conn); err != nil {
-		return fmt.Errorf("write ProtocolVersion: %v", err)
+	sharePolicies := map[string]vncserver.SharePolicy{
+		"disconnect": vncserver.DisconnectOthers,
+		"refuse":     vncserver.RefuseExclusive,
+		"share":      vncserver.AlwaysShare,
 	}
-	if err := protocolVersion.Read(conn); err != nil {
-		return fmt.Errorf("read ProtocolVersion: %v", err)
+	colors, ok := themes[*theme]
+	if !ok {
+		log.Fatalf("-theme must be light or dark, but was %q", *theme)
 	}
-	if protocolVersion.Major != 3 || protocolVersion.Minor != 3 {
-		return fmt.Errorf("only version 3.3 is supported, but client requested %d.%d", protocolVersion.Major, protocolVersion.Minor)
+	if *pageSize < 0 {
+		log.Fatalf("-page_size must not be negative, but was %d", *pageSize)
 	}
-
-	// Using VNC authentication because the built-in macOS client won't connect otherwise. Accepts any password.
-	if err := authScheme.Write(conn, bo); err != nil {
-		return fmt.Errorf("write VNC auth scheme: %v", err)
+	if !isSortOrder(*sortOrder) {
+		log.Fatalf("-sort must be name, mtime, size, or random, but was %q", *sortOrder)
+	}
+	sharePolicy, ok := sharePolicies[*sharing]
+	if !ok {
+		log.Fatalf("-sharing must be disconnect, refuse, or share, but was %q", *sharing)
 	}
-	// Send empty challenge
-	if err := authChallenge.Write(conn); err != nil {
-		return fmt.Errorf("write VNC auth challenge: %v", err)
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls_cert and -tls_key must be set together")
 	}
-	if err := authResponse.Read(conn); err != nil {
-		return fmt.Errorf("read VNC auth response: %v", err)
+	if *tlsClientCA != "" && *tlsCert == "" {
+		log.Fatal("-tls_client_ca requires -tls_cert")
 	}
-	// Always OK
-	if err := authResult.Write(conn, bo); err != nil {
-		return fmt.Errorf("write VNC auth result: %v", err)
+	if *recordDir != "" && *readOnly {
+		log.Fatal("-record writes files, so it can't be used with -read_only")
 	}
 
-	if err := clientInit.Read(conn); err != nil {
-		return fmt.Errorf("read ClientInitialisation: %v", err)
+	if *passwordFile != "" {
+		if *password != "" {
+			log.Fatal("-password and -password_file are mutually exclusive")
+		}
+		contents, err := ioutil.ReadFile(*passwordFile)
+		if err != nil {
+			log.Fatalf("couldn't read password file: %v", err)
+		}
+		*password = strings.TrimRight(strings.SplitN(string(contents), "\n", 2)[0], "\r")
 	}
 
-	ui, err := NewUI(wdir)
+	// Offering VNC authentication, besides RSA-AES, because the built-in macOS client won't connect otherwise. Both accept any password unless -password or -view_only_password is set, in which case only those are accepted.
+	security := &rfb.SecurityHandlers{}
+	if *msLogonFile != "" {
+		credentials, err := readCredentials(*msLogonFile)
+		if err != nil {
+			log.Fatalf("couldn't read MS-Logon credentials: %v", err)
+		}
+		security.Register(&rfb.MSLogonIISecurityHandler{Verify: func(username, password string) bool {
+			expected, ok := credentials[username]
+			return ok && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+		}})
+	}
+	key, err := loadRSAKey(*rsaKey)
 	if err != nil {
-		return fmt.Errorf("create UI: %v", err)
+		log.Fatalf("couldn't load RSA key: %v", err)
 	}
-	if *runOnce {
-		log.Println("quitting…")
-		defer os.Exit(0)
+	for _, securityType := range []rfb.SecurityType{rfb.SecurityTypeRA256, rfb.SecurityTypeRA2, rfb.SecurityTypeRAne256, rfb.SecurityTypeRA2ne} {
+		security.Register(&rfb.RSAAESSecurityHandler{SecurityType: securityType, Key: key, Password: *password, ViewOnlyPassword: *viewPassword})
 	}
-
-	serverInit = rfb.ServerInitialisationMessage{
-		FramebufferWidth:  uint16(ui.Width),
-		FramebufferHeight: uint16(ui.Height),
-		PixelFormat:       pixelFormat,
-		Name:              ui.Title,
-	}
-	if err := serverInit.Write(conn, bo); err != nil {
-		return fmt.Errorf("write ServerInitialisation: %v", err)
+	security.Register(&rfb.VNCSecurityHandler{Password: *password, ViewOnlyPassword: *viewPassword})
+	// With -tls_security, every other security type is offered only inside the tunnel, so that none of them can be chosen in the clear.
+	if *tlsSecurity {
+		tlsConfig, err := newSelfSignedTLSConfig()
+		if err != nil {
+			log.Fatalf("couldn't configure TLS: %v", err)
+		}
+		inner := security
+		security = &rfb.SecurityHandlers{}
+		security.Register(&rfb.TLSSecurityHandler{Config: tlsConfig, Inner: inner})
 	}
 
-	r := bufio.NewReader(conn)
-	w := bufio.NewWriter(conn)
+	files := NewFiles(dir, *readOnly, *outputDir, *recordDir)
 
-	for {
-		messageType, err := r.Peek(1)
-		if err != nil {
-			return fmt.Errorf("read message type: %v", err)
+	registry := extension.NewRegistry()
+	registerBuiltinEncoders(registry, *compression, *jpegQuality)
+	for _, path := range plugins {
+		if err := registry.Load(path); err != nil {
+			log.Fatalf("couldn't load plugin %q: %v", path, err)
 		}
-		switch messageType[0] {
-		case 0: // SetPixelFormat
-			var m rfb.SetPixelFormatMessage
-			if err := m.Read(r, bo); err != nil {
-				return fmt.Errorf("read SetPixelFormat: %v", err)
-			}
-			pixelFormat = m.PixelFormat
+	}
 
-		case 2: // SetEncodings
-			var m rfb.SetEncodingsMessage
-			if err := m.Read(r, bo); err != nil {
-				return fmt.Errorf("read SetEncodings: %v", err)
-			}
-			// Nothing to do.
+	var hooks rfb.Hooks = rfb.NopHooks{}
+	if *otelStderr {
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+		if err != nil {
+			log.Fatalf("couldn't create trace exporter: %v", err)
+		}
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		hooks = otelhooks.New(provider.Tracer("github.com/alltom/vncfreethumb/cmd/server"))
+	}
+	var serverMetrics *metrics
+	if *metricsAddr != "" {
+		serverMetrics = newMetrics()
+		hooks = rfb.JoinHooks(hooks, serverMetrics)
+	}
 
-		case 3: // FramebufferUpdateRequest
-			var m rfb.FramebufferUpdateRequestMessage
-			if err := m.Read(r, bo); err != nil {
-				return fmt.Errorf("read FramebufferUpdateRequest: %v", err)
-			}
+	ui, err := NewUI(files, *pixelRatio, registry.Tools, &UIOptions{PageSize: *pageSize, SortOrder: *sortOrder})
+	if err != nil {
+		log.Fatalf("couldn't create UI: %v", err)
+	}
+	ui.Colors = colors
+	if cfg != nil {
+		if err := cfg.applyUI(ui); err != nil {
+			log.Fatalf("couldn't apply config: %v", err)
+		}
+	}
+	for _, setting := range colorFlags {
+		if err := applyColor(ui, setting); err != nil {
+			log.Fatalf("couldn't apply -color: %v", err)
+		}
+	}
+	ui.Resize(fbWidth, fbHeight)
+	opts := &vncserver.Options{
+		Name:             "freethumb",
+		Security:         security,
+		Hooks:            hooks,
+		Encoders:         registry.Encoders,
+		XVPHandler:       registry.XVPHandler,
+		Strict:           *strict,
+		HandshakeTimeout: *hsTimeout,
+		IdleTimeout:      *idleTimeout,
+		MaxFPS:           *fps,
+		ViewOnly:         *viewOnly,
+		SharePolicy:      sharePolicy,
+	}
+	if *fileTransfer {
+		opts.Files = fileTransferHandler{files}
+	}
+	if serverMetrics != nil {
+		opts.Stats = &serverMetrics.stats
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", serverMetrics)
+		if err := serveHTTP(*metricsAddr, mux); err != nil {
+			log.Fatalf("couldn't serve metrics: %v", err)
+		}
+	}
+	if *debugAddr != "" {
+		// net/http/pprof and expvar register their handlers with http.DefaultServeMux, which nothing else serves.
+		expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+		if err := serveHTTP(*debugAddr, http.DefaultServeMux); err != nil {
+			log.Fatalf("couldn't serve debug endpoints: %v", err)
+		}
+	}
+	sessionPath := *sessionFile
+	if sessionPath != "" {
+		var err error
+		if sessionPath, err = filepath.Abs(sessionPath); err != nil {
+			log.Fatalf("couldn't find session: %v", err)
+		}
+		l, err := loadLayout(sessionPath)
+		if err != nil {
+			log.Fatalf("couldn't load session: %v", err)
+		}
+		if l != nil {
+			ui.SetLayout(*l)
+		}
+	}
+	var macroSteps []macroStep
+	if *macro != "" {
+		if macroSteps, err = loadMacro(*macro); err != nil {
+			log.Fatalf("couldn't load macro: %v", err)
+		}
+	}
+	d := &desktop{ui: ui}
+	server := vncserver.NewServer(d, opts)
 
-			r := image.Rect(int(m.X), int(m.Y), int(m.X)+int(m.Width), int(m.Y)+int(m.Height))
-			img := image.NewRGBA(r)
-			ui.Update(img, &keyEvent, &pointerEvent)
+	var tlsConfig *tls.Config
+	if *tlsCert != "" {
+		if tlsConfig, err = newListenerTLSConfig(*tlsCert, *tlsKey, *tlsClientCA); err != nil {
+			log.Fatalf("couldn't configure TLS listener: %v", err)
+		}
+	}
 
-			img2, err := rfb.NewPixelFormatImage(pixelFormat, r)
+	// With -run_once or -connect, the first session to get past the handshake ends the server when it ends.
+	finished := make(chan error, 1)
+	serve := func(conn net.Conn) {
+		if *recordDir != "" {
+			recorded, err := recordConn(conn, files)
 			if err != nil {
-				return fmt.Errorf("create PixelFormatImage: %v", err)
+				log.Printf("couldn't start recording: %v", err)
+			} else {
+				conn = recorded
 			}
-			if err := img2.CopyFromRGBA(img); err != nil {
-				return fmt.Errorf("serialize image: %v", err)
+		}
+		if *trace {
+			conn = rfb.TraceConn(conn, log.New(log.Writer(), conn.RemoteAddr().String()+" ", log.Flags()))
+		}
+		err := server.ServeConn(context.Background(), conn)
+		var protocolErr *rfb.ProtocolError
+		var handshakeErr *vncserver.HandshakeError
+		started := !errors.As(err, &handshakeErr)
+		switch {
+		case errors.Is(err, io.EOF):
+			log.Print("client disconnected")
+		case errors.Is(err, vncserver.ErrDisplaced), errors.Is(err, vncserver.ErrServerClosed), errors.Is(err, vncserver.ErrIdle):
+			log.Printf("disconnected: %v", err)
+		case errors.As(err, &protocolErr):
+			log.Printf("client broke the protocol: %v", err)
+		case err != nil:
+			log.Printf("serve failed: %v", err)
+		}
+		if err := conn.Close(); err != nil {
+			log.Printf("couldn't close connection: %v", err)
+		}
+		if (started && *runOnce) || *connect != "" {
+			select {
+			case finished <- err:
+			default:
 			}
+		}
+	}
 
-			var update rfb.FramebufferUpdateMessage
-			update.Rectangles = []*rfb.FramebufferUpdateRect{
-				&rfb.FramebufferUpdateRect{
-					X: m.X, Y: m.Y, Width: m.Width, Height: m.Height,
-					EncodingType: 0, PixelData: img2.Pix,
-				},
-			}
-			if err := update.Write(w, bo); err != nil {
-				return fmt.Errorf("write FramebufferUpdate: %v", err)
-			}
-			if err := w.Flush(); err != nil {
-				return fmt.Errorf("flush FramebufferUpdate: %v", err)
+	var ln net.Listener
+	var advertiser *mdnsAdvertiser
+	acceptErr := make(chan error, 1)
+	if *connect != "" {
+		// A reverse connection: the viewer listens, and the server speaks first as usual once it's connected.
+		dialer := net.Dialer{Timeout: connectTimeout, KeepAlive: *keepAlive}
+		conn, err := dialer.Dial("tcp", *connect)
+		if err != nil {
+			log.Fatalf("couldn't connect to viewer: %v", err)
+		}
+		log.Printf("connected to %s", *connect)
+		if tlsConfig != nil {
+			conn = tls.Server(conn, tlsConfig)
+		}
+		go serve(conn)
+	} else {
+		lc := net.ListenConfig{KeepAlive: *keepAlive}
+		if ln, err = lc.Listen(context.Background(), "tcp", *addr); err != nil {
+			log.Fatalf("couldn't listen: %v", err)
+		}
+		if tlsConfig != nil {
+			ln = tls.NewListener(ln, tlsConfig)
+		}
+		log.Print("listening…")
+		if *mdns {
+			tcpAddr := ln.Addr().(*net.TCPAddr)
+			if tcpAddr.IP.IsLoopback() {
+				log.Printf("advertising with mDNS, but only listening on %v, which other hosts can't reach", tcpAddr.IP)
 			}
-
-		case 4: // KeyEvent
-			if err := keyEvent.Read(r, bo); err != nil {
-				return fmt.Errorf("read KeyEvent: %v", err)
+			if advertiser, err = advertiseMDNS("freethumb", tcpAddr.Port); err != nil {
+				log.Fatalf("couldn't advertise with mDNS: %v", err)
 			}
-			ui.Update(image.NewNRGBA(image.ZR), &keyEvent, &pointerEvent)
-
-		case 5: // PointerEvent
-			if err := pointerEvent.Read(r, bo); err != nil {
-				return fmt.Errorf("read PointerEvent: %v", err)
+		}
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					acceptErr <- err
+					return
+				}
+				log.Print("accepted connection")
+				go serve(conn)
 			}
-			ui.Update(image.NewNRGBA(image.ZR), &keyEvent, &pointerEvent)
+		}()
+	}
 
-		case 6: // ClientCutText
-			var m rfb.ClientCutTextMessage
-			if err := m.Read(r, bo); err != nil {
-				return fmt.Errorf("read ClientCutText: %v", err)
+	if *wsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/websockify", websocket.Handler(func(conn net.Conn) {
+			log.Print("accepted WebSocket connection")
+			serve(conn)
+		}))
+		if *novncDir != "" {
+			if _, err := os.Stat(filepath.Join(*novncDir, "vnc.html")); err != nil {
+				log.Fatalf("couldn't find noVNC: %v", err)
 			}
-			// Ignore.
+			files := http.FileServer(http.Dir(*novncDir))
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/" {
+					// noVNC connects to /websockify on the host that served it by default.
+					http.Redirect(w, r, "/vnc.html?autoconnect=true", http.StatusFound)
+					return
+				}
+				files.ServeHTTP(w, r)
+			})
+		} else {
+			mux.HandleFunc("/", serveViewer)
+		}
+		if err := serveHTTP(*wsAddr, mux); err != nil {
+			log.Fatalf("couldn't serve WebSocket: %v", err)
+		}
+	} else if *novncDir != "" {
+		log.Fatal("-novnc_dir needs -websocket_addr")
+	}
+	if *previewAddr != "" {
+		if err := serveHTTP(*previewAddr, (&preview{server, d}).handler()); err != nil {
+			log.Fatalf("couldn't serve preview: %v", err)
+		}
+	}
+	if macroSteps != nil {
+		go playMacro(server, d, macroSteps)
+	}
+	// Tooltips show once the pointer rests, which no client says, so the UI is told the time.
+	go func() {
+		for now := range time.Tick(tickInterval) {
+			server.Update(func() { ui.Tick(now) })
+		}
+	}()
+	sessionDone, sessionSaved := make(chan struct{}), make(chan struct{})
+	if sessionPath != "" && files.CanWrite(sessionPath) {
+		saver := &sessionSaver{files: files, name: sessionPath, server: server, d: d}
+		go func() {
+			saver.run(sessionDone)
+			close(sessionSaved)
+		}()
+	} else {
+		if sessionPath != "" {
+			log.Printf("read-only: not saving the session to %s, which is outside -output_dir", sessionPath)
+		}
+		close(sessionSaved)
+	}
 
-		default:
-			return fmt.Errorf("received unrecognized message type %d", messageType[0])
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case sig := <-signals:
+		log.Printf("got %v, shutting down…", sig)
+	case err := <-finished:
+		log.Println("quitting…")
+		// The client hanging up is how sessions normally end.
+		if !errors.Is(err, io.EOF) {
+			exitCode = 1
+		}
+	case err := <-acceptErr:
+		log.Printf("couldn't accept connection: %v", err)
+		exitCode = 1
+	}
+	// Another signal kills the process, in case shutting down hangs.
+	signal.Stop(signals)
+	if advertiser != nil {
+		if err := advertiser.Close(); err != nil {
+			log.Printf("couldn't stop advertising with mDNS: %v", err)
 		}
 	}
+	if ln != nil {
+		if err := ln.Close(); err != nil {
+			log.Printf("couldn't close listener: %v", err)
+		}
+	}
+	if !shutdown(server) {
+		exitCode = 1
+	}
+	// The last changes, from clients that were just disconnected, are saved too.
+	close(sessionDone)
+	<-sessionSaved
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
 
+// serveHTTP serves handler on addr in the background, for endpoints such as -metrics_addr.
+func serveHTTP(addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(ln, handler); err != nil {
+			log.Printf("couldn't serve HTTP on %s: %v", addr, err)
+		}
+	}()
 	return nil
 }
+
+// shutdown ends every session, giving them shutdownTimeout to finish what they're sending, and reports whether they all did.
+func shutdown(server *vncserver.Server) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx, "freethumb is shutting down"); err != nil {
+		log.Printf("couldn't shut down cleanly: %v", err)
+		return false
+	}
+	return true
+}
+
+// readCredentials reads a file of username:password lines.
+func readCredentials(path string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make(map[string]string)
+	for idx, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected username:password", idx+1)
+		}
+		credentials[parts[0]] = parts[1]
+	}
+	return credentials, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// mDNS, from RFC 6762, and DNS-SD, from RFC 6763, as much as it takes to advertise one service.
+const (
+	mdnsPort = 5353
+
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+
+	dnsClassIN    = 1
+	dnsCacheFlush = 0x8000 // in the class of records that only this host answers for
+	dnsUnicast    = 0x8000 // in the class of questions that ask for a unicast reply
+
+	// TTLs that RFC 6762 recommends for records with host names and for the rest.
+	mdnsHostTTL  = 120
+	mdnsOtherTTL = 4500
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+// rfbService is the DNS-SD service type of VNC servers.
+var rfbService = []string{"_rfb", "_tcp", "local"}
+
+// mdnsAdvertiser answers mDNS queries for a VNC server on the local network.
+type mdnsAdvertiser struct {
+	conn     *net.UDPConn
+	instance []string // the service instance's name, such as "freethumb on host._rfb._tcp.local"
+	host     []string // the host's name, such as "host.local"
+	port     int
+	ips      []net.IP
+}
+
+// advertiseMDNS announces the server listening on port as name, and answers queries for it until Close.
+func advertiseMDNS(name string, port int) (*mdnsAdvertiser, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("get host name: %v", err)
+	}
+	hostname = strings.SplitN(hostname, ".", 2)[0]
+	var ips []net.IP
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("list addresses: %v", err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			ips = append(ips, ipNet.IP.To4())
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no IPv4 addresses to advertise")
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, fmt.Errorf("listen for mDNS: %v", err)
+	}
+
+	a := &mdnsAdvertiser{
+		conn:     conn,
+		instance: append([]string{name + " on " + hostname}, rfbService...),
+		host:     []string{hostname, "local"},
+		port:     port,
+		ips:      ips,
+	}
+	go a.serve()
+	go func() {
+		// RFC 6762 has announcements sent at least twice, a second apart.
+		for i := 0; i < 2; i++ {
+			if err := a.send(a.answers(dnsTypeANY, mdnsOtherTTL), mdnsGroup, 0, nil); err != nil {
+				log.Printf("couldn't announce mDNS service: %v", err)
+				return
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+	return a, nil
+}
+
+// Close withdraws the service, telling other hosts to forget it, and stops answering queries.
+func (a *mdnsAdvertiser) Close() error {
+	if err := a.send(a.answers(dnsTypeANY, 0), mdnsGroup, 0, nil); err != nil {
+		log.Printf("couldn't withdraw mDNS service: %v", err)
+	}
+	return a.conn.Close()
+}
+
+func (a *mdnsAdvertiser) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		id, questions, err := parseDNSQuery(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, q := range questions {
+			answers := a.answersFor(q)
+			if answers == nil {
+				continue
+			}
+			// Queries from other ports are from simple resolvers, which expect a unicast reply to their ID, as in plain DNS. So do queries that ask for one.
+			to, replyID, question := mdnsGroup, uint16(0), (*dnsQuestion)(nil)
+			if from.Port != mdnsPort {
+				to, replyID, question = from, id, &q
+			} else if q.class&dnsUnicast != 0 {
+				to = from
+			}
+			if err := a.send(answers, to, replyID, question); err != nil {
+				log.Printf("couldn't answer mDNS query: %v", err)
+			}
+		}
+	}
+}
+
+type dnsQuestion struct {
+	name       []string
+	typ, class uint16
+}
+
+type dnsRecord struct {
+	name       []string
+	typ, class uint16
+	ttl        uint32
+	data       []byte
+}
+
+// answersFor returns the records that answer q, or nil if it isn't about this service.
+func (a *mdnsAdvertiser) answersFor(q dnsQuestion) []dnsRecord {
+	wants := func(typ uint16) bool { return q.typ == typ || q.typ == dnsTypeANY }
+	switch {
+	case sameDNSName(q.name, rfbService) && wants(dnsTypePTR):
+		return a.answers(dnsTypeANY, mdnsOtherTTL)
+	case sameDNSName(q.name, a.instance) && (wants(dnsTypeSRV) || wants(dnsTypeTXT)):
+		return a.answers(dnsTypeANY, mdnsOtherTTL)[1:]
+	case sameDNSName(q.name, a.host) && wants(dnsTypeA):
+		return a.answers(dnsTypeA, mdnsHostTTL)
+	}
+	return nil
+}
+
+// answers returns the PTR, SRV, TXT, and A records of the service, or only the A records if typ is dnsTypeA. If ttl is 0, they withdraw the service. Host records never live longer than mdnsHostTTL.
+func (a *mdnsAdvertiser) answers(typ uint16, ttl uint32) []dnsRecord {
+	hostTTL := ttl
+	if hostTTL > mdnsHostTTL {
+		hostTTL = mdnsHostTTL
+	}
+	var records []dnsRecord
+	if typ != dnsTypeA {
+		srv := make([]byte, 6)
+		binary.BigEndian.PutUint16(srv[4:], uint16(a.port))
+		records = append(records,
+			dnsRecord{rfbService, dnsTypePTR, dnsClassIN, ttl, encodeDNSName(a.instance)},
+			dnsRecord{a.instance, dnsTypeSRV, dnsClassIN | dnsCacheFlush, hostTTL, append(srv, encodeDNSName(a.host)...)},
+			// DNS-SD requires a TXT record, even an empty one.
+			dnsRecord{a.instance, dnsTypeTXT, dnsClassIN | dnsCacheFlush, ttl, []byte{0}},
+		)
+	}
+	for _, ip := range a.ips {
+		records = append(records, dnsRecord{a.host, dnsTypeA, dnsClassIN | dnsCacheFlush, hostTTL, ip})
+	}
+	return records
+}
+
+// send writes a response with answers to to. Replies to plain DNS queries repeat the question.
+func (a *mdnsAdvertiser) send(answers []dnsRecord, to *net.UDPAddr, id uint16, question *dnsQuestion) error {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400) // a response, and authoritative
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	if question != nil {
+		binary.BigEndian.PutUint16(msg[4:], 1)
+		msg = append(msg, encodeDNSName(question.name)...)
+		msg = append(msg, byte(question.typ>>8), byte(question.typ), 0, dnsClassIN)
+	}
+	for _, r := range answers {
+		msg = append(msg, encodeDNSName(r.name)...)
+		var fixed [10]byte
+		binary.BigEndian.PutUint16(fixed[0:], r.typ)
+		binary.BigEndian.PutUint16(fixed[2:], r.class)
+		binary.BigEndian.PutUint32(fixed[4:], r.ttl)
+		binary.BigEndian.PutUint16(fixed[8:], uint16(len(r.data)))
+		msg = append(append(msg, fixed[:]...), r.data...)
+	}
+	_, err := a.conn.WriteToUDP(msg, to)
+	return err
+}
+
+// parseDNSQuery returns the ID and questions of a DNS query.
+func parseDNSQuery(msg []byte) (uint16, []dnsQuestion, error) {
+	if len(msg) < 12 {
+		return 0, nil, errors.New("message too short")
+	}
+	if binary.BigEndian.Uint16(msg[2:])&0x8000 != 0 {
+		return 0, nil, errors.New("not a query")
+	}
+	var questions []dnsQuestion
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil {
+			return 0, nil, err
+		}
+		if next+4 > len(msg) {
+			return 0, nil, errors.New("question too short")
+		}
+		questions = append(questions, dnsQuestion{name, binary.BigEndian.Uint16(msg[next:]), binary.BigEndian.Uint16(msg[next+2:])})
+		off = next + 4
+	}
+	return binary.BigEndian.Uint16(msg[0:]), questions, nil
+}
+
+// readDNSName reads the name at off in msg, following compression pointers, and returns it with the offset just past it.
+func readDNSName(msg []byte, off int) ([]string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return nil, 0, errors.New("name runs past the end of the message")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return labels, end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return nil, 0, errors.New("name runs past the end of the message")
+			}
+			if jumps++; jumps > 16 {
+				return nil, 0, errors.New("too many compression pointers")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		case n&0xc0 != 0:
+			return nil, 0, fmt.Errorf("unsupported label type %#x", n&0xc0)
+		default:
+			if off+1+n > len(msg) {
+				return nil, 0, errors.New("label runs past the end of the message")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+func encodeDNSName(labels []string) []byte {
+	var b []byte
+	for _, label := range labels {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b = append(append(b, byte(len(label))), label...)
+	}
+	return append(b, 0)
+}
+
+// sameDNSName compares names as DNS does, ignoring ASCII case.
+func sameDNSName(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"image"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// encodeBuckets are the upper bounds, in seconds, of the encode latency histogram's buckets.
+var encodeBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// metrics collects statistics across every connection as rfb.Hooks and serves them in the Prometheus text format.
+type metrics struct {
+	rfb.NopHooks
+
+	// stats counts messages, rectangles, and bytes, fed by every connection's rfb.Conn.
+	stats rfb.Stats
+
+	mu           sync.Mutex
+	connected    int
+	connections  int
+	authFailures int
+	encodeCounts []int // by bucket of encodeBuckets, plus one for slower frames
+	encodeSum    float64
+}
+
+var _ rfb.Hooks = (*metrics)(nil)
+
+func newMetrics() *metrics {
+	return &metrics{encodeCounts: make([]int, len(encodeBuckets)+1)}
+}
+
+func (m *metrics) Connection(ctx context.Context, remoteAddr string) (context.Context, func(err error)) {
+	m.mu.Lock()
+	m.connected++
+	m.connections++
+	m.mu.Unlock()
+	return ctx, func(error) {
+		m.mu.Lock()
+		m.connected--
+		m.mu.Unlock()
+	}
+}
+
+func (m *metrics) HandshakePhase(ctx context.Context, phase string) func(err error) {
+	return func(err error) {
+		if err != nil && phase == "Authentication" {
+			m.mu.Lock()
+			m.authFailures++
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *metrics) EncodeFrame(ctx context.Context, rect image.Rectangle) func(bytes int, err error) {
+	start := time.Now()
+	return func(int, error) {
+		seconds := time.Since(start).Seconds()
+		bucket := sort.SearchFloat64s(encodeBuckets, seconds)
+		m.mu.Lock()
+		m.encodeCounts[bucket]++
+		m.encodeSum += seconds
+		m.mu.Unlock()
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snapshot := m.stats.Snapshot()
+	m.mu.Lock()
+	connected, connections, authFailures := m.connected, m.connections, m.authFailures
+	encodeCounts, encodeSum := append([]int(nil), m.encodeCounts...), m.encodeSum
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	b := bufio.NewWriter(w)
+	defer b.Flush()
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("freethumb_clients_connected", "gauge", "Clients connected now, including those still in the handshake.")
+	fmt.Fprintf(b, "freethumb_clients_connected %d\n", connected)
+	metric("freethumb_connections_total", "counter", "Connections accepted.")
+	fmt.Fprintf(b, "freethumb_connections_total %d\n", connections)
+	metric("freethumb_auth_failures_total", "counter", "Handshakes that failed authentication.")
+	fmt.Fprintf(b, "freethumb_auth_failures_total %d\n", authFailures)
+	metric("freethumb_frames_total", "counter", "Framebuffer updates rendered and sent.")
+	fmt.Fprintf(b, "freethumb_frames_total %d\n", snapshot.Frames)
+	metric("freethumb_send_seconds_total", "counter", "Time spent writing to clients, which grows when they read slowly.")
+	fmt.Fprintf(b, "freethumb_send_seconds_total %g\n", snapshot.SendTime.Seconds())
+
+	for _, dir := range []struct {
+		name     string
+		messages map[string]rfb.MessageStats
+	}{{"received", snapshot.Received}, {"sent", snapshot.Sent}} {
+		var names []string
+		for name := range dir.messages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		metric("freethumb_messages_"+dir.name+"_total", "counter", "Messages "+dir.name+", by type.")
+		for _, name := range names {
+			fmt.Fprintf(b, "freethumb_messages_%s_total{type=%q} %d\n", dir.name, name, dir.messages[name].Count)
+		}
+		metric("freethumb_message_bytes_"+dir.name+"_total", "counter", "Bytes of messages "+dir.name+", by type.")
+		for _, name := range names {
+			fmt.Fprintf(b, "freethumb_message_bytes_%s_total{type=%q} %d\n", dir.name, name, dir.messages[name].Bytes)
+		}
+	}
+
+	var encodingTypes []uint32
+	for encodingType := range snapshot.Rectangles {
+		encodingTypes = append(encodingTypes, encodingType)
+	}
+	sort.Slice(encodingTypes, func(i, j int) bool { return encodingTypes[i] < encodingTypes[j] })
+	metric("freethumb_rectangles_total", "counter", "FramebufferUpdate rectangles sent, by encoding.")
+	for _, encodingType := range encodingTypes {
+		fmt.Fprintf(b, "freethumb_rectangles_total{encoding=%q} %d\n", rfb.EncodingName(encodingType), snapshot.Rectangles[encodingType])
+	}
+	metric("freethumb_rectangle_bytes_total", "counter", "Bytes of FramebufferUpdate rectangles sent, headers included, by encoding.")
+	for _, encodingType := range encodingTypes {
+		fmt.Fprintf(b, "freethumb_rectangle_bytes_total{encoding=%q} %d\n", rfb.EncodingName(encodingType), snapshot.RectangleBytes[encodingType])
+	}
+
+	metric("freethumb_frame_encode_seconds", "histogram", "Time to render, encode, and write each framebuffer update.")
+	total := 0
+	for idx, le := range encodeBuckets {
+		total += encodeCounts[idx]
+		fmt.Fprintf(b, "freethumb_frame_encode_seconds_bucket{le=\"%g\"} %d\n", le, total)
+	}
+	total += encodeCounts[len(encodeBuckets)]
+	fmt.Fprintf(b, "freethumb_frame_encode_seconds_bucket{le=\"+Inf\"} %d\n", total)
+	fmt.Fprintf(b, "freethumb_frame_encode_seconds_sum %g\n", encodeSum)
+	fmt.Fprintf(b, "freethumb_frame_encode_seconds_count %d\n", total)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// defaultPageSize is the most windows that show at a time unless UIOptions say otherwise, which is also the default -page_size.
+const defaultPageSize = 100
+
+// pages returns how many pages the files take up, which is at least one.
+func (ui *UI) pages() int {
+	if ui.pageSize <= 0 || len(ui.entries) <= ui.pageSize {
+		return 1
+	}
+	return (len(ui.entries) + ui.pageSize - 1) / ui.pageSize
+}
+
+// turnPage shows the page step pages after this one, or before if step is negative, as far as there are pages.
+func (ui *UI) turnPage(step int) {
+	if n := ui.page + step; n >= 0 && n < ui.pages() {
+		ui.loadPage(n)
+	}
+}
+
+// loadPage shows page n, counting from 0, loading the images on it that aren't loaded already, and arranging them as they were when it last showed. The windows of other pages are let go.
+func (ui *UI) loadPage(n int) {
+	if n >= ui.pages() {
+		n = ui.pages() - 1
+	}
+	ui.offPage = ui.Layout()
+
+	loaded := make(map[string]*Window, len(ui.windows))
+	for _, win := range ui.windows {
+		loaded[win.name] = win
+	}
+	entries := ui.entries
+	if ui.pageSize > 0 {
+		entries = entries[n*ui.pageSize:]
+		if len(entries) > ui.pageSize {
+			entries = entries[:ui.pageSize]
+		}
+	}
+	var windows []*Window
+	for _, info := range entries {
+		win, ok := loaded[info.Name()]
+		if !ok {
+			win = ui.newWindow(info)
+		}
+		if win != nil {
+			win.moving, win.selected = false, false
+			windows = append(windows, win)
+		}
+	}
+	ui.windows, ui.page = windows, n
+	ui.band, ui.cropping = image.ZR, false
+	ui.hover.tooltip = nil
+	ui.arrange(ui.offPage)
+
+	ui.pageLabel = nil
+	if pages := ui.pages(); pages > 1 {
+		ui.pageLabel = textMask([]string{fmt.Sprintf("page %d of %d", n+1, pages)}, ui.PixelRatio)
+	}
+}
+
+// pageLabelRect returns where the page indicator is, in framebuffer pixels, at the bottom right of the screen, or the empty rectangle if everything fits on one page.
+func (ui *UI) pageLabelRect() image.Rectangle {
+	if ui.pageLabel == nil {
+		return image.ZR
+	}
+	margin := pmulf(image.Pt(gridMargin, gridMargin), ui.PixelRatio)
+	size := ui.pageLabel.Rect.Size()
+	return ui.pageLabel.Rect.Add(image.Pt(ui.Width, ui.Height).Sub(size).Sub(margin))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/keysym"
+	"image"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPages(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		if err := writePNG(filepath.Join(dir, fmt.Sprintf("%d.png", i)), image.NewGray(image.Rect(0, 0, 20, 10))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := NewFiles(dir, true, "", "")
+	ui, err := NewUI(files, 1, nil, &UIOptions{PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func() []string {
+		var names []string
+		for _, win := range ui.windows {
+			names = append(names, win.name)
+		}
+		return names
+	}
+	turn := func(sym uint32) {
+		for _, pressed := range []bool{true, false} {
+			ui.HandleEvent(&rfb.KeyEventMessage{Pressed: pressed, KeySym: sym}, &rfb.PointerEventMessage{})
+		}
+	}
+
+	if want := []string{"0.png", "1.png"}; !reflect.DeepEqual(names(), want) {
+		t.Fatalf("expected only %v to be loaded, but got %v", want, names())
+	}
+	if ui.pageLabel == nil {
+		t.Error("expected a page indicator")
+	}
+	ui.windows[1].pos = image.Pt(300, 200)
+	ui.Damage()
+
+	// The windows of the first page go, so the screen where they were is damaged.
+	turn(keysym.PageDown)
+	if want := []string{"2.png", "3.png"}; !reflect.DeepEqual(names(), want) {
+		t.Errorf("expected page 2 to show %v, but got %v", want, names())
+	}
+	if damage := ui.Damage(); !containsRect(damage, image.Rect(300, 200, 310, 205)) {
+		t.Errorf("expected where 1.png was to be damaged, but got %v", damage)
+	}
+
+	// The last page has what's left, and there's no page after it.
+	turn(keysym.PageDown)
+	turn(keysym.PageDown)
+	if want := []string{"4.png"}; ui.page != 2 || !reflect.DeepEqual(names(), want) {
+		t.Errorf("expected page 3 to show %v, but page %d shows %v", want, ui.page+1, names())
+	}
+
+	// Turning back finds windows as they were left, and the session keeps them meanwhile.
+	if l := ui.Layout(); len(l.Windows) != 5 {
+		t.Errorf("expected the layout of all 5 windows, but got %+v", l)
+	}
+	turn(keysym.PageUp)
+	turn(keysym.PageUp)
+	turn(keysym.PageUp)
+	if ui.page != 0 || ui.windows[1].pos != image.Pt(300, 200) {
+		t.Errorf("expected 1.png to be back where it was on page 1, but page %d shows it at %v", ui.page+1, ui.windows[1].pos)
+	}
+
+	if ui, err = NewUI(files, 1, nil, &UIOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ui.windows) != 5 || ui.pageLabel != nil {
+		t.Errorf("expected all 5 windows on one page without an indicator, but got %v", names())
+	}
+}
+
+// containsRect returns whether r is in one of rects.
+func containsRect(rects []image.Rectangle, r image.Rectangle) bool {
+	for _, s := range rects {
+		if r.In(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"github.com/alltom/vncfreethumb/vncserver"
+	"image"
+	"image/jpeg"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+const (
+	// previewInterval is how often /preview checks whether the screen changed.
+	previewInterval = 200 * time.Millisecond
+
+	previewQuality = 80
+)
+
+// preview serves a read-only view of the screen over HTTP, for glancing at the session from a browser: /preview streams it as MJPEG, sending a frame whenever it changes, and /preview.jpg is a snapshot.
+type preview struct {
+	server *vncserver.Server
+	d      *desktop
+}
+
+func (p *preview) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/preview", p.serveStream)
+	mux.HandleFunc("/preview.jpg", p.serveSnapshot)
+	return mux
+}
+
+// frame returns a copy of the screen as clients see it.
+func (p *preview) frame() *image.RGBA {
+	var frame *image.RGBA
+	// Update keeps the desktop to itself, so the frame isn't drawn while clients handle events.
+	p.server.Update(func() {
+		img := p.d.Render(image.Rect(0, 0, p.d.ui.Width, p.d.ui.Height)).(*image.RGBA)
+		frame = &image.RGBA{Pix: append([]byte(nil), img.Pix...), Stride: img.Stride, Rect: img.Rect}
+	})
+	return frame
+}
+
+func (p *preview) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, p.frame(), &jpeg.Options{Quality: previewQuality}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+func (p *preview) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	w.Header().Set("Cache-Control", "no-store")
+
+	ticker := time.NewTicker(previewInterval)
+	defer ticker.Stop()
+	var last []byte
+	var buf bytes.Buffer
+	for {
+		if frame := p.frame(); !bytes.Equal(frame.Pix, last) {
+			buf.Reset()
+			if err := jpeg.Encode(&buf, frame, &jpeg.Options{Quality: previewQuality}); err != nil {
+				log.Printf("couldn't encode preview: %v", err)
+				return
+			}
+			part, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":   {"image/jpeg"},
+				"Content-Length": {strconv.Itoa(buf.Len())},
+			})
+			if err != nil {
+				return
+			}
+			if _, err := part.Write(buf.Bytes()); err != nil {
+				return
+			}
+			flusher.Flush()
+			last = frame.Pix
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
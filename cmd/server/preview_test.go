@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/alltom/vncfreethumb/vncserver"
+	"image/color"
+	"image/jpeg"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPreviewSnapshot(t *testing.T) {
+	ui, err := NewUI(NewFiles("testdata/fixtures", true, "", ""), 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &desktop{ui: ui}
+	p := &preview{vncserver.NewServer(d, nil), d}
+
+	rec := httptest.NewRecorder()
+	p.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/preview.jpg", nil))
+	if got := rec.Header().Get("Content-Type"); got != "image/jpeg" {
+		t.Fatalf("expected a JPEG, but got %q", got)
+	}
+	img, err := jpeg.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Size(); got.X != ui.Width || got.Y != ui.Height {
+		t.Errorf("expected the whole %dx%d screen, but got %v", ui.Width, ui.Height, got)
+	}
+	// JPEG is lossy, but the background is flat.
+	r, g, b, _ := img.At(ui.Width-10, ui.Height-10).RGBA()
+	got := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xff}
+	if want := defaultColors.Background.(color.RGBA); absDiff(got.R, want.R) > 4 || absDiff(got.G, want.G) > 4 || absDiff(got.B, want.B) > 4 {
+		t.Errorf("expected the background, %v, but got %v", want, got)
+	}
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
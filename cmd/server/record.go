@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb/fbs"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordingConn copies what the server writes to conn into an FBS file, whose timestamps start when the connection did.
+type recordingConn struct {
+	net.Conn
+	f *os.File
+
+	mu sync.Mutex
+	w  *fbs.Writer // nil once writing to the file failed
+}
+
+var addrReplacer = strings.NewReplacer(":", "_", "[", "", "]", "")
+
+// recordConn starts recording conn to a new file in files' record directory, named for the time and the client's address.
+func recordConn(conn net.Conn, files *Files) (net.Conn, error) {
+	name := fmt.Sprintf("%s-%s.fbs", time.Now().Format("20060102-150405.000"), addrReplacer.Replace(conn.RemoteAddr().String()))
+	f, err := files.CreateRecording(name)
+	if err != nil {
+		return nil, err
+	}
+	w, err := fbs.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	log.Printf("recording to %s", f.Name())
+	return &recordingConn{Conn: conn, f: f, w: w}, nil
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.mu.Lock()
+		if c.w != nil {
+			if _, err := c.w.Write(p[:n]); err != nil {
+				// The session is more important than its recording.
+				log.Printf("couldn't record to %s, so stopped: %v", c.f.Name(), err)
+				c.w = nil
+			}
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *recordingConn) Close() error {
+	err := c.Conn.Close()
+	if ferr := c.f.Close(); ferr != nil && err == nil {
+		err = fmt.Errorf("close recording: %w", ferr)
+	}
+	return err
+}
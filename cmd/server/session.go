@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncfreethumb/vncserver"
+	"image"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// sessionInterval is how often the layout is checked for changes to save.
+const sessionInterval = time.Second
+
+// layout is how the windows are arranged, as a -session file saves it, from the back window to the front.
+type layout struct {
+	Windows []windowLayout `json:"windows"`
+}
+
+// windowLayout is where a window is, in logical pixels, and what it shows. Crops are in the coordinates of the image once it's flipped.
+type windowLayout struct {
+	Name     string          `json:"name"`
+	Pos      image.Point     `json:"pos"`
+	Scale    float64         `json:"scale"`
+	Crop     image.Rectangle `json:"crop"`
+	LastCrop image.Rectangle `json:"last_crop"`
+	FlipX    bool            `json:"flip_x,omitempty"`
+	FlipY    bool            `json:"flip_y,omitempty"`
+}
+
+// Layout returns how the windows are arranged now, those on the page showing first, then those on other pages as they were when their page last showed, or as SetLayout left them.
+func (ui *UI) Layout() layout {
+	var l layout
+	shown := make(map[string]bool, len(ui.windows))
+	for _, win := range ui.windows {
+		shown[win.name] = true
+		l.Windows = append(l.Windows, windowLayout{Name: win.name, Pos: win.pos, Scale: win.scale, Crop: win.crop, LastCrop: win.lastCrop, FlipX: win.flipX, FlipY: win.flipY})
+	}
+	for _, wl := range ui.offPage.Windows {
+		if !shown[wl.Name] {
+			l.Windows = append(l.Windows, wl)
+		}
+	}
+	return l
+}
+
+// SetLayout arranges the windows as l says, matching them by name, including those on other pages, for when their page shows.
+func (ui *UI) SetLayout(l layout) {
+	ui.offPage = l
+	ui.arrange(l)
+}
+
+// arrange arranges the windows on the page as l says. Windows that l doesn't mention, such as images added since it was saved, go in front, where they are; those that l mentions but aren't on the page are ignored.
+func (ui *UI) arrange(l layout) {
+	byName := make(map[string]*Window, len(ui.windows))
+	for _, win := range ui.windows {
+		byName[win.name] = win
+	}
+	var windows []*Window
+	for _, wl := range l.Windows {
+		win, ok := byName[wl.Name]
+		if !ok {
+			continue
+		}
+		delete(byName, wl.Name)
+		if wl.FlipX != win.flipX {
+			win.Flip(true, ui.PixelRatio)
+		}
+		if wl.FlipY != win.flipY {
+			win.Flip(false, ui.PixelRatio)
+		}
+		// The image may have changed since, so crops are kept to it.
+		b := win.img.Bounds()
+		win.crop, win.lastCrop = b, b
+		if crop := wl.Crop.Intersect(b); !crop.Empty() {
+			win.crop = crop
+		}
+		if crop := wl.LastCrop.Intersect(b); !crop.Empty() {
+			win.lastCrop = crop
+		}
+		win.pos = wl.Pos
+		if wl.Scale > 0 && wl.Scale != win.scale {
+			win.scale = wl.Scale
+			win.Render(ui.PixelRatio)
+		}
+		windows = append(windows, win)
+	}
+	for _, win := range ui.windows {
+		if _, ok := byName[win.name]; ok {
+			windows = append(windows, win)
+		}
+	}
+	ui.windows = windows
+}
+
+// loadLayout reads the layout saved at path. It returns nil if there's no file there yet.
+func loadLayout(path string) (*layout, error) {
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var l layout
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &l, nil
+}
+
+// sessionSaver saves the layout of d, which server serves, to a -session file whenever it changes, through files.
+type sessionSaver struct {
+	files  *Files
+	name   string
+	server *vncserver.Server
+	d      *desktop
+	saved  []byte
+}
+
+// save writes the layout if it changed since the last save.
+func (s *sessionSaver) save() {
+	var l layout
+	s.server.Update(func() { l = s.d.ui.Layout() })
+	data, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		log.Printf("couldn't encode session: %v", err)
+		return
+	}
+	data = append(data, '\n')
+	if bytes.Equal(data, s.saved) {
+		return
+	}
+	if err := s.files.WriteFile(s.name, data); err != nil {
+		log.Printf("couldn't save session: %v", err)
+		return
+	}
+	s.saved = data
+}
+
+// run saves the layout every sessionInterval until done is closed, and once more then.
+func (s *sessionSaver) run(done <-chan struct{}) {
+	ticker := time.NewTicker(sessionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.save()
+		case <-done:
+			s.save()
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLayoutRoundTrip(t *testing.T) {
+	files := NewFiles("testdata/fixtures", true, "", "")
+	ui, err := NewUI(files, 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Raise a.png to the front, then move, scale, crop, and flip it.
+	ui.moveToFront(0)
+	win := ui.windows[1]
+	win.pos = image.Pt(300, 200)
+	win.scale = 0.25
+	win.crop = image.Rect(10, 20, 110, 220)
+	win.Flip(false, ui.PixelRatio)
+	want := ui.Layout()
+
+	// The session goes in the output directory, even in read-only mode.
+	output := NewFiles("testdata/fixtures", true, t.TempDir(), "")
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := output.WriteFile("session.json", data); err != nil {
+		t.Fatal(err)
+	}
+	l, err := loadLayout(output.OutputPath("session.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewUI(files, 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.SetLayout(*l)
+	if got := restored.Layout(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the layout to be restored as %+v, but got %+v", want, got)
+	}
+	if got := restored.windows[1]; !reflect.DeepEqual(got.img, win.img) {
+		t.Error("expected a.png to be flipped again")
+	}
+}
+
+func TestSetLayoutChanges(t *testing.T) {
+	ui, err := NewUI(NewFiles("testdata/fixtures", true, "", ""), 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The session only knows about b.png, and a gone.png, and b.png has shrunk since.
+	ui.SetLayout(layout{Windows: []windowLayout{
+		{Name: "gone.png", Pos: image.Pt(1, 2), Scale: 1},
+		{Name: "b.png", Pos: image.Pt(50, 60), Scale: 1, Crop: image.Rect(200, 100, 400, 300), LastCrop: image.Rect(500, 500, 600, 600)},
+	}})
+	if len(ui.windows) != 2 || ui.windows[0].name != "b.png" || ui.windows[1].name != "a.png" {
+		t.Fatalf("expected b.png behind a.png, which is new to the session, but got %+v", ui.Layout())
+	}
+	b := ui.windows[0]
+	if want := image.Rect(200, 100, 240, 160); b.crop != want {
+		t.Errorf("expected the crop to be kept to the image, as %v, but got %v", want, b.crop)
+	}
+	if want := b.img.Bounds(); b.lastCrop != want {
+		t.Errorf("expected a crop outside the image to be dropped for %v, but got %v", want, b.lastCrop)
+	}
+	if a := ui.windows[1]; a.pos != image.ZP || a.scale != 0.5 {
+		t.Errorf("expected a.png to stay where it was, but it's at %v at scale %v", a.pos, a.scale)
+	}
+}
+
+func TestWriteFileReadOnly(t *testing.T) {
+	dir, output := t.TempDir(), t.TempDir()
+	if err := NewFiles(dir, true, "", "").WriteFile("session.json", []byte("{}")); !errors.Is(err, errReadOnly) {
+		t.Errorf("expected a write in read-only mode to fail with %v, but got %v", errReadOnly, err)
+	}
+	// With an output directory, names that lead out of it are refused too.
+	files := NewFiles(dir, true, output, "")
+	for _, name := range []string{filepath.Join(dir, "session.json"), filepath.Join("..", filepath.Base(dir), "session.json")} {
+		if files.CanWrite(name) {
+			t.Errorf("expected %s not to be writable in read-only mode", name)
+		}
+		if err := files.WriteFile(name, []byte("{}")); !errors.Is(err, errReadOnly) {
+			t.Errorf("expected writing %s in read-only mode to fail with %v, but got %v", name, errReadOnly, err)
+		}
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*")); len(names) != 0 {
+		t.Errorf("expected nothing to be written, but found %v", names)
+	}
+	if err := files.WriteFile(filepath.Join(output, "session.json"), []byte("{}")); err != nil {
+		t.Errorf("expected a write inside the output directory to succeed, but got %v", err)
+	}
+}
+
+func TestLoadLayoutMissing(t *testing.T) {
+	l, err := loadLayout(filepath.Join(t.TempDir(), "session.json"))
+	if l != nil || err != nil {
+		t.Errorf("expected no layout and no error without a session file, but got %v and %v", l, err)
+	}
+}
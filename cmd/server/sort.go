@@ -0,0 +1,101 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// sortOrders are the orders that -sort chooses from and Keys.Sort cycles through: by file name, by when files were modified, oldest first, by file size, smallest first, and shuffled.
+var sortOrders = []string{"name", "mtime", "size", "random"}
+
+// isSortOrder returns whether order is one of sortOrders.
+func isSortOrder(order string) bool {
+	for _, o := range sortOrders {
+		if o == order {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSortOrder pages through the files in order, one of sortOrders, and stacks the windows on the page in it, from the back to the front, which Shift-grid then lays them out in. Each time the order becomes random, it shuffles them anew.
+func (ui *UI) SetSortOrder(order string) {
+	ui.sortEntries(order)
+	ui.loadPage(ui.page)
+	ui.sortWindows(ui.windows)
+}
+
+// sortEntries makes order the sort order and puts the files in it, without loading the page again.
+func (ui *UI) sortEntries(order string) {
+	if order == "random" {
+		ui.shuffle = uint32(time.Now().UnixNano())
+	}
+	ui.order = order
+	sort.SliceStable(ui.entries, func(i, j int) bool {
+		return ui.lessFile(ui.entries[i].Name(), ui.entries[j].Name(), ui.entries[i], ui.entries[j])
+	})
+}
+
+// cycleSortOrder stacks the windows in the sort order after the current one. Undo puts them back.
+func (ui *UI) cycleSortOrder() {
+	next := sortOrders[0]
+	for i, order := range sortOrders[:len(sortOrders)-1] {
+		if order == ui.order {
+			next = sortOrders[i+1]
+		}
+	}
+	ui.undoLayout()
+	ui.SetSortOrder(next)
+	log.Printf("sorting by %s", next)
+}
+
+// sortWindows sorts windows in the UI's sort order.
+func (ui *UI) sortWindows(windows []*Window) {
+	sort.SliceStable(windows, func(i, j int) bool {
+		return ui.lessFile(windows[i].name, windows[j].name, windows[i].info, windows[j].info)
+	})
+}
+
+// lessFile returns whether the file named a, with info ai, which may be nil, comes before b in the UI's sort order. Files that tie come by name.
+func (ui *UI) lessFile(a, b string, ai, bi os.FileInfo) bool {
+	switch ui.order {
+	case "mtime":
+		if at, bt := modTime(ai), modTime(bi); !at.Equal(bt) {
+			return at.Before(bt)
+		}
+	case "size":
+		if as, bs := fileSize(ai), fileSize(bi); as != bs {
+			return as < bs
+		}
+	case "random":
+		if ah, bh := ui.shuffleHash(a), ui.shuffleHash(b); ah != bh {
+			return ah < bh
+		}
+	}
+	return a < b
+}
+
+// shuffleHash returns where the named file goes in the random order, which is the same until the order is shuffled again.
+func (ui *UI) shuffleHash(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte{byte(ui.shuffle), byte(ui.shuffle >> 8), byte(ui.shuffle >> 16), byte(ui.shuffle >> 24)})
+	h.Write([]byte(name))
+	return h.Sum32()
+}
+
+func modTime(info os.FileInfo) time.Time {
+	if info == nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func fileSize(info os.FileInfo) int64 {
+	if info == nil {
+		return 0
+	}
+	return info.Size()
+}
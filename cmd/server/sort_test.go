@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/keysym"
+	"image"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSortOrder(t *testing.T) {
+	dir := t.TempDir()
+	// a.png is the biggest and the newest, c.png the smallest, and b.png the oldest.
+	for name, size := range map[string]int{"a.png": 30, "b.png": 20, "c.png": 10} {
+		// Noise doesn't compress, so bigger images make bigger files.
+		img := image.NewGray(image.Rect(0, 0, size, size))
+		rand.New(rand.NewSource(1)).Read(img.Pix)
+		if err := writePNG(filepath.Join(dir, name), img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"a.png": 0, "b.png": 2 * time.Hour, "c.png": time.Hour} {
+		if err := os.Chtimes(filepath.Join(dir, name), now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := NewFiles(dir, true, "", "")
+	// Sorted from the start, the first page is of the first files in the order.
+	ui, err := NewUI(files, 1, nil, &UIOptions{PageSize: 1, SortOrder: "size"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func() []string {
+		var names []string
+		for _, win := range ui.windows {
+			names = append(names, win.name)
+		}
+		return names
+	}
+	if want := []string{"c.png"}; !reflect.DeepEqual(names(), want) {
+		t.Errorf("expected the first page to be %v, but got %v", want, names())
+	}
+
+	if ui, err = NewUI(files, 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		order string
+		want  []string
+	}{
+		{"mtime", []string{"b.png", "c.png", "a.png"}},
+		{"size", []string{"c.png", "b.png", "a.png"}},
+		{"name", []string{"a.png", "b.png", "c.png"}},
+	} {
+		if ui.SetSortOrder(tt.order); !reflect.DeepEqual(names(), tt.want) {
+			t.Errorf("sorted by %s, expected %v, but got %v", tt.order, tt.want, names())
+		}
+	}
+
+	// Shuffling keeps every window, and the order holds until it's shuffled again.
+	ui.SetSortOrder("random")
+	shuffled := names()
+	ui.sortWindows(ui.windows)
+	if got := names(); !reflect.DeepEqual(got, shuffled) {
+		t.Errorf("expected the random order to hold as %v, but got %v", shuffled, got)
+	}
+	sort.Strings(shuffled)
+	if want := []string{"a.png", "b.png", "c.png"}; !reflect.DeepEqual(shuffled, want) {
+		t.Errorf("expected the shuffle of %v, but got %v", want, shuffled)
+	}
+
+	// The key cycles from the last order back to the first, and undo reverses it.
+	before := ui.Layout()
+	for _, pressed := range []bool{true, false} {
+		ui.HandleEvent(&rfb.KeyEventMessage{Pressed: pressed, KeySym: keysym.RuneToKeysym('o')}, &rfb.PointerEventMessage{})
+	}
+	if want := []string{"a.png", "b.png", "c.png"}; ui.order != "name" || !reflect.DeepEqual(names(), want) {
+		t.Errorf("expected the key to sort by name, as %v, but got %v by %s", want, names(), ui.order)
+	}
+	ui.undo()
+	if got := ui.Layout(); !reflect.DeepEqual(got, before) {
+		t.Errorf("expected undo to restore %+v, but got %+v", before, got)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"time"
+)
+
+// newSelfSignedTLSConfig returns a TLS configuration with a self-signed certificate generated on the spot, for clients of the TLS security type that offer no anonymous Diffie-Hellman cipher suites but accept any certificate.
+func newSelfSignedTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "freethumb"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate: %v", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}, nil
+}
+
+// newListenerTLSConfig returns a TLS configuration for wrapping whole connections, as stunnel does, with the certificate and key in the given PEM files. If clientCAFile isn't empty, clients must present a certificate signed by one of the CAs in it.
+func newListenerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAFile != "" {
+		contents, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CAs: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(contents) {
+			return nil, fmt.Errorf("no certificates in %q", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// loadRSAKey returns the RSA key for the RSA-AES security types, from the PEM file at path, in PKCS #1 or PKCS #8, or, if path is empty, a 2048-bit key generated on the spot.
+func loadRSAKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("generate key: %v", err)
+		}
+		return key, nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %q", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse %q: %v", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%q holds a %T, not an RSA key", path, parsed)
+	}
+	return key, nil
+}
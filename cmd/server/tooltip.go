@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"time"
+)
+
+const (
+	// tooltipDelay is how long the pointer rests over a window before its tooltip shows.
+	tooltipDelay = 800 * time.Millisecond
+
+	// tickInterval is how often the UI is told the time, to show tooltips.
+	tickInterval = 100 * time.Millisecond
+)
+
+// tooltipOffset is where a tooltip goes from the pointer, in logical pixels, clear of the arrow.
+var tooltipOffset = image.Pt(12, 18)
+
+// hover follows the pointer, which shows a tooltip once it rests over a window.
+type hover struct {
+	loc     image.Point
+	since   time.Time // When the pointer came to rest at loc, or zero if no tooltip is due
+	tooltip *image.Alpha
+	at      image.Point // Where tooltip goes, in framebuffer pixels
+}
+
+// move notes the pointer's location, hiding the tooltip if it moved. A tooltip isn't due while buttons are held.
+func (ui *UI) move(loc image.Point, buttonMask uint8) {
+	h := &ui.hover
+	if loc != h.loc || buttonMask != 0 {
+		h.loc, h.since, h.tooltip = loc, time.Now(), nil
+	}
+	if buttonMask != 0 {
+		h.since = time.Time{}
+	}
+}
+
+// Tick tells the UI the time, so that it shows the tooltip of the window under the pointer once the pointer has rested there long enough.
+func (ui *UI) Tick(now time.Time) {
+	h := &ui.hover
+	if h.since.IsZero() || now.Sub(h.since) < tooltipDelay {
+		return
+	}
+	h.since = time.Time{}
+	var win *Window
+	for _, w := range ui.windows {
+		if h.loc.In(w.ScreenRect()) {
+			win = w
+		}
+	}
+	if win == nil {
+		return
+	}
+
+	k := ui.PixelRatio
+	h.tooltip = textMask(win.Info(), k)
+	// The tooltip stays on the screen, moving to the other side of the pointer if need be.
+	size := h.tooltip.Rect.Size()
+	h.at = pmulf(h.loc.Add(tooltipOffset), k)
+	if h.at.X+size.X > ui.Width {
+		h.at.X = int(math.Max(0, float64(ui.Width-size.X)))
+	}
+	if h.at.Y+size.Y > ui.Height {
+		h.at.Y = int(math.Max(0, float64(pmulf(h.loc, k).Y-size.Y)))
+	}
+}
+
+// tooltipRect returns where the tooltip is, in framebuffer pixels, or the empty rectangle if there's none.
+func (ui *UI) tooltipRect() image.Rectangle {
+	if ui.hover.tooltip == nil {
+		return image.ZR
+	}
+	return ui.hover.tooltip.Rect.Add(ui.hover.at)
+}
+
+// drawTooltip draws the tooltip, if any.
+func (ui *UI) drawTooltip(img draw.Image) {
+	if r := ui.tooltipRect(); !r.Empty() {
+		ui.drawTextBox(img, r, ui.hover.tooltip)
+	}
+}
+
+// drawTextBox draws text, a mask from textMask, at r as a box of the background color outlined and written in the label color.
+func (ui *UI) drawTextBox(img draw.Image, r image.Rectangle, text *image.Alpha) {
+	label := image.NewUniform(ui.Colors.Label)
+	border := int(math.Max(1, math.Round(ui.PixelRatio)))
+	draw.Draw(img, r, label, image.ZP, draw.Src)
+	draw.Draw(img, r.Inset(border), image.NewUniform(ui.Colors.Background), image.ZP, draw.Src)
+	draw.DrawMask(img, r, label, image.ZP, text, image.ZP, draw.Over)
+}
+
+// Info returns what the window's tooltip says about its file: the name, the image's size, and the file's size and when it was modified.
+func (win *Window) Info() []string {
+	b := win.img.Bounds()
+	lines := []string{win.name}
+	if win.loadErr == nil {
+		lines = append(lines, fmt.Sprintf("%d x %d pixels", b.Dx(), b.Dy()))
+	}
+	if win.info != nil {
+		lines = append(lines, formatSize(win.info.Size()), "modified "+win.info.ModTime().Format("2006-01-02 15:04"))
+	}
+	return lines
+}
+
+// formatSize returns n bytes in the largest unit of which there's at least one.
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	units := []string{"KiB", "MiB", "GiB"}
+	size, i := float64(n)/1024, 0
+	for size >= 1024 && i < len(units)-1 {
+		size, i = size/1024, i+1
+	}
+	return fmt.Sprintf("%.1f %s", size, units[i])
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTooltip(t *testing.T) {
+	dir := t.TempDir()
+	if err := writePNG(filepath.Join(dir, "a.png"), image.NewRGBA(image.Rect(0, 0, 400, 300))); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2021, 3, 4, 5, 6, 0, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(dir, "a.png"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	ui, err := NewUI(NewFiles(dir, true, "", ""), 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &desktop{ui: ui}
+	info, err := os.Stat(filepath.Join(dir, "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ui.windows[0].Info(), []string{"a.png", "400 x 300 pixels", formatSize(info.Size()), "modified 2021-03-04 05:06"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the tooltip to say %q, but got %q", want, got)
+	}
+
+	// Events to desktop are in framebuffer pixels, so this is (100, 100).
+	playEvents(d, script(pointer(200, 200, 0)))
+	d.Damage()
+	ui.Tick(time.Now())
+	if ui.hover.tooltip != nil {
+		t.Fatal("expected no tooltip before the pointer rests")
+	}
+	ui.Tick(time.Now().Add(tooltipDelay))
+	r := ui.tooltipRect()
+	if want := image.Pt(2*(100+tooltipOffset.X), 2*(100+tooltipOffset.Y)); r.Min != want {
+		t.Errorf("expected a tooltip at %v, but got %v", want, r)
+	}
+	if damage := nonEmpty(d.Damage()); len(damage) != 1 || damage[0] != r {
+		t.Errorf("expected the tooltip, at %v, to be damaged, but got %v", r, damage)
+	}
+
+	// Moving hides it, and it doesn't show over the background.
+	playEvents(d, script(pointer(600, 600, 0)))
+	if damage := nonEmpty(d.Damage()); len(damage) != 1 || damage[0] != r {
+		t.Errorf("expected where the tooltip was, %v, to be damaged, but got %v", r, damage)
+	}
+	ui.Tick(time.Now().Add(tooltipDelay))
+	if ui.hover.tooltip != nil {
+		t.Error("expected no tooltip over the background")
+	}
+}
+
+func nonEmpty(rects []image.Rectangle) []image.Rectangle {
+	var nonEmpty []image.Rectangle
+	for _, r := range rects {
+		if !r.Empty() {
+			nonEmpty = append(nonEmpty, r)
+		}
+	}
+	return nonEmpty
+}
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int64]string{0: "0 bytes", 1023: "1023 bytes", 1536: "1.5 KiB", 5 << 20: "5.0 MiB", 3 << 40: "3072.0 GiB"} {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) is %q, but expected %q", n, got, want)
+		}
+	}
+}
@@ -1,48 +1,158 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"github.com/alltom/vncfreethumb/extension"
 	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/keysym"
+	"github.com/alltom/vncfreethumb/vncserver"
 	"github.com/nfnt/resize"
 	"image"
 	"image/color"
 	"image/draw"
-	_ "image/png"
-	"io/ioutil"
 	"log"
 	"math"
 	"os"
-	"path/filepath"
-)
-
-var (
-	foldColor = image.NewUniform(color.RGBA{0, 0, 0xff, 0xff})
+	"unicode"
 )
 
+// The size of the screen that NewUI makes, in logical pixels, which is also the default -width and -height.
 const (
 	windowWidth  = 1200
 	windowHeight = 720
 )
 
+// Keys are the keys that crop, flip, and export the window under the pointer, and that act on every window, as lowercase characters. Page Up and Page Down, which aren't characters, turn the page.
+type Keys struct {
+	CropTop, CropLeft, CropBottom, CropRight rune
+
+	// FlipHorizontal mirrors the window left to right, and FlipVertical top to bottom.
+	FlipHorizontal, FlipVertical rune
+
+	// Export writes what the window shows, at the image's full resolution, to a file beside the image's.
+	Export rune
+
+	// SendToBack puts the window behind all the others, and Lower and Raise move it behind or in front of the next window that it overlaps.
+	SendToBack, Lower, Raise rune
+
+	// ResetCrops shows every window's whole image, wherever the pointer is, and Undo reverses that or the last arrangement.
+	ResetCrops, Undo rune
+
+	// Grid lays the windows out in a grid, from back to front, or in the sort order if shifted, and Cascade stacks them diagonally, as they overlap.
+	Grid, Cascade rune
+
+	// Sort stacks the windows in the next of sortOrders.
+	Sort rune
+
+	// Labels shows or hides the file name under each window.
+	Labels rune
+
+	// Exif shows or hides an overlay on the window of what its EXIF data says about the photo.
+	Exif rune
+}
+
+var defaultKeys = Keys{CropTop: 'w', CropLeft: 'a', CropBottom: 's', CropRight: 'd', FlipHorizontal: 'h', FlipVertical: 'v', Export: 'e', SendToBack: 'b', Lower: '[', Raise: ']', ResetCrops: 'r', Undo: 'z', Grid: 'g', Cascade: 'c', Sort: 'o', Labels: 'l', Exif: 'i'}
+
+// Colors are what the UI draws with, other than images.
+type Colors struct {
+	// Background is behind the windows, Fold marks the edges where windows are cropped, Selection covers the rectangle being dragged out to crop a window or select windows, Highlight outlines the selected windows, and Label is the text of their labels, which are on the background color.
+	Background, Fold, Selection, Highlight, Label color.Color
+}
+
+var defaultColors = Colors{
+	Background: color.RGBA{0xee, 0xee, 0xee, 0xff},
+	Fold:       color.RGBA{0, 0, 0xff, 0xff},
+	Selection:  color.NRGBA{0xb7, 0x96, 0xd4, 0x88},
+	Highlight:  color.RGBA{0x8a, 0x5c, 0xb4, 0xff},
+	Label:      color.RGBA{0x33, 0x33, 0x33, 0xff},
+}
+
+// themes are the presets of Colors that -theme chooses from.
+var themes = map[string]Colors{
+	"light": defaultColors,
+	"dark": {
+		Background: color.RGBA{0x20, 0x20, 0x20, 0xff},
+		Fold:       color.RGBA{0x4d, 0x9f, 0xff, 0xff},
+		Selection:  color.NRGBA{0x8a, 0x6c, 0xc4, 0x88},
+		Highlight:  color.RGBA{0xb4, 0x8e, 0xe0, 0xff},
+		Label:      color.RGBA{0xdd, 0xdd, 0xdd, 0xff},
+	},
+}
+
 type UI struct {
-	Title         string
 	Width, Height int
 
-	windows     []*Window
-	pendingCrop image.Rectangle
+	// PixelRatio is the number of framebuffer pixels per logical pixel. Layout and input are in logical pixels.
+	PixelRatio float64
+
+	Keys   Keys
+	Colors Colors
+
+	files    *Files
+	entries  []os.FileInfo // The files that may be images, in the sort order, which pages show pageSize at a time
+	pageSize int           // If 0, every file is on one page
+	page     int
+	offPage  layout // How windows not on the page were last arranged
+	windows  []*Window
+	band     image.Rectangle // The rectangle being dragged out, to crop a window or to select the windows in it
+	cropping bool
+	labels   bool   // Whether windows show their file names
+	order    string // One of sortOrders
+	shuffle  uint32 // Seeds the random sort order
+	hover    hover
+	tools    []extension.Tool
+
+	pageLabel *image.Alpha // Says which page shows, if there's more than one
+
+	arrowCursor, crosshairCursor *vncserver.Cursor
 
 	keyPressing  bool
+	undo         func() // If set, reverses the last command that can be undone
 	eventHandler func(keyEvent *rfb.KeyEventMessage, pointerEvent *rfb.PointerEventMessage)
+
+	// How the screen looked at the last call to Damage.
+	drawnWindows     map[*Window]windowDrawing
+	drawnBand        image.Rectangle
+	drawnTooltip     *image.Alpha
+	drawnTooltipRect image.Rectangle
+	drawnPageLabel   *image.Alpha
+	drawnPageRect    image.Rectangle
+	drawnScreen      image.Rectangle
+}
+
+// windowDrawing is how a window was drawn: where, in framebuffer pixels, including its folds, its highlight, its label, its EXIF overlay, and the shadow it leaves while being dragged, what it showed, and how far from the back it was.
+type windowDrawing struct {
+	rect     image.Rectangle
+	crop     image.Rectangle
+	scaled   image.Image
+	label    *image.Alpha
+	exif     *image.Alpha
+	moving   bool
+	selected bool
+	z        int
 }
 
 type Window struct {
+	name           string      // The file that img was loaded from
+	info           os.FileInfo // The file's, if known
 	img            image.Image
+	loadErr        error // If set, img is a placeholder that says why the file couldn't be loaded
+	flipX, flipY   bool  // Whether img is mirrored from the file, left to right and top to bottom
 	crop, lastCrop image.Rectangle
 	scale          float64
 	scaled         image.Image
 
-	pos    image.Point
-	moving bool
+	pos      image.Point
+	moving   bool
+	selected bool
+
+	// label is the window's label, for labelWidth, at labelRatio.
+	label      *image.Alpha
+	labelWidth int
+	labelRatio float64
+
+	exif *image.Alpha // If set, the EXIF overlay shows, saying this
 }
 
 func (win *Window) ScreenRect() image.Rectangle {
@@ -72,77 +182,371 @@ func rmulf(r image.Rectangle, k float64) image.Rectangle {
 	return image.Rectangle{pmulf(r.Min, k), pmulf(r.Max, k)}
 }
 
-func (win *Window) Render() {
-	r := rmulf(win.img.Bounds(), win.scale)
+// Flip mirrors the window left to right if horizontal, or top to bottom otherwise. Crops are in the coordinates of img, so they're mirrored too, to keep showing the same part of it.
+func (win *Window) Flip(horizontal bool, pixelRatio float64) {
+	b := win.img.Bounds()
+	flipped := image.NewRGBA(b)
+	draw.Draw(flipped, b, win.img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	if horizontal {
+		for y := 0; y < h; y++ {
+			row := flipped.Pix[y*flipped.Stride : y*flipped.Stride+4*w]
+			for i, j := 0, 4*(w-1); i < j; i, j = i+4, j-4 {
+				for k := 0; k < 4; k++ {
+					row[i+k], row[j+k] = row[j+k], row[i+k]
+				}
+			}
+		}
+		win.flipX = !win.flipX
+	} else {
+		tmp := make([]byte, 4*w)
+		for y := 0; y < h/2; y++ {
+			top := flipped.Pix[y*flipped.Stride : y*flipped.Stride+4*w]
+			bottom := flipped.Pix[(h-1-y)*flipped.Stride : (h-1-y)*flipped.Stride+4*w]
+			copy(tmp, top)
+			copy(top, bottom)
+			copy(bottom, tmp)
+		}
+		win.flipY = !win.flipY
+	}
+	win.img = flipped
+	win.crop = mirrorRect(win.crop, b, horizontal)
+	win.lastCrop = mirrorRect(win.lastCrop, b, horizontal)
+	win.Render(pixelRatio)
+}
+
+// mirrorRect returns where r is in bounds once bounds is mirrored left to right if horizontal, or top to bottom otherwise.
+func mirrorRect(r, bounds image.Rectangle, horizontal bool) image.Rectangle {
+	if horizontal {
+		x := bounds.Min.X + bounds.Max.X
+		return image.Rect(x-r.Max.X, r.Min.Y, x-r.Min.X, r.Max.Y)
+	}
+	y := bounds.Min.Y + bounds.Max.Y
+	return image.Rect(r.Min.X, y-r.Max.Y, r.Max.X, y-r.Min.Y)
+}
+
+// Label returns the window's label, which fits under it, at pixelRatio, drawing it again if the window's width changed.
+func (win *Window) Label(pixelRatio float64) *image.Alpha {
+	if width := win.ScreenRect().Dx(); win.label == nil || width != win.labelWidth || pixelRatio != win.labelRatio {
+		win.label, win.labelWidth, win.labelRatio = labelMask(win.name, width, pixelRatio), width, pixelRatio
+	}
+	return win.label
+}
+
+// labelRect returns where the window's label goes, in framebuffer pixels, clear of its folds and highlight.
+func (ui *UI) labelRect(win *Window) image.Rectangle {
+	k := ui.PixelRatio
+	fold := int(math.Round(2 * k))
+	r := rmulf(win.ScreenRect(), k)
+	label := win.Label(k)
+	return label.Rect.Add(image.Pt(r.Min.X, r.Max.Y+2*fold))
+}
+
+func (win *Window) Render(pixelRatio float64) {
+	r := rmulf(win.img.Bounds(), win.scale*pixelRatio)
 	scaled := resize.Resize(uint(r.Dx()), uint(r.Dy()), win.img, resize.Lanczos3)
 	scaled2 := image.NewRGBA(r)
 	draw.Draw(scaled2, r, scaled, scaled.Bounds().Min, draw.Src)
 	win.scaled = scaled2
 }
 
-func NewUI(wdir string) (*UI, error) {
-	fileInfos, err := ioutil.ReadDir(wdir)
-	if err != nil {
-		return nil, fmt.Errorf("list files in %q: %v", wdir, err)
+// toolWindow adapts Window to extension.Window.
+type toolWindow struct {
+	win        *Window
+	pixelRatio float64
+}
+
+func (tw toolWindow) Image() image.Image {
+	return tw.win.img
+}
+
+func (tw toolWindow) SetImage(img image.Image) {
+	tw.win.img = img
+	tw.win.crop = tw.win.crop.Intersect(img.Bounds())
+	tw.win.lastCrop = tw.win.lastCrop.Intersect(img.Bounds())
+	if tw.win.crop.Empty() {
+		tw.win.crop = img.Bounds()
+	}
+	tw.win.Render(tw.pixelRatio)
+}
+
+func (tw toolWindow) Crop() image.Rectangle {
+	return tw.win.crop
+}
+
+func (tw toolWindow) SetCrop(crop image.Rectangle) {
+	if crop = crop.Intersect(tw.win.img.Bounds()); !crop.Empty() {
+		tw.win.crop = crop
 	}
+}
+
+// UIOptions configures how NewUI shows the files in its directory.
+type UIOptions struct {
+	// PageSize is the most windows that show at a time, which are all that are loaded. If 0, every file is on one page.
+	PageSize int
 
-	var windows []*Window
+	// SortOrder is one of sortOrders, which the files are paged through and the windows stacked in. If empty, it's by name.
+	SortOrder string
+}
+
+// NewUI shows the images in files' directory, loading the first page of them. If opts is nil, pages are defaultPageSize long, and the files are in order by name.
+func NewUI(files *Files, pixelRatio float64, tools []extension.Tool, opts *UIOptions) (*UI, error) {
+	if opts == nil {
+		opts = &UIOptions{PageSize: defaultPageSize}
+	}
+	fileInfos, err := files.ReadDir()
+	if err != nil {
+		return nil, fmt.Errorf("list files in %q: %v", files.Dir, err)
+	}
+	var entries []os.FileInfo
 	for _, info := range fileInfos {
-		img, err := func() (image.Image, error) {
-			f, err := os.Open(filepath.Join(wdir, info.Name()))
-			if err != nil {
-				return nil, fmt.Errorf("open image: %v", err)
-			}
-			img, _, err := image.Decode(f)
-			if err != nil {
-				return nil, fmt.Errorf("decode %q: %v", info.Name(), err)
-			}
-			return img, nil
-		}()
-		if err != nil {
-			log.Print(err)
-			continue
+		if !info.IsDir() {
+			entries = append(entries, info)
 		}
-
-		win := &Window{img: img, crop: img.Bounds(), lastCrop: img.Bounds(), scale: 0.5, pos: image.Pt(0, 0)}
-		win.Render()
-		windows = append(windows, win)
 	}
 
-	ui := &UI{Title: "freethumb", Width: windowWidth, Height: windowHeight, windows: windows}
+	ui := &UI{
+		Width:      int(math.Round(windowWidth * pixelRatio)),
+		Height:     int(math.Round(windowHeight * pixelRatio)),
+		PixelRatio: pixelRatio,
+		Keys:       defaultKeys,
+		Colors:     defaultColors,
+		files:      files,
+		entries:    entries,
+		pageSize:   opts.PageSize,
+		tools:      tools,
+
+		arrowCursor:     scaleCursor(arrowCursor, pixelRatio),
+		crosshairCursor: scaleCursor(crosshairCursor, pixelRatio),
+	}
 	ui.eventHandler = ui.defaultEventHandler
+	order := opts.SortOrder
+	if order == "" {
+		order = "name"
+	}
+	ui.sortEntries(order)
+	ui.loadPage(0)
+	// Clients start with the whole screen, so only report what changes from here.
+	ui.Damage()
 	return ui, nil
 }
 
-func (ui *UI) Update(img draw.Image, keyEvent *rfb.KeyEventMessage, pointerEvent *rfb.PointerEventMessage) image.Rectangle {
-	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0xee, 0xee, 0xee, 0xff}), image.ZP, draw.Src)
+// newWindow loads the file into a window at the top left of the screen, or returns nil if it isn't an image. Images that fail to load show why.
+func (ui *UI) newWindow(info os.FileInfo) *Window {
+	img, err := decodeImage(ui.files, info.Name())
+	var loadErr error
+	if err != nil {
+		log.Printf("couldn't load %q: %v", info.Name(), err)
+		// Other files may share the directory.
+		if errors.Is(err, image.ErrFormat) && !isImageName(info.Name()) {
+			return nil
+		}
+		img, loadErr = errorImage(info.Name(), err), err
+	}
+	win := &Window{name: info.Name(), info: info, img: img, loadErr: loadErr, crop: img.Bounds(), lastCrop: img.Bounds(), scale: 0.5, pos: image.Pt(0, 0)}
+	win.Render(ui.PixelRatio)
+	return win
+}
+
+// HandleEvent updates the UI for the latest key and pointer events, in framebuffer pixels.
+func (ui *UI) HandleEvent(keyEvent *rfb.KeyEventMessage, pointerEvent *rfb.PointerEventMessage) {
+	// Event handlers work in logical pixels.
+	logicalPointerEvent := *pointerEvent
+	logicalPointerEvent.X = uint16(float64(pointerEvent.X) / ui.PixelRatio)
+	logicalPointerEvent.Y = uint16(float64(pointerEvent.Y) / ui.PixelRatio)
+	ui.eventHandler(keyEvent, &logicalPointerEvent)
+}
 
-	ui.eventHandler(keyEvent, pointerEvent)
+// Draw draws the part of the screen in img's bounds.
+func (ui *UI) Draw(img draw.Image) {
+	background := image.NewUniform(ui.Colors.Background)
+	draw.Draw(img, img.Bounds(), background, image.ZP, draw.Src)
+	foldColor := image.NewUniform(ui.Colors.Fold)
+	highlightColor := image.NewUniform(ui.Colors.Highlight)
+	labelColor := image.NewUniform(ui.Colors.Label)
 
+	k := ui.PixelRatio
+	fold := int(math.Round(2 * k))
 	for _, win := range ui.windows {
 		if win.moving {
-			draw.DrawMask(img, image.Rectangle{win.WindowToScreen(win.img.Bounds().Min), win.WindowToScreen(win.img.Bounds().Max)}, win.scaled, image.ZP, image.NewUniform(color.Alpha{0x22}), image.ZP, draw.Over)
+			r := rmulf(image.Rectangle{win.WindowToScreen(win.img.Bounds().Min), win.WindowToScreen(win.img.Bounds().Max)}, k)
+			draw.DrawMask(img, r, win.scaled, image.ZP, image.NewUniform(color.Alpha{0x22}), image.ZP, draw.Over)
 		}
-		r := win.ScreenRect()
-		draw.Draw(img, r, win.scaled, pmulf(win.crop.Min, win.scale), draw.Src)
+		r := rmulf(win.ScreenRect(), k)
+		draw.Draw(img, r, win.scaled, pmulf(win.crop.Min, win.scale*k), draw.Src)
 
 		if win.crop.Min.X != win.img.Bounds().Min.X {
-			draw.Draw(img, image.Rect(r.Min.X-2, r.Min.Y, r.Min.X, r.Max.Y), foldColor, image.ZP, draw.Src)
+			draw.Draw(img, image.Rect(r.Min.X-fold, r.Min.Y, r.Min.X, r.Max.Y), foldColor, image.ZP, draw.Src)
 		}
 		if win.crop.Min.Y != win.img.Bounds().Min.Y {
-			draw.Draw(img, image.Rect(r.Min.X, r.Min.Y-2, r.Max.X, r.Min.Y), foldColor, image.ZP, draw.Src)
+			draw.Draw(img, image.Rect(r.Min.X, r.Min.Y-fold, r.Max.X, r.Min.Y), foldColor, image.ZP, draw.Src)
 		}
 		if win.crop.Max.X != win.img.Bounds().Max.X {
-			draw.Draw(img, image.Rect(r.Max.X, r.Min.Y, r.Max.X+2, r.Max.Y), foldColor, image.ZP, draw.Src)
+			draw.Draw(img, image.Rect(r.Max.X, r.Min.Y, r.Max.X+fold, r.Max.Y), foldColor, image.ZP, draw.Src)
 		}
 		if win.crop.Max.Y != win.img.Bounds().Max.Y {
-			draw.Draw(img, image.Rect(r.Min.X, r.Max.Y, r.Max.X, r.Max.Y+2), foldColor, image.ZP, draw.Src)
+			draw.Draw(img, image.Rect(r.Min.X, r.Max.Y, r.Max.X, r.Max.Y+fold), foldColor, image.ZP, draw.Src)
+		}
+		// The highlight goes around the folds, so that they still show.
+		if win.selected {
+			outer, inner := r.Inset(-2*fold), r.Inset(-fold)
+			for _, bar := range []image.Rectangle{
+				{outer.Min, image.Pt(outer.Max.X, inner.Min.Y)},
+				{image.Pt(outer.Min.X, inner.Max.Y), outer.Max},
+				{image.Pt(outer.Min.X, inner.Min.Y), image.Pt(inner.Min.X, inner.Max.Y)},
+				{image.Pt(inner.Max.X, inner.Min.Y), image.Pt(outer.Max.X, inner.Max.Y)},
+			} {
+				draw.Draw(img, bar, highlightColor, image.ZP, draw.Src)
+			}
+		}
+		if ui.labels {
+			label := ui.labelRect(win)
+			draw.Draw(img, label, background, image.ZP, draw.Src)
+			draw.DrawMask(img, label, labelColor, image.ZP, win.Label(k), image.ZP, draw.Over)
+		}
+		ui.drawExif(img, win)
+	}
+
+	if r := ui.pageLabelRect(); !r.Empty() {
+		ui.drawTextBox(img, r, ui.pageLabel)
+	}
+	draw.Draw(img, rmulf(ui.band, k), image.NewUniform(ui.Colors.Selection), image.ZP, draw.Over)
+	ui.drawTooltip(img)
+}
+
+// drawing returns how Update draws win, which is the z'th window from the back.
+func (ui *UI) drawing(win *Window, z int) windowDrawing {
+	k := ui.PixelRatio
+	fold := int(math.Round(2 * k))
+	r := rmulf(win.ScreenRect(), k).Inset(-fold)
+	if win.selected {
+		r = r.Inset(-fold)
+	}
+	var label *image.Alpha
+	if ui.labels {
+		label = win.Label(k)
+		r = r.Union(ui.labelRect(win))
+	}
+	if win.exif != nil {
+		r = r.Union(ui.exifRect(win))
+	}
+	if win.moving {
+		r = r.Union(rmulf(image.Rectangle{win.WindowToScreen(win.img.Bounds().Min), win.WindowToScreen(win.img.Bounds().Max)}, k))
+	}
+	return windowDrawing{rect: r, crop: win.crop, scaled: win.scaled, label: label, exif: win.exif, moving: win.moving, selected: win.selected, z: z}
+}
+
+// Damage returns the parts of the screen, in framebuffer pixels, that Draw would draw differently than it did at the last call: wherever a window, the band, the tooltip, or the page indicator was or is now, if it changed at all.
+func (ui *UI) Damage() []image.Rectangle {
+	screen := image.Rect(0, 0, ui.Width, ui.Height)
+	var damage []image.Rectangle
+	windows := make(map[*Window]windowDrawing, len(ui.windows))
+	for z, win := range ui.windows {
+		d := ui.drawing(win, z)
+		if old, ok := ui.drawnWindows[win]; !ok || old != d {
+			damage = append(damage, old.rect, d.rect)
+		}
+		windows[win] = d
+	}
+	// Windows go when the page turns.
+	for win, old := range ui.drawnWindows {
+		if _, ok := windows[win]; !ok {
+			damage = append(damage, old.rect)
+		}
+	}
+	if ui.band != ui.drawnBand {
+		damage = append(damage, rmulf(ui.drawnBand, ui.PixelRatio), rmulf(ui.band, ui.PixelRatio))
+	}
+	if ui.hover.tooltip != ui.drawnTooltip {
+		damage = append(damage, ui.drawnTooltipRect, ui.tooltipRect())
+	}
+	if ui.pageLabel != ui.drawnPageLabel {
+		damage = append(damage, ui.drawnPageRect, ui.pageLabelRect())
+	}
+	if screen != ui.drawnScreen {
+		damage = []image.Rectangle{screen}
+	}
+	ui.drawnWindows, ui.drawnBand, ui.drawnScreen = windows, ui.band, screen
+	ui.drawnTooltip, ui.drawnTooltipRect = ui.hover.tooltip, ui.tooltipRect()
+	ui.drawnPageLabel, ui.drawnPageRect = ui.pageLabel, ui.pageLabelRect()
+	return damage
+}
+
+// MoveTracker follows the frames sent to one client, to find what it can copy from its framebuffer.
+type MoveTracker struct {
+	ui *UI
+
+	// The front window in the last frame that Moves saw, where it was in framebuffer pixels, and what it looked like, and the size of the screen.
+	sentTop    *Window
+	sentRect   image.Rectangle
+	sentCrop   image.Rectangle
+	sentScaled image.Image
+	sentScreen image.Rectangle
+}
+
+func (ui *UI) NewMoveTracker() *MoveTracker {
+	return &MoveTracker{ui: ui}
+}
+
+// Moves returns CopyRect rectangles for the part of the frame just drawn for region r that can be copied from the previous frame: the window being dragged, which is in front now and was in the previous frame, so its old pixels were all visible. A frame that doesn't cover the whole screen leaves the client's copy partly stale, so it makes the next frame start over. Clients may not keep their framebuffer's contents through a resize, so neither does the first frame after one.
+func (t *MoveTracker) Moves(r image.Rectangle) []*rfb.FramebufferUpdateRect {
+	ui := t.ui
+	screen := image.Rect(0, 0, ui.Width, ui.Height)
+	if r.Intersect(screen) != screen || len(ui.windows) == 0 {
+		t.sentTop = nil
+		return nil
+	}
+
+	var moves []*rfb.FramebufferUpdateRect
+	win := ui.windows[len(ui.windows)-1]
+	cur := rmulf(win.ScreenRect(), ui.PixelRatio)
+	if win == t.sentTop && win.moving && t.sentScreen == screen && t.sentRect.Size() == cur.Size() && t.sentCrop == win.crop && t.sentScaled == win.scaled {
+		delta := cur.Min.Sub(t.sentRect.Min)
+		dst := cur.Intersect(screen).Intersect(t.sentRect.Intersect(screen).Add(delta))
+		if delta != image.ZP && !dst.Empty() {
+			moves = append(moves, rfb.NewCopyRect(dst, dst.Min.Sub(delta)))
+		}
+	}
+	t.sentTop, t.sentRect, t.sentCrop, t.sentScaled, t.sentScreen = win, cur, win.crop, win.scaled, screen
+	return moves
+}
+
+// Resize changes the size of the screen, in framebuffer pixels. Windows that it leaves partly off the screen, having been wholly on it, and those it leaves wholly off, move to the nearest place where they're on it again, as far as they fit.
+func (ui *UI) Resize(width, height int) {
+	oldScreen := ui.logicalScreen()
+	ui.Width, ui.Height = width, height
+	screen := ui.logicalScreen()
+	for _, win := range ui.windows {
+		r := win.ScreenRect()
+		if r.In(screen) || (!r.In(oldScreen) && r.Overlaps(screen)) {
+			continue
+		}
+		if r.Max.X > screen.Max.X {
+			win.pos.X -= r.Max.X - screen.Max.X
+		}
+		if r.Max.Y > screen.Max.Y {
+			win.pos.Y -= r.Max.Y - screen.Max.Y
+		}
+		if win.pos.X < 0 {
+			win.pos.X = 0
+		}
+		if win.pos.Y < 0 {
+			win.pos.Y = 0
 		}
 	}
+}
 
-	draw.Draw(img, ui.pendingCrop, image.NewUniform(color.NRGBA{0xb7, 0x96, 0xd4, 0x88}), image.ZP, draw.Over)
+// logicalScreen returns the screen in logical pixels.
+func (ui *UI) logicalScreen() image.Rectangle {
+	return rmulf(image.Rect(0, 0, ui.Width, ui.Height), 1/ui.PixelRatio)
+}
 
-	return image.Rect(0, 0, ui.Width, ui.Height)
+// Cursor returns the pointer shape for clients that draw the cursor themselves: a crosshair while selecting a crop, and an arrow otherwise.
+func (ui *UI) Cursor() *vncserver.Cursor {
+	if ui.cropping {
+		return ui.crosshairCursor
+	}
+	return ui.arrowCursor
 }
 
 func (ui *UI) moveToFront(windowIdx int) {
@@ -153,8 +557,66 @@ func (ui *UI) moveToFront(windowIdx int) {
 	ui.windows[len(ui.windows)-1] = win
 }
 
+// restack moves the window at windowIdx to index to in the stack, shifting those in between.
+func (ui *UI) restack(windowIdx, to int) {
+	win := ui.windows[windowIdx]
+	for ; windowIdx < to; windowIdx++ {
+		ui.windows[windowIdx] = ui.windows[windowIdx+1]
+	}
+	for ; windowIdx > to; windowIdx-- {
+		ui.windows[windowIdx] = ui.windows[windowIdx-1]
+	}
+	ui.windows[to] = win
+}
+
+// nextOverlapping returns the index of the nearest window behind the window at windowIdx, if step is -1, or in front of it, if step is 1, that it overlaps, or -1 if there's none.
+func (ui *UI) nextOverlapping(windowIdx, step int) int {
+	r := ui.windows[windowIdx].ScreenRect()
+	for i := windowIdx + step; i >= 0 && i < len(ui.windows); i += step {
+		if ui.windows[i].ScreenRect().Overlaps(r) {
+			return i
+		}
+	}
+	return -1
+}
+
+// resetCrops uncrops every window, keeping the images where they are, and remembers the crops for Undo.
+func (ui *UI) resetCrops() {
+	type crops struct{ crop, lastCrop image.Rectangle }
+	old := make(map[*Window]crops, len(ui.windows))
+	for _, win := range ui.windows {
+		if win.crop == win.img.Bounds() {
+			continue
+		}
+		old[win] = crops{win.crop, win.lastCrop}
+		// As with a right click, the crop is kept to toggle back to.
+		win.pos = win.WindowToScreen(win.img.Bounds().Min)
+		win.crop, win.lastCrop = win.img.Bounds(), win.crop
+	}
+	if len(old) == 0 {
+		return
+	}
+	ui.undo = func() {
+		for win, c := range old {
+			// Windows may have moved since, so the images stay where they are now.
+			win.pos = win.WindowToScreen(c.crop.Min)
+			win.crop, win.lastCrop = c.crop, c.lastCrop
+		}
+	}
+}
+
 func (ui *UI) defaultEventHandler(keyEvent *rfb.KeyEventMessage, pointerEvent *rfb.PointerEventMessage) {
 	loc := image.Pt(int(pointerEvent.X), int(pointerEvent.Y))
+	ui.move(loc, pointerEvent.ButtonMask)
+	// The page turns before anything else, which then acts on the windows of the new page.
+	if !ui.keyPressing && keyEvent.Pressed && (keyEvent.KeySym == keysym.PageUp || keyEvent.KeySym == keysym.PageDown) {
+		if keyEvent.KeySym == keysym.PageUp {
+			ui.turnPage(-1)
+		} else {
+			ui.turnPage(1)
+		}
+		ui.keyPressing = true
+	}
 	targetWin := -1
 	for idx, win := range ui.windows {
 		if loc.In(win.ScreenRect()) {
@@ -162,41 +624,97 @@ func (ui *UI) defaultEventHandler(keyEvent *rfb.KeyEventMessage, pointerEvent *r
 		}
 	}
 
-	if !ui.keyPressing && keyEvent.Pressed && targetWin != -1 {
+	// Modifiers are ignored, so that a shifted key still counts as a press.
+	if !ui.keyPressing && keyEvent.Pressed && !keysym.IsModifier(keyEvent.KeySym) {
+		r, _ := keysym.KeysymToRune(keyEvent.KeySym)
+		switch unicode.ToLower(r) {
+		case ui.Keys.ResetCrops:
+			ui.resetCrops()
+			ui.keyPressing = true
+		case ui.Keys.Grid:
+			ui.arrangeGrid(unicode.IsUpper(r))
+			ui.keyPressing = true
+		case ui.Keys.Labels:
+			ui.labels = !ui.labels
+			ui.keyPressing = true
+		case ui.Keys.Cascade:
+			ui.arrangeCascade()
+			ui.keyPressing = true
+		case ui.Keys.Sort:
+			ui.cycleSortOrder()
+			ui.keyPressing = true
+		case ui.Keys.Undo:
+			if ui.undo != nil {
+				ui.undo()
+				ui.undo = nil
+			}
+			ui.keyPressing = true
+		}
+	}
+	if !ui.keyPressing && keyEvent.Pressed && targetWin != -1 && !keysym.IsModifier(keyEvent.KeySym) {
 		win := ui.windows[targetWin]
 		oldcrop := win.crop
-		switch keyEvent.KeySym {
-		case 119: // w
+		r, _ := keysym.KeysymToRune(keyEvent.KeySym)
+		switch unicode.ToLower(r) {
+		case ui.Keys.CropTop:
 			if win.crop.Min.Y != win.img.Bounds().Min.Y {
 				win.crop.Min.Y = win.img.Bounds().Min.Y
 			} else {
 				win.crop.Min.Y = win.ScreenToWindow(loc).Y
 			}
-		case 97: // a
+		case ui.Keys.CropLeft:
 			if win.crop.Min.X != win.img.Bounds().Min.X {
 				win.crop.Min.X = win.img.Bounds().Min.X
 			} else {
 				win.crop.Min.X = win.ScreenToWindow(loc).X
 			}
-		case 115: // s
+		case ui.Keys.CropBottom:
 			if win.crop.Max.Y != win.img.Bounds().Max.Y {
 				win.crop.Max.Y = win.img.Bounds().Max.Y
 			} else {
 				win.crop.Max.Y = win.ScreenToWindow(loc).Y
 			}
-		case 100: // d
+		case ui.Keys.CropRight:
 			if win.crop.Max.X != win.img.Bounds().Max.X {
 				win.crop.Max.X = win.img.Bounds().Max.X
 			} else {
 				win.crop.Max.X = win.ScreenToWindow(loc).X
 			}
+		case ui.Keys.FlipHorizontal, ui.Keys.FlipVertical:
+			win.Flip(unicode.ToLower(r) == ui.Keys.FlipHorizontal, ui.PixelRatio)
+			// The window stays where it is, showing its crop mirrored.
+			oldcrop = win.crop
+		case ui.Keys.SendToBack:
+			ui.restack(targetWin, 0)
+		case ui.Keys.Lower:
+			if i := ui.nextOverlapping(targetWin, -1); i != -1 {
+				ui.restack(targetWin, i)
+			}
+		case ui.Keys.Raise:
+			if i := ui.nextOverlapping(targetWin, 1); i != -1 {
+				ui.restack(targetWin, i)
+			}
+		case ui.Keys.Exif:
+			ui.toggleExif(win)
+		case ui.Keys.Export:
+			if name, err := exportCrop(ui.files, win); err != nil {
+				log.Printf("couldn't export %q: %v", win.name, err)
+			} else {
+				log.Printf("exported %q to %q", win.name, name)
+			}
+		default:
+			for _, tool := range ui.tools {
+				if tool.KeySym() == keyEvent.KeySym {
+					tool.Apply(toolWindow{win, ui.PixelRatio}, win.ScreenToWindow(loc))
+				}
+			}
 		}
 		win.pos.X += int(float64(win.crop.Min.X-oldcrop.Min.X) * win.scale)
 		win.pos.Y += int(float64(win.crop.Min.Y-oldcrop.Min.Y) * win.scale)
 
 		ui.keyPressing = true
 	}
-	if !keyEvent.Pressed {
+	if !keyEvent.Pressed && !keysym.IsModifier(keyEvent.KeySym) {
 		ui.keyPressing = false
 	}
 
@@ -204,25 +722,55 @@ func (ui *UI) defaultEventHandler(keyEvent *rfb.KeyEventMessage, pointerEvent *r
 		win := ui.windows[targetWin]
 		ui.moveToFront(targetWin)
 
-		win.moving = true
+		// Dragging a selected window drags the rest of the selection with it. Any other window goes alone, and the selection is dropped.
+		moving := []*Window{win}
+		for _, other := range ui.windows {
+			if !win.selected {
+				other.selected = false
+			} else if other.selected && other != win {
+				moving = append(moving, other)
+			}
+		}
+		for _, w := range moving {
+			w.moving = true
+		}
 		lastX, lastY := loc.X, loc.Y
 		ui.eventHandler = func(keyEvent *rfb.KeyEventMessage, pointerEvent *rfb.PointerEventMessage) {
 			if pointerEvent.ButtonMask&0b1 == 0 {
-				win.moving = false
+				for _, w := range moving {
+					w.moving = false
+				}
 				ui.eventHandler = ui.defaultEventHandler
 				return
 			}
 			dp := image.Pt(int(pointerEvent.X)-lastX, int(pointerEvent.Y)-lastY)
-			win.pos = win.pos.Add(dp)
+			for _, w := range moving {
+				w.pos = w.pos.Add(dp)
+			}
 			lastX, lastY = int(pointerEvent.X), int(pointerEvent.Y)
 		}
+	} else if pointerEvent.ButtonMask&0b1 > 0 {
+		// Dragging on the background selects the windows entirely in the band, as it's dragged out. A click selects none.
+		ui.eventHandler = func(keyEvent *rfb.KeyEventMessage, pointerEvent *rfb.PointerEventMessage) {
+			ui.band = image.Rectangle{loc, image.Pt(int(pointerEvent.X), int(pointerEvent.Y))}.Canon()
+			for _, win := range ui.windows {
+				win.selected = !ui.band.Empty() && win.ScreenRect().In(ui.band)
+			}
+			if pointerEvent.ButtonMask&0b1 == 0 {
+				ui.band = image.ZR
+				ui.eventHandler = ui.defaultEventHandler
+			}
+		}
+		ui.eventHandler(keyEvent, pointerEvent)
 	} else if pointerEvent.ButtonMask&0b100 > 0 && targetWin != -1 {
 		win := ui.windows[targetWin]
 		ui.moveToFront(targetWin)
 
+		ui.cropping = true
 		ui.eventHandler = func(keyEvent *rfb.KeyEventMessage, pointerEvent *rfb.PointerEventMessage) {
 			loc2 := image.Pt(int(pointerEvent.X), int(pointerEvent.Y))
 			if pointerEvent.ButtonMask&0b100 == 0 {
+				ui.cropping = false
 				if math.Hypot(float64(loc2.X-loc.X), float64(loc2.Y-loc.Y)) < 10 {
 					oldcrop := win.crop
 					if win.img.Bounds() == win.crop {
@@ -237,12 +785,12 @@ func (ui *UI) defaultEventHandler(keyEvent *rfb.KeyEventMessage, pointerEvent *r
 					win.pos = win.WindowToScreen(newcrop.Min)
 					win.crop = newcrop
 				}
-				ui.pendingCrop = image.ZR
+				ui.band = image.ZR
 
 				ui.eventHandler = ui.defaultEventHandler
 				return
 			}
-			ui.pendingCrop = image.Rectangle{loc, loc2}.Canon()
+			ui.band = image.Rectangle{loc, loc2}.Canon()
 		}
 	}
 }
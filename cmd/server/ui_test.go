@@ -0,0 +1,115 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// playEvents sends events to d, at a pixel ratio of 1.
+func playEvents(d *desktop, events []event) {
+	for _, e := range events {
+		if e.key != nil {
+			d.HandleKey(*e.key)
+		}
+		if e.pointer != nil {
+			d.HandlePointer(*e.pointer)
+		}
+	}
+}
+
+func TestResetCropsUndo(t *testing.T) {
+	ui, err := NewUI(NewFiles("testdata/fixtures", true, "", ""), 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &desktop{ui: ui}
+	play := func(events []event) { playEvents(d, events) }
+	play(script(pointer(60, 40, 0), tap('s'), cropKeys, cropSelect))
+	cropped := ui.Layout()
+
+	// Resetting works wherever the pointer is.
+	play(script(pointer(600, 500, 0), tap('r')))
+	for _, win := range ui.windows {
+		if win.crop != win.img.Bounds() {
+			t.Errorf("expected %s to be uncropped, but its crop is %v", win.name, win.crop)
+		}
+		if win.lastCrop == win.img.Bounds() {
+			t.Errorf("expected %s to keep its crop to toggle back to", win.name)
+		}
+		// Neither window was dragged, so both images are still in the corner.
+		if win.pos != image.ZP {
+			t.Errorf("expected %s's image to stay put, but it moved to %v", win.name, win.pos)
+		}
+	}
+
+	play(tap('z'))
+	if got := ui.Layout(); !reflect.DeepEqual(got, cropped) {
+		t.Errorf("expected undo to restore %+v, but got %+v", cropped, got)
+	}
+	// There's only the one reset to undo.
+	play(script(drag(image.Pt(20, 20), image.Pt(40, 40), leftButton), tap('z')))
+	for _, win := range ui.windows {
+		if win.crop == win.img.Bounds() {
+			t.Errorf("expected a second undo to do nothing, but %s is uncropped", win.name)
+		}
+	}
+}
+
+func TestRestack(t *testing.T) {
+	ui, err := NewUI(NewFiles("testdata/fixtures", true, "", ""), 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &desktop{ui: ui}
+	order := func() string {
+		var names []string
+		for _, win := range ui.windows {
+			names = append(names, win.name)
+		}
+		return strings.Join(names, " ")
+	}
+	for _, tt := range []struct {
+		events []event
+		want   string
+	}{
+		// b.png starts in front, where both windows are.
+		{script(pointer(10, 10, 0), tap('b')), "b.png a.png"},
+		{tap(']'), "b.png a.png"},
+		{tap('['), "a.png b.png"},
+		{script(pointer(150, 120, 0), tap(']')), "b.png a.png"},
+		// Once apart, the windows stay as they are, since neither is in the way of the other.
+		{script(drag(image.Pt(150, 120), image.Pt(550, 520), leftButton), pointer(550, 520, 0), tap('[')), "b.png a.png"},
+	} {
+		playEvents(d, tt.events)
+		if got := order(); got != tt.want {
+			t.Errorf("expected the windows in order %q, but got %q", tt.want, got)
+		}
+	}
+}
+
+func TestResizeRelayout(t *testing.T) {
+	ui, err := NewUI(NewFiles("testdata/fixtures", true, "", ""), 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := ui.windows[0], ui.windows[1]
+	a.pos, b.pos = image.Pt(900, 500), image.Pt(-60, 100)
+	// The screen shrinks to 800x500 logical pixels. a.png no longer fits, so it moves back on. b.png was already hanging off the edge, and still shows some, so it stays.
+	ui.Resize(1600, 1000)
+	if want := image.Pt(600, 350); a.pos != want {
+		t.Errorf("expected a.png to move to %v, but it's at %v", want, a.pos)
+	}
+	if want := image.Pt(-60, 100); b.pos != want {
+		t.Errorf("expected b.png to stay at %v, but it's at %v", want, b.pos)
+	}
+	// At 150x100, b.png is wholly off the screen, so it moves back on too, and a.png, being bigger than the screen, goes in the corner.
+	ui.Resize(300, 200)
+	if want := image.Pt(0, 20); b.pos != want {
+		t.Errorf("expected b.png to move to %v, but it's at %v", want, b.pos)
+	}
+	if a.pos != image.ZP {
+		t.Errorf("expected a.png to move to the corner, but it's at %v", a.pos)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+// serveViewer serves viewerPage at /, for -websocket_addr without -novnc_dir.
+func serveViewer(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, viewerPage)
+}
+
+// viewerPage is a minimal browser client, so that nothing needs installing to use the board from a browser. It speaks RFB 3.8 over WebSocket at /websockify on the host that served it, with no authentication or VNC authentication, and the Raw, CopyRect, and DesktopSize encodings, scaling the screen to fit the window.
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>freethumb</title>
+<style>
+html, body { margin: 0; height: 100%; background: #333; overflow: hidden; }
+canvas { display: block; margin: auto; max-width: 100vw; max-height: 100vh; outline: none; }
+#status { position: fixed; top: 0; left: 0; right: 0; padding: 8px; font: 14px sans-serif; color: #eee; background: #000a; }
+#status:empty { display: none; }
+</style>
+</head>
+<body>
+<div id="status">Connecting…</div>
+<canvas id="screen" width="0" height="0" tabindex="0"></canvas>
+<script>
+"use strict";
+
+const status = document.getElementById("status");
+const canvas = document.getElementById("screen");
+const ctx = canvas.getContext("2d");
+
+// DES, for VNC authentication, which encrypts the server's challenge with the password.
+const desTables = (function () {
+  const t = {
+    ip: [58,50,42,34,26,18,10,2,60,52,44,36,28,20,12,4,62,54,46,38,30,22,14,6,64,56,48,40,32,24,16,8,57,49,41,33,25,17,9,1,59,51,43,35,27,19,11,3,61,53,45,37,29,21,13,5,63,55,47,39,31,23,15,7],
+    p: [16,7,20,21,29,12,28,17,1,15,23,26,5,18,31,10,2,8,24,14,32,27,3,9,19,13,30,6,22,11,4,25],
+    pc1: [57,49,41,33,25,17,9,1,58,50,42,34,26,18,10,2,59,51,43,35,27,19,11,3,60,52,44,36,63,55,47,39,31,23,15,7,62,54,46,38,30,22,14,6,61,53,45,37,29,21,13,5,28,20,12,4],
+    pc2: [14,17,11,24,1,5,3,28,15,6,21,10,23,19,12,4,26,8,16,7,27,20,13,2,41,52,31,37,47,55,30,40,51,45,33,48,44,49,39,56,34,53,46,42,50,36,29,32],
+    shifts: [1,1,2,2,2,2,2,2,1,2,2,2,2,2,2,1],
+    s: [
+      [14,4,13,1,2,15,11,8,3,10,6,12,5,9,0,7,0,15,7,4,14,2,13,1,10,6,12,11,9,5,3,8,4,1,14,8,13,6,2,11,15,12,9,7,3,10,5,0,15,12,8,2,4,9,1,7,5,11,3,14,10,0,6,13],
+      [15,1,8,14,6,11,3,4,9,7,2,13,12,0,5,10,3,13,4,7,15,2,8,14,12,0,1,10,6,9,11,5,0,14,7,11,10,4,13,1,5,8,12,6,9,3,2,15,13,8,10,1,3,15,4,2,11,6,7,12,0,5,14,9],
+      [10,0,9,14,6,3,15,5,1,13,12,7,11,4,2,8,13,7,0,9,3,4,6,10,2,8,5,14,12,11,15,1,13,6,4,9,8,15,3,0,11,1,2,12,5,10,14,7,1,10,13,0,6,9,8,7,4,15,14,3,11,5,2,12],
+      [7,13,14,3,0,6,9,10,1,2,8,5,11,12,4,15,13,8,11,5,6,15,0,3,4,7,2,12,1,10,14,9,10,6,9,0,12,11,7,13,15,1,3,14,5,2,8,4,3,15,0,6,10,1,13,8,9,4,5,11,12,7,2,14],
+      [2,12,4,1,7,10,11,6,8,5,3,15,13,0,14,9,14,11,2,12,4,7,13,1,5,0,15,10,3,9,8,6,4,2,1,11,10,13,7,8,15,9,12,5,6,3,0,14,11,8,12,7,1,14,2,13,6,15,0,9,10,4,5,3],
+      [12,1,10,15,9,2,6,8,0,13,3,4,14,7,5,11,10,15,4,2,7,12,9,5,6,1,13,14,0,11,3,8,9,14,15,5,2,8,12,3,7,0,4,10,1,13,11,6,4,3,2,12,9,5,15,10,11,14,1,7,6,0,8,13],
+      [4,11,2,14,15,0,8,13,3,12,9,7,5,10,6,1,13,0,11,7,4,9,1,10,14,3,5,12,2,15,8,6,1,4,11,13,12,3,7,14,10,15,6,8,0,5,9,2,6,11,13,8,1,4,10,7,9,5,0,15,14,2,3,12],
+      [13,2,8,4,6,15,11,1,10,9,3,14,5,0,12,7,1,15,13,8,10,3,7,4,12,5,6,11,0,14,9,2,7,11,4,1,9,12,14,2,0,6,10,13,15,3,5,8,2,1,14,7,4,10,8,13,15,12,9,0,3,5,6,11],
+    ],
+    e: [],
+    fp: [],
+  };
+  for (let i = 0; i < 48; i++) t.e.push((Math.floor(i / 6) * 4 + i % 6 + 31) % 32 + 1);
+  t.ip.forEach(function (from, to) { t.fp[from - 1] = to + 1; });
+  return t;
+})();
+
+function toBits(bytes) {
+  const bits = [];
+  bytes.forEach(function (b) { for (let i = 7; i >= 0; i--) bits.push((b >> i) & 1); });
+  return bits;
+}
+
+function fromBits(bits) {
+  const bytes = new Uint8Array(bits.length / 8);
+  bits.forEach(function (bit, i) { bytes[i >> 3] |= bit << (7 - (i & 7)); });
+  return bytes;
+}
+
+function permute(bits, table) {
+  return table.map(function (i) { return bits[i - 1]; });
+}
+
+function desEncrypt(key, block) {
+  const t = desTables;
+  const k = permute(toBits(key), t.pc1);
+  let c = k.slice(0, 28), d = k.slice(28);
+  const subkeys = t.shifts.map(function (n) {
+    c = c.slice(n).concat(c.slice(0, n));
+    d = d.slice(n).concat(d.slice(0, n));
+    return permute(c.concat(d), t.pc2);
+  });
+  const bits = permute(toBits(block), t.ip);
+  let l = bits.slice(0, 32), r = bits.slice(32);
+  subkeys.forEach(function (subkey) {
+    const x = permute(r, t.e).map(function (bit, i) { return bit ^ subkey[i]; });
+    const out = [];
+    for (let i = 0; i < 8; i++) {
+      const g = x.slice(6 * i, 6 * i + 6);
+      const v = t.s[i][(g[0] << 5 | g[5] << 4) | (g[1] << 3 | g[2] << 2 | g[3] << 1 | g[4])];
+      for (let j = 3; j >= 0; j--) out.push((v >> j) & 1);
+    }
+    const f = permute(out, t.p);
+    const next = l.map(function (bit, i) { return bit ^ f[i]; });
+    l = r;
+    r = next;
+  });
+  return fromBits(permute(r.concat(l), t.fp));
+}
+
+// vncResponse encrypts the challenge with the password, whose first 8 bytes are the key, each with its bits reversed.
+function vncResponse(password, challenge) {
+  const key = new Uint8Array(8);
+  for (let i = 0; i < 8 && i < password.length; i++) {
+    let b = password.charCodeAt(i) & 0xff, reversed = 0;
+    for (let j = 0; j < 8; j++) reversed |= ((b >> j) & 1) << (7 - j);
+    key[i] = reversed;
+  }
+  const response = new Uint8Array(16);
+  response.set(desEncrypt(key, challenge.slice(0, 8)), 0);
+  response.set(desEncrypt(key, challenge.slice(8, 16)), 8);
+  return response;
+}
+
+// keysym returns the X keysym of a key event, or 0 if it has none.
+const namedKeys = {
+  Backspace: 0xff08, Tab: 0xff09, Enter: 0xff0d, Escape: 0xff1b, Delete: 0xffff,
+  Home: 0xff50, ArrowLeft: 0xff51, ArrowUp: 0xff52, ArrowRight: 0xff53, ArrowDown: 0xff54,
+  PageUp: 0xff55, PageDown: 0xff56, End: 0xff57, Insert: 0xff63,
+  Shift: 0xffe1, Control: 0xffe3, Meta: 0xffe7, Alt: 0xffe9,
+};
+for (let i = 1; i <= 12; i++) namedKeys["F" + i] = 0xffbd + i;
+
+function keysym(e) {
+  if (e.key in namedKeys) return namedKeys[e.key];
+  const chars = Array.from(e.key);
+  if (chars.length !== 1) return 0;
+  const cp = chars[0].codePointAt(0);
+  return cp < 0x100 ? cp : 0x01000000 + cp;
+}
+
+function connect() {
+  const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/websockify", "binary");
+  ws.binaryType = "arraybuffer";
+
+  // Messages split the stream anywhere, so reads wait for as many bytes as they need.
+  let buf = new Uint8Array(0), pos = 0, wake = null, closed = false;
+  ws.onmessage = function (e) {
+    const data = new Uint8Array(e.data);
+    const rest = buf.subarray(pos);
+    buf = new Uint8Array(rest.length + data.length);
+    buf.set(rest, 0);
+    buf.set(data, rest.length);
+    pos = 0;
+    if (wake) wake();
+  };
+  ws.onclose = function () {
+    closed = true;
+    if (wake) wake();
+  };
+  async function read(n) {
+    while (buf.length - pos < n) {
+      if (closed) throw new Error("Disconnected.");
+      await new Promise(function (resolve) { wake = resolve; });
+    }
+    const bytes = buf.slice(pos, pos + n);
+    pos += n;
+    return bytes;
+  }
+  async function u8() { return (await read(1))[0]; }
+  async function u16() { const b = await read(2); return b[0] << 8 | b[1]; }
+  async function u32() { const b = await read(4); return (b[0] << 24 | b[1] << 16 | b[2] << 8 | b[3]) >>> 0; }
+  async function s32() { return (await u32()) | 0; }
+  async function str() { return new TextDecoder().decode(await read(await u32())); }
+
+  function send(bytes) { ws.send(new Uint8Array(bytes)); }
+  function be16(n) { return [n >> 8 & 0xff, n & 0xff]; }
+  function be32(n) { return [n >>> 24 & 0xff, n >> 16 & 0xff, n >> 8 & 0xff, n & 0xff]; }
+
+  let connected = false, full = true;
+  function request() {
+    send([3, full ? 0 : 1].concat(be16(0), be16(0), be16(canvas.width), be16(canvas.height)));
+    full = false;
+  }
+
+  async function run() {
+    await new Promise(function (resolve, reject) { ws.onopen = resolve; ws.onerror = function () { reject(new Error("Couldn't connect.")); }; });
+    const version = new TextDecoder().decode(await read(12));
+    if (!version.startsWith("RFB 003.")) throw new Error("Not a VNC server.");
+    send(Array.from(new TextEncoder().encode("RFB 003.008\n")));
+
+    const count = await u8();
+    if (count === 0) throw new Error(await str());
+    const types = Array.from(await read(count));
+    if (types.includes(1)) {
+      send([1]);
+    } else if (types.includes(2)) {
+      send([2]);
+      const challenge = await read(16);
+      send(Array.from(vncResponse(window.prompt("Password") || "", challenge)));
+    } else {
+      throw new Error("The server offers no security type that this viewer supports: " + types.join(", ") + ".");
+    }
+    if (await u32() !== 0) throw new Error(await str());
+
+    send([1]);
+    const width = await u16(), height = await u16();
+    await read(16);
+    document.title = await str();
+    canvas.width = width;
+    canvas.height = height;
+
+    // 32 bits per pixel, little endian, true color, red in the lowest byte, as canvas pixels are.
+    send([0, 0, 0, 0, 32, 24, 0, 1].concat(be16(255), be16(255), be16(255), [0, 8, 16, 0, 0, 0]));
+    const encodings = [1, 0, -223];
+    send([2, 0].concat(be16(encodings.length), [].concat.apply([], encodings.map(be32))));
+    request();
+    connected = true;
+    status.textContent = "";
+    canvas.focus();
+
+    for (;;) {
+      const type = await u8();
+      if (type === 0) {
+        await read(1);
+        const rects = await u16();
+        for (let i = 0; i < rects; i++) {
+          const x = await u16(), y = await u16(), w = await u16(), h = await u16(), encoding = await s32();
+          if (encoding === 0) {
+            const pixels = await read(w * h * 4);
+            for (let j = 3; j < pixels.length; j += 4) pixels[j] = 255;
+            if (w > 0 && h > 0) ctx.putImageData(new ImageData(new Uint8ClampedArray(pixels.buffer), w, h), x, y);
+          } else if (encoding === 1) {
+            const sx = await u16(), sy = await u16();
+            ctx.drawImage(canvas, sx, sy, w, h, x, y, w, h);
+          } else if (encoding === -223) {
+            canvas.width = w;
+            canvas.height = h;
+            full = true;
+          } else {
+            throw new Error("The server sent encoding " + encoding + ", which this viewer doesn't support.");
+          }
+        }
+        request();
+      } else if (type === 1) {
+        await read(3);
+        await read(await u16() * 6);
+      } else if (type === 2) {
+        // Bell
+      } else if (type === 3) {
+        await read(3);
+        await read(await u32());
+      } else {
+        throw new Error("The server sent message type " + type + ", which this viewer doesn't support.");
+      }
+    }
+  }
+
+  // Input is in framebuffer pixels, however the canvas is scaled.
+  let buttons = 0;
+  function pointer(e, extra) {
+    if (!connected) return;
+    const r = canvas.getBoundingClientRect();
+    const x = Math.max(0, Math.min(canvas.width - 1, Math.floor((e.clientX - r.left) * canvas.width / r.width)));
+    const y = Math.max(0, Math.min(canvas.height - 1, Math.floor((e.clientY - r.top) * canvas.height / r.height)));
+    send([5, buttons | extra].concat(be16(x), be16(y)));
+  }
+  function mouse(e) {
+    e.preventDefault();
+    // RFB has the middle button second and the right third, where browsers have them the other way around.
+    buttons = (e.buttons & 1) | (e.buttons & 4 ? 2 : 0) | (e.buttons & 2 ? 4 : 0);
+    pointer(e, 0);
+  }
+  canvas.addEventListener("mousemove", mouse);
+  canvas.addEventListener("mousedown", mouse);
+  canvas.addEventListener("mouseup", mouse);
+  canvas.addEventListener("contextmenu", function (e) { e.preventDefault(); });
+  canvas.addEventListener("wheel", function (e) {
+    e.preventDefault();
+    const button = e.deltaY < 0 ? 8 : 16;
+    pointer(e, button);
+    pointer(e, 0);
+  }, { passive: false });
+  function key(down) {
+    return function (e) {
+      const sym = keysym(e);
+      if (!connected || sym === 0) return;
+      e.preventDefault();
+      send([4, down ? 1 : 0, 0, 0].concat(be32(sym)));
+    };
+  }
+  canvas.addEventListener("keydown", key(true));
+  canvas.addEventListener("keyup", key(false));
+
+  run().catch(function (err) {
+    connected = false;
+    status.textContent = err.message;
+    ws.close();
+  });
+}
+
+connect();
+</script>
+</body>
+</html>
+`
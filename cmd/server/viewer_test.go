@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeViewer(t *testing.T) {
+	rec := httptest.NewRecorder()
+	serveViewer(rec, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Fatalf("expected HTML, but got %q", got)
+	}
+	if !strings.Contains(rec.Body.String(), `"/websockify"`) {
+		t.Error("expected the viewer to connect to /websockify")
+	}
+
+	rec = httptest.NewRecorder()
+	serveViewer(rec, httptest.NewRequest("GET", "/vnc.html", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected only / to be served, but got %d for /vnc.html", rec.Code)
+	}
+}
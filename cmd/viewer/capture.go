@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/alltom/vncfreethumb/video"
+	"image"
+	"log"
+	"sync"
+	"time"
+)
+
+// capture writes each frame that the viewer shows to a video, as they arrive.
+type capture struct {
+	path  string
+	start time.Time
+
+	mu sync.Mutex
+	w  video.Writer // nil once finished, or since it failed
+}
+
+func newCapture(path string, fps float64) (*capture, error) {
+	w, err := video.Create(path, &video.Options{FPS: fps})
+	if err != nil {
+		return nil, err
+	}
+	return &capture{path: path, start: time.Now(), w: w}, nil
+}
+
+// frame adds img, shown from now.
+func (c *capture) frame(img image.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w == nil {
+		return
+	}
+	if err := c.w.WriteFrame(img, time.Since(c.start)); err != nil {
+		log.Printf("couldn't capture frame, so stopped capturing: %v", err)
+		c.w.Close(time.Since(c.start))
+		c.w = nil
+	}
+}
+
+// finish ends the video now.
+func (c *capture) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w == nil {
+		return
+	}
+	if err := c.w.Close(time.Since(c.start)); err != nil {
+		log.Printf("couldn't finish capture: %v", err)
+	} else {
+		log.Printf("wrote %s", c.path)
+	}
+	c.w = nil
+}
@@ -0,0 +1,254 @@
+// Command viewer is a minimal VNC viewer, built on package rfbclient, that shows a server's screen in a local web browser and forwards the browser's key and pointer events to it, so servers can be tried without a third-party client.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb/keysym"
+	"github.com/alltom/vncfreethumb/rfbclient"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	addr         = flag.String("addr", "127.0.0.1:5900", "Address of the VNC server, as host:port.")
+	httpAddr     = flag.String("http", "localhost:8080", "Address to serve the viewer on; open it in a browser.")
+	password     = flag.String("password", "", "Password for servers that require VNC authentication.")
+	passwordFile = flag.String("password_file", "", "If set, reads the password from the first line of this file instead.")
+	exclusive    = flag.Bool("exclusive", false, "If true, asks the server to disconnect other clients, rather than to share the desktop with them.")
+	capturePath  = flag.String("capture", "", "If set, records the session to this file until the viewer exits: as animated PNG if it ends in .png or .apng, or with ffmpeg, as for .mp4, otherwise.")
+	captureFPS   = flag.Float64("capture_fps", 10, "With -capture, the frame rate of videos written with ffmpeg. Animated PNG shows each update for exactly as long as it was shown.")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		log.Fatalf("expected no args, but got %d", flag.NArg())
+	}
+	if *passwordFile != "" {
+		if *password != "" {
+			log.Fatal("-password and -password_file are mutually exclusive")
+		}
+		contents, err := ioutil.ReadFile(*passwordFile)
+		if err != nil {
+			log.Fatalf("couldn't read password file: %v", err)
+		}
+		*password = strings.TrimRight(strings.SplitN(string(contents), "\n", 2)[0], "\r")
+	}
+
+	if *captureFPS <= 0 {
+		log.Fatalf("-capture_fps must be positive, but was %v", *captureFPS)
+	}
+
+	conn, err := net.DialTimeout("tcp", *addr, 30*time.Second)
+	if err != nil {
+		log.Fatalf("couldn't connect: %v", err)
+	}
+	v := &viewer{changed: make(chan struct{})}
+	v.client, err = rfbclient.NewClient(context.Background(), conn, &rfbclient.Options{
+		Password: func() (string, error) { return *password, nil },
+		Shared:   !*exclusive,
+		OnUpdate: func([]image.Rectangle) {
+			v.update(nil)
+			if v.capture != nil {
+				v.capture.frame(v.client.Image())
+			}
+		},
+	})
+	if err != nil {
+		log.Fatalf("couldn't start session: %v", err)
+	}
+	if *capturePath != "" {
+		if v.capture, err = newCapture(*capturePath, *captureFPS); err != nil {
+			log.Fatalf("couldn't start capture: %v", err)
+		}
+		// Finish the video however the viewer exits.
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-interrupted
+			v.capture.finish()
+			os.Exit(1)
+		}()
+	}
+
+	l, err := net.Listen("tcp", *httpAddr)
+	if err != nil {
+		log.Fatalf("couldn't listen: %v", err)
+	}
+	log.Printf("viewing %q at http://%s/", v.client.Name(), l.Addr())
+	go func() {
+		log.Fatalf("couldn't serve viewer: %v", http.Serve(l, v))
+	}()
+
+	err = v.client.Run(context.Background())
+	v.update(err)
+	if v.capture != nil {
+		v.capture.finish()
+	}
+	log.Fatalf("disconnected: %v", err)
+}
+
+// viewer serves the page, the frames for it to show, and the events it sends back.
+type viewer struct {
+	client  *rfbclient.Client
+	capture *capture // nil without -capture
+
+	mu      sync.Mutex
+	version int           // incremented with each update
+	changed chan struct{} // closed and replaced with each update
+	err     error         // why the session ended, if it has
+}
+
+// update wakes the pages waiting for a new frame, and records err, if the session ended.
+func (v *viewer) update(err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.version++
+	if err != nil {
+		v.err = err
+	}
+	close(v.changed)
+	v.changed = make(chan struct{})
+}
+
+func (v *viewer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := page.Execute(w, v.client.Name()); err != nil {
+			log.Printf("couldn't render page: %v", err)
+		}
+	case "/frame":
+		v.serveFrame(w, r)
+	case "/key", "/pointer":
+		if r.Method != http.MethodPost {
+			http.Error(w, "expected POST", http.StatusMethodNotAllowed)
+			return
+		}
+		var err error
+		if r.URL.Path == "/key" {
+			err = v.key(r.FormValue("key"), r.FormValue("down") == "1")
+		} else {
+			err = v.pointer(r.FormValue("x"), r.FormValue("y"), r.FormValue("buttons"))
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveFrame waits until there's a frame newer than the after parameter, then sends it as a PNG, with its version in the X-Frame-Version header.
+func (v *viewer) serveFrame(w http.ResponseWriter, r *http.Request) {
+	after, _ := strconv.Atoi(r.FormValue("after"))
+	v.mu.Lock()
+	for v.version <= after && v.err == nil {
+		changed := v.changed
+		v.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		v.mu.Lock()
+	}
+	version, err := v.version, v.err
+	v.mu.Unlock()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("disconnected: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&buf, v.client.Image()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Version", strconv.Itoa(version))
+	w.Write(buf.Bytes())
+}
+
+// namedKeys are the keysyms of the KeyboardEvent.key values that aren't the characters they type.
+var namedKeys = map[string]uint32{
+	"Backspace":   keysym.BackSpace,
+	"Tab":         keysym.Tab,
+	"Enter":       keysym.Return,
+	"Escape":      keysym.Escape,
+	"Delete":      keysym.Delete,
+	"Insert":      keysym.Insert,
+	"Home":        keysym.Home,
+	"End":         keysym.End,
+	"PageUp":      keysym.PageUp,
+	"PageDown":    keysym.PageDown,
+	"ArrowLeft":   keysym.Left,
+	"ArrowUp":     keysym.Up,
+	"ArrowRight":  keysym.Right,
+	"ArrowDown":   keysym.Down,
+	"Shift":       keysym.ShiftL,
+	"Control":     keysym.ControlL,
+	"Alt":         keysym.AltL,
+	"AltGraph":    keysym.ISOLevel3Shift,
+	"Meta":        keysym.MetaL,
+	"CapsLock":    keysym.CapsLock,
+	"NumLock":     keysym.NumLock,
+	"ScrollLock":  keysym.ScrollLock,
+	"Pause":       keysym.Pause,
+	"PrintScreen": keysym.Print,
+	"ContextMenu": keysym.Menu,
+}
+
+// key presses or releases the key named by a KeyboardEvent.key value.
+func (v *viewer) key(name string, down bool) error {
+	sym, ok := namedKeys[name]
+	switch {
+	case ok:
+	case utf8.RuneCountInString(name) == 1:
+		r, _ := utf8.DecodeRuneInString(name)
+		sym = keysym.RuneToKeysym(r)
+	case len(name) >= 2 && name[0] == 'F':
+		n, err := strconv.Atoi(name[1:])
+		if err != nil || n < 1 || n > 35 {
+			return fmt.Errorf("unknown key %q", name)
+		}
+		sym = keysym.F1 + uint32(n-1)
+	default:
+		return fmt.Errorf("unknown key %q", name)
+	}
+	return v.client.KeyEvent(sym, down)
+}
+
+// pointer moves the pointer to (x, y) with the buttons in the RFB button mask held.
+func (v *viewer) pointer(x, y, buttons string) error {
+	px, err := strconv.Atoi(x)
+	if err != nil {
+		return fmt.Errorf("invalid x: %v", err)
+	}
+	py, err := strconv.Atoi(y)
+	if err != nil {
+		return fmt.Errorf("invalid y: %v", err)
+	}
+	mask, err := strconv.ParseUint(buttons, 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid buttons: %v", err)
+	}
+	return v.client.PointerEvent(image.Pt(px, py), uint8(mask))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"html/template"
+)
+
+// page shows the frames from /frame on a canvas, fetching the next as soon as each arrives, and posts key and pointer events to /key and /pointer, in order.
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}}</title>
+<style>
+body { margin: 0; background: #333; color: #eee; font: 14px sans-serif; }
+canvas { display: block; outline: none; cursor: default; }
+#status { position: fixed; bottom: 0; left: 0; padding: 4px 8px; background: rgba(0, 0, 0, 0.6); }
+#status:empty { display: none; }
+</style>
+</head>
+<body>
+<canvas id="screen" tabindex="0" width="0" height="0"></canvas>
+<div id="status">Connecting…</div>
+<script>
+"use strict";
+const canvas = document.getElementById("screen");
+const ctx = canvas.getContext("2d");
+const status = document.getElementById("status");
+
+// Events are posted one at a time, so that they arrive in order. Pointer moves that are still waiting are replaced by newer ones.
+const queue = [];
+let sending = false;
+function send(path, params) {
+	const last = queue[queue.length - 1];
+	if (path === "/pointer" && last && last.path === "/pointer" && last.params.buttons === params.buttons) {
+		last.params = params;
+	} else {
+		queue.push({path, params});
+	}
+	if (!sending) {
+		flush();
+	}
+}
+async function flush() {
+	sending = true;
+	while (queue.length > 0) {
+		const {path, params} = queue.shift();
+		try {
+			const resp = await fetch(path, {method: "POST", body: new URLSearchParams(params)});
+			if (!resp.ok) {
+				console.log(path, await resp.text());
+			}
+		} catch (e) {
+			status.textContent = "Couldn't send event: " + e;
+		}
+	}
+	sending = false;
+}
+
+async function poll() {
+	let version = 0;
+	for (;;) {
+		let resp;
+		try {
+			resp = await fetch("/frame?after=" + version);
+		} catch (e) {
+			status.textContent = "Couldn't fetch frame: " + e;
+			return;
+		}
+		if (!resp.ok) {
+			status.textContent = await resp.text();
+			return;
+		}
+		version = Number(resp.headers.get("X-Frame-Version"));
+		const bitmap = await createImageBitmap(await resp.blob());
+		if (canvas.width !== bitmap.width || canvas.height !== bitmap.height) {
+			canvas.width = bitmap.width;
+			canvas.height = bitmap.height;
+		}
+		ctx.drawImage(bitmap, 0, 0);
+		bitmap.close();
+		status.textContent = "";
+	}
+}
+
+// RFB numbers buttons left, middle, right; the DOM numbers them left, right, middle.
+let buttons = 0;
+function pointer(e) {
+	const r = canvas.getBoundingClientRect();
+	const x = Math.min(Math.max(Math.floor(e.clientX - r.left), 0), canvas.width - 1);
+	const y = Math.min(Math.max(Math.floor(e.clientY - r.top), 0), canvas.height - 1);
+	buttons = (e.buttons & 1) | (e.buttons & 4) >> 1 | (e.buttons & 2) << 1;
+	send("/pointer", {x, y, buttons});
+	return {x, y};
+}
+canvas.addEventListener("mousedown", e => { canvas.focus(); pointer(e); e.preventDefault(); });
+window.addEventListener("mouseup", pointer);
+window.addEventListener("mousemove", pointer);
+canvas.addEventListener("contextmenu", e => e.preventDefault());
+canvas.addEventListener("wheel", e => {
+	// Buttons 4 and 5 scroll up and down; each click is a press and a release.
+	const {x, y} = pointer(e);
+	const button = e.deltaY < 0 ? 8 : 16;
+	send("/pointer", {x, y, buttons: buttons | button});
+	send("/pointer", {x, y, buttons});
+	e.preventDefault();
+}, {passive: false});
+
+function key(e, down) {
+	if (e.isComposing) {
+		return;
+	}
+	send("/key", {key: e.key, down: down ? "1" : "0"});
+	e.preventDefault();
+}
+canvas.addEventListener("keydown", e => key(e, true));
+canvas.addEventListener("keyup", e => key(e, false));
+
+canvas.focus();
+poll();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,242 @@
+// Command vncbench measures how quickly a VNC server answers update requests, so that changes to encoding and the update pipeline can be quantified. It connects -clients simulated viewers, each of which requests an update -rate times per second, as long as the last one was answered, and reports throughput and percentiles of the time that updates took to arrive.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfbclient"
+	"image"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	addr         = flag.String("addr", "127.0.0.1:5900", "Address of the VNC server, as host:port.")
+	clients      = flag.Int("clients", 10, "How many viewers to simulate at once.")
+	rate         = flag.Float64("rate", 10, "Updates that each viewer requests per second.")
+	duration     = flag.Duration("duration", 10*time.Second, "How long to measure for, once every viewer has connected.")
+	encodings    = flag.String("encodings", "", "Comma-separated encodings to ask for, most preferred first, such as tight,hextile,raw. Defaults to all that package rfbclient decodes.")
+	incremental  = flag.Bool("incremental", false, "If true, requests after each viewer's first are incremental. Servers answer those only once something changes, so latency includes waiting for changes.")
+	password     = flag.String("password", "", "Password for servers that require VNC authentication.")
+	passwordFile = flag.String("password_file", "", "If set, reads the password from the first line of this file instead.")
+)
+
+// connectTimeout is how long each viewer has to connect and finish the handshake.
+const connectTimeout = 30 * time.Second
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		log.Fatalf("expected no args, but got %d", flag.NArg())
+	}
+	if *clients <= 0 {
+		log.Fatalf("-clients must be positive, but was %d", *clients)
+	}
+	if *rate <= 0 {
+		log.Fatalf("-rate must be positive, but was %v", *rate)
+	}
+	encodingTypes, err := parseEncodings(*encodings)
+	if err != nil {
+		log.Fatalf("couldn't parse -encodings: %v", err)
+	}
+	if *passwordFile != "" {
+		if *password != "" {
+			log.Fatal("-password and -password_file are mutually exclusive")
+		}
+		contents, err := ioutil.ReadFile(*passwordFile)
+		if err != nil {
+			log.Fatalf("couldn't read password file: %v", err)
+		}
+		*password = strings.TrimRight(strings.SplitN(string(contents), "\n", 2)[0], "\r")
+	}
+
+	// Connect every viewer before measuring any, so that they're all measured under the same load.
+	viewers := make([]*viewer, *clients)
+	errs := make([]error, *clients)
+	var wg sync.WaitGroup
+	for idx := range viewers {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			viewers[idx], errs[idx] = connect(encodingTypes)
+		}(idx)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			log.Fatalf("couldn't connect: %v", err)
+		}
+	}
+	log.Printf("connected %d viewers; measuring for %v", *clients, *duration)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	start := time.Now()
+	for idx, v := range viewers {
+		wg.Add(1)
+		go func(idx int, v *viewer) {
+			defer wg.Done()
+			errs[idx] = v.run(ctx)
+		}(idx, v)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	for _, err := range errs {
+		if err != nil {
+			log.Fatalf("viewer failed: %v", err)
+		}
+	}
+	report(viewers, elapsed)
+}
+
+// parseEncodings returns the encoding types named in list, or nil if it's empty.
+func parseEncodings(list string) ([]uint32, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var types []uint32
+	for _, name := range strings.Split(list, ",") {
+		found := false
+		for _, t := range rfbclient.DefaultEncodings {
+			if strings.EqualFold(strings.TrimSpace(name), rfb.EncodingName(t)) {
+				types = append(types, t)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown encoding %q", name)
+		}
+	}
+	return types, nil
+}
+
+// viewer is one simulated viewer and what it measured.
+type viewer struct {
+	client   *rfbclient.Client
+	conn     *countingConn
+	answered chan time.Time // When each update arrived
+
+	latencies []time.Duration
+	skipped   int // Requests not sent because the last one wasn't answered yet
+}
+
+// countingConn counts the bytes read from it.
+type countingConn struct {
+	net.Conn
+	n int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func connect(encodingTypes []uint32) (*viewer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", *addr)
+	if err != nil {
+		return nil, err
+	}
+	v := &viewer{conn: &countingConn{Conn: conn}, answered: make(chan time.Time, 16)}
+	v.client, err = rfbclient.NewClient(ctx, v.conn, &rfbclient.Options{
+		Password:      func() (string, error) { return *password, nil },
+		Shared:        true,
+		Encodings:     encodingTypes,
+		ManualUpdates: true,
+		OnUpdate: func([]image.Rectangle) {
+			select {
+			case v.answered <- time.Now():
+			default:
+			}
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return v, nil
+}
+
+// run requests updates at -rate, recording how long each took, until ctx ends. It closes the connection before returning.
+func (v *viewer) run(ctx context.Context) error {
+	defer v.client.Close()
+	runErr := make(chan error, 1)
+	go func() { runErr <- v.client.Run(context.Background()) }()
+	// Only bytes received while measuring count.
+	atomic.StoreInt64(&v.conn.n, 0)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer ticker.Stop()
+	var sent time.Time // When the outstanding request was sent, or zero if there isn't one
+	requested := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-runErr:
+			return err
+		case at := <-v.answered:
+			// Updates that weren't requested, if the server sends any, aren't timed.
+			if !sent.IsZero() {
+				v.latencies = append(v.latencies, at.Sub(sent))
+				sent = time.Time{}
+			}
+		case <-ticker.C:
+			if !sent.IsZero() {
+				v.skipped++
+				continue
+			}
+			sent = time.Now()
+			// The first request is for the whole framebuffer, to fill it.
+			if err := v.client.RequestUpdate(requested && *incremental); err != nil {
+				return err
+			}
+			requested = true
+		}
+	}
+}
+
+func report(viewers []*viewer, elapsed time.Duration) {
+	var latencies []time.Duration
+	var bytes int64
+	skipped := 0
+	for _, v := range viewers {
+		latencies = append(latencies, v.latencies...)
+		bytes += atomic.LoadInt64(&v.conn.n)
+		skipped += v.skipped
+	}
+	seconds := elapsed.Seconds()
+	fmt.Printf("%d viewers for %v: %d updates (%.1f/s), %s (%s/s), %d requests skipped while waiting for updates\n",
+		len(viewers), elapsed.Round(time.Millisecond), len(latencies), float64(len(latencies))/seconds, formatBytes(float64(bytes)), formatBytes(float64(bytes)/seconds), skipped)
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		return latencies[int(math.Ceil(p*float64(len(latencies))))-1].Round(time.Microsecond)
+	}
+	fmt.Printf("latency: p50 %v, p90 %v, p99 %v, max %v\n", percentile(0.5), percentile(0.9), percentile(0.99), latencies[len(latencies)-1].Round(time.Microsecond))
+}
+
+func formatBytes(n float64) string {
+	switch {
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f MB", n/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1f kB", n/1e3)
+	}
+	return fmt.Sprintf("%.0f B", n)
+}
@@ -0,0 +1,102 @@
+// Command vncsnap connects to a VNC server, takes one full frame, and writes it as a PNG.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"github.com/alltom/vncfreethumb/rfbclient"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	addr         = flag.String("addr", "127.0.0.1:5900", "Address of the VNC server, as host:port.")
+	output       = flag.String("o", "-", "File to write the PNG to, or - for stdout.")
+	password     = flag.String("password", "", "Password for servers that require VNC authentication.")
+	passwordFile = flag.String("password_file", "", "If set, reads the password from the first line of this file instead.")
+	timeout      = flag.Duration("timeout", 30*time.Second, "How long to wait for the connection, the handshake, and the frame, together.")
+	exclusive    = flag.Bool("exclusive", false, "If true, asks the server to disconnect other clients, rather than to share the desktop with them.")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		log.Fatalf("expected no args, but got %d", flag.NArg())
+	}
+	if *passwordFile != "" {
+		if *password != "" {
+			log.Fatal("-password and -password_file are mutually exclusive")
+		}
+		contents, err := ioutil.ReadFile(*passwordFile)
+		if err != nil {
+			log.Fatalf("couldn't read password file: %v", err)
+		}
+		*password = strings.TrimRight(strings.SplitN(string(contents), "\n", 2)[0], "\r")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	img, err := snap(ctx)
+	if err != nil {
+		log.Fatalf("couldn't take a frame: %v", err)
+	}
+
+	// Encode before creating the file, so that a failure doesn't leave an empty one.
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		log.Fatalf("couldn't encode PNG: %v", err)
+	}
+	if *output == "-" {
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			log.Fatalf("couldn't write PNG: %v", err)
+		}
+		return
+	}
+	if err := ioutil.WriteFile(*output, buf.Bytes(), 0666); err != nil {
+		log.Fatalf("couldn't write PNG: %v", err)
+	}
+}
+
+// snap returns the first full frame that the server at -addr sends.
+func snap(ctx context.Context) (image.Image, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", *addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	frame := make(chan image.Image, 1)
+	var client *rfbclient.Client
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	client, err = rfbclient.NewClient(ctx, conn, &rfbclient.Options{
+		Password: func() (string, error) { return *password, nil },
+		Shared:   !*exclusive,
+		OnUpdate: func(rects []image.Rectangle) {
+			// Run asks for the whole framebuffer first, so its first update has all of it.
+			select {
+			case frame <- client.Image():
+			default:
+			}
+			stop()
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = client.Run(runCtx)
+	select {
+	case img := <-frame:
+		return img, nil
+	default:
+		return nil, err
+	}
+}
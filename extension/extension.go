@@ -0,0 +1,142 @@
+/*
+Package extension defines the interfaces through which third-party code adds UI tools, image loaders, rectangle encoders, and power control to the server without forking it.
+
+Extensions are Go plugins (see the standard library's plugin package) that export a function named Register with the signature of RegisterFunc:
+
+	package main
+
+	import "github.com/alltom/vncfreethumb/extension"
+
+	func Register(r *extension.Registry) error {
+		r.RegisterTool(myTool{})
+		return nil
+	}
+
+Build one with "go build -buildmode=plugin" against the same version of this module as the server, then pass its path to the server's -plugin flag.
+*/
+package extension
+
+import (
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"image"
+	"io"
+	"plugin"
+)
+
+// RegisterFunc is the type of the Register function every plugin must export.
+type RegisterFunc = func(r *Registry) error
+
+// Window is the view of a UI window that tools operate on.
+type Window interface {
+	Image() image.Image
+	SetImage(img image.Image)
+
+	// Crop is the visible region of Image.
+	Crop() image.Rectangle
+	SetCrop(crop image.Rectangle)
+}
+
+// Tool is a UI command invoked by pressing a key while the pointer is over a window.
+type Tool interface {
+	// KeySym is the key that invokes the tool, such as keysym.LowerC from package rfb/keysym. Keys used by built-in commands take precedence, whether shifted or not.
+	KeySym() uint32
+
+	// Apply runs the tool on win. pt is the pointer location in the coordinates of win.Image().
+	Apply(win Window, pt image.Point)
+}
+
+// Encoder encodes framebuffer rectangles for clients that list its encoding type in SetEncodings. Each connection gets its own Encoder, so encoders may keep state such as compression streams.
+type Encoder interface {
+	EncodingType() uint32
+
+	// Encode returns rectangles that together cover img.
+	Encode(img *rfb.PixelFormatImage) ([]*rfb.FramebufferUpdateRect, error)
+}
+
+// ConfigurableEncoder is an Encoder that honors the compression and quality levels that clients ask for. Configure is called after each SetEncodings that selects the encoder.
+type ConfigurableEncoder interface {
+	Encoder
+	Configure(settings rfb.EncodingSettings)
+}
+
+// SelectiveEncoder is an Encoder that only suits some rectangles, such as ones with few colours. Rectangles that it doesn't suit go to the next encoding in the client's order of preference, or raw. Since it isn't told, Suits must not change state that the client shares.
+type SelectiveEncoder interface {
+	Encoder
+	Suits(img *rfb.PixelFormatImage) bool
+}
+
+// Registry collects the extensions provided by plugins.
+type Registry struct {
+	Tools    []Tool
+	Encoders map[uint32]func() Encoder
+
+	// XVPHandler, if set, carries out xvp power-control operations requested by clients.
+	XVPHandler func(code uint8) error
+}
+
+func NewRegistry() *Registry {
+	return &Registry{Encoders: make(map[uint32]func() Encoder)}
+}
+
+func (r *Registry) RegisterTool(tool Tool) {
+	r.Tools = append(r.Tools, tool)
+}
+
+// RegisterLoader adds an image format that the UI can open. It is equivalent to image.RegisterFormat.
+func (r *Registry) RegisterLoader(name, magic string, decode func(io.Reader) (image.Image, error), decodeConfig func(io.Reader) (image.Config, error)) {
+	image.RegisterFormat(name, magic, decode, decodeConfig)
+}
+
+// RegisterEncoder adds a constructor for the encoder of encodingType, replacing any other.
+func (r *Registry) RegisterEncoder(encodingType uint32, newEncoder func() Encoder) {
+	r.Encoders[encodingType] = newEncoder
+}
+
+// RegisterH264Codec offers EncodingTypeOpenH264 to clients, compressing with codec.
+func (r *Registry) RegisterH264Codec(codec rfb.H264Codec) {
+	r.RegisterEncoder(rfb.EncodingTypeOpenH264, func() Encoder {
+		return &openH264Encoder{rfb.OpenH264Encoder{Codec: codec}}
+	})
+}
+
+type openH264Encoder struct {
+	enc rfb.OpenH264Encoder
+}
+
+func (*openH264Encoder) EncodingType() uint32 {
+	return rfb.EncodingTypeOpenH264
+}
+
+func (e *openH264Encoder) Encode(img *rfb.PixelFormatImage) ([]*rfb.FramebufferUpdateRect, error) {
+	rect, err := e.enc.Encode(img)
+	if err != nil {
+		return nil, err
+	}
+	return []*rfb.FramebufferUpdateRect{rect}, nil
+}
+
+// RegisterXVPHandler offers the xvp extension to clients, calling handler with rfb.XVPShutdown, rfb.XVPReboot, or rfb.XVPReset when one asks. If handler returns an error, the client is told that the operation failed.
+func (r *Registry) RegisterXVPHandler(handler func(code uint8) error) {
+	r.XVPHandler = handler
+}
+
+// Load opens the Go plugin at path and calls its Register function.
+func (r *Registry) Load(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("open plugin: %v", err)
+	}
+	sym, err := p.Lookup("Register")
+	if err != nil {
+		return fmt.Errorf("look up Register: %v", err)
+	}
+	register, ok := sym.(RegisterFunc)
+	if !ok {
+		return fmt.Errorf("expected Register to have type %T, but it has type %T", RegisterFunc(nil), sym)
+	}
+	if err := register(r); err != nil {
+		return fmt.Errorf("register: %v", err)
+	}
+	return nil
+}
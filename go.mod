@@ -4,5 +4,11 @@ go 1.15
 
 require (
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
-	golang.org/x/text v0.3.5
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
+	golang.org/x/text v0.3.6
 )
@@ -0,0 +1,111 @@
+//go:build interop
+// +build interop
+
+package interop
+
+import (
+	"context"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/vncserver"
+	"image"
+	"image/color"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// desktop is a solid desktopColor, which passes on what clients copy.
+type desktop struct {
+	cutText chan string
+}
+
+func (d *desktop) Size() (width, height int) { return 640, 480 }
+
+func (d *desktop) Render(r image.Rectangle) image.Image {
+	return image.NewUniform(desktopColor)
+}
+
+func (d *desktop) HandleKey(event rfb.KeyEventMessage)         {}
+func (d *desktop) HandlePointer(event rfb.PointerEventMessage) {}
+
+func (d *desktop) HandleCutText(text string) {
+	select {
+	case d.cutText <- text:
+	default:
+	}
+}
+
+// expectCutText waits for a client to copy text that starts with prefix, and returns it.
+func (d *desktop) expectCutText(t *testing.T, prefix string) string {
+	t.Helper()
+	timeout := time.After(startTimeout)
+	for {
+		select {
+		case text := <-d.cutText:
+			if strings.HasPrefix(text, prefix) {
+				return text
+			}
+		case <-timeout:
+			t.Fatalf("gave up waiting for the client to copy %q after %v", prefix, startTimeout)
+		}
+	}
+}
+
+// serve serves a desktop on every interface, so that containers can reach it, until the test ends, and returns it with its port.
+func serve(t *testing.T) (*desktop, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &desktop{cutText: make(chan string, 16)}
+	srv := vncserver.NewServer(d, &vncserver.Options{Name: "interop"})
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		ln.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx, "")
+	})
+	return d, strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func TestTigerVNCViewer(t *testing.T) {
+	for _, encoding := range []string{"Tight", "Hextile", "Raw"} {
+		encoding := encoding
+		t.Run(encoding, func(t *testing.T) {
+			d, port := serve(t)
+			c := run(t, "tigervnc-viewer", "-e", "VNC_SERVER=host.docker.internal::"+port, "-e", "ENCODING="+encoding)
+
+			// The viewer is full screen, with the desktop in the middle of the display.
+			eventually(t, "the viewer to show the desktop", func() bool {
+				img, err := c.screenshot()
+				return err == nil && near(img.At(320, 240))
+			})
+
+			if _, err := c.exec("setclip", "copied in the viewer"); err != nil {
+				t.Fatalf("couldn't copy in the viewer: %v", err)
+			}
+			d.expectCutText(t, "copied in the viewer")
+		})
+	}
+}
+
+func TestNoVNC(t *testing.T) {
+	d, port := serve(t)
+	run(t, "novnc", "-e", "VNC_SERVER=host.docker.internal:"+port)
+
+	// interop.html reports back through the clipboard.
+	d.expectCutText(t, "hello from noVNC")
+	var r, g, b uint8
+	text := d.expectCutText(t, "pixel ")
+	if _, err := fmt.Sscanf(text, "pixel %d %d %d", &r, &g, &b); err != nil {
+		t.Fatalf("couldn't parse %q: %v", text, err)
+	}
+	if got := (color.RGBA{r, g, b, 0xff}); !near(got) {
+		t.Errorf("expected noVNC to draw %v, but it drew %v", desktopColor, got)
+	}
+}
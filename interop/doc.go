@@ -0,0 +1,6 @@
+// Package interop checks this module's client and server against third-party implementations: x11vnc and TigerVNC's servers, TigerVNC's viewer, and noVNC in headless Chromium. Each runs in a Docker container, built from testdata, so the tests are opt-in, behind the interop build tag:
+//
+//	go test -tags interop ./interop
+//
+// Tests are skipped if the docker command isn't installed. The first run builds the images, which takes some minutes.
+package interop
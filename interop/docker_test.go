@@ -0,0 +1,136 @@
+//go:build interop
+// +build interop
+
+package interop
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTimeout bounds how long containers have to start serving, and third-party programs have to react.
+const startTimeout = time.Minute
+
+// desktopColor is the colour of every desktop in the tests, as set by the containers' start.sh.
+var desktopColor = color.RGBA{0x33, 0x66, 0x99, 0xff}
+
+var (
+	builtMu sync.Mutex
+	built   = map[string]string{}
+)
+
+// build builds the image in testdata/name, once per run, and returns its tag.
+func build(t *testing.T, name string) string {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker isn't installed")
+	}
+	builtMu.Lock()
+	defer builtMu.Unlock()
+	if tag, ok := built[name]; ok {
+		return tag
+	}
+	tag := "vncfreethumb-interop-" + name
+	docker(t, "build", "-q", "-t", tag, "-f", "testdata/"+name+"/Dockerfile", "testdata")
+	built[name] = tag
+	return tag
+}
+
+// docker runs the docker command with args and returns its output, failing t if it fails.
+func docker(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("docker", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			t.Fatalf("docker %s failed: %v\n%s", args[0], err, exitErr.Stderr)
+		}
+		t.Fatalf("docker %s failed: %v", args[0], err)
+	}
+	return string(out)
+}
+
+// container is a running container, which is removed when its test ends.
+type container struct {
+	id string
+}
+
+// run starts a container from the image in testdata/name, with extra args to docker run. Containers can reach the host as host.docker.internal.
+func run(t *testing.T, name string, args ...string) *container {
+	t.Helper()
+	tag := build(t, name)
+	args = append([]string{"run", "-d", "--add-host", "host.docker.internal:host-gateway"}, args...)
+	c := &container{strings.TrimSpace(docker(t, append(args, tag)...))}
+	t.Cleanup(func() {
+		if t.Failed() {
+			out, _ := exec.Command("docker", "logs", c.id).CombinedOutput()
+			t.Logf("%s container logged:\n%s", name, out)
+		}
+		exec.Command("docker", "rm", "-f", c.id).Run()
+	})
+	return c
+}
+
+// runServer starts an RFB server from the image in testdata/name, publishing port 5900 on the loopback interface, and returns its address once it sends a ProtocolVersion.
+func runServer(t *testing.T, name string, args ...string) (*container, string) {
+	t.Helper()
+	c := run(t, name, append([]string{"-p", "127.0.0.1::5900"}, args...)...)
+	addr := strings.TrimSpace(strings.SplitN(docker(t, "port", c.id, "5900/tcp"), "\n", 2)[0])
+	// Docker accepts connections to published ports before anything in the container listens, so only a ProtocolVersion shows that the server is up.
+	eventually(t, "the server to start", func() bool {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		version := make([]byte, 12)
+		_, err = io.ReadFull(conn, version)
+		return err == nil && bytes.HasPrefix(version, []byte("RFB "))
+	})
+	return c, addr
+}
+
+// exec runs a command in the container and returns its output.
+func (c *container) exec(args ...string) (string, error) {
+	out, err := exec.Command("docker", append([]string{"exec", c.id}, args...)...).Output()
+	return string(out), err
+}
+
+// screenshot returns what the container's display shows.
+func (c *container) screenshot() (image.Image, error) {
+	out, err := c.exec("screenshot")
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(strings.NewReader(out))
+}
+
+// eventually calls f until it returns true, failing t if it doesn't within startTimeout.
+func eventually(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(startTimeout)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("gave up waiting for %s after %v", what, startTimeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// near reports whether c is desktopColor, give or take what lossy encodings lose.
+func near(c color.Color) bool {
+	got := color.RGBAModel.Convert(c).(color.RGBA)
+	within := func(a, b uint8) bool { return a-b < 8 || b-a < 8 }
+	return within(got.R, desktopColor.R) && within(got.G, desktopColor.G) && within(got.B, desktopColor.B)
+}
@@ -0,0 +1,147 @@
+//go:build interop
+// +build interop
+
+package interop
+
+import (
+	"context"
+	"errors"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfb/rfbtest"
+	"github.com/alltom/vncfreethumb/rfbclient"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+const password = "secret"
+
+// servers are the third-party servers that the client is tested against, with the encodings that each can send.
+var servers = []struct {
+	name      string
+	encodings []uint32
+}{
+	{"x11vnc", []uint32{rfb.EncodingTypeRaw, rfb.EncodingTypeRRE, rfb.EncodingTypeCoRRE, rfb.EncodingTypeHextile, rfb.EncodingTypeTight}},
+	{"tigervnc", []uint32{rfb.EncodingTypeRaw, rfb.EncodingTypeRRE, rfb.EncodingTypeHextile, rfb.EncodingTypeTight}},
+}
+
+// proxy returns a serve function for rfbtest.Connect that relays the pipe to the server at addr.
+func proxy(addr string) func(conn net.Conn) error {
+	return func(conn net.Conn) error {
+		server, err := net.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+		go func() {
+			io.Copy(server, conn)
+			server.Close()
+		}()
+		_, err = io.Copy(conn, server)
+		return err
+	}
+}
+
+func handshakeOptions(password string) rfb.ClientHandshakeOptions {
+	return rfb.ClientHandshakeOptions{Password: func() (string, error) { return password, nil }, Shared: true}
+}
+
+func TestServers(t *testing.T) {
+	for _, server := range servers {
+		server := server
+		t.Run(server.name, func(t *testing.T) {
+			c, addr := runServer(t, server.name, "-e", "PASSWORD="+password)
+			t.Run("Handshake", func(t *testing.T) { testHandshake(t, addr) })
+			t.Run("Encodings", func(t *testing.T) { testEncodings(t, addr, server.encodings) })
+			t.Run("Clipboard", func(t *testing.T) { testClipboard(t, c, addr) })
+		})
+	}
+}
+
+func testHandshake(t *testing.T, addr string) {
+	c := rfbtest.Connect(t, proxy(addr), handshakeOptions(password))
+	if got := c.Handshake.SecurityType; got != rfb.SecurityTypeVNC {
+		t.Errorf("expected VNC authentication, but got %v", got)
+	}
+	if init := c.Handshake.ServerInit; init.FramebufferWidth != 640 || init.FramebufferHeight != 480 || !strings.Contains(init.Name, "interop") {
+		t.Errorf("expected a 640x480 desktop named interop, but got %dx%d, named %q", init.FramebufferWidth, init.FramebufferHeight, init.Name)
+	}
+	c.Close()
+
+	_, err := rfbtest.TryConnect(t, proxy(addr), handshakeOptions("guess"))
+	var failure *rfb.SecurityFailure
+	if !errors.As(err, &failure) {
+		t.Errorf("expected a SecurityFailure for the wrong password, but got %v", err)
+	}
+}
+
+func testEncodings(t *testing.T, addr string, encodings []uint32) {
+	for _, pf := range []rfb.PixelFormat{rfb.PixelFormatRGBA8888LittleEndian, rfb.PixelFormatRGB565} {
+		for _, encoding := range encodings {
+			c := rfbtest.Connect(t, proxy(addr), handshakeOptions(password))
+			c.Send(&rfb.SetPixelFormatMessage{PixelFormat: pf}, &rfb.SetEncodingsMessage{EncodingTypes: []uint32{encoding}})
+			u := c.RequestUpdate(false)
+			for _, rect := range u.Rectangles {
+				if rect.EncodingType != encoding {
+					t.Errorf("%v: asked for only %s, but got a %s rectangle", pf, rfb.EncodingName(encoding), rfb.EncodingName(rect.EncodingType))
+					break
+				}
+			}
+			// RGB565 keeps only the top bits of each channel, which near allows for.
+			if got := c.Framebuffer.At(10, 10); !near(got) {
+				t.Errorf("%v, %s: expected the desktop to be %v, but got %v", pf, rfb.EncodingName(encoding), desktopColor, got)
+			}
+			c.Close()
+		}
+	}
+}
+
+func testClipboard(t *testing.T, container *container, addr string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cutText := make(chan string, 16)
+	client, err := rfbclient.NewClient(context.Background(), conn, &rfbclient.Options{
+		Password: func() (string, error) { return password, nil },
+		Shared:   true,
+		OnCutText: func(text string) {
+			select {
+			case cutText <- text:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	defer client.Close()
+	go client.Run(context.Background())
+
+	if _, err := container.exec("setclip", "copied on the server"); err != nil {
+		t.Fatalf("couldn't copy on the server: %v", err)
+	}
+	eventually(t, "the server's clipboard", func() bool {
+		for {
+			select {
+			case text := <-cutText:
+				if text == "copied on the server" {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	})
+
+	if err := client.CutText("copied in the client"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the client's clipboard", func() bool {
+		// getclip fails until something owns a selection.
+		text, err := container.exec("getclip")
+		return err == nil && text == "copied in the client"
+	})
+}
@@ -0,0 +1,6 @@
+#!/bin/sh
+# Serves noVNC, proxied to $VNC_SERVER, a host:port, and opens interop.html in headless Chromium, which runs until the container is stopped.
+set -e
+websockify --web /novnc 6080 "$VNC_SERVER" &
+until curl -fs http://localhost:6080/interop.html >/dev/null; do sleep 0.1; done
+exec chromium --headless --no-sandbox --disable-gpu --disable-dev-shm-usage --remote-debugging-port=9222 http://localhost:6080/interop.html
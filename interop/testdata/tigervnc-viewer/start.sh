@@ -0,0 +1,6 @@
+#!/bin/sh
+# Connects to $VNC_SERVER, a host::port, preferring $ENCODING: Tight, Hextile, or Raw.
+set -e
+Xvfb :1 -screen 0 640x480x24 &
+until xset q >/dev/null 2>&1; do sleep 0.1; done
+exec vncviewer -FullScreen -Shared -AutoSelect=0 -PreferredEncoding="${ENCODING:-Tight}" "$VNC_SERVER"
@@ -0,0 +1,8 @@
+#!/bin/sh
+set -e
+printf '%s\n' "${PASSWORD:-secret}" | vncpasswd -f >/tmp/passwd
+Xvnc :1 -geometry 640x480 -depth 24 -rfbport 5900 -localhost no -AlwaysShared -desktop interop \
+	-SecurityTypes VncAuth -PasswordFile /tmp/passwd &
+# The tests compare what clients see with this colour.
+until xsetroot -solid '#336699' 2>/dev/null; do sleep 0.1; done
+wait
@@ -0,0 +1,6 @@
+#!/bin/sh
+set -e
+Xvfb :1 -screen 0 640x480x24 &
+# The tests compare what clients see with this colour.
+until xsetroot -solid '#336699' 2>/dev/null; do sleep 0.1; done
+exec x11vnc -display :1 -rfbport 5900 -forever -shared -nocursor -desktop interop -passwd "${PASSWORD:-secret}"
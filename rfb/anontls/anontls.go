@@ -0,0 +1,507 @@
+// Package anontls implements the server side of TLS 1.0 to 1.2 with anonymous Diffie-Hellman key exchange, which is what clients of RFB's TLS security type, such as vino and gtk-vnc, offer, and which crypto/tls doesn't support. Anonymous key exchange keeps out eavesdroppers, but not a man in the middle, since the server proves nothing about who it is.
+package anontls
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+)
+
+// Protocol versions.
+const (
+	versionTLS10 = 0x0301
+	versionTLS11 = 0x0302
+	versionTLS12 = 0x0303
+)
+
+// Record content types.
+const (
+	recordChangeCipherSpec = 20
+	recordAlert            = 21
+	recordHandshake        = 22
+	recordApplicationData  = 23
+)
+
+// Handshake message types.
+const (
+	typeClientHello       = 1
+	typeServerHello       = 2
+	typeServerKeyExchange = 12
+	typeServerHelloDone   = 14
+	typeClientKeyExchange = 16
+	typeFinished          = 20
+)
+
+// Alert levels and descriptions.
+const (
+	alertWarning = 1
+	alertFatal   = 2
+
+	alertCloseNotify       = 0
+	alertUnexpectedMessage = 10
+	alertBadRecordMAC      = 20
+	alertRecordOverflow    = 22
+	alertHandshakeFailure  = 40
+	alertIllegalParameter  = 47
+	alertDecodeError       = 50
+	alertDecryptError      = 51
+	alertProtocolVersion   = 70
+	alertInternalError     = 80
+	alertNoRenegotiation   = 100
+)
+
+// Extensions, and the cipher suite value that stands for renegotiation_info.
+const (
+	extensionExtendedMasterSecret = 23
+	extensionRenegotiationInfo    = 0xff01
+	scsvRenegotiation             = 0x00ff
+)
+
+const (
+	recordHeaderLen = 5
+	// maxPlaintext is the most data that a record carries, and maxCiphertext the most that one read may take, with room for a MAC, padding, and an IV.
+	maxPlaintext  = 1 << 14
+	maxCiphertext = maxPlaintext + 2048
+	// maxHandshake bounds the handshake messages that are read, which for the server are only ClientHello, ClientKeyExchange, and Finished.
+	maxHandshake = 1 << 16
+	finishedLen  = 12
+)
+
+// dhPrime is the 2048-bit group from RFC 7919, ffdhe2048, whose generator is 2.
+var dhPrime, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617AD3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797ABC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F619172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005C58EF1837D1683B2C6F34A26C1B2EFFA886B423861285C97FFFFFFFFFFFFFFFF", 16)
+
+var dhGenerator = big.NewInt(2)
+
+// Server runs the server's side of a TLS handshake on conn and returns the connection to use from then on. A client that offers none of the anonymous cipher suites is handed to crypto/tls with fallback, for clients that take any certificate, or refused if fallback is nil.
+func Server(conn net.Conn, fallback *tls.Config) (net.Conn, error) {
+	c := &Conn{Conn: conn}
+	c.in.version, c.out.version = versionTLS10, versionTLS10
+	hello, err := c.readClientHello()
+	if err != nil {
+		return nil, fmt.Errorf("read ClientHello: %w", err)
+	}
+	if hello.suite == nil {
+		if fallback == nil {
+			c.sendAlert(alertFatal, alertHandshakeFailure)
+			return nil, errors.New("the client offered no anonymous Diffie-Hellman cipher suite")
+		}
+		tlsConn := tls.Server(&replayConn{Conn: conn, r: io.MultiReader(bytes.NewReader(c.raw), conn)}, fallback)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	c.raw = nil
+	if err := c.serverHandshake(hello); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// replayConn reads what was already read from Conn again before reading more.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Conn is the server's end of a TLS connection that Server has finished the handshake on.
+type Conn struct {
+	net.Conn
+
+	inMu      sync.Mutex
+	in        halfConn
+	handshake []byte // Handshake messages read but not yet handled.
+	input     []byte // Application data read but not yet returned.
+	readErr   error
+	raw       []byte // Every record read, until the handshake is known not to fall back.
+
+	outMu sync.Mutex
+	out   halfConn
+
+	transcript []byte // The handshake messages so far, for Finished.
+}
+
+// clientHello is what the server uses of a ClientHello.
+type clientHello struct {
+	version              uint16
+	random               []byte
+	suite                *cipherSuite
+	secureRenegotiation  bool
+	extendedMasterSecret bool
+}
+
+func (c *Conn) readClientHello() (*clientHello, error) {
+	c.raw = []byte{}
+	msg, err := c.readHandshake(typeClientHello)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{b: msg[4:]}
+	hello := &clientHello{version: uint16(p.u16()), random: p.bytes(32)}
+	p.vector8()
+	suites := p.vector16()
+	compressions := p.vector8()
+	var extensions []byte
+	if len(p.b) > 0 {
+		extensions = p.vector16()
+	}
+	if p.failed || len(suites)%2 != 0 || bytes.IndexByte(compressions, 0) < 0 {
+		c.sendAlert(alertFatal, alertDecodeError)
+		return nil, errors.New("malformed ClientHello")
+	}
+	if hello.version < versionTLS10 {
+		c.sendAlert(alertFatal, alertProtocolVersion)
+		return nil, fmt.Errorf("the client only supports SSL %#04x", hello.version)
+	}
+	if hello.version > versionTLS12 {
+		hello.version = versionTLS12
+	}
+
+	offered := make(map[uint16]bool)
+	for i := 0; i < len(suites); i += 2 {
+		offered[binary.BigEndian.Uint16(suites[i:])] = true
+	}
+	hello.secureRenegotiation = offered[scsvRenegotiation]
+	for i := range cipherSuites {
+		if s := &cipherSuites[i]; offered[s.id] && hello.version >= s.minVersion {
+			hello.suite = s
+			break
+		}
+	}
+	for e := (parser{b: extensions}); len(e.b) > 0 && !e.failed; {
+		typ, data := e.u16(), e.vector16()
+		switch typ {
+		case extensionRenegotiationInfo:
+			// This is the first handshake, so there's nothing to renegotiate from.
+			if !bytes.Equal(data, []byte{0}) {
+				c.sendAlert(alertFatal, alertHandshakeFailure)
+				return nil, errors.New("the client sent a renegotiation_info for another handshake")
+			}
+			hello.secureRenegotiation = true
+		case extensionExtendedMasterSecret:
+			hello.extendedMasterSecret = true
+		}
+	}
+	return hello, nil
+}
+
+func (c *Conn) serverHandshake(hello *clientHello) error {
+	version, suite := hello.version, hello.suite
+	c.in.version, c.out.version = version, version
+
+	serverRandom := make([]byte, 32)
+	if _, err := rand.Read(serverRandom); err != nil {
+		return err
+	}
+	private, err := rand.Int(rand.Reader, new(big.Int).Sub(dhPrime, big.NewInt(3)))
+	if err != nil {
+		return err
+	}
+	private.Add(private, big.NewInt(2))
+	public := new(big.Int).Exp(dhGenerator, private, dhPrime)
+
+	serverHello := []byte{byte(version >> 8), byte(version)}
+	serverHello = append(serverHello, serverRandom...)
+	serverHello = append(serverHello, 0) // No session ID, since sessions aren't resumed.
+	serverHello = append(serverHello, byte(suite.id>>8), byte(suite.id), 0)
+	var extensions []byte
+	if hello.secureRenegotiation {
+		extensions = append(extensions, 0xff, 0x01, 0, 1, 0)
+	}
+	if hello.extendedMasterSecret {
+		extensions = append(extensions, 0, extensionExtendedMasterSecret, 0, 0)
+	}
+	if len(extensions) > 0 {
+		serverHello = appendVector16(serverHello, extensions)
+	}
+	var keyExchange []byte
+	for _, n := range []*big.Int{dhPrime, dhGenerator, public} {
+		keyExchange = appendVector16(keyExchange, n.Bytes())
+	}
+	var flight []byte
+	flight = append(flight, c.handshakeMessage(typeServerHello, serverHello)...)
+	flight = append(flight, c.handshakeMessage(typeServerKeyExchange, keyExchange)...)
+	flight = append(flight, c.handshakeMessage(typeServerHelloDone, nil)...)
+	if err := c.writeRecord(recordHandshake, flight); err != nil {
+		return fmt.Errorf("write ServerHello: %w", err)
+	}
+
+	msg, err := c.readHandshake(typeClientKeyExchange)
+	if err != nil {
+		return fmt.Errorf("read ClientKeyExchange: %w", err)
+	}
+	p := parser{b: msg[4:]}
+	clientPublic := new(big.Int).SetBytes(p.vector16())
+	if p.failed || len(p.b) > 0 {
+		c.sendAlert(alertFatal, alertDecodeError)
+		return errors.New("malformed ClientKeyExchange")
+	}
+	// Values outside (1, p-1) would give away the shared secret.
+	if clientPublic.Cmp(big.NewInt(1)) <= 0 || clientPublic.Cmp(new(big.Int).Sub(dhPrime, big.NewInt(1))) >= 0 {
+		c.sendAlert(alertFatal, alertIllegalParameter)
+		return errors.New("the client's Diffie-Hellman public value is out of range")
+	}
+	c.transcript = append(c.transcript, msg...)
+	// The premaster secret has no leading zeros, which Bytes drops.
+	preMaster := new(big.Int).Exp(clientPublic, private, dhPrime).Bytes()
+	var master []byte
+	if hello.extendedMasterSecret {
+		master = prf(version, preMaster, "extended master secret", transcriptHash(version, c.transcript), 48)
+	} else {
+		master = prf(version, preMaster, "master secret", append(append([]byte(nil), hello.random...), serverRandom...), 48)
+	}
+
+	macLen, keyLen, ivLen := suite.macLen(), suite.keyLen, suite.ivLen()
+	keys := prf(version, master, "key expansion", append(append([]byte(nil), serverRandom...), hello.random...), 2*(macLen+keyLen+ivLen))
+	clientMAC, keys := keys[:macLen], keys[macLen:]
+	serverMAC, keys := keys[:macLen], keys[macLen:]
+	clientKey, keys := keys[:keyLen], keys[keyLen:]
+	serverKey, keys := keys[:keyLen], keys[keyLen:]
+	clientIV, serverIV := keys[:ivLen], keys[ivLen:]
+
+	c.inMu.Lock()
+	typ, payload, err := c.readRecord()
+	if err == nil && (typ != recordChangeCipherSpec || !bytes.Equal(payload, []byte{1}) || len(c.handshake) > 0) {
+		c.sendAlert(alertFatal, alertUnexpectedMessage)
+		err = fmt.Errorf("expected ChangeCipherSpec, but got a record of type %d", typ)
+	}
+	if err == nil {
+		err = c.in.setKeys(suite, clientMAC, clientKey, clientIV)
+	}
+	c.inMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("read ChangeCipherSpec: %w", err)
+	}
+
+	expected := prf(version, master, "client finished", transcriptHash(version, c.transcript), finishedLen)
+	msg, err = c.readHandshake(typeFinished)
+	if err != nil {
+		return fmt.Errorf("read Finished: %w", err)
+	}
+	if !hmac.Equal(msg[4:], expected) {
+		c.sendAlert(alertFatal, alertDecryptError)
+		return errors.New("the client's Finished doesn't match the handshake")
+	}
+	c.transcript = append(c.transcript, msg...)
+
+	if err := c.writeRecord(recordChangeCipherSpec, []byte{1}); err != nil {
+		return fmt.Errorf("write ChangeCipherSpec: %w", err)
+	}
+	if err := c.out.setKeys(suite, serverMAC, serverKey, serverIV); err != nil {
+		return err
+	}
+	finished := prf(version, master, "server finished", transcriptHash(version, c.transcript), finishedLen)
+	if err := c.writeRecord(recordHandshake, c.handshakeMessage(typeFinished, finished)); err != nil {
+		return fmt.Errorf("write Finished: %w", err)
+	}
+	c.transcript = nil
+	return nil
+}
+
+// handshakeMessage returns a handshake message of type typ with the given body, adding it to the transcript.
+func (c *Conn) handshakeMessage(typ uint8, body []byte) []byte {
+	msg := []byte{typ, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	msg = append(msg, body...)
+	c.transcript = append(c.transcript, msg...)
+	return msg
+}
+
+// readHandshake returns the next handshake message, with its header, which must be of type typ. ClientHello is added to the transcript here, since nothing else is known of it yet; other messages are added by the caller once it has hashed what came before.
+func (c *Conn) readHandshake(typ uint8) ([]byte, error) {
+	c.inMu.Lock()
+	defer c.inMu.Unlock()
+	for len(c.handshake) < 4 || len(c.handshake) < 4+handshakeLen(c.handshake) {
+		if len(c.handshake) >= 4 && handshakeLen(c.handshake) > maxHandshake {
+			c.sendAlert(alertFatal, alertDecodeError)
+			return nil, errors.New("handshake message too long")
+		}
+		recordType, payload, err := c.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		if recordType != recordHandshake {
+			c.sendAlert(alertFatal, alertUnexpectedMessage)
+			return nil, fmt.Errorf("expected a handshake message, but got a record of type %d", recordType)
+		}
+		c.handshake = append(c.handshake, payload...)
+	}
+	n := 4 + handshakeLen(c.handshake)
+	msg := append([]byte(nil), c.handshake[:n]...)
+	c.handshake = c.handshake[n:]
+	if msg[0] != typ {
+		c.sendAlert(alertFatal, alertUnexpectedMessage)
+		return nil, fmt.Errorf("expected handshake message %d, but got %d", typ, msg[0])
+	}
+	if typ == typeClientHello {
+		c.transcript = append(c.transcript, msg...)
+	}
+	return msg, nil
+}
+
+// handshakeLen returns the length of the body of the handshake message that starts b.
+func handshakeLen(b []byte) int {
+	return int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+}
+
+// readRecord returns the type and payload of the next record that isn't an alert. A close_notify alert ends the connection with io.EOF, and other fatal alerts with an error. c.inMu must be held.
+func (c *Conn) readRecord() (uint8, []byte, error) {
+	for {
+		header := make([]byte, recordHeaderLen)
+		if _, err := io.ReadFull(c.Conn, header); err != nil {
+			return 0, nil, err
+		}
+		typ, length := header[0], int(binary.BigEndian.Uint16(header[3:]))
+		if header[1] != 3 {
+			c.sendAlert(alertFatal, alertProtocolVersion)
+			return 0, nil, fmt.Errorf("unsupported record version %#02x%02x", header[1], header[2])
+		}
+		if length > maxCiphertext {
+			c.sendAlert(alertFatal, alertRecordOverflow)
+			return 0, nil, fmt.Errorf("record length %d exceeds maximum of %d", length, maxCiphertext)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(c.Conn, body); err != nil {
+			return 0, nil, err
+		}
+		if c.raw != nil {
+			c.raw = append(append(c.raw, header...), body...)
+		}
+		payload, alert := c.in.open(typ, body)
+		if alert != 0 {
+			c.sendAlert(alertFatal, alert)
+			return 0, nil, errors.New("record failed authentication")
+		}
+		if typ != recordAlert {
+			return typ, payload, nil
+		}
+		if len(payload) != 2 {
+			c.sendAlert(alertFatal, alertDecodeError)
+			return 0, nil, errors.New("malformed alert")
+		}
+		if payload[1] == alertCloseNotify {
+			return 0, nil, io.EOF
+		}
+		if payload[0] == alertFatal {
+			return 0, nil, fmt.Errorf("the client sent alert %d", payload[1])
+		}
+	}
+}
+
+// writeRecord sends payload, which must fit in a record, as a record of type typ.
+func (c *Conn) writeRecord(typ uint8, payload []byte) error {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	record, err := c.out.seal(typ, payload)
+	if err != nil {
+		return err
+	}
+	_, err = c.Conn.Write(record)
+	return err
+}
+
+// sendAlert tells the client about a problem, ignoring any error, since the connection is failing anyway.
+func (c *Conn) sendAlert(level, description uint8) {
+	c.writeRecord(recordAlert, []byte{level, description})
+}
+
+// Read returns application data from the client. Renegotiation is refused.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.inMu.Lock()
+	defer c.inMu.Unlock()
+	for len(c.input) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		typ, payload, err := c.readRecord()
+		switch {
+		case err != nil:
+			c.readErr = err
+		case typ == recordApplicationData:
+			c.input = payload
+		case typ == recordHandshake:
+			c.sendAlert(alertWarning, alertNoRenegotiation)
+		default:
+			c.sendAlert(alertFatal, alertUnexpectedMessage)
+			c.readErr = fmt.Errorf("unexpected record of type %d", typ)
+		}
+	}
+	n := copy(p, c.input)
+	c.input = c.input[n:]
+	return n, nil
+}
+
+// Write sends p to the client, in as many records as it takes.
+func (c *Conn) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxPlaintext {
+			chunk = chunk[:maxPlaintext]
+		}
+		if err := c.writeRecord(recordApplicationData, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close tells the client that the connection is closing, and closes it.
+func (c *Conn) Close() error {
+	c.sendAlert(alertWarning, alertCloseNotify)
+	return c.Conn.Close()
+}
+
+// parser reads the fields of a handshake message, noting if it runs out.
+type parser struct {
+	b      []byte
+	failed bool
+}
+
+func (p *parser) bytes(n int) []byte {
+	if n > len(p.b) {
+		p.failed, p.b = true, nil
+		return nil
+	}
+	b := p.b[:n]
+	p.b = p.b[n:]
+	return b
+}
+
+func (p *parser) u16() int {
+	b := p.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (p *parser) vector8() []byte {
+	b := p.bytes(1)
+	if b == nil {
+		return nil
+	}
+	return p.bytes(int(b[0]))
+}
+
+func (p *parser) vector16() []byte {
+	return p.bytes(p.u16())
+}
+
+// appendVector16 appends v to b, after its length in two bytes.
+func appendVector16(b, v []byte) []byte {
+	return append(append(b, byte(len(v)>>8), byte(len(v))), v...)
+}
@@ -0,0 +1,164 @@
+package anontls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echo serves one connection with Server, sending back the first n bytes that the client sends before closing it, and reports what went wrong on errc.
+func echo(t *testing.T, fallback *tls.Config, n int64) (addr string, errc chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	errc = make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		tlsConn, err := Server(conn, fallback)
+		if err != nil {
+			errc <- err
+			return
+		}
+		_, err = io.CopyN(tlsConn, tlsConn, n)
+		tlsConn.Close()
+		errc <- err
+	}()
+	return ln.Addr().String(), errc
+}
+
+// TestOpenSSL connects with OpenSSL's client, which offers anonymous Diffie-Hellman cipher suites from security level 0, in each version and cipher suite.
+func TestOpenSSL(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl isn't installed")
+	}
+	for _, tt := range []struct {
+		version, cipher, want string
+	}{
+		{"-tls1_2", "ADH-AES128-GCM-SHA256", "ADH-AES128-GCM-SHA256"},
+		{"-tls1_2", "ADH-AES128-SHA", "ADH-AES128-SHA"},
+		{"-tls1_2", "ADH-AES256-SHA", "ADH-AES256-SHA"},
+		{"-tls1_1", "ADH-AES128-SHA", "ADH-AES128-SHA"},
+		{"-tls1", "ADH-AES256-SHA", "ADH-AES256-SHA"},
+		// The server prefers GCM, and CBC only before TLS 1.2.
+		{"-tls1_2", "ADH", "ADH-AES128-GCM-SHA256"},
+		{"-tls1", "ADH", "ADH-AES256-SHA"},
+	} {
+		// Enough lines to take more than one record each way.
+		input := strings.Repeat(strings.Repeat("x", 1000)+"\n", 40)
+		addr, errc := echo(t, nil, int64(len(input)))
+		cmd := exec.Command("openssl", "s_client", "-connect", addr, tt.version, "-cipher", tt.cipher+":@SECLEVEL=0", "-brief", "-ign_eof")
+		cmd.Stdin = strings.NewReader(input)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		err := cmd.Run()
+		if serverErr := <-errc; serverErr != nil {
+			t.Errorf("%s %s: server failed: %v; openssl said %s", tt.version, tt.cipher, serverErr, stderr.String())
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: openssl failed: %v: %s", tt.version, tt.cipher, err, stderr.String())
+			continue
+		}
+		if !strings.Contains(stderr.String(), "Ciphersuite: "+tt.want+"\n") {
+			t.Errorf("%s %s: expected %s to be negotiated, but openssl said %s", tt.version, tt.cipher, tt.want, stderr.String())
+		}
+		if stdout.String() != input {
+			t.Errorf("%s %s: expected %d bytes echoed, but got %d", tt.version, tt.cipher, len(input), stdout.Len())
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+
+	// crypto/tls offers no anonymous cipher suites, so it gets the certificate.
+	addr, errc := echo(t, fallback, 5)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(conn, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("expected hello back, but got %q and %v", buf, err)
+	}
+	conn.Close()
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+
+	// Without a fallback, such clients are refused.
+	addr, errc = echo(t, nil, 5)
+	if conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true}); err == nil {
+		conn.Close()
+		t.Error("expected a client without anonymous cipher suites to be refused")
+	}
+	if err := <-errc; err == nil {
+		t.Error("expected the server to fail the handshake")
+	}
+}
+
+func TestRecordTampering(t *testing.T) {
+	for _, version := range []uint16{versionTLS10, versionTLS11, versionTLS12} {
+		for i := range cipherSuites {
+			suite := &cipherSuites[i]
+			if version < suite.minVersion {
+				continue
+			}
+			macKey, key, iv := make([]byte, suite.macLen()), make([]byte, suite.keyLen), make([]byte, suite.ivLen())
+			rand.Read(key)
+			var out, in halfConn
+			out.version, in.version = version, version
+			if err := out.setKeys(suite, macKey, key, iv); err != nil {
+				t.Fatal(err)
+			}
+			in.setKeys(suite, macKey, key, iv)
+			for _, payload := range []string{"", "hello", strings.Repeat("x", 100)} {
+				record, err := out.seal(recordApplicationData, []byte(payload))
+				if err != nil {
+					t.Fatal(err)
+				}
+				// A copy of the state opens the tampered record, so that the real one can still open the original.
+				tampered := append([]byte(nil), record[recordHeaderLen:]...)
+				tampered[len(tampered)-1] ^= 1
+				if _, alert := (&halfConn{version: in.version, seq: in.seq, aead: in.aead, block: in.block, mac: in.mac, iv: in.iv}).open(recordApplicationData, tampered); alert != alertBadRecordMAC {
+					t.Errorf("%#04x %#04x: expected a tampered record to fail, but got alert %d", version, suite.id, alert)
+				}
+				got, alert := in.open(recordApplicationData, record[recordHeaderLen:])
+				if alert != 0 || string(got) != payload {
+					t.Errorf("%#04x %#04x: expected %q, but got %q and alert %d", version, suite.id, payload, got, alert)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package anontls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"hash"
+)
+
+// cipherSuite is a supported cipher suite: AES with anonymous Diffie-Hellman key exchange, in GCM, or in CBC with HMAC-SHA1.
+type cipherSuite struct {
+	id         uint16
+	keyLen     int
+	gcm        bool
+	minVersion uint16
+}
+
+// cipherSuites are the supported cipher suites, most preferred first.
+var cipherSuites = []cipherSuite{
+	{0x00a6, 16, true, versionTLS12},  // TLS_DH_anon_WITH_AES_128_GCM_SHA256
+	{0x003a, 32, false, versionTLS10}, // TLS_DH_anon_WITH_AES_256_CBC_SHA
+	{0x0034, 16, false, versionTLS10}, // TLS_DH_anon_WITH_AES_128_CBC_SHA
+}
+
+// macLen and ivLen are the sizes of the MAC key and the IV that the suite takes from the key block: GCM's implicit nonce, or the first CBC IV, which only TLS 1.0 uses.
+func (s *cipherSuite) macLen() int {
+	if s.gcm {
+		return 0
+	}
+	return sha1.Size
+}
+
+func (s *cipherSuite) ivLen() int {
+	if s.gcm {
+		return 4
+	}
+	return aes.BlockSize
+}
+
+// halfConn is the state of one direction of the record layer. Until ChangeCipherSpec installs keys, records are neither encrypted nor authenticated.
+type halfConn struct {
+	version uint16
+	seq     uint64
+
+	aead  cipher.AEAD  // With GCM.
+	block cipher.Block // With CBC.
+	mac   hash.Hash    // With CBC.
+	iv    []byte       // GCM's implicit nonce, or TLS 1.0's CBC IV, which is the last ciphertext block of the record before.
+}
+
+// setKeys switches to protecting records with suite's cipher and the given keys, from sequence number 0.
+func (hc *halfConn) setKeys(suite *cipherSuite, macKey, key, iv []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	if suite.gcm {
+		if hc.aead, err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	} else {
+		hc.block, hc.mac = block, hmac.New(sha1.New, macKey)
+	}
+	hc.iv = append([]byte(nil), iv...)
+	hc.seq = 0
+	return nil
+}
+
+// additionalData returns what the MAC or the AEAD covers besides the payload: the sequence number, then the record's type, version, and payload length.
+func (hc *halfConn) additionalData(typ uint8, length int) []byte {
+	ad := make([]byte, 13)
+	binary.BigEndian.PutUint64(ad, hc.seq)
+	ad[8] = typ
+	binary.BigEndian.PutUint16(ad[9:], hc.version)
+	binary.BigEndian.PutUint16(ad[11:], uint16(length))
+	return ad
+}
+
+// seal returns a record of type typ that carries payload, which must be at most maxPlaintext bytes.
+func (hc *halfConn) seal(typ uint8, payload []byte) ([]byte, error) {
+	record := []byte{typ, byte(hc.version >> 8), byte(hc.version), 0, 0}
+	switch {
+	case hc.aead != nil:
+		// The explicit part of the nonce is the sequence number, which never repeats.
+		explicit := make([]byte, 8)
+		binary.BigEndian.PutUint64(explicit, hc.seq)
+		nonce := append(append([]byte(nil), hc.iv...), explicit...)
+		record = append(record, explicit...)
+		record = hc.aead.Seal(record, nonce, payload, hc.additionalData(typ, len(payload)))
+
+	case hc.block != nil:
+		hc.mac.Reset()
+		hc.mac.Write(hc.additionalData(typ, len(payload)))
+		hc.mac.Write(payload)
+		plain := hc.mac.Sum(append([]byte(nil), payload...))
+		padding := aes.BlockSize - len(plain)%aes.BlockSize
+		for i := 0; i < padding; i++ {
+			plain = append(plain, byte(padding-1))
+		}
+		iv := hc.iv
+		if hc.version >= versionTLS11 {
+			iv = make([]byte, aes.BlockSize)
+			if _, err := rand.Read(iv); err != nil {
+				return nil, err
+			}
+			record = append(record, iv...)
+		}
+		start := len(record)
+		record = append(record, plain...)
+		cipher.NewCBCEncrypter(hc.block, iv).CryptBlocks(record[start:], plain)
+		if hc.version < versionTLS11 {
+			hc.iv = append([]byte(nil), record[len(record)-aes.BlockSize:]...)
+		}
+
+	default:
+		record = append(record, payload...)
+	}
+	binary.BigEndian.PutUint16(record[3:], uint16(len(record)-recordHeaderLen))
+	hc.seq++
+	return record, nil
+}
+
+// open returns the payload of a record of type typ with the given body, or the alert to send if it's been tampered with.
+func (hc *halfConn) open(typ uint8, body []byte) ([]byte, uint8) {
+	switch {
+	case hc.aead != nil:
+		if len(body) < 8+hc.aead.Overhead() {
+			return nil, alertBadRecordMAC
+		}
+		nonce := append(append([]byte(nil), hc.iv...), body[:8]...)
+		payload, err := hc.aead.Open(nil, nonce, body[8:], hc.additionalData(typ, len(body)-8-hc.aead.Overhead()))
+		if err != nil {
+			return nil, alertBadRecordMAC
+		}
+		hc.seq++
+		return payload, 0
+
+	case hc.block != nil:
+		iv := hc.iv
+		if hc.version >= versionTLS11 {
+			if len(body) < aes.BlockSize {
+				return nil, alertBadRecordMAC
+			}
+			iv, body = body[:aes.BlockSize], body[aes.BlockSize:]
+		}
+		macLen := hc.mac.Size()
+		if len(body)%aes.BlockSize != 0 || len(body) < macLen+1 {
+			return nil, alertBadRecordMAC
+		}
+		plain := make([]byte, len(body))
+		cipher.NewCBCDecrypter(hc.block, iv).CryptBlocks(plain, body)
+		if hc.version < versionTLS11 {
+			hc.iv = append([]byte(nil), body[len(body)-aes.BlockSize:]...)
+		}
+		// Every padding byte holds the padding length, less one. Bad padding fails the same way as a bad MAC.
+		padding := int(plain[len(plain)-1]) + 1
+		good := 1
+		if padding+macLen > len(plain) {
+			good, padding = 0, 1
+		}
+		for _, b := range plain[len(plain)-padding:] {
+			good &= subtle.ConstantTimeByteEq(b, byte(padding-1))
+		}
+		payload := plain[:len(plain)-padding-macLen]
+		hc.mac.Reset()
+		hc.mac.Write(hc.additionalData(typ, len(payload)))
+		hc.mac.Write(payload)
+		good &= subtle.ConstantTimeCompare(hc.mac.Sum(nil), plain[len(payload):len(plain)-padding])
+		if good != 1 {
+			return nil, alertBadRecordMAC
+		}
+		hc.seq++
+		return payload, 0
+	}
+	hc.seq++
+	return body, 0
+}
+
+// prf is TLS's pseudorandom function, which expands secret into n bytes: P_SHA256 from TLS 1.2 on, and P_MD5 XORed with P_SHA1 before.
+func prf(version uint16, secret []byte, label string, seed []byte, n int) []byte {
+	labelAndSeed := append([]byte(label), seed...)
+	out := make([]byte, n)
+	if version >= versionTLS12 {
+		pHash(sha256.New, secret, labelAndSeed, out)
+		return out
+	}
+	half := (len(secret) + 1) / 2
+	pHash(md5.New, secret[:half], labelAndSeed, out)
+	sha := make([]byte, n)
+	pHash(sha1.New, secret[len(secret)-half:], labelAndSeed, sha)
+	for i := range out {
+		out[i] ^= sha[i]
+	}
+	return out
+}
+
+// pHash fills out with P_hash(secret, seed) from RFC 5246 section 5.
+func pHash(h func() hash.Hash, secret, seed, out []byte) {
+	mac := hmac.New(h, secret)
+	mac.Write(seed)
+	a := mac.Sum(nil)
+	for n := 0; n < len(out); {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(seed)
+		n += copy(out[n:], mac.Sum(nil))
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+}
+
+// transcriptHash hashes handshake messages for Finished and the extended master secret, with the PRF's hash: SHA-256 from TLS 1.2 on, and MD5 and SHA-1 together before.
+func transcriptHash(version uint16, transcript []byte) []byte {
+	if version >= versionTLS12 {
+		sum := sha256.Sum256(transcript)
+		return sum[:]
+	}
+	md5Sum, sha1Sum := md5.Sum(transcript), sha1.Sum(transcript)
+	return append(md5Sum[:], sha1Sum[:]...)
+}
@@ -0,0 +1,188 @@
+package rfb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"strings"
+)
+
+// EncodingTypeExtendedClipboard is the Extended Clipboard pseudo-encoding. Servers reply to clients that list it with an ExtendedClipboard message with ClipboardCaps, after which either side may send ExtendedClipboard messages in place of plain cut text.
+const EncodingTypeExtendedClipboard = uint32(0xc0a1e5ce)
+
+// ExtendedClipboard flags: formats in the low bits, and actions in the high bits.
+const (
+	ClipboardText  = uint32(1 << 0) // UTF-8, with CRLF line endings
+	ClipboardRTF   = uint32(1 << 1)
+	ClipboardHTML  = uint32(1 << 2)
+	ClipboardDIB   = uint32(1 << 3)
+	ClipboardFiles = uint32(1 << 4)
+
+	// ClipboardCaps announces the formats and actions that the sender supports, with a maximum size for each format.
+	ClipboardCaps = uint32(1 << 24)
+	// ClipboardRequest asks for the data in the given formats.
+	ClipboardRequest = uint32(1 << 25)
+	// ClipboardPeek asks which formats are available, to be answered with ClipboardNotify.
+	ClipboardPeek = uint32(1 << 26)
+	// ClipboardNotify says that the clipboard has changed and which formats are available.
+	ClipboardNotify = uint32(1 << 27)
+	// ClipboardProvide sends the data in the given formats.
+	ClipboardProvide = uint32(1 << 28)
+
+	clipboardFormatMask = uint32(0xffff)
+)
+
+// maxExtendedClipboardLength bounds the size of an ExtendedClipboard message, compressed or not.
+const maxExtendedClipboardLength = 16 << 20
+
+// ExtendedClipboard is the content of an Extended Clipboard message, which is sent as ClientCutTextMessage or ServerCutTextMessage.
+type ExtendedClipboard struct {
+	Flags uint32
+
+	// Sizes has the maximum size of each format in Flags, in order from the lowest bit, when Flags has ClipboardCaps.
+	Sizes []uint32
+
+	// Data has the data for each format in Flags, in order from the lowest bit, when Flags has ClipboardProvide.
+	Data [][]byte
+}
+
+// NewClipboardProvideText returns a message that provides text.
+func NewClipboardProvideText(text string) *ExtendedClipboard {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	return &ExtendedClipboard{Flags: ClipboardProvide | ClipboardText, Data: [][]byte{append([]byte(text), 0)}}
+}
+
+// Text returns the text provided by a message with ClipboardProvide, with LF line endings.
+func (c *ExtendedClipboard) Text() (string, bool) {
+	if c.Flags&ClipboardProvide == 0 || c.Flags&ClipboardText == 0 || len(c.Data) == 0 {
+		return "", false
+	}
+	// Text is the lowest format bit, so it comes first.
+	text := string(bytes.TrimRight(c.Data[0], "\x00"))
+	return strings.ReplaceAll(text, "\r\n", "\n"), true
+}
+
+func (c *ExtendedClipboard) formats() int {
+	return bits.OnesCount32(c.Flags & clipboardFormatMask)
+}
+
+func (c *ExtendedClipboard) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], c.Flags)
+	buf.Write(b[:])
+	switch {
+	case c.Flags&ClipboardCaps != 0:
+		if len(c.Sizes) != c.formats() {
+			return nil, fmt.Errorf("expected %d sizes for the formats in flags %#x, but found %d", c.formats(), c.Flags, len(c.Sizes))
+		}
+		for _, size := range c.Sizes {
+			binary.BigEndian.PutUint32(b[:], size)
+			buf.Write(b[:])
+		}
+	case c.Flags&ClipboardProvide != 0:
+		if len(c.Data) != c.formats() {
+			return nil, fmt.Errorf("expected data for the %d formats in flags %#x, but found %d", c.formats(), c.Flags, len(c.Data))
+		}
+		zw := zlib.NewWriter(&buf)
+		for _, data := range c.Data {
+			binary.BigEndian.PutUint32(b[:], uint32(len(data)))
+			zw.Write(b[:])
+			zw.Write(data)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("compress clipboard data: %w", err)
+		}
+	}
+	if buf.Len() > maxExtendedClipboardLength {
+		return nil, fmt.Errorf("clipboard message too long: %d bytes > %d bytes", buf.Len(), maxExtendedClipboardLength)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *ExtendedClipboard) unmarshal(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("clipboard message too short: %d bytes", len(data))
+	}
+	c.Flags = binary.BigEndian.Uint32(data)
+	c.Sizes, c.Data = nil, nil
+	data = data[4:]
+	switch {
+	case c.Flags&ClipboardCaps != 0:
+		if len(data) < 4*c.formats() {
+			return fmt.Errorf("expected %d sizes for the formats in flags %#x, but found %d bytes", c.formats(), c.Flags, len(data))
+		}
+		for i := 0; i < c.formats(); i++ {
+			c.Sizes = append(c.Sizes, binary.BigEndian.Uint32(data[4*i:]))
+		}
+	case c.Flags&ClipboardProvide != 0:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("decompress clipboard data: %w", err)
+		}
+		r := io.LimitReader(zr, maxExtendedClipboardLength)
+		remaining := maxExtendedClipboardLength
+		for i := 0; i < c.formats(); i++ {
+			var b [4]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return fmt.Errorf("read clipboard data size: %w", err)
+			}
+			size := binary.BigEndian.Uint32(b[:])
+			if int64(size) > int64(remaining) {
+				return fmt.Errorf("clipboard data too long: %d bytes > %d bytes", size, remaining)
+			}
+			remaining -= int(size)
+			formatData, err := ioutil.ReadAll(io.LimitReader(r, int64(size)))
+			if err != nil {
+				return fmt.Errorf("read clipboard data: %w", err)
+			}
+			if len(formatData) != int(size) {
+				return fmt.Errorf("expected %d bytes of clipboard data, but found %d", size, len(formatData))
+			}
+			c.Data = append(c.Data, formatData)
+		}
+	}
+	return nil
+}
+
+// readExtendedClipboard reads the rest of a cut text message whose length field, as a signed number, is negative.
+func readExtendedClipboard(r io.Reader, length uint32) (*ExtendedClipboard, error) {
+	n := -int64(int32(length))
+	if n > maxExtendedClipboardLength {
+		return nil, fmt.Errorf("clipboard message too long: %d bytes > %d bytes", n, maxExtendedClipboardLength)
+	}
+	// As with text, the buffer grows as the data arrives.
+	var data bytes.Buffer
+	if copied, err := io.Copy(&data, io.LimitReader(r, n)); err != nil {
+		return nil, err
+	} else if copied < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	c := &ExtendedClipboard{}
+	if err := c.unmarshal(data.Bytes()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// writeExtendedClipboard writes a cut text message of messageType carrying c.
+func writeExtendedClipboard(w io.Writer, bo binary.ByteOrder, messageType uint8, c *ExtendedClipboard) error {
+	data, err := c.marshal()
+	if err != nil {
+		return err
+	}
+	var buf [8]byte
+	buf[0] = messageType
+	bo.PutUint32(buf[4:], uint32(-int32(len(data))))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestExtendedClipboardRoundTrip(t *testing.T) {
+	caps := &ExtendedClipboard{Flags: ClipboardCaps | ClipboardRequest | ClipboardProvide | ClipboardText | ClipboardHTML, Sizes: []uint32{1 << 20, 0}}
+	text := "héllo\nwörld ☃"
+	provide := NewClipboardProvideText(text)
+
+	var buf bytes.Buffer
+	if err := (&ClientCutTextMessage{Extended: caps}).Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&ServerCutTextMessage{Extended: provide}).Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&ClientCutTextMessage{Text: "plain"}).Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+
+	var m ClientCutTextMessage
+	if err := m.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Extended, caps) {
+		t.Errorf("expected %+v, but got %+v", caps, m.Extended)
+	}
+	var m2 ServerCutTextMessage
+	if err := m2.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if m2.Extended == nil {
+		t.Fatal("expected an extended clipboard message")
+	}
+	if got, ok := m2.Extended.Text(); !ok || got != text {
+		t.Errorf("expected text %q, but got %q", text, got)
+	}
+	if !bytes.Contains(m2.Extended.Data[0], []byte("\r\n")) {
+		t.Errorf("expected CRLF line endings on the wire, but got %q", m2.Extended.Data[0])
+	}
+	if err := m.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if m.Extended != nil || m.Text != "plain" {
+		t.Errorf("expected plain cut text, but got %+v", m)
+	}
+}
@@ -0,0 +1,152 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"sync"
+)
+
+// maxColourMapLength is the most colours that a colour map can have, since pixel values index into it with at most 16 bits.
+const maxColourMapLength = 1 << 16
+
+// ColourMap is the palette of a PixelFormat that isn't TrueColor. Pixel values are indexes into Colours.
+type ColourMap struct {
+	Colours color.Palette
+
+	// lookup maps colours with 5 bits per component to their nearest index, if there are few enough colours to compute it.
+	lookup []uint16
+}
+
+// NewColourMap returns a colour map of colours, which must not be empty.
+func NewColourMap(colours color.Palette) *ColourMap {
+	m := &ColourMap{Colours: colours}
+	if len(colours) <= 256 {
+		m.lookup = make([]uint16, 1<<15)
+		for i := range m.lookup {
+			r, g, b := uint8(i>>10)<<3|4, uint8(i>>5&0x1f)<<3|4, uint8(i&0x1f)<<3|4
+			m.lookup[i] = uint16(colours.Index(color.RGBA{r, g, b, 0xff}))
+		}
+	}
+	return m
+}
+
+// Index returns the index of the colour nearest to c.
+func (m *ColourMap) Index(c color.Color) uint32 {
+	if m.lookup == nil {
+		return uint32(m.Colours.Index(c))
+	}
+	r, g, b, _ := c.RGBA()
+	return uint32(m.lookup[r>>11<<10|g>>11<<5|b>>11])
+}
+
+func (m *ColourMap) indexRGB(r, g, b uint8) uint32 {
+	if m.lookup == nil {
+		return uint32(m.Colours.Index(color.RGBA{r, g, b, 0xff}))
+	}
+	return uint32(m.lookup[uint32(r>>3)<<10|uint32(g>>3)<<5|uint32(b>>3)])
+}
+
+// at returns the colour of pixel, or black if the pixel is outside the map.
+func (m *ColourMap) at(pixel uint32) color.Color {
+	if pixel >= uint32(len(m.Colours)) {
+		return color.Black
+	}
+	return m.Colours[pixel]
+}
+
+// Entries returns a SetColourMapEntriesMessage that sends the whole colour map.
+func (m *ColourMap) Entries() *SetColourMapEntriesMessage {
+	colours := make([]color.RGBA64, len(m.Colours))
+	for i, c := range m.Colours {
+		colours[i] = color.RGBA64Model.Convert(c).(color.RGBA64)
+	}
+	return &SetColourMapEntriesMessage{Colours: colours}
+}
+
+var (
+	defaultColourMap     *ColourMap
+	defaultColourMapOnce sync.Once
+)
+
+// DefaultColourMap returns a 256-colour map in which each index has 3 bits of red, 3 of green, and 2 of blue, from most to least significant. It suits servers that choose the colour map for 8-bit clients.
+func DefaultColourMap() *ColourMap {
+	defaultColourMapOnce.Do(func() {
+		colours := make(color.Palette, 256)
+		for i := range colours {
+			colours[i] = color.RGBA{uint8((i >> 5) * 0xff / 7), uint8((i >> 2 & 7) * 0xff / 7), uint8((i & 3) * 0xff / 3), 0xff}
+		}
+		defaultColourMap = NewColourMap(colours)
+	})
+	return defaultColourMap
+}
+
+// SetColourMapEntriesMessage is sent by servers to set the colours of clients whose PixelFormat isn't TrueColor.
+type SetColourMapEntriesMessage struct {
+	FirstColour uint16
+	Colours     []color.RGBA64
+}
+
+func (m *SetColourMapEntriesMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	first, colours, err := readColourMapEntries(r, bo, 1)
+	m.FirstColour, m.Colours = first, colours
+	return err
+}
+
+func (m *SetColourMapEntriesMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	return writeColourMapEntries(w, bo, 1, m.FirstColour, m.Colours)
+}
+
+// FixColourMapEntriesMessage is sent by clients to fix colours in the colour map, from old versions of the protocol. It has the same format as SetColourMapEntriesMessage.
+type FixColourMapEntriesMessage struct {
+	FirstColour uint16
+	Colours     []color.RGBA64
+}
+
+func (m *FixColourMapEntriesMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	first, colours, err := readColourMapEntries(r, bo, 1)
+	m.FirstColour, m.Colours = first, colours
+	return err
+}
+
+func (m *FixColourMapEntriesMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	return writeColourMapEntries(w, bo, 1, m.FirstColour, m.Colours)
+}
+
+func readColourMapEntries(r io.Reader, bo binary.ByteOrder, messageType uint8) (uint16, []color.RGBA64, error) {
+	var buf [6]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, nil, err
+	}
+	if buf[0] != messageType {
+		return 0, nil, protocolErrorf(buf[0], 0, "expected message type %d", messageType)
+	}
+	first := bo.Uint16(buf[2:])
+	data := make([]byte, 6*int(bo.Uint16(buf[4:])))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, fmt.Errorf("read colours: %w", err)
+	}
+	colours := make([]color.RGBA64, len(data)/6)
+	for i := range colours {
+		colours[i] = color.RGBA64{bo.Uint16(data[6*i:]), bo.Uint16(data[6*i+2:]), bo.Uint16(data[6*i+4:]), 0xffff}
+	}
+	return first, colours, nil
+}
+
+func writeColourMapEntries(w io.Writer, bo binary.ByteOrder, messageType uint8, first uint16, colours []color.RGBA64) error {
+	if len(colours) > 0xffff || int(first)+len(colours) > maxColourMapLength {
+		return fmt.Errorf("colours %d through %d exceed the maximum of %d", first, int(first)+len(colours)-1, maxColourMapLength-1)
+	}
+	buf := make([]byte, 6+6*len(colours))
+	buf[0] = messageType
+	bo.PutUint16(buf[2:], first)
+	bo.PutUint16(buf[4:], uint16(len(colours)))
+	for i, c := range colours {
+		bo.PutUint16(buf[6+6*i:], c.R)
+		bo.PutUint16(buf[6+6*i+2:], c.G)
+		bo.PutUint16(buf[6+6*i+4:], c.B)
+	}
+	_, err := w.Write(buf)
+	return err
+}
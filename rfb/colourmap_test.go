@@ -0,0 +1,57 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestSetColourMapEntriesRoundTrip(t *testing.T) {
+	m := SetColourMapEntriesMessage{FirstColour: 3, Colours: []color.RGBA64{{0xffff, 0, 0x1234, 0xffff}, {0, 0x8000, 0, 0xffff}}}
+	var buf bytes.Buffer
+	if err := m.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	var m2 SetColourMapEntriesMessage
+	if err := m2.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, m2) {
+		t.Errorf("expected %+v, but got %+v", m, m2)
+	}
+}
+
+func TestColourMapImage(t *testing.T) {
+	pf := PixelFormat{BitsPerPixel: 8, BitDepth: 8}
+	img, err := NewPixelFormatImage(pf, image.Rect(0, 0, 3, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := image.NewRGBA(img.Rect)
+	src.Set(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+	src.Set(1, 0, color.RGBA{0, 0xff, 0xff, 0xff})
+	src.Set(2, 0, color.RGBA{0xff, 0xff, 0xff, 0xff})
+	if err := img.CopyFromRGBA(src); err != nil {
+		t.Fatal(err)
+	}
+	// With 3 bits of red, 3 of green, and 2 of blue, these colours are exact.
+	if want := []uint8{0xe0, 0x1f, 0xff}; !bytes.Equal(img.Pix, want) {
+		t.Errorf("expected indexes %x, but got %x", want, img.Pix)
+	}
+
+	dst := image.NewRGBA(img.Rect)
+	if err := img.CopyToRGBA(dst); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Pix, src.Pix) {
+		t.Errorf("expected %x, but got %x", src.Pix, dst.Pix)
+	}
+
+	img.Set(0, 0, color.RGBA{0, 0, 0xff, 0xff})
+	if r, g, b, _ := img.At(0, 0).RGBA(); r != 0 || g != 0 || b != 0xffff {
+		t.Errorf("expected blue, but got %v", img.At(0, 0))
+	}
+}
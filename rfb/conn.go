@@ -0,0 +1,153 @@
+package rfb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn is the server side of a session after the handshake. It buffers reads and writes, and keeps track of the pixel format that the client asked for. Send may be called from any number of goroutines, such as one for each source of updates, bells, and clipboard changes, but only one goroutine may call Receive at a time.
+type Conn struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	bo        binary.ByteOrder
+	maxLength int // The longest message that Receive accepts, or 0 for no limit beyond those of each message's Read
+	stats     []*Stats
+
+	wmu sync.Mutex // Held while writing, so that messages aren't interleaved
+	w   *bufio.Writer
+
+	mu          sync.Mutex
+	pixelFormat PixelFormat
+}
+
+// NewConn returns a Conn for a session on conn whose ServerInitialisation had pixelFormat, such as the Conn and ServerInit.PixelFormat of a ServerHandshakeResult.
+func NewConn(conn net.Conn, pixelFormat PixelFormat) *Conn {
+	return &Conn{
+		conn:        conn,
+		r:           bufio.NewReader(conn),
+		bo:          binary.BigEndian,
+		w:           bufio.NewWriter(conn),
+		pixelFormat: pixelFormat,
+	}
+}
+
+// StrictMaxMessageLength is the longest message that a Conn in strict mode accepts, in bytes. It bounds clipboard text to about a megabyte, and is plenty for other messages.
+var StrictMaxMessageLength = 1 << 20
+
+// SetStrict turns strict mode on or off. In strict mode, Receive fails with a *ProtocolError as soon as a message runs longer than StrictMaxMessageLength, rather than allowing each length field up to the more generous maximum of its type, such as MaxCutTextLength. That bounds what an untrusted client can make the server read and allocate per message.
+func (c *Conn) SetStrict(strict bool) {
+	c.maxLength = 0
+	if strict {
+		c.maxLength = StrictMaxMessageLength
+	}
+}
+
+// SetStats makes the Conn record the messages it sends and receives in each of stats, such as one for the connection and one for the whole server.
+func (c *Conn) SetStats(stats ...*Stats) {
+	c.stats = stats
+}
+
+// Receive reads the next message from the client. A SetPixelFormatMessage changes PixelFormat, unless its pixel format is invalid, which is a *ProtocolError.
+func (c *Conn) Receive() (ClientMessage, error) {
+	r := &messageReader{r: c.r, max: c.maxLength}
+	m, err := ReadClientMessage(r, c.bo)
+	if err != nil {
+		return nil, err
+	}
+	for _, stats := range c.stats {
+		stats.received(MessageName(m), r.n)
+	}
+	if m, ok := m.(*SetPixelFormatMessage); ok {
+		if err := m.PixelFormat.Validate(); err != nil {
+			return nil, protocolErrorf(m.MessageType(), 4, "invalid pixel format: %v", err)
+		}
+		c.mu.Lock()
+		c.pixelFormat = m.PixelFormat
+		c.mu.Unlock()
+	}
+	return m, nil
+}
+
+// Send writes messages to the client and flushes them. Messages from concurrent calls aren't interleaved, and those from one call are written together.
+func (c *Conn) Send(messages ...ServerMessage) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if len(c.stats) > 0 {
+		defer func(start time.Time) {
+			for _, stats := range c.stats {
+				stats.sending(time.Since(start))
+			}
+		}(time.Now())
+	}
+	for _, m := range messages {
+		w := &countingWriter{w: c.w}
+		if err := writeMessage(m, w, c.bo); err != nil {
+			return err
+		}
+		for _, stats := range c.stats {
+			stats.sent(m, w.n)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += n
+	return n, err
+}
+
+// PixelFormat returns the pixel format that the client last asked for, in which FramebufferUpdate rectangles must be encoded.
+func (c *Conn) PixelFormat() PixelFormat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pixelFormat
+}
+
+// messageReader counts the bytes of one message, and fails reads beyond the first max bytes of it unless max is 0.
+type messageReader struct {
+	r           io.Reader
+	n, max      int
+	messageType uint8
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	if r.max > 0 {
+		if r.n == r.max {
+			return 0, protocolErrorf(r.messageType, r.max, "message is longer than %d bytes", r.max)
+		}
+		if len(p) > r.max-r.n {
+			p = p[:r.max-r.n]
+		}
+	}
+	n, err := r.r.Read(p)
+	if n > 0 && r.n == 0 {
+		r.messageType = p[0]
+	}
+	r.n += n
+	return n, err
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Close closes the underlying connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
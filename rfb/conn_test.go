@@ -0,0 +1,93 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConnSendConcurrently(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewConn(server, pixelFormat)
+	defer c.Close()
+
+	const senders, messages = 4, 20
+	text := strings.Repeat("x", 5000) // Longer than the write buffer, so that writes would interleave without locking
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				if err := c.Send(&ServerCutTextMessage{Text: text}, &BellMessage{}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < senders*messages; i++ {
+		m, err := ReadServerMessage(client, binary.BigEndian, pixelFormat, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, ok := m.(*ServerCutTextMessage); !ok || m.Text != text {
+			t.Fatalf("expected ServerCutText, but got %v", m)
+		}
+		if m, err := ReadServerMessage(client, binary.BigEndian, pixelFormat, nil); err != nil {
+			t.Fatal(err)
+		} else if _, ok := m.(*BellMessage); !ok {
+			t.Fatalf("expected Bell to follow in the same batch, but got %v", m)
+		}
+	}
+	wg.Wait()
+}
+
+func TestConnReceivePixelFormat(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewConn(server, pixelFormat)
+	defer c.Close()
+
+	go func() {
+		(&SetPixelFormatMessage{PixelFormat: PixelFormatRGB565}).Write(client, binary.BigEndian)
+		(&SetPixelFormatMessage{PixelFormat: PixelFormat{BitsPerPixel: 24, BitDepth: 24, TrueColor: true}}).Write(client, binary.BigEndian)
+	}()
+	if _, err := c.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	if pf := c.PixelFormat(); pf != PixelFormatRGB565 {
+		t.Errorf("expected the client's pixel format, but got %v", pf)
+	}
+	var protocolErr *ProtocolError
+	if _, err := c.Receive(); !errors.As(err, &protocolErr) {
+		t.Errorf("expected a ProtocolError for an invalid pixel format, but got %v", err)
+	}
+	if pf := c.PixelFormat(); pf != PixelFormatRGB565 {
+		t.Errorf("expected an invalid pixel format to be ignored, but got %v", pf)
+	}
+}
+
+func TestConnStrict(t *testing.T) {
+	server, client := net.Pipe()
+	c := NewConn(server, pixelFormat)
+	c.SetStrict(true)
+
+	go func() {
+		defer client.Close()
+		(&KeyEventMessage{Pressed: true, KeySym: 'a'}).Write(client, binary.BigEndian)
+		(&ClientCutTextMessage{Text: strings.Repeat("x", StrictMaxMessageLength)}).Write(client, binary.BigEndian)
+	}()
+	if _, err := c.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	var protocolErr *ProtocolError
+	if _, err := c.Receive(); !errors.As(err, &protocolErr) || protocolErr.MessageType != 6 || protocolErr.Offset != StrictMaxMessageLength {
+		t.Errorf("expected a ProtocolError at the limit, but got %v", err)
+	}
+	c.Close()
+}
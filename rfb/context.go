@@ -0,0 +1,48 @@
+package rfb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// WithContext runs f, which reads from or writes to conn, with conn's deadline set to ctx's, and unblocks f's reads and writes if ctx is canceled first. If ctx ends before f returns, the error wraps ctx.Err(). Since f may be interrupted partway through a message, conn should be closed after such an error.
+func WithContext(ctx context.Context, conn net.Conn, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return fmt.Errorf("set deadline: %w", err)
+		}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// A deadline in the past unblocks pending reads and writes.
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	err := f()
+	close(stop)
+	<-stopped
+	if _, ok := ctx.Deadline(); ok || ctx.Err() != nil {
+		conn.SetDeadline(time.Time{})
+	}
+	if err == nil {
+		return nil
+	}
+	// conn's deadline can pass just before ctx's does.
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		<-ctx.Done()
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	return err
+}
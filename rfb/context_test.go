@@ -0,0 +1,51 @@
+package rfb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWithContextCancel(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := WithContext(ctx, server, func() error {
+		var version ProtocolVersionMessage
+		return version.Read(server)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, but got %v", err)
+	}
+
+	// The connection is usable again afterwards.
+	go (&ProtocolVersionMessage{Major: 3, Minor: 8}).Write(client)
+	var version ProtocolVersionMessage
+	if err := WithContext(context.Background(), server, func() error { return version.Read(server) }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerHandshakeTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// The client reads the server's version but never replies.
+	go (&ProtocolVersionMessage{}).Read(client)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := ServerHandshake(ctx, server, ServerHandshakeOptions{
+		Init: func(ClientInitialisationMessage) (ServerInitialisationMessage, error) {
+			return ServerInitialisationMessage{}, nil
+		},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, but got %v", err)
+	}
+}
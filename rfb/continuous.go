@@ -0,0 +1,70 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// EncodingTypeContinuousUpdates is the ContinuousUpdates pseudo-encoding, -313. Servers reply to clients that list it with EndOfContinuousUpdatesMessage, after which the client may send EnableContinuousUpdatesMessage.
+const EncodingTypeContinuousUpdates = uint32(0xfffffec7)
+
+// EnableContinuousUpdatesMessage is sent by clients to ask the server to send FramebufferUpdateMessage whenever the region changes, without waiting for FramebufferUpdateRequestMessage, or to stop.
+type EnableContinuousUpdatesMessage struct {
+	Enable bool
+
+	X      uint16
+	Y      uint16
+	Width  uint16
+	Height uint16
+}
+
+func (m *EnableContinuousUpdatesMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [10]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	if buf[0] != 150 {
+		return protocolErrorf(buf[0], 0, "expected message type 150")
+	}
+	m.Enable = buf[1] != 0
+	m.X = bo.Uint16(buf[2:])
+	m.Y = bo.Uint16(buf[4:])
+	m.Width = bo.Uint16(buf[6:])
+	m.Height = bo.Uint16(buf[8:])
+	return nil
+}
+
+func (m *EnableContinuousUpdatesMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	var buf [10]byte
+	buf[0] = 150
+	if m.Enable {
+		buf[1] = 1
+	}
+	bo.PutUint16(buf[2:], m.X)
+	bo.PutUint16(buf[4:], m.Y)
+	bo.PutUint16(buf[6:], m.Width)
+	bo.PutUint16(buf[8:], m.Height)
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	return nil
+}
+
+// EndOfContinuousUpdatesMessage is sent by servers when continuous updates stop, and once to tell a client that lists EncodingTypeContinuousUpdates that they're supported.
+type EndOfContinuousUpdatesMessage struct{}
+
+func (m *EndOfContinuousUpdatesMessage) Read(r io.Reader) error {
+	var buf [1]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	if buf[0] != 150 {
+		return protocolErrorf(buf[0], 0, "expected message type 150")
+	}
+	return nil
+}
+
+func (m *EndOfContinuousUpdatesMessage) Write(w io.Writer) error {
+	_, err := w.Write([]byte{150})
+	return err
+}
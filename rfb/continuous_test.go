@@ -0,0 +1,30 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestContinuousUpdatesMessagesRoundTrip(t *testing.T) {
+	m := EnableContinuousUpdatesMessage{Enable: true, X: 1, Y: 2, Width: 300, Height: 400}
+	var buf bytes.Buffer
+	if err := m.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	var end EndOfContinuousUpdatesMessage
+	if err := end.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var m2 EnableContinuousUpdatesMessage
+	if err := m2.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if m2 != m {
+		t.Errorf("expected %+v, but got %+v", m, m2)
+	}
+	if err := end.Read(&buf); err != nil {
+		t.Fatal(err)
+	}
+}
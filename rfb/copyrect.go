@@ -0,0 +1,50 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+)
+
+// NewCopyRect returns a rectangle telling the client to copy the pixels at src in its framebuffer to dst. The copy happens when the client reaches the rectangle, so put it before any rectangles in the same update that overwrite the source.
+func NewCopyRect(dst image.Rectangle, src image.Point) *FramebufferUpdateRect {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:], uint16(src.X))
+	binary.BigEndian.PutUint16(data[2:], uint16(src.Y))
+	return &FramebufferUpdateRect{
+		X: uint16(dst.Min.X), Y: uint16(dst.Min.Y), Width: uint16(dst.Dx()), Height: uint16(dst.Dy()),
+		EncodingType: EncodingTypeCopyRectangle, PixelData: data,
+	}
+}
+
+// CopyRectSource returns the top-left corner of the region that a CopyRect rectangle copies from.
+func (rect *FramebufferUpdateRect) CopyRectSource() (image.Point, error) {
+	if rect.EncodingType != EncodingTypeCopyRectangle {
+		return image.Point{}, fmt.Errorf("expected encoding type %d, but found %d", EncodingTypeCopyRectangle, rect.EncodingType)
+	}
+	if len(rect.PixelData) != 4 {
+		return image.Point{}, fmt.Errorf("expected 4 bytes of CopyRect data, but found %d", len(rect.PixelData))
+	}
+	return image.Pt(int(binary.BigEndian.Uint16(rect.PixelData[0:])), int(binary.BigEndian.Uint16(rect.PixelData[2:]))), nil
+}
+
+// CopyRect applies a CopyRect rectangle to img, which holds the client's framebuffer. The source and destination may overlap.
+func (img *PixelFormatImage) CopyRect(rect *FramebufferUpdateRect) error {
+	src, err := rect.CopyRectSource()
+	if err != nil {
+		return err
+	}
+	dst := rect.Bounds()
+	if !dst.In(img.Rect) || !dst.Sub(dst.Min).Add(src).In(img.Rect) {
+		return fmt.Errorf("CopyRect from %v to %v is outside of framebuffer %v", src, dst, img.Rect)
+	}
+	// Copy rows in the order that reads each one before it's overwritten; copy handles overlap within a row.
+	for i := 0; i < dst.Dy(); i++ {
+		row := i
+		if src.Y < dst.Min.Y {
+			row = dst.Dy() - 1 - i
+		}
+		copy(img.Pix[img.idx(dst.Min.X, dst.Min.Y+row):img.idx(dst.Max.X, dst.Min.Y+row)], img.Pix[img.idx(src.X, src.Y+row):img.idx(src.X+dst.Dx(), src.Y+row)])
+	}
+	return nil
+}
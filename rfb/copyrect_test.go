@@ -0,0 +1,56 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestCopyRect(t *testing.T) {
+	fb := image.Rect(0, 0, 40, 30)
+	for _, tc := range []struct {
+		dst image.Rectangle
+		src image.Point
+	}{
+		{image.Rect(20, 15, 30, 25), image.Pt(2, 3)},
+		{image.Rect(5, 5, 25, 20), image.Pt(8, 9)}, // Overlapping, moving up and left.
+		{image.Rect(8, 9, 28, 24), image.Pt(5, 5)}, // Overlapping, moving down and right.
+		{image.Rect(0, 4, 40, 30), image.Pt(0, 0)}, // Full rows.
+	} {
+		orig := randomImage(pixelFormat, fb, 50, 1)
+		img, _ := NewPixelFormatImage(pixelFormat, fb)
+		copy(img.Pix, orig.Pix)
+
+		rect := NewCopyRect(tc.dst, tc.src)
+		var buf bytes.Buffer
+		if err := rect.Write(&buf, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+		var rect2 FramebufferUpdateRect
+		if err := rect2.Read(&buf, binary.BigEndian, pixelFormat, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := img.CopyRect(&rect2); err != nil {
+			t.Fatal(err)
+		}
+
+		delta := tc.src.Sub(tc.dst.Min)
+		for y := fb.Min.Y; y < fb.Max.Y; y++ {
+			for x := fb.Min.X; x < fb.Max.X; x++ {
+				want := orig.At(x, y)
+				if image.Pt(x, y).In(tc.dst) {
+					want = orig.At(x+delta.X, y+delta.Y)
+				}
+				if got := img.At(x, y); got != want {
+					t.Fatalf("copy %v to %v: at (%d, %d), expected %v, but got %v", tc.src, tc.dst, x, y, want, got)
+				}
+			}
+		}
+	}
+
+	img, _ := NewPixelFormatImage(pixelFormat, fb)
+	if err := img.CopyRect(NewCopyRect(image.Rect(0, 0, 10, 10), image.Pt(35, 0))); err == nil {
+		t.Errorf("expected an error for a source outside of the framebuffer")
+	}
+}
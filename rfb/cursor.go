@@ -0,0 +1,195 @@
+package rfb
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// EncodingTypeCursor is the RichCursor pseudo-encoding, -239. Clients that list it draw the cursor themselves, in the shape that the server sends as a rectangle with this encoding type.
+const EncodingTypeCursor = uint32(0xffffff11)
+
+// EncodingTypeXCursor is the XCursor pseudo-encoding, -240, for clients that can only draw cursors in two colors.
+const EncodingTypeXCursor = uint32(0xffffff10)
+
+// NewCursorRect returns a rectangle that sets the client's cursor to img, with its hotspot at hotspot in img's coordinates. Pixels at least half opaque are drawn, opaquely; the rest are transparent.
+func NewCursorRect(img image.Image, hotspot image.Point, pixelFormat PixelFormat) (*FramebufferUpdateRect, error) {
+	b := img.Bounds()
+	size := image.Rect(0, 0, b.Dx(), b.Dy())
+	pixels, err := NewPixelFormatImage(pixelFormat, size)
+	if err != nil {
+		return nil, err
+	}
+	rgba := image.NewRGBA(size)
+	mask := cursorMask(img)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			rgba.SetRGBA(x, y, color.RGBA{c.R, c.G, c.B, 0xff})
+		}
+	}
+	if err := pixels.CopyFromRGBA(rgba); err != nil {
+		return nil, err
+	}
+	hotspot = hotspot.Sub(b.Min)
+	return &FramebufferUpdateRect{
+		X: uint16(hotspot.X), Y: uint16(hotspot.Y), Width: uint16(b.Dx()), Height: uint16(b.Dy()),
+		EncodingType: EncodingTypeCursor, PixelData: append(pixels.Pix, mask...),
+	}, nil
+}
+
+// NewXCursorRect returns a rectangle that sets the client's cursor to a two-color version of img, with its hotspot at hotspot in img's coordinates. Pixels at least half opaque are drawn, in the average color of either the dark or the light ones.
+func NewXCursorRect(img image.Image, hotspot image.Point) *FramebufferUpdateRect {
+	b := img.Bounds()
+	hotspot = hotspot.Sub(b.Min)
+	rect := &FramebufferUpdateRect{
+		X: uint16(hotspot.X), Y: uint16(hotspot.Y), Width: uint16(b.Dx()), Height: uint16(b.Dy()),
+		EncodingType: EncodingTypeXCursor,
+	}
+	if b.Empty() {
+		return rect
+	}
+
+	mask := cursorMask(img)
+	bitmap := make([]byte, len(mask))
+	rowBytes := (b.Dx() + 7) / 8
+	var sums [2][3]uint32 // dark, then light
+	var counts [2]uint32
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if mask[y*rowBytes+x/8]&(0x80>>uint(x%8)) == 0 {
+				continue
+			}
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			light := 0
+			if color.GrayModel.Convert(color.RGBA{c.R, c.G, c.B, 0xff}).(color.Gray).Y >= 0x80 {
+				light = 1
+			} else {
+				bitmap[y*rowBytes+x/8] |= 0x80 >> uint(x%8)
+			}
+			sums[light][0] += uint32(c.R)
+			sums[light][1] += uint32(c.G)
+			sums[light][2] += uint32(c.B)
+			counts[light]++
+		}
+	}
+	colors := [2][3]byte{{0, 0, 0}, {0xff, 0xff, 0xff}}
+	for i := range colors {
+		if counts[i] > 0 {
+			for j := range colors[i] {
+				colors[i][j] = uint8(sums[i][j] / counts[i])
+			}
+		}
+	}
+
+	// The primary color is for bits set in the bitmap, which are the dark pixels.
+	rect.PixelData = append(append(append(colors[0][:], colors[1][:]...), bitmap...), mask...)
+	return rect
+}
+
+// Cursor decodes a rectangle with EncodingTypeCursor or EncodingTypeXCursor, returning the cursor's image, with transparent pixels where it isn't drawn, and its hotspot.
+func (rect *FramebufferUpdateRect) Cursor(pixelFormat PixelFormat) (*image.NRGBA, image.Point, error) {
+	size := image.Rect(0, 0, int(rect.Width), int(rect.Height))
+	switch rect.EncodingType {
+	case EncodingTypeCursor:
+	case EncodingTypeXCursor:
+		cursor, err := decodeXCursor(rect.PixelData, size)
+		if err != nil {
+			return nil, image.Point{}, err
+		}
+		return cursor, image.Pt(int(rect.X), int(rect.Y)), nil
+	default:
+		return nil, image.Point{}, fmt.Errorf("expected encoding type %d or %d, but found %d", EncodingTypeCursor, EncodingTypeXCursor, rect.EncodingType)
+	}
+	pixels, err := NewPixelFormatImage(pixelFormat, size)
+	if err != nil {
+		return nil, image.Point{}, err
+	}
+	maskLen := cursorMaskLength(size.Dx(), size.Dy())
+	if len(rect.PixelData) != len(pixels.Pix)+maskLen {
+		return nil, image.Point{}, fmt.Errorf("expected %d bytes of cursor data, but found %d", len(pixels.Pix)+maskLen, len(rect.PixelData))
+	}
+	copy(pixels.Pix, rect.PixelData)
+	rgba := image.NewRGBA(size)
+	if err := pixels.CopyToRGBA(rgba); err != nil {
+		return nil, image.Point{}, err
+	}
+	cursor := image.NewNRGBA(size)
+	draw.DrawMask(cursor, size, rgba, image.Point{}, cursorMaskImage(rect.PixelData[len(pixels.Pix):], size), image.Point{}, draw.Src)
+	return cursor, image.Pt(int(rect.X), int(rect.Y)), nil
+}
+
+func decodeXCursor(data []byte, size image.Rectangle) (*image.NRGBA, error) {
+	cursor := image.NewNRGBA(size)
+	if size.Empty() {
+		if len(data) != 0 {
+			return nil, fmt.Errorf("expected no data for an empty cursor, but found %d bytes", len(data))
+		}
+		return cursor, nil
+	}
+	maskLen := cursorMaskLength(size.Dx(), size.Dy())
+	if len(data) != xCursorLength(size.Dx(), size.Dy()) {
+		return nil, fmt.Errorf("expected %d bytes of cursor data, but found %d", xCursorLength(size.Dx(), size.Dy()), len(data))
+	}
+	primary := color.NRGBA{data[0], data[1], data[2], 0xff}
+	secondary := color.NRGBA{data[3], data[4], data[5], 0xff}
+	bitmap, mask := data[6:6+maskLen], data[6+maskLen:]
+	rowBytes := (size.Dx() + 7) / 8
+	for y := 0; y < size.Dy(); y++ {
+		for x := 0; x < size.Dx(); x++ {
+			bit := byte(0x80 >> uint(x%8))
+			if mask[y*rowBytes+x/8]&bit == 0 {
+				continue
+			}
+			if bitmap[y*rowBytes+x/8]&bit != 0 {
+				cursor.SetNRGBA(x, y, primary)
+			} else {
+				cursor.SetNRGBA(x, y, secondary)
+			}
+		}
+	}
+	return cursor, nil
+}
+
+// xCursorLength returns the size of an XCursor payload: two colors, a bitmap, and a bitmask.
+func xCursorLength(width, height int) int {
+	if width == 0 || height == 0 {
+		return 0
+	}
+	return 6 + 2*cursorMaskLength(width, height)
+}
+
+// cursorMaskLength returns the size of a cursor bitmask, which has a bit per pixel, most significant first, with rows padded to whole bytes.
+func cursorMaskLength(width, height int) int {
+	return (width + 7) / 8 * height
+}
+
+// cursorMask returns a bitmask with bits set for pixels of img that are at least half opaque.
+func cursorMask(img image.Image) []byte {
+	b := img.Bounds()
+	rowBytes := (b.Dx() + 7) / 8
+	mask := make([]byte, cursorMaskLength(b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if _, _, _, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA(); a >= 0x8000 {
+				mask[y*rowBytes+x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+	}
+	return mask
+}
+
+// cursorMaskImage returns mask as an image that is opaque where bits are set.
+func cursorMaskImage(mask []byte, r image.Rectangle) *image.Alpha {
+	rowBytes := (r.Dx() + 7) / 8
+	img := image.NewAlpha(r)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			if mask[y*rowBytes+x/8]&(0x80>>uint(x%8)) != 0 {
+				img.SetAlpha(x, y, color.Alpha{0xff})
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,94 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	// Offset bounds and an odd width exercise hotspot translation and mask padding.
+	src := image.NewNRGBA(image.Rect(10, 10, 21, 17))
+	for y := 10; y < 17; y++ {
+		for x := 10; x < 21; x++ {
+			if (x+y)%3 != 0 {
+				src.SetNRGBA(x, y, color.NRGBA{uint8(x * 10), uint8(y * 10), 0x80, 0xff})
+			}
+		}
+	}
+
+	for _, pf := range []PixelFormat{pixelFormat, pixelFormat16} {
+		rect, err := NewCursorRect(src, image.Pt(12, 13), pf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := rect.Write(&buf, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+		var rect2 FramebufferUpdateRect
+		if err := rect2.Read(&buf, binary.BigEndian, pf, nil); err != nil {
+			t.Fatal(err)
+		}
+		cursor, hotspot, err := rect2.Cursor(pf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hotspot != image.Pt(2, 3) {
+			t.Errorf("expected hotspot (2,3), but got %v", hotspot)
+		}
+		for y := 0; y < 7; y++ {
+			for x := 0; x < 11; x++ {
+				want := src.NRGBAAt(10+x, 10+y)
+				got := cursor.NRGBAAt(x, y)
+				if got.A != want.A {
+					t.Fatalf("%d bpp: at (%d, %d), expected alpha %d, but got %d", pf.BitsPerPixel, x, y, want.A, got.A)
+				}
+				if pf.BitsPerPixel == 32 && got != want {
+					t.Fatalf("at (%d, %d), expected %v, but got %v", x, y, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestXCursorRoundTrip(t *testing.T) {
+	dark, light := color.NRGBA{0x10, 0x20, 0x30, 0xff}, color.NRGBA{0xf0, 0xe0, 0xd0, 0xff}
+	src := image.NewNRGBA(image.Rect(0, 0, 9, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 9; x++ {
+			switch (x + 2*y) % 3 {
+			case 1:
+				src.SetNRGBA(x, y, dark)
+			case 2:
+				src.SetNRGBA(x, y, light)
+			}
+		}
+	}
+
+	rect := NewXCursorRect(src, image.Pt(4, 2))
+	var buf bytes.Buffer
+	if err := rect.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	var rect2 FramebufferUpdateRect
+	if err := rect2.Read(&buf, binary.BigEndian, pixelFormat, nil); err != nil {
+		t.Fatal(err)
+	}
+	cursor, hotspot, err := rect2.Cursor(pixelFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hotspot != image.Pt(4, 2) {
+		t.Errorf("expected hotspot (4,2), but got %v", hotspot)
+	}
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 9; x++ {
+			if want, got := src.NRGBAAt(x, y), cursor.NRGBAAt(x, y); got != want {
+				t.Fatalf("at (%d, %d), expected %v, but got %v", x, y, want, got)
+			}
+		}
+	}
+}
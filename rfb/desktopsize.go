@@ -0,0 +1,154 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// EncodingTypeExtendedDesktopSize is the ExtendedDesktopSize pseudo-encoding, -308. Clients that list it may send SetDesktopSizeMessage, and servers tell them about the framebuffer's size and screen layout with ExtendedDesktopSize rectangles.
+const EncodingTypeExtendedDesktopSize = uint32(0xfffffecc)
+
+// Reasons for an ExtendedDesktopSize rectangle.
+const (
+	DesktopSizeReasonServer      = uint16(0) // The server changed the size, or the client just listed the encoding.
+	DesktopSizeReasonClient      = uint16(1) // Reply to this client's SetDesktopSizeMessage.
+	DesktopSizeReasonOtherClient = uint16(2) // Another client's SetDesktopSizeMessage changed the size.
+)
+
+// Status codes for an ExtendedDesktopSize rectangle replying to SetDesktopSizeMessage.
+const (
+	DesktopSizeStatusOK             = uint16(0)
+	DesktopSizeStatusProhibited     = uint16(1)
+	DesktopSizeStatusOutOfResources = uint16(2)
+	DesktopSizeStatusInvalidLayout  = uint16(3)
+)
+
+// maxScreens bounds the screens in one message. The count is one byte on the wire, so this only guards against nonsense.
+const maxScreens = 255
+
+// Screen is a region of the framebuffer that a client may show as a separate monitor.
+type Screen struct {
+	ID     uint32
+	X, Y   uint16
+	Width  uint16
+	Height uint16
+	Flags  uint32
+}
+
+// ExtendedDesktopSize is the content of an ExtendedDesktopSize rectangle.
+type ExtendedDesktopSize struct {
+	Reason, Status uint16
+	Width, Height  uint16
+	Screens        []Screen
+}
+
+// Rect returns the rectangle to send in a FramebufferUpdateMessage.
+func (s *ExtendedDesktopSize) Rect() (*FramebufferUpdateRect, error) {
+	if len(s.Screens) > maxScreens {
+		return nil, fmt.Errorf("too many screens: %d > %d", len(s.Screens), maxScreens)
+	}
+	data := make([]byte, 4, 4+16*len(s.Screens))
+	data[0] = uint8(len(s.Screens))
+	data = appendScreens(data, binary.BigEndian, s.Screens)
+	return &FramebufferUpdateRect{
+		X: s.Reason, Y: s.Status, Width: s.Width, Height: s.Height,
+		EncodingType: EncodingTypeExtendedDesktopSize, PixelData: data,
+	}, nil
+}
+
+// ExtendedDesktopSize decodes a rectangle with EncodingTypeExtendedDesktopSize.
+func (rect *FramebufferUpdateRect) ExtendedDesktopSize() (*ExtendedDesktopSize, error) {
+	if rect.EncodingType != EncodingTypeExtendedDesktopSize {
+		return nil, fmt.Errorf("expected encoding type %d, but found %d", EncodingTypeExtendedDesktopSize, rect.EncodingType)
+	}
+	if len(rect.PixelData) < 4 || len(rect.PixelData) != 4+16*int(rect.PixelData[0]) {
+		return nil, fmt.Errorf("malformed ExtendedDesktopSize data of %d bytes", len(rect.PixelData))
+	}
+	return &ExtendedDesktopSize{
+		Reason: rect.X, Status: rect.Y, Width: rect.Width, Height: rect.Height,
+		Screens: parseScreens(rect.PixelData[4:], binary.BigEndian, int(rect.PixelData[0])),
+	}, nil
+}
+
+// readExtendedDesktopSize reads the payload of an ExtendedDesktopSize rectangle.
+func readExtendedDesktopSize(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, 4+16*int(header[0]))
+	copy(data, header[:])
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// SetDesktopSizeMessage is sent by clients to ask the server to change the framebuffer's size and screen layout. The server replies with an ExtendedDesktopSize rectangle with DesktopSizeReasonClient.
+type SetDesktopSizeMessage struct {
+	Width, Height uint16
+	Screens       []Screen
+}
+
+func (m *SetDesktopSizeMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	if buf[0] != 251 {
+		return protocolErrorf(buf[0], 0, "expected message type 251")
+	}
+	m.Width = bo.Uint16(buf[2:])
+	m.Height = bo.Uint16(buf[4:])
+	data := make([]byte, 16*int(buf[6]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	m.Screens = parseScreens(data, bo, int(buf[6]))
+	return nil
+}
+
+func (m *SetDesktopSizeMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if len(m.Screens) > maxScreens {
+		return fmt.Errorf("too many screens: %d > %d", len(m.Screens), maxScreens)
+	}
+	buf := make([]byte, 8, 8+16*len(m.Screens))
+	buf[0] = 251
+	bo.PutUint16(buf[2:], m.Width)
+	bo.PutUint16(buf[4:], m.Height)
+	buf[6] = uint8(len(m.Screens))
+	buf = appendScreens(buf, bo, m.Screens)
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+func appendScreens(buf []byte, bo binary.ByteOrder, screens []Screen) []byte {
+	for _, s := range screens {
+		var b [16]byte
+		bo.PutUint32(b[0:], s.ID)
+		bo.PutUint16(b[4:], s.X)
+		bo.PutUint16(b[6:], s.Y)
+		bo.PutUint16(b[8:], s.Width)
+		bo.PutUint16(b[10:], s.Height)
+		bo.PutUint32(b[12:], s.Flags)
+		buf = append(buf, b[:]...)
+	}
+	return buf
+}
+
+func parseScreens(data []byte, bo binary.ByteOrder, count int) []Screen {
+	screens := make([]Screen, count)
+	for i := range screens {
+		b := data[16*i:]
+		screens[i] = Screen{
+			ID: bo.Uint32(b[0:]),
+			X:  bo.Uint16(b[4:]), Y: bo.Uint16(b[6:]),
+			Width: bo.Uint16(b[8:]), Height: bo.Uint16(b[10:]),
+			Flags: bo.Uint32(b[12:]),
+		}
+	}
+	return screens
+}
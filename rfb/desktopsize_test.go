@@ -0,0 +1,54 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestSetDesktopSizeMessageRoundTrip(t *testing.T) {
+	m := SetDesktopSizeMessage{
+		Width: 1920, Height: 1080,
+		Screens: []Screen{{ID: 1, Width: 1280, Height: 1080}, {ID: 2, X: 1280, Width: 640, Height: 480, Flags: 7}},
+	}
+	var buf bytes.Buffer
+	if err := m.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	var m2 SetDesktopSizeMessage
+	if err := m2.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, m2) {
+		t.Errorf("expected %+v, but got %+v", m, m2)
+	}
+}
+
+func TestExtendedDesktopSizeRoundTrip(t *testing.T) {
+	s := ExtendedDesktopSize{
+		Reason: DesktopSizeReasonClient, Status: DesktopSizeStatusOK,
+		Width: 800, Height: 600,
+		Screens: []Screen{{ID: 3, Width: 800, Height: 600}},
+	}
+	rect, err := s.Rect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{rect, rect}}
+	var buf bytes.Buffer
+	if err := update.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	var update2 FramebufferUpdateMessage
+	if err := update2.Read(&buf, binary.BigEndian, pixelFormat, nil); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := update2.Rectangles[1].ExtendedDesktopSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&s, s2) {
+		t.Errorf("expected %+v, but got %+v", s, s2)
+	}
+}
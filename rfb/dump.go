@@ -0,0 +1,357 @@
+package rfb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dump returns a multi-line description of m that is more detailed than m.String(), such as the header of each rectangle in a FramebufferUpdateMessage. Pixel data and other large payloads are elided, leaving only their lengths.
+func Dump(m fmt.Stringer) string {
+	var b strings.Builder
+	b.WriteString(m.String())
+	switch m := m.(type) {
+	case *FramebufferUpdateMessage:
+		for i, rect := range m.Rectangles {
+			fmt.Fprintf(&b, "\n  rectangle %d: %s", i, rect)
+		}
+	case *SetEncodingsMessage:
+		for _, t := range m.EncodingTypes {
+			fmt.Fprintf(&b, "\n  %s (%d)", EncodingName(t), int32(t))
+		}
+	case *SetDesktopSizeMessage:
+		dumpScreens(&b, m.Screens)
+	case *ClientCutTextMessage:
+		dumpCutText(&b, m.Text, m.Extended)
+	case *ServerCutTextMessage:
+		dumpCutText(&b, m.Text, m.Extended)
+	case *FileTransferMessage:
+		if m.ContentType != FileTransferFilePacket && len(m.Data) > 0 {
+			fmt.Fprintf(&b, "\n  %s", quoteTruncated(m.Data))
+		}
+	case *FenceMessage:
+		if len(m.Data) > 0 {
+			fmt.Fprintf(&b, "\n  %x", m.Data)
+		}
+	case *SetColourMapEntriesMessage:
+		dumpColours(&b, m.FirstColour, len(m.Colours))
+	case *FixColourMapEntriesMessage:
+		dumpColours(&b, m.FirstColour, len(m.Colours))
+	}
+	return b.String()
+}
+
+func dumpScreens(b *strings.Builder, screens []Screen) {
+	for _, s := range screens {
+		fmt.Fprintf(b, "\n  screen %d: %dx%d at (%d, %d), flags %#x", s.ID, s.Width, s.Height, s.X, s.Y, s.Flags)
+	}
+}
+
+func dumpCutText(b *strings.Builder, text string, extended *ExtendedClipboard) {
+	if extended == nil {
+		fmt.Fprintf(b, "\n  %s", quoteTruncated([]byte(text)))
+		return
+	}
+	i := 0
+	for bit := uint32(1); bit < 1<<16; bit <<= 1 {
+		if extended.Flags&bit == 0 {
+			continue
+		}
+		name := flagNames(bit, clipboardFormatNames)
+		if i < len(extended.Sizes) {
+			fmt.Fprintf(b, "\n  %s: up to %d bytes", name, extended.Sizes[i])
+		}
+		if i < len(extended.Data) {
+			fmt.Fprintf(b, "\n  %s: %d bytes", name, len(extended.Data[i]))
+		}
+		i++
+	}
+}
+
+func dumpColours(b *strings.Builder, first uint16, n int) {
+	if n > 0 {
+		fmt.Fprintf(b, "\n  colours %d through %d (elided)", first, int(first)+n-1)
+	}
+}
+
+// maxDumpText is the most bytes of text that Dump shows from a message.
+const maxDumpText = 64
+
+func quoteTruncated(data []byte) string {
+	if len(data) > maxDumpText {
+		return fmt.Sprintf("%q… (%d more bytes)", data[:maxDumpText], len(data)-maxDumpText)
+	}
+	return fmt.Sprintf("%q", data)
+}
+
+type flagName struct {
+	flag uint32
+	name string
+}
+
+// flagNames returns the names of the flags set in flags, separated by "|", with any unnamed flags in hexadecimal.
+func flagNames(flags uint32, names []flagName) string {
+	var parts []string
+	for _, n := range names {
+		if flags&n.flag != 0 {
+			parts = append(parts, n.name)
+			flags &^= n.flag
+		}
+	}
+	if flags != 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%#x", flags))
+	}
+	return strings.Join(parts, "|")
+}
+
+var clipboardFormatNames = []flagName{
+	{ClipboardText, "Text"},
+	{ClipboardRTF, "RTF"},
+	{ClipboardHTML, "HTML"},
+	{ClipboardDIB, "DIB"},
+	{ClipboardFiles, "Files"},
+}
+
+var clipboardFlagNames = append([]flagName{
+	{ClipboardCaps, "Caps"},
+	{ClipboardRequest, "Request"},
+	{ClipboardPeek, "Peek"},
+	{ClipboardNotify, "Notify"},
+	{ClipboardProvide, "Provide"},
+}, clipboardFormatNames...)
+
+var fenceFlagNames = []flagName{
+	{FenceBlockBefore, "BlockBefore"},
+	{FenceBlockAfter, "BlockAfter"},
+	{FenceSyncNext, "SyncNext"},
+	{FenceRequest, "Request"},
+}
+
+// EncodingName returns the name of an encoding or pseudo-encoding type, such as "Raw" or "CompressLevel6", or its signed number if it's unknown.
+func EncodingName(t uint32) string {
+	switch {
+	case t >= EncodingTypeCompressLevel0 && t <= EncodingTypeCompressLevel9:
+		return fmt.Sprintf("CompressLevel%d", t-EncodingTypeCompressLevel0)
+	case t >= EncodingTypeQualityLevel0 && t <= EncodingTypeQualityLevel9:
+		return fmt.Sprintf("QualityLevel%d", t-EncodingTypeQualityLevel0)
+	}
+	switch t {
+	case EncodingTypeRaw:
+		return "Raw"
+	case EncodingTypeCopyRectangle:
+		return "CopyRect"
+	case EncodingTypeRRE:
+		return "RRE"
+	case EncodingTypeCoRRE:
+		return "CoRRE"
+	case EncodingTypeHextile:
+		return "Hextile"
+	case EncodingTypeTight:
+		return "Tight"
+	case EncodingTypeOpenH264:
+		return "OpenH264"
+	case EncodingTypeCursor:
+		return "Cursor"
+	case EncodingTypeXCursor:
+		return "XCursor"
+	case EncodingTypeExtendedDesktopSize:
+		return "ExtendedDesktopSize"
+	case EncodingTypeContinuousUpdates:
+		return "ContinuousUpdates"
+	case EncodingTypeFence:
+		return "Fence"
+	case EncodingTypeXVP:
+		return "xvp"
+	case EncodingTypeExtendedClipboard:
+		return "ExtendedClipboard"
+	}
+	return fmt.Sprint(int32(t))
+}
+
+func (pf PixelFormat) String() string {
+	endian := "little-endian"
+	if pf.BigEndian {
+		endian = "big-endian"
+	}
+	if !pf.TrueColor {
+		return fmt.Sprintf("%d bpp, depth %d, %s, colour map", pf.BitsPerPixel, pf.BitDepth, endian)
+	}
+	return fmt.Sprintf("%d bpp, depth %d, %s, max %d/%d/%d, shift %d/%d/%d", pf.BitsPerPixel, pf.BitDepth, endian, pf.RedMax, pf.GreenMax, pf.BlueMax, pf.RedShift, pf.GreenShift, pf.BlueShift)
+}
+
+func (s AuthenticationScheme) String() string {
+	switch s {
+	case AuthenticationSchemeInvalid:
+		return "Invalid"
+	case AuthenticationSchemeNone:
+		return "None"
+	case AuthenticationSchemeVNC:
+		return "VNC"
+	}
+	return fmt.Sprintf("AuthenticationScheme(%d)", uint32(s))
+}
+
+func (t SecurityType) String() string {
+	switch t {
+	case SecurityTypeInvalid:
+		return "Invalid"
+	case SecurityTypeNone:
+		return "None"
+	case SecurityTypeVNC:
+		return "VNC"
+	case SecurityTypeRA2:
+		return "RA2"
+	case SecurityTypeRA2ne:
+		return "RA2ne"
+	case SecurityTypeRA256:
+		return "RA2_256"
+	case SecurityTypeRAne256:
+		return "RA2ne_256"
+	case SecurityTypeTLS:
+		return "TLS"
+	}
+	return fmt.Sprintf("SecurityType(%d)", uint8(t))
+}
+
+func (r VNCAuthenticationResult) String() string {
+	switch r {
+	case VNCAuthenticationResultOK:
+		return "OK"
+	case VNCAuthenticationResultFailed:
+		return "Failed"
+	case VNCAuthenticationResultTooMany:
+		return "TooMany"
+	}
+	return fmt.Sprintf("VNCAuthenticationResult(%d)", uint32(r))
+}
+
+func (m *ProtocolVersionMessage) String() string {
+	return fmt.Sprintf("ProtocolVersion{%d.%d}", m.Major, m.Minor)
+}
+
+func (m *AuthenticationSchemeMessageRFB33) String() string {
+	if m.Scheme == AuthenticationSchemeInvalid {
+		return fmt.Sprintf("AuthenticationScheme{%s, Reason: %q}", m.Scheme, m.Reason)
+	}
+	return fmt.Sprintf("AuthenticationScheme{%s}", m.Scheme)
+}
+
+func (m *VNCAuthenticationChallengeMessage) String() string {
+	return fmt.Sprintf("VNCAuthenticationChallenge{%x}", m[:])
+}
+
+func (m *VNCAuthenticationResponseMessage) String() string {
+	return fmt.Sprintf("VNCAuthenticationResponse{%x}", m[:])
+}
+
+func (m *VNCAuthenticationResultMessage) String() string {
+	return fmt.Sprintf("VNCAuthenticationResult{%s}", m.Result)
+}
+
+func (m *SecurityTypesMessageRFB37) String() string {
+	if len(m.Types) == 0 {
+		return fmt.Sprintf("SecurityTypes{Reason: %q}", m.Reason)
+	}
+	return fmt.Sprintf("SecurityTypes{%v}", m.Types)
+}
+
+func (m *SecurityTypeSelectionMessageRFB37) String() string {
+	return fmt.Sprintf("SecurityTypeSelection{%s}", m.Type)
+}
+
+func (m *SecurityResultMessageRFB38) String() string {
+	if m.Result == VNCAuthenticationResultOK {
+		return fmt.Sprintf("SecurityResult{%s}", m.Result)
+	}
+	return fmt.Sprintf("SecurityResult{%s, Reason: %q}", m.Result, m.Reason)
+}
+
+func (m *ClientInitialisationMessage) String() string {
+	return fmt.Sprintf("ClientInitialisation{Shared: %t}", m.Shared)
+}
+
+func (m *ServerInitialisationMessage) String() string {
+	return fmt.Sprintf("ServerInitialisation{%dx%d, %s, Name: %q}", m.FramebufferWidth, m.FramebufferHeight, m.PixelFormat, m.Name)
+}
+
+func (m *SetPixelFormatMessage) String() string {
+	return fmt.Sprintf("SetPixelFormat{%s}", m.PixelFormat)
+}
+
+func (m *FixColourMapEntriesMessage) String() string {
+	return fmt.Sprintf("FixColourMapEntries{FirstColour: %d, %d colours}", m.FirstColour, len(m.Colours))
+}
+
+func (m *SetEncodingsMessage) String() string {
+	names := make([]string, len(m.EncodingTypes))
+	for i, t := range m.EncodingTypes {
+		names[i] = EncodingName(t)
+	}
+	return fmt.Sprintf("SetEncodings{%s}", strings.Join(names, ", "))
+}
+
+func (m *FramebufferUpdateRequestMessage) String() string {
+	return fmt.Sprintf("FramebufferUpdateRequest{Incremental: %t, %dx%d at (%d, %d)}", m.Incremental, m.Width, m.Height, m.X, m.Y)
+}
+
+func (m *KeyEventMessage) String() string {
+	return fmt.Sprintf("KeyEvent{Pressed: %t, KeySym: %#x}", m.Pressed, m.KeySym)
+}
+
+func (m *PointerEventMessage) String() string {
+	return fmt.Sprintf("PointerEvent{ButtonMask: %#02x, (%d, %d)}", m.ButtonMask, m.X, m.Y)
+}
+
+func (m *ClientCutTextMessage) String() string {
+	return "ClientCutText" + cutTextString(m.Text, m.Extended)
+}
+
+func (m *ServerCutTextMessage) String() string {
+	return "ServerCutText" + cutTextString(m.Text, m.Extended)
+}
+
+func cutTextString(text string, extended *ExtendedClipboard) string {
+	if extended == nil {
+		return fmt.Sprintf("{%d bytes}", len(text))
+	}
+	return fmt.Sprintf("{Extended: %s}", flagNames(extended.Flags, clipboardFlagNames))
+}
+
+func (m *FileTransferMessage) String() string {
+	return fmt.Sprintf("FileTransfer{ContentType: %d, ContentParam: %d, Size: %d, %d bytes}", m.ContentType, m.ContentParam, m.Size, len(m.Data))
+}
+
+func (m *EnableContinuousUpdatesMessage) String() string {
+	return fmt.Sprintf("EnableContinuousUpdates{Enable: %t, %dx%d at (%d, %d)}", m.Enable, m.Width, m.Height, m.X, m.Y)
+}
+
+func (m *FenceMessage) String() string {
+	return fmt.Sprintf("Fence{%s, %d bytes}", flagNames(m.Flags, fenceFlagNames), len(m.Data))
+}
+
+func (m *XVPMessage) String() string {
+	return fmt.Sprintf("xvp{Version: %d, Code: %d}", m.Version, m.Code)
+}
+
+func (m *SetDesktopSizeMessage) String() string {
+	return fmt.Sprintf("SetDesktopSize{%dx%d, %d screens}", m.Width, m.Height, len(m.Screens))
+}
+
+func (m *FramebufferUpdateMessage) String() string {
+	return fmt.Sprintf("FramebufferUpdate{%d rectangles}", len(m.Rectangles))
+}
+
+func (rect *FramebufferUpdateRect) String() string {
+	return fmt.Sprintf("%s %dx%d at (%d, %d), %d bytes", EncodingName(rect.EncodingType), rect.Width, rect.Height, rect.X, rect.Y, len(rect.PixelData))
+}
+
+func (m *SetColourMapEntriesMessage) String() string {
+	return fmt.Sprintf("SetColourMapEntries{FirstColour: %d, %d colours}", m.FirstColour, len(m.Colours))
+}
+
+func (m *BellMessage) String() string {
+	return "Bell{}"
+}
+
+func (m *EndOfContinuousUpdatesMessage) String() string {
+	return "EndOfContinuousUpdates{}"
+}
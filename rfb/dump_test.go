@@ -0,0 +1,42 @@
+package rfb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMessageString(t *testing.T) {
+	for _, test := range []struct {
+		m    interface{ String() string }
+		want string
+	}{
+		{&KeyEventMessage{Pressed: true, KeySym: 0x61}, "KeyEvent{Pressed: true, KeySym: 0x61}"},
+		{&SetEncodingsMessage{EncodingTypes: []uint32{EncodingTypeTight, EncodingTypeCompressLevel0 + 6, EncodingTypeFence, 0x12345}}, "SetEncodings{Tight, CompressLevel6, Fence, 74565}"},
+		{&FenceMessage{Flags: FenceBlockBefore | FenceRequest | 1<<8, Data: []byte{1, 2}}, "Fence{BlockBefore|Request|0x100, 2 bytes}"},
+		{&ClientCutTextMessage{Extended: NewClipboardProvideText("hi")}, "ClientCutText{Extended: Provide|Text}"},
+		{&SecurityTypesMessageRFB37{Types: []SecurityType{SecurityTypeNone, SecurityTypeVNC, 99}}, "SecurityTypes{[None VNC SecurityType(99)]}"},
+		{&ServerInitialisationMessage{FramebufferWidth: 640, FramebufferHeight: 480, PixelFormat: PixelFormat{BitsPerPixel: 8, BitDepth: 8}, Name: "x"}, `ServerInitialisation{640x480, 8 bpp, depth 8, little-endian, colour map, Name: "x"}`},
+	} {
+		if got := test.m.String(); got != test.want {
+			t.Errorf("expected %q, but got %q", test.want, got)
+		}
+	}
+}
+
+func TestDumpElidesPixelData(t *testing.T) {
+	m := &FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{
+		{X: 1, Y: 2, Width: 10, Height: 10, EncodingType: EncodingTypeRaw, PixelData: make([]byte, 400)},
+		{Width: 640, Height: 480, EncodingType: EncodingTypeExtendedDesktopSize},
+	}}
+	want := "FramebufferUpdate{2 rectangles}\n" +
+		"  rectangle 0: Raw 10x10 at (1, 2), 400 bytes\n" +
+		"  rectangle 1: ExtendedDesktopSize 640x480 at (0, 0), 0 bytes"
+	if got := Dump(m); got != want {
+		t.Errorf("expected %q, but got %q", want, got)
+	}
+
+	text := strings.Repeat("a", 100)
+	if got := Dump(&ServerCutTextMessage{Text: text}); !strings.HasSuffix(got, "… (36 more bytes)") {
+		t.Errorf("expected long text to be truncated, but got %q", got)
+	}
+}
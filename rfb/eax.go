@@ -0,0 +1,107 @@
+package rfb
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// eaxTagSize is the size of EAX's tag, and of its nonces as the RSA-AES security types use them: a full AES block.
+const eaxTagSize = 16
+
+// eax is the EAX mode of Bellare, Rogaway, and Wagner, an AEAD built from CTR mode and OMAC, which is CMAC. Go's standard library has no EAX, and the RSA-AES security types use nothing else.
+type eax struct {
+	block  cipher.Block
+	k1, k2 [16]byte // CMAC's subkeys, for a complete last block and a padded one.
+}
+
+// newEAX returns EAX over block, which must have 16-byte blocks, as AES does.
+func newEAX(block cipher.Block) cipher.AEAD {
+	e := &eax{block: block}
+	var l [16]byte
+	block.Encrypt(l[:], l[:])
+	double(&e.k1, &l)
+	double(&e.k2, &e.k1)
+	return e
+}
+
+// double multiplies in by x in GF(2^128), as CMAC derives subkeys.
+func double(out, in *[16]byte) {
+	carry := in[0] >> 7
+	for i := 0; i < 15; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[15] = in[15]<<1 ^ carry*0x87
+}
+
+// omac is EAX's tweaked OMAC: CMAC over a block holding tweak, followed by data.
+func (e *eax) omac(tweak byte, data []byte) [16]byte {
+	msg := make([]byte, 16, 16+len(data))
+	msg[15] = tweak
+	msg = append(msg, data...)
+
+	var mac [16]byte
+	for len(msg) > 16 {
+		xorBlock(&mac, msg[:16])
+		e.block.Encrypt(mac[:], mac[:])
+		msg = msg[16:]
+	}
+	last := e.k1
+	if len(msg) < 16 {
+		last = e.k2
+		last[len(msg)] ^= 0x80
+	}
+	xorBlock(&mac, last[:])
+	xorBlock(&mac, msg)
+	e.block.Encrypt(mac[:], mac[:])
+	return mac
+}
+
+// xorBlock XORs b, which is at most a block long, into the start of dst.
+func xorBlock(dst *[16]byte, b []byte) {
+	for i := range b {
+		dst[i] ^= b[i]
+	}
+}
+
+func (e *eax) NonceSize() int {
+	return eaxTagSize
+}
+
+func (e *eax) Overhead() int {
+	return eaxTagSize
+}
+
+// tag returns the tag for ciphertext and additionalData, given n, the OMAC of the nonce, which is also where CTR mode starts counting.
+func (e *eax) tag(n [16]byte, ciphertext, additionalData []byte) [16]byte {
+	h := e.omac(1, additionalData)
+	c := e.omac(2, ciphertext)
+	xorBlock(&n, h[:])
+	xorBlock(&n, c[:])
+	return n
+}
+
+func (e *eax) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	n := e.omac(0, nonce)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCTR(e.block, n[:]).XORKeyStream(ciphertext, plaintext)
+	tag := e.tag(n, ciphertext, additionalData)
+	return append(append(dst, ciphertext...), tag[:]...)
+}
+
+var errEAXOpen = errors.New("message authentication failed")
+
+func (e *eax) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < eaxTagSize {
+		return nil, errEAXOpen
+	}
+	ciphertext, received := ciphertext[:len(ciphertext)-eaxTagSize], ciphertext[len(ciphertext)-eaxTagSize:]
+	n := e.omac(0, nonce)
+	tag := e.tag(n, ciphertext, additionalData)
+	if subtle.ConstantTimeCompare(tag[:], received) != 1 {
+		return nil, errEAXOpen
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(e.block, n[:]).XORKeyStream(plaintext, ciphertext)
+	return append(dst, plaintext...), nil
+}
@@ -0,0 +1,232 @@
+package rfb
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+// Encoding encodes and decodes the payloads of FramebufferUpdateRect for one encoding type.
+type Encoding interface {
+	Type() uint32
+
+	// Encode returns the payload for the part of img in rect, which must be within img.
+	Encode(rect image.Rectangle, img *PixelFormatImage) ([]byte, error)
+
+	// Decode reads one payload for rect from r. Payloads aren't length-prefixed, so it must read exactly the payload and no more.
+	Decode(r io.Reader, rect image.Rectangle, pixelFormat PixelFormat) (*PixelFormatImage, error)
+}
+
+// Encodings is a registry of encodings for reading and decoding FramebufferUpdateRect.
+type Encodings struct {
+	encodings []Encoding
+	pool      *BufferPool
+	limits    *Limits
+}
+
+// Limits bounds what FramebufferUpdateMessage.Read and FramebufferUpdateRect.Read allocate for the rectangles that a peer declares, so that a malicious or corrupt stream can't exhaust memory. Exceeding them is a *LimitError. Zero fields are unlimited.
+type Limits struct {
+	// MaxRectPixels is the most pixels, width times height, that one rectangle may cover.
+	MaxRectPixels int
+
+	// MaxUpdateBytes is the most payload bytes that the rectangles of one FramebufferUpdate may have in total.
+	MaxUpdateBytes int
+}
+
+// DefaultLimits is used by Encodings that have no Limits set, including a nil *Encodings. It allows 8192×8192 rectangles and 512 MiB updates.
+var DefaultLimits = Limits{MaxRectPixels: 1 << 26, MaxUpdateBytes: 1 << 29}
+
+// DefaultEncodings is used by Read and Decode when they're given nil. It has the encodings in this package whose rectangles can be decoded on their own: raw, RRE, CoRRE, and Hextile. CopyRect, Tight, and Open H.264 rectangles depend on earlier ones, so they're handled separately.
+var DefaultEncodings = NewEncodings(RawEncoding{}, RREEncoding{}, CoRREEncoding{}, HextileEncoding{})
+
+// NewEncodings returns a registry with encodings registered in order.
+func NewEncodings(encodings ...Encoding) *Encodings {
+	e := &Encodings{}
+	for _, encoding := range encodings {
+		e.Register(encoding)
+	}
+	return e
+}
+
+// Register adds encoding, replacing any encoding of the same type.
+func (e *Encodings) Register(encoding Encoding) {
+	for idx, enc := range e.encodings {
+		if enc.Type() == encoding.Type() {
+			e.encodings[idx] = encoding
+			return
+		}
+	}
+	e.encodings = append(e.encodings, encoding)
+}
+
+// SetBufferPool makes FramebufferUpdateRect.Read take payloads from pool, so that they can be returned with Release.
+func (e *Encodings) SetBufferPool(pool *BufferPool) {
+	e.pool = pool
+}
+
+func (e *Encodings) bufferPool() *BufferPool {
+	if e == nil {
+		return nil
+	}
+	return e.pool
+}
+
+// SetLimits replaces DefaultLimits for reads with e.
+func (e *Encodings) SetLimits(limits Limits) {
+	e.limits = &limits
+}
+
+func (e *Encodings) currentLimits() Limits {
+	if e == nil || e.limits == nil {
+		return DefaultLimits
+	}
+	return *e.limits
+}
+
+// checkRect returns a *LimitError if a rectangle of width×height pixels, whose payload would bring an update to size bytes, exceeds limits.
+func (limits Limits) checkRect(width, height, size int) error {
+	if pixels := width * height; limits.MaxRectPixels > 0 && pixels > limits.MaxRectPixels {
+		return &LimitError{What: "rectangle pixels", Size: pixels, Max: limits.MaxRectPixels}
+	}
+	return limits.checkUpdate(size)
+}
+
+func (limits Limits) checkUpdate(size int) error {
+	if limits.MaxUpdateBytes > 0 && size > limits.MaxUpdateBytes {
+		return &LimitError{What: "FramebufferUpdate bytes", Size: size, Max: limits.MaxUpdateBytes}
+	}
+	return nil
+}
+
+// Lookup returns the encoding registered for encodingType, or nil.
+func (e *Encodings) Lookup(encodingType uint32) Encoding {
+	if e == nil {
+		e = DefaultEncodings
+	}
+	for _, enc := range e.encodings {
+		if enc.Type() == encodingType {
+			return enc
+		}
+	}
+	return nil
+}
+
+// Encode returns a rectangle covering img in the encoding registered for encodingType.
+func (e *Encodings) Encode(encodingType uint32, img *PixelFormatImage) (*FramebufferUpdateRect, error) {
+	encoding := e.Lookup(encodingType)
+	if encoding == nil {
+		return nil, fmt.Errorf("%w: no encoding registered for encoding type %d", ErrUnsupportedEncoding, encodingType)
+	}
+	data, err := encoding.Encode(img.Rect, img)
+	if err != nil {
+		return nil, err
+	}
+	r := img.Rect
+	return &FramebufferUpdateRect{
+		X: uint16(r.Min.X), Y: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+		EncodingType: encodingType, PixelData: data,
+	}, nil
+}
+
+// RawEncoding is EncodingTypeRaw: pixels in the wire format, row by row.
+type RawEncoding struct{}
+
+func (RawEncoding) Type() uint32 {
+	return EncodingTypeRaw
+}
+
+func (RawEncoding) Encode(rect image.Rectangle, img *PixelFormatImage) ([]byte, error) {
+	return img.rawPixels(rect), nil
+}
+
+func (RawEncoding) Decode(r io.Reader, rect image.Rectangle, pixelFormat PixelFormat) (*PixelFormatImage, error) {
+	img, err := NewPixelFormatImage(pixelFormat, rect)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, img.Pix); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// RREEncoding is EncodingTypeRRE, using EncodeRRE and DecodeRRE.
+type RREEncoding struct{}
+
+func (RREEncoding) Type() uint32 {
+	return EncodingTypeRRE
+}
+
+func (RREEncoding) Encode(rect image.Rectangle, img *PixelFormatImage) ([]byte, error) {
+	return encodeRRE(img, rect, false), nil
+}
+
+func (RREEncoding) Decode(r io.Reader, rect image.Rectangle, pixelFormat PixelFormat) (*PixelFormatImage, error) {
+	return decodeWith(r, rect, pixelFormat, DecodeRRE)
+}
+
+// CoRREEncoding is EncodingTypeCoRRE for a single rectangle of at most 255×255 pixels. To encode a larger image, use EncodeCoRRE, which splits it.
+type CoRREEncoding struct{}
+
+func (CoRREEncoding) Type() uint32 {
+	return EncodingTypeCoRRE
+}
+
+func (CoRREEncoding) Encode(rect image.Rectangle, img *PixelFormatImage) ([]byte, error) {
+	if rect.Dx() > coRRETileSize || rect.Dy() > coRRETileSize {
+		return nil, fmt.Errorf("CoRRE rectangles can be at most %d×%d, but %v is %d×%d", coRRETileSize, coRRETileSize, rect, rect.Dx(), rect.Dy())
+	}
+	return encodeRRE(img, rect, true), nil
+}
+
+func (CoRREEncoding) Decode(r io.Reader, rect image.Rectangle, pixelFormat PixelFormat) (*PixelFormatImage, error) {
+	return decodeWith(r, rect, pixelFormat, DecodeCoRRE)
+}
+
+// HextileEncoding is EncodingTypeHextile, using EncodeHextile and DecodeHextile.
+type HextileEncoding struct{}
+
+func (HextileEncoding) Type() uint32 {
+	return EncodingTypeHextile
+}
+
+func (HextileEncoding) Encode(rect image.Rectangle, img *PixelFormatImage) ([]byte, error) {
+	if rect != img.Rect {
+		img = img.crop(rect)
+	}
+	return EncodeHextile(img), nil
+}
+
+func (HextileEncoding) Decode(r io.Reader, rect image.Rectangle, pixelFormat PixelFormat) (*PixelFormatImage, error) {
+	return decodeWith(r, rect, pixelFormat, DecodeHextile)
+}
+
+func decodeWith(r io.Reader, rect image.Rectangle, pixelFormat PixelFormat, decode func(io.Reader, *PixelFormatImage) error) (*PixelFormatImage, error) {
+	img, err := NewPixelFormatImage(pixelFormat, rect)
+	if err != nil {
+		return nil, err
+	}
+	if err := decode(r, img); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// readPayload reads one payload with encoding, returning its bytes.
+func readPayload(r io.Reader, encoding Encoding, rect image.Rectangle, pixelFormat PixelFormat, pool *BufferPool) ([]byte, error) {
+	rawLength := int(pixelFormat.BitsPerPixel/8) * rect.Dx() * rect.Dy()
+	if _, ok := encoding.(RawEncoding); ok {
+		// Raw payloads have a known length, so there's nothing to decode.
+		payload := pool.Get(rawLength)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			pool.Put(payload)
+			return nil, err
+		}
+		return payload, nil
+	}
+	payload := pool.buffer(rawLength)
+	if _, err := encoding.Decode(io.TeeReader(r, payload), rect, pixelFormat); err != nil {
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"testing"
+)
+
+// solidEncoding is a made-up encoding whose payload is a single pixel that fills the rectangle.
+type solidEncoding struct{}
+
+func (solidEncoding) Type() uint32 {
+	return 0x7fff0000
+}
+
+func (solidEncoding) Encode(rect image.Rectangle, img *PixelFormatImage) ([]byte, error) {
+	var buf bytes.Buffer
+	img.writePixel(&buf, img.getPixel(img.idx(rect.Min.X, rect.Min.Y)))
+	return buf.Bytes(), nil
+}
+
+func (solidEncoding) Decode(r io.Reader, rect image.Rectangle, pixelFormat PixelFormat) (*PixelFormatImage, error) {
+	img, err := NewPixelFormatImage(pixelFormat, rect)
+	if err != nil {
+		return nil, err
+	}
+	pixel, err := img.readPixel(r)
+	if err != nil {
+		return nil, err
+	}
+	img.fill(rect, pixel)
+	return img, nil
+}
+
+func TestEncodings(t *testing.T) {
+	custom := NewEncodings(RawEncoding{}, solidEncoding{})
+	r := image.Rect(10, 20, 50, 40)
+	for _, tc := range []struct {
+		encodings    *Encodings
+		encodingType uint32
+		colors       int
+	}{
+		{nil, EncodingTypeRaw, 50},
+		{DefaultEncodings, EncodingTypeRRE, 3},
+		{DefaultEncodings, EncodingTypeCoRRE, 3},
+		{DefaultEncodings, EncodingTypeHextile, 3},
+		{custom, EncodingTypeRaw, 50},
+		{custom, solidEncoding{}.Type(), 1},
+	} {
+		src := randomImage(pixelFormat, r, tc.colors, 1)
+		rect, err := tc.encodings.Encode(tc.encodingType, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		update := FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{rect, rect}}
+		var buf bytes.Buffer
+		if err := update.Write(&buf, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+		var update2 FramebufferUpdateMessage
+		if err := update2.Read(&buf, binary.BigEndian, pixelFormat, tc.encodings); err != nil {
+			t.Fatalf("encoding type %d: %v", tc.encodingType, err)
+		}
+		if len(update2.Rectangles) != 2 {
+			t.Fatalf("encoding type %d: expected 2 rectangles, but got %d", tc.encodingType, len(update2.Rectangles))
+		}
+		dst, err := update2.Rectangles[1].Decode(pixelFormat, tc.encodings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(src.Pix, dst.Pix) {
+			t.Errorf("encoding type %d: decoded pixels differ from encoded pixels", tc.encodingType)
+		}
+	}
+
+	rect, _ := custom.Encode(solidEncoding{}.Type(), randomImage(pixelFormat, r, 1, 1))
+	var buf bytes.Buffer
+	if err := rect.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	var rect2 FramebufferUpdateRect
+	if err := rect2.Read(&buf, binary.BigEndian, pixelFormat, nil); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Errorf("expected ErrUnsupportedEncoding reading an encoding that isn't registered, but got %v", err)
+	}
+}
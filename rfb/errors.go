@@ -0,0 +1,46 @@
+package rfb
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnsupportedEncoding is matched, with errors.Is, by errors for rectangles in an encoding that can't be decoded.
+	ErrUnsupportedEncoding = errors.New("unsupported encoding")
+
+	// ErrUnsupportedVersion is matched by errors for peers that speak a version of the protocol other than 3.x.
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+)
+
+// ProtocolError is returned, possibly wrapped, for data that breaks the protocol, as opposed to an I/O error. Find one with errors.As.
+type ProtocolError struct {
+	// MessageType is the type byte of the message that broke the protocol. It's meaningless during the handshake.
+	MessageType uint8
+
+	// Offset is where the problem was found, in bytes from the start of the message.
+	Offset int
+
+	Reason string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("message type %d, byte %d: %s", e.MessageType, e.Offset, e.Reason)
+}
+
+// protocolErrorf returns a ProtocolError with a formatted reason.
+func protocolErrorf(messageType uint8, offset int, format string, args ...interface{}) error {
+	return &ProtocolError{MessageType: messageType, Offset: offset, Reason: fmt.Sprintf(format, args...)}
+}
+
+// LimitError is returned, possibly wrapped, when a peer declares more data than the Limits allow, before it's allocated. Find one with errors.As.
+type LimitError struct {
+	// What names the quantity that was too large, such as "rectangle pixels".
+	What string
+
+	Size, Max int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s %d exceeds limit of %d", e.What, e.Size, e.Max)
+}
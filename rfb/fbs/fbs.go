@@ -0,0 +1,130 @@
+// Package fbs reads and writes FBS (FrameBuffer Stream) files, the session format of rfbproxy and vncrec. An FBS file holds the bytes that a server sent to a client, from its ProtocolVersion on, in blocks stamped with when they arrived.
+package fbs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Version is the header that Writer writes. Reader also accepts versions 001.001 and 001.002, which have the same block format.
+const Version = "FBS 001.000\n"
+
+// maxBlockLength bounds the blocks that Reader will read.
+const maxBlockLength = 64 << 20
+
+// Block is a run of bytes that the recorded client read at once.
+type Block struct {
+	Data []byte
+
+	// Timestamp is when the block arrived, since the start of the session. It has millisecond precision.
+	Timestamp time.Duration
+}
+
+// Reader reads an FBS file.
+type Reader struct {
+	r     io.Reader
+	block Block
+	data  []byte // The unread part of block.Data
+}
+
+// NewReader reads the header from r and returns a Reader for the blocks that follow.
+func NewReader(r io.Reader) (*Reader, error) {
+	var header [len(Version)]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	switch string(header[:]) {
+	case "FBS 001.000\n", "FBS 001.001\n", "FBS 001.002\n":
+	default:
+		return nil, fmt.Errorf("unsupported header %q", header[:])
+	}
+	return &Reader{r: r}, nil
+}
+
+// ReadBlock returns the next block, or io.EOF after the last one. Reads that were in the middle of a block continue from the next one.
+func (r *Reader) ReadBlock() (Block, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return Block{}, err
+	}
+	length := binary.BigEndian.Uint32(buf[:])
+	if length > maxBlockLength {
+		return Block{}, fmt.Errorf("block length %d exceeds maximum of %d", length, maxBlockLength)
+	}
+	// Data is padded to a multiple of 4 bytes.
+	data := make([]byte, (length+3)&^3)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return Block{}, unexpectedEOF(err)
+	}
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return Block{}, unexpectedEOF(err)
+	}
+	r.block = Block{Data: data[:length], Timestamp: time.Duration(binary.BigEndian.Uint32(buf[:])) * time.Millisecond}
+	r.data = nil
+	return r.block, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Read reads the recorded bytes as one stream, across blocks, so that a session can be decoded with the rfb package.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		block, err := r.ReadBlock()
+		if err != nil {
+			return 0, err
+		}
+		r.data = block.Data
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// Timestamp returns the timestamp of the block that the last Read or ReadBlock came from.
+func (r *Reader) Timestamp() time.Duration {
+	return r.block.Timestamp
+}
+
+// Writer writes an FBS file.
+type Writer struct {
+	w     io.Writer
+	start time.Time
+}
+
+// NewWriter writes the header to w and returns a Writer for blocks. Blocks written with Write are stamped with the time since NewWriter was called.
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := io.WriteString(w, Version); err != nil {
+		return nil, fmt.Errorf("write header: %w", err)
+	}
+	return &Writer{w: w, start: time.Now()}, nil
+}
+
+// Write writes p as a block that arrived now, so that a Writer can record a connection, as with io.TeeReader.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.WriteBlock(Block{Data: p, Timestamp: time.Since(w.start)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteBlock writes block, whose Timestamp is truncated to milliseconds.
+func (w *Writer) WriteBlock(block Block) error {
+	if len(block.Data) > maxBlockLength {
+		return fmt.Errorf("block length %d exceeds maximum of %d", len(block.Data), maxBlockLength)
+	}
+	padded := (len(block.Data) + 3) &^ 3
+	buf := make([]byte, 4+padded+4)
+	binary.BigEndian.PutUint32(buf, uint32(len(block.Data)))
+	copy(buf[4:], block.Data)
+	binary.BigEndian.PutUint32(buf[4+padded:], uint32(block.Timestamp/time.Millisecond))
+	_, err := w.w.Write(buf)
+	return err
+}
@@ -0,0 +1,94 @@
+package fbs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/alltom/vncfreethumb/rfb"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	blocks := []Block{
+		{Data: []byte("RFB 003.008\n"), Timestamp: 0},
+		{Data: []byte{1, 1}, Timestamp: 15 * time.Millisecond},
+		{Data: []byte{0, 0, 0, 0, 0}, Timestamp: 2 * time.Second},
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks {
+		if err := w.WriteBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wire := []byte("FBS 001.000\n\x00\x00\x00\x0cRFB 003.008\n\x00\x00\x00\x00\x00\x00\x00\x02\x01\x01\x00\x00\x00\x00\x00\x0f")
+	if !bytes.HasPrefix(buf.Bytes(), wire) {
+		t.Errorf("expected the file to start with %q, but got %q", wire, buf.Bytes())
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Block
+	for {
+		block, err := r.ReadBlock()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, block)
+	}
+	if !reflect.DeepEqual(got, blocks) {
+		t.Errorf("expected %v, but got %v", blocks, got)
+	}
+
+	if r, err = NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-2])); err != nil {
+		t.Fatal(err)
+	}
+	r.ReadBlock()
+	r.ReadBlock()
+	if _, err := r.ReadBlock(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for a truncated block, but got %v", err)
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	// A recorded handshake decodes with the rfb package, across block boundaries.
+	var session bytes.Buffer
+	(&rfb.ProtocolVersionMessage{Major: 3, Minor: 8}).Write(&session)
+	(&rfb.KeyEventMessage{Pressed: true, KeySym: 'a'}).Write(&session, binary.BigEndian)
+	var file bytes.Buffer
+	w, _ := NewWriter(&file)
+	for idx, b := range session.Bytes() {
+		if err := w.WriteBlock(Block{Data: []byte{b}, Timestamp: time.Duration(idx) * time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewReader(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version rfb.ProtocolVersionMessage
+	if err := version.Read(r); err != nil {
+		t.Fatal(err)
+	}
+	if version != (rfb.ProtocolVersionMessage{Major: 3, Minor: 8}) {
+		t.Errorf("expected version 3.8, but got %v", &version)
+	}
+	if r.Timestamp() != 11*time.Millisecond {
+		t.Errorf("expected the last byte read to be from 11ms, but got %s", r.Timestamp())
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil || len(rest) != 8 {
+		t.Errorf("expected the KeyEvent's 8 bytes, but got %v, %v", rest, err)
+	}
+}
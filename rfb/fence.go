@@ -0,0 +1,67 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// EncodingTypeFence is the Fence pseudo-encoding, -312. Servers reply to clients that list it with a FenceMessage request, after which either side may send FenceMessage.
+const EncodingTypeFence = uint32(0xfffffec8)
+
+// FenceMessage flags.
+const (
+	// FenceBlockBefore asks that messages before the fence be fully processed before it is.
+	FenceBlockBefore = uint32(1 << 0)
+
+	// FenceBlockAfter asks that messages after the fence not be processed until it has been.
+	FenceBlockAfter = uint32(1 << 1)
+
+	// FenceSyncNext asks that the response wait until the message after the fence has been processed.
+	FenceSyncNext = uint32(1 << 2)
+
+	// FenceRequest marks a fence that the other side must send back, with the same data and the flags it supports.
+	FenceRequest = uint32(1 << 31)
+)
+
+const maxFenceDataLength = 64
+
+// FenceMessage synchronizes the message streams. It has the same format in both directions: clients send ClientFence and servers send ServerFence.
+type FenceMessage struct {
+	Flags uint32
+	Data  []byte
+}
+
+func (m *FenceMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [9]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	if buf[0] != 248 {
+		return protocolErrorf(buf[0], 0, "expected message type 248")
+	}
+	m.Flags = bo.Uint32(buf[4:])
+	if buf[8] > maxFenceDataLength {
+		return protocolErrorf(buf[0], 8, "fence data length %d exceeds maximum of %d", buf[8], maxFenceDataLength)
+	}
+	m.Data = make([]byte, buf[8])
+	if _, err := io.ReadFull(r, m.Data); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *FenceMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if len(m.Data) > maxFenceDataLength {
+		return fmt.Errorf("fence data length %d exceeds maximum of %d", len(m.Data), maxFenceDataLength)
+	}
+	buf := make([]byte, 9, 9+len(m.Data))
+	buf[0] = 248
+	bo.PutUint32(buf[4:], m.Flags)
+	buf[8] = uint8(len(m.Data))
+	buf = append(buf, m.Data...)
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	return nil
+}
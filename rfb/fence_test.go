@@ -0,0 +1,27 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestFenceMessageRoundTrip(t *testing.T) {
+	m := FenceMessage{Flags: FenceRequest | FenceBlockBefore, Data: []byte("hello")}
+	var buf bytes.Buffer
+	if err := m.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	var m2 FenceMessage
+	if err := m2.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if m2.Flags != m.Flags || !bytes.Equal(m2.Data, m.Data) {
+		t.Errorf("expected %+v, but got %+v", m, m2)
+	}
+
+	m.Data = make([]byte, maxFenceDataLength+1)
+	if err := m.Write(&buf, binary.BigEndian); err == nil {
+		t.Errorf("expected an error writing too much fence data")
+	}
+}
@@ -0,0 +1,276 @@
+package rfb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// FileTransferMessage content types, from UltraVNC's file transfer protocol.
+const (
+	FileTransferDirContentRequest = uint8(1)  // Client lists a directory or the drives.
+	FileTransferDirPacket         = uint8(2)  // Server describes a directory, a file, or the drives.
+	FileTransferRequest           = uint8(3)  // Client asks to download a file.
+	FileTransferFileHeader        = uint8(4)  // Server starts a download.
+	FileTransferFilePacket        = uint8(5)  // Either side sends a chunk of a file.
+	FileTransferEndOfFile         = uint8(6)  // Either side finishes a file.
+	FileTransferAbort             = uint8(7)  // Either side abandons a transfer.
+	FileTransferOffer             = uint8(8)  // Client asks to upload a file.
+	FileTransferAcceptHeader      = uint8(9)  // Server accepts or rejects an upload.
+	FileTransferCommand           = uint8(10) // Client asks to create or delete a file or directory.
+	FileTransferCommandReturn     = uint8(11) // Server answers a command.
+	FileTransferAccess            = uint8(14) // Client asks whether file transfer is allowed.
+)
+
+// FileTransferMessage content params.
+const (
+	FileTransferDirContent = uint8(1) // With FileTransferDirContentRequest.
+	FileTransferDrivesList = uint8(2) // With FileTransferDirContentRequest.
+
+	FileTransferDirectory  = uint8(1) // With FileTransferDirPacket: the directory being listed.
+	FileTransferFile       = uint8(2) // With FileTransferDirPacket: an entry in the directory.
+	FileTransferDrives     = uint8(3) // With FileTransferDirPacket: the list of drives.
+	FileTransferDirCreated = uint8(4) // With FileTransferCommandReturn.
+)
+
+// fileTransferFailed is the Size of replies that report failure.
+const fileTransferFailed = ^uint32(0)
+
+// fileTransferChunkSize is the size of the file chunks that the server sends, which is what UltraVNC uses.
+const fileTransferChunkSize = 8192
+
+// maxFileTransferLength bounds the data in a FileTransferMessage.
+const maxFileTransferLength = 1 << 20
+
+// FileTransferMessage is UltraVNC's file transfer message, type 7. It has the same format in both directions.
+type FileTransferMessage struct {
+	ContentType  uint8
+	ContentParam uint8
+	Size         uint32 // File size, error indication, or compression flag, depending on ContentType.
+	Data         []byte
+}
+
+func (m *FileTransferMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [12]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	if buf[0] != 7 {
+		return protocolErrorf(buf[0], 0, "expected message type 7")
+	}
+	m.ContentType = buf[1]
+	m.ContentParam = buf[2]
+	m.Size = bo.Uint32(buf[4:])
+	length := bo.Uint32(buf[8:])
+	if length > maxFileTransferLength {
+		return protocolErrorf(buf[0], 8, "file transfer data length %d exceeds maximum of %d", length, maxFileTransferLength)
+	}
+	m.Data = make([]byte, length)
+	if _, err := io.ReadFull(r, m.Data); err != nil {
+		return fmt.Errorf("read data: %w", err)
+	}
+	return nil
+}
+
+func (m *FileTransferMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if len(m.Data) > maxFileTransferLength {
+		return fmt.Errorf("file transfer data length %d exceeds maximum of %d", len(m.Data), maxFileTransferLength)
+	}
+	buf := make([]byte, 12, 12+len(m.Data))
+	buf[0] = 7
+	buf[1] = m.ContentType
+	buf[2] = m.ContentParam
+	bo.PutUint32(buf[4:], m.Size)
+	bo.PutUint32(buf[8:], uint32(len(m.Data)))
+	_, err := w.Write(append(buf, m.Data...))
+	return err
+}
+
+// FileHandler provides the files that a FileTransfer offers. They are in a single directory, which is presented to clients as the root of drive C.
+type FileHandler interface {
+	ReadDir() ([]os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+
+	// Create returns a writer for an uploaded file, which is closed when the upload ends. It must fail if the file already exists, since the file transfer protocol has no way for a client to ask to replace one. If it returns an error, the upload is rejected.
+	Create(name string) (io.WriteCloser, error)
+}
+
+// FileTransfer serves one client's file transfer requests.
+type FileTransfer struct {
+	Handler FileHandler
+
+	upload io.WriteCloser
+}
+
+// Handle replies to m, which was sent by the client, using send.
+func (ft *FileTransfer) Handle(m *FileTransferMessage, send func(*FileTransferMessage) error) error {
+	switch m.ContentType {
+	case FileTransferAccess:
+		return send(&FileTransferMessage{ContentType: FileTransferAccess, Size: 1})
+
+	case FileTransferDirContentRequest:
+		if m.ContentParam == FileTransferDrivesList {
+			return send(&FileTransferMessage{ContentType: FileTransferDirPacket, ContentParam: FileTransferDrives, Data: []byte("C:l\x00")})
+		}
+		return ft.list(string(bytes.TrimRight(m.Data, "\x00")), send)
+
+	case FileTransferRequest:
+		return ft.download(string(bytes.TrimRight(m.Data, "\x00")), send)
+
+	case FileTransferOffer:
+		ft.Close()
+		// The offer is "path,date".
+		path := string(bytes.TrimRight(m.Data, "\x00"))
+		if i := strings.LastIndexByte(path, ','); i >= 0 {
+			path = path[:i]
+		}
+		w, err := ft.Handler.Create(baseName(path))
+		if err != nil {
+			return send(&FileTransferMessage{ContentType: FileTransferAcceptHeader, Size: fileTransferFailed, Data: []byte(path)})
+		}
+		ft.upload = w
+		return send(&FileTransferMessage{ContentType: FileTransferAcceptHeader, Data: []byte(path)})
+
+	case FileTransferFilePacket:
+		if ft.upload == nil {
+			return nil
+		}
+		data := m.Data
+		if m.Size != 0 {
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				ft.Close()
+				return send(&FileTransferMessage{ContentType: FileTransferAbort})
+			}
+			data, err = ioutil.ReadAll(io.LimitReader(zr, maxFileTransferLength+1))
+			if err != nil || len(data) > maxFileTransferLength {
+				ft.Close()
+				return send(&FileTransferMessage{ContentType: FileTransferAbort})
+			}
+		}
+		if _, err := ft.upload.Write(data); err != nil {
+			ft.Close()
+			return send(&FileTransferMessage{ContentType: FileTransferAbort})
+		}
+		return nil
+
+	case FileTransferEndOfFile, FileTransferAbort:
+		return ft.Close()
+
+	case FileTransferCommand:
+		// Directories can't be created or files deleted.
+		return send(&FileTransferMessage{ContentType: FileTransferCommandReturn, ContentParam: FileTransferDirCreated, Size: fileTransferFailed, Data: m.Data})
+	}
+	return nil
+}
+
+// Close ends any upload in progress.
+func (ft *FileTransfer) Close() error {
+	if ft.upload == nil {
+		return nil
+	}
+	err := ft.upload.Close()
+	ft.upload = nil
+	return err
+}
+
+func (ft *FileTransfer) list(dir string, send func(*FileTransferMessage) error) error {
+	var infos []os.FileInfo
+	if isRoot(dir) {
+		// An unreadable directory is listed as empty.
+		infos, _ = ft.Handler.ReadDir()
+	}
+	if err := send(&FileTransferMessage{ContentType: FileTransferDirPacket, ContentParam: FileTransferDirectory, Data: []byte(dir)}); err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if err := send(&FileTransferMessage{ContentType: FileTransferDirPacket, ContentParam: FileTransferFile, Data: findData(info)}); err != nil {
+			return err
+		}
+	}
+	// An empty directory packet ends the listing.
+	return send(&FileTransferMessage{ContentType: FileTransferDirPacket, ContentParam: FileTransferDirectory})
+}
+
+func (ft *FileTransfer) download(path string, send func(*FileTransferMessage) error) error {
+	fail := func() error {
+		return send(&FileTransferMessage{ContentType: FileTransferFileHeader, Size: fileTransferFailed, Data: []byte(path)})
+	}
+	name := baseName(path)
+	info, err := ft.Handler.Stat(name)
+	if err != nil || !info.Mode().IsRegular() || info.Size() >= int64(fileTransferFailed) {
+		return fail()
+	}
+	f, err := ft.Handler.Open(name)
+	if err != nil {
+		return fail()
+	}
+	defer f.Close()
+	if err := send(&FileTransferMessage{ContentType: FileTransferFileHeader, Size: uint32(info.Size()), Data: []byte(path)}); err != nil {
+		return err
+	}
+	// The file is read a chunk at a time as it's sent, so however big it is, only a chunk of it is held.
+	for left := info.Size(); left > 0; {
+		n := int64(fileTransferChunkSize)
+		if left < n {
+			n = left
+		}
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			// The file can't be read to the size the header promised, so the download is abandoned.
+			return send(&FileTransferMessage{ContentType: FileTransferAbort})
+		}
+		if err := send(&FileTransferMessage{ContentType: FileTransferFilePacket, Data: chunk}); err != nil {
+			return err
+		}
+		left -= n
+	}
+	return send(&FileTransferMessage{ContentType: FileTransferEndOfFile})
+}
+
+// isRoot reports whether dir, a Windows path from the client, is the root of drive C.
+func isRoot(dir string) bool {
+	dir = strings.TrimRight(dir, `\/`)
+	return dir == "" || strings.EqualFold(dir, "C:")
+}
+
+// baseName returns the last element of a Windows or Unix path.
+func baseName(path string) string {
+	if i := strings.LastIndexAny(path, `\/`); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+// findData returns info as a Windows WIN32_FIND_DATAA structure, which is how file transfer describes directory entries.
+func findData(info os.FileInfo) []byte {
+	const nameLength = 260
+	buf := make([]byte, 44+nameLength+14)
+	binary.LittleEndian.PutUint32(buf[0:], 0x80) // FILE_ATTRIBUTE_NORMAL
+	t := fileTime(info.ModTime())
+	for _, off := range []int{4, 12, 20} {
+		binary.LittleEndian.PutUint64(buf[off:], t)
+	}
+	size := uint64(info.Size())
+	binary.LittleEndian.PutUint32(buf[28:], uint32(size>>32))
+	binary.LittleEndian.PutUint32(buf[32:], uint32(size))
+	// The name field is NUL-terminated.
+	copy(buf[44:44+nameLength-1], info.Name())
+	return buf
+}
+
+// fileTime returns t as a Windows FILETIME, the number of 100 ns intervals since 1601.
+func fileTime(t time.Time) uint64 {
+	const epochDelta = 116444736000000000 // 1601 to 1970
+	return uint64(t.UnixNano()/100) + epochDelta
+}
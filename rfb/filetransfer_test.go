@@ -0,0 +1,154 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type memoryFiles map[string]*bytes.Buffer
+
+func (files memoryFiles) ReadDir() ([]os.FileInfo, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (files memoryFiles) Stat(name string) (os.FileInfo, error) {
+	buf, ok := files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return memoryFileInfo{name, int64(buf.Len())}, nil
+}
+
+// memoryFileRead counts the bytes read from memoryFiles.
+var memoryFileRead int
+
+type countingReader struct{ io.Reader }
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	memoryFileRead += n
+	return n, err
+}
+
+func (files memoryFiles) Open(name string) (io.ReadCloser, error) {
+	buf, ok := files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(countingReader{bytes.NewReader(buf.Bytes())}), nil
+}
+
+type memoryFileInfo struct {
+	name string
+	size int64
+}
+
+func (info memoryFileInfo) Name() string       { return info.name }
+func (info memoryFileInfo) Size() int64        { return info.size }
+func (info memoryFileInfo) Mode() os.FileMode  { return 0666 }
+func (info memoryFileInfo) ModTime() time.Time { return time.Time{} }
+func (info memoryFileInfo) IsDir() bool        { return false }
+func (info memoryFileInfo) Sys() interface{}   { return nil }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func (files memoryFiles) Create(name string) (io.WriteCloser, error) {
+	if _, ok := files[name]; ok {
+		return nil, os.ErrExist
+	}
+	files[name] = new(bytes.Buffer)
+	return nopWriteCloser{files[name]}, nil
+}
+
+func TestFileTransfer(t *testing.T) {
+	content := bytes.Repeat([]byte("image data "), 2000)
+	files := memoryFiles{"a.png": bytes.NewBuffer(content)}
+	ft := FileTransfer{Handler: files}
+
+	// Every reply goes over the wire, to check the message format too.
+	var wire bytes.Buffer
+	send := func(m *FileTransferMessage) error {
+		// The header's size comes from Stat, not from reading the whole file first.
+		if m.ContentType == FileTransferFileHeader && m.Size != fileTransferFailed && memoryFileRead != 0 {
+			t.Errorf("expected the file header before the file is read, but %d bytes were read first", memoryFileRead)
+		}
+		return m.Write(&wire, binary.BigEndian)
+	}
+	var replies []FileTransferMessage
+	receive := func() {
+		for wire.Len() > 0 {
+			var m FileTransferMessage
+			if err := m.Read(&wire, binary.BigEndian); err != nil {
+				t.Fatal(err)
+			}
+			replies = append(replies, m)
+		}
+	}
+
+	if err := ft.Handle(&FileTransferMessage{ContentType: FileTransferRequest, Data: []byte(`C:\a.png`)}, send); err != nil {
+		t.Fatal(err)
+	}
+	receive()
+	if len(replies) < 3 || replies[0].ContentType != FileTransferFileHeader || replies[0].Size != uint32(len(content)) || replies[len(replies)-1].ContentType != FileTransferEndOfFile {
+		t.Fatalf("expected a file header, packets, and end of file, but got %d replies starting with %+v", len(replies), replies[0])
+	}
+	var downloaded []byte
+	for _, m := range replies[1 : len(replies)-1] {
+		if len(m.Data) > fileTransferChunkSize {
+			t.Errorf("expected packets of at most %d bytes, but got %d", fileTransferChunkSize, len(m.Data))
+		}
+		downloaded = append(downloaded, m.Data...)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("downloaded %d bytes that don't match the %d in the file", len(downloaded), len(content))
+	}
+
+	replies = nil
+	if err := ft.Handle(&FileTransferMessage{ContentType: FileTransferRequest, Data: []byte(`C:\missing.png`)}, send); err != nil {
+		t.Fatal(err)
+	}
+	receive()
+	if len(replies) != 1 || replies[0].Size != fileTransferFailed {
+		t.Errorf("expected one failed file header for a missing file, but got %+v", replies)
+	}
+
+	replies = nil
+	for _, m := range []*FileTransferMessage{
+		{ContentType: FileTransferOffer, Size: 5, Data: []byte(`C:\b.png,01/02/2020 03:04`)},
+		{ContentType: FileTransferFilePacket, Data: []byte("hel")},
+		{ContentType: FileTransferFilePacket, Data: []byte("lo")},
+		{ContentType: FileTransferEndOfFile},
+	} {
+		if err := ft.Handle(m, send); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive()
+	if len(replies) != 1 || replies[0].ContentType != FileTransferAcceptHeader || replies[0].Size != 0 {
+		t.Errorf("expected the upload to be accepted, but got %+v", replies)
+	}
+	if got := files["b.png"].String(); got != "hello" {
+		t.Errorf("expected upload to contain %q, but got %q", "hello", got)
+	}
+
+	// An upload doesn't replace a file that's already there.
+	replies = nil
+	if err := ft.Handle(&FileTransferMessage{ContentType: FileTransferOffer, Size: 5, Data: []byte(`C:\a.png,01/02/2020 03:04`)}, send); err != nil {
+		t.Fatal(err)
+	}
+	receive()
+	if len(replies) != 1 || replies[0].ContentType != FileTransferAcceptHeader || replies[0].Size != fileTransferFailed {
+		t.Errorf("expected the upload over a.png to be rejected, but got %+v", replies)
+	}
+	if !bytes.Equal(files["a.png"].Bytes(), content) {
+		t.Error("expected a.png to be left as it was")
+	}
+}
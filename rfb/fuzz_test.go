@@ -0,0 +1,133 @@
+//go:build go1.18
+// +build go1.18
+
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// The fuzz targets check that no input makes a Read method panic. Run one with, for example:
+//
+//	go test ./rfb -run '^$' -fuzz FuzzReadClientMessage
+
+func fuzzSeeds(f *testing.F, messages ...interface{ MessageType() uint8 }) {
+	for _, m := range messages {
+		var buf bytes.Buffer
+		if err := writeMessage(m, &buf, binary.BigEndian); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+}
+
+func FuzzReadClientMessage(f *testing.F) {
+	fuzzSeeds(f,
+		&SetPixelFormatMessage{PixelFormat: pixelFormat},
+		&FixColourMapEntriesMessage{FirstColour: 1, Colours: []color.RGBA64{{1, 2, 3, 0xffff}}},
+		&SetEncodingsMessage{EncodingTypes: []uint32{EncodingTypeRaw, EncodingTypeTight, EncodingTypeFence}},
+		&FramebufferUpdateRequestMessage{Incremental: true, Width: 10, Height: 10},
+		&KeyEventMessage{Pressed: true, KeySym: 'a'},
+		&PointerEventMessage{ButtonMask: 1, X: 2, Y: 3},
+		&ClientCutTextMessage{Text: "text"},
+		&ClientCutTextMessage{Extended: NewClipboardProvideText("text")},
+		&FileTransferMessage{ContentType: 1, Data: []byte("C:")},
+		&EnableContinuousUpdatesMessage{Enable: true, Width: 10, Height: 10},
+		&FenceMessage{Flags: FenceRequest, Data: []byte{1, 2}},
+		&XVPMessage{Version: XVPVersion, Code: XVPReboot},
+		&SetDesktopSizeMessage{Width: 10, Height: 10, Screens: []Screen{{Width: 10, Height: 10}}},
+	)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		for {
+			if _, err := ReadClientMessage(r, binary.BigEndian); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func FuzzReadServerMessage(f *testing.F) {
+	img, _ := NewPixelFormatImage(pixelFormat, image.Rect(0, 0, 4, 4))
+	var rects []*FramebufferUpdateRect
+	for _, encodingType := range []uint32{EncodingTypeRaw, EncodingTypeRRE, EncodingTypeCoRRE, EncodingTypeHextile} {
+		rect, err := DefaultEncodings.Encode(encodingType, img)
+		if err != nil {
+			f.Fatal(err)
+		}
+		rects = append(rects, rect)
+	}
+	tight, err := (&TightEncoder{}).Encode(img)
+	if err != nil {
+		f.Fatal(err)
+	}
+	cursor, err := NewCursorRect(image.NewNRGBA(image.Rect(0, 0, 2, 2)), image.Point{}, pixelFormat)
+	if err != nil {
+		f.Fatal(err)
+	}
+	rects = append(rects, tight...)
+	rects = append(rects, cursor, NewXCursorRect(image.NewNRGBA(image.Rect(0, 0, 2, 2)), image.Point{}))
+	fuzzSeeds(f,
+		&FramebufferUpdateMessage{Rectangles: rects},
+		&SetColourMapEntriesMessage{FirstColour: 1, Colours: []color.RGBA64{{1, 2, 3, 0xffff}}},
+		&BellMessage{},
+		&ServerCutTextMessage{Text: "text"},
+		&ServerCutTextMessage{Extended: &ExtendedClipboard{Flags: ClipboardCaps | ClipboardText, Sizes: []uint32{1 << 20}}},
+		&EndOfContinuousUpdatesMessage{},
+	)
+	// Small limits keep each input quick to run.
+	encodings := NewEncodings(RawEncoding{}, RREEncoding{}, CoRREEncoding{}, HextileEncoding{})
+	encodings.SetLimits(Limits{MaxRectPixels: 1 << 16, MaxUpdateBytes: 1 << 20})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		for {
+			m, err := ReadServerMessage(r, binary.BigEndian, pixelFormat, encodings)
+			if err != nil {
+				return
+			}
+			// Decoding is where rectangles are interpreted, so it should survive anything that reads.
+			if m, ok := m.(*FramebufferUpdateMessage); ok {
+				var tight TightDecoder
+				for _, rect := range m.Rectangles {
+					if rect.EncodingType == EncodingTypeTight {
+						tight.Decode(rect, pixelFormat)
+					} else {
+						rect.Decode(pixelFormat, encodings)
+					}
+				}
+			}
+		}
+	})
+}
+
+// FuzzReadHandshake fuzzes the handshake messages, with the first byte choosing which to read.
+func FuzzReadHandshake(f *testing.F) {
+	bo := binary.BigEndian
+	reads := []func([]byte) error{
+		func(b []byte) error { var m ProtocolVersionMessage; return m.Read(bytes.NewReader(b)) },
+		func(b []byte) error { var m AuthenticationSchemeMessageRFB33; return m.Read(bytes.NewReader(b), bo) },
+		func(b []byte) error { var m SecurityTypesMessageRFB37; return m.Read(bytes.NewReader(b), bo) },
+		func(b []byte) error { var m SecurityResultMessageRFB38; return m.Read(bytes.NewReader(b), bo) },
+		func(b []byte) error { var m ClientInitialisationMessage; return m.Read(bytes.NewReader(b)) },
+		func(b []byte) error { var m ServerInitialisationMessage; return m.Read(bytes.NewReader(b), bo) },
+	}
+	f.Add([]byte("\x00RFB 003.008\n"))
+	f.Add([]byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 'n', 'o'})
+	f.Add([]byte{2, 2, 1, 2})
+	f.Add([]byte{3, 0, 0, 0, 1, 0, 0, 0, 3, 'b', 'a', 'd'})
+	f.Add([]byte{4, 1})
+	var serverInit bytes.Buffer
+	serverInit.WriteByte(5)
+	(&ServerInitialisationMessage{FramebufferWidth: 10, FramebufferHeight: 10, PixelFormat: pixelFormat, Name: "test"}).Write(&serverInit, bo)
+	f.Add(serverInit.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		reads[int(data[0])%len(reads)](data[1:])
+	})
+}
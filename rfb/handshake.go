@@ -0,0 +1,317 @@
+package rfb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ServerHandshakeOptions configures ServerHandshake.
+type ServerHandshakeOptions struct {
+	// Security authenticates clients. If nil, only SecurityTypeNone is offered.
+	Security *SecurityHandlers
+
+	// Init returns the ServerInitialisation to send once the client has authenticated and sent clientInit, so servers can set up a framebuffer only for clients that get that far.
+	Init func(clientInit ClientInitialisationMessage) (ServerInitialisationMessage, error)
+
+	// Hooks observes each phase of the handshake. If nil, NopHooks is used.
+	Hooks Hooks
+}
+
+// ServerHandshakeResult is the state negotiated by ServerHandshake.
+type ServerHandshakeResult struct {
+	// Conn is the connection to use for the rest of the session, which the security type may have wrapped, as with TLS.
+	Conn net.Conn
+
+	// ProtocolVersion is the version that the session uses, which may be older than the client's.
+	ProtocolVersion ProtocolVersionMessage
+
+	// Shared is the client's request to share the desktop with other clients.
+	Shared bool
+
+	// ViewOnly is whether the client authenticated as one that may only watch, as marked by ViewOnly.
+	ViewOnly bool
+
+	// ServerInit is what was sent to the client, including the initial PixelFormat.
+	ServerInit ServerInitialisationMessage
+}
+
+// ServerHandshake runs the server side of the handshake on conn, from ProtocolVersion through ServerInitialisation, offering version 3.8 and accepting 3.3 and 3.7. It gives up when ctx ends, as in WithContext.
+func ServerHandshake(ctx context.Context, conn net.Conn, opts ServerHandshakeOptions) (*ServerHandshakeResult, error) {
+	var result *ServerHandshakeResult
+	err := WithContext(ctx, conn, func() error {
+		var err error
+		result, err = serverHandshake(ctx, conn, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func serverHandshake(ctx context.Context, conn net.Conn, opts ServerHandshakeOptions) (*ServerHandshakeResult, error) {
+	bo := binary.BigEndian
+	hooks := opts.Hooks
+	if hooks == nil {
+		hooks = NopHooks{}
+	}
+	security := opts.Security
+	if security == nil {
+		security = &SecurityHandlers{}
+		security.Register(NoneSecurityHandler{})
+	}
+	phase := func(name string, f func() error) error {
+		end := hooks.HandshakePhase(ctx, name)
+		err := f()
+		end(err)
+		return err
+	}
+	result := &ServerHandshakeResult{Conn: conn, ProtocolVersion: ProtocolVersionMessage{Major: 3, Minor: 8}}
+
+	if err := phase("ProtocolVersion", func() error {
+		version := &result.ProtocolVersion
+		if err := version.Write(conn); err != nil {
+			return fmt.Errorf("write ProtocolVersion: %w", err)
+		}
+		if err := version.Read(conn); err != nil {
+			return fmt.Errorf("read ProtocolVersion: %w", err)
+		}
+		if version.Major != 3 {
+			return fmt.Errorf("%w: only version 3 is supported, but client requested %d.%d", ErrUnsupportedVersion, version.Major, version.Minor)
+		}
+		// Per the spec, unknown minor versions are treated as 3.3, except later versions, which are treated as the latest version this server supports.
+		switch {
+		case version.Minor >= 8:
+			version.Minor = 8
+		case version.Minor == 7:
+		default:
+			version.Minor = 3
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := phase("Authentication", func() error {
+		var err error
+		result.Conn, err = security.Serve(conn, result.ProtocolVersion, bo)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	result.ViewOnly = IsViewOnly(result.Conn)
+
+	var clientInit ClientInitialisationMessage
+	if err := phase("ClientInitialisation", func() error {
+		if err := clientInit.Read(result.Conn); err != nil {
+			return fmt.Errorf("read ClientInitialisation: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	result.Shared = clientInit.Shared
+
+	if err := phase("ServerInitialisation", func() error {
+		var err error
+		if result.ServerInit, err = opts.Init(clientInit); err != nil {
+			return err
+		}
+		if err := result.ServerInit.Write(result.Conn, bo); err != nil {
+			return fmt.Errorf("write ServerInitialisation: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ClientHandshakeOptions configures ClientHandshake.
+type ClientHandshakeOptions struct {
+	// Password is called for the password if the server requires VNC authentication. If nil, only servers that offer SecurityTypeNone can be used.
+	Password func() (string, error)
+
+	// Shared asks the server to leave other clients connected.
+	Shared bool
+
+	// Hooks observes each phase of the handshake. If nil, NopHooks is used.
+	Hooks Hooks
+}
+
+// ClientHandshakeResult is the state negotiated by ClientHandshake.
+type ClientHandshakeResult struct {
+	// ProtocolVersion is the version that the session uses, which may be older than the server's.
+	ProtocolVersion ProtocolVersionMessage
+
+	// SecurityType is the security type that authenticated the client.
+	SecurityType SecurityType
+
+	// ServerInit describes the framebuffer, including the PixelFormat that the server will use until the client sends SetPixelFormat.
+	ServerInit ServerInitialisationMessage
+}
+
+// ClientHandshake runs the client side of the handshake on conn, from ProtocolVersion through ServerInitialisation. It supports versions 3.3, 3.7, and 3.8, and SecurityTypeNone and SecurityTypeVNC, preferring SecurityTypeNone. If the server rejects the client, the error is a *SecurityFailure. It gives up when ctx ends, as in WithContext.
+func ClientHandshake(ctx context.Context, conn net.Conn, opts ClientHandshakeOptions) (*ClientHandshakeResult, error) {
+	var result *ClientHandshakeResult
+	err := WithContext(ctx, conn, func() error {
+		var err error
+		result, err = clientHandshake(ctx, conn, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func clientHandshake(ctx context.Context, conn net.Conn, opts ClientHandshakeOptions) (*ClientHandshakeResult, error) {
+	bo := binary.BigEndian
+	hooks := opts.Hooks
+	if hooks == nil {
+		hooks = NopHooks{}
+	}
+	phase := func(name string, f func() error) error {
+		end := hooks.HandshakePhase(ctx, name)
+		err := f()
+		end(err)
+		return err
+	}
+	result := &ClientHandshakeResult{}
+
+	if err := phase("ProtocolVersion", func() error {
+		version := &result.ProtocolVersion
+		if err := version.Read(conn); err != nil {
+			return fmt.Errorf("read ProtocolVersion: %w", err)
+		}
+		if version.Major != 3 {
+			return fmt.Errorf("%w: only version 3 is supported, but server offered %d.%d", ErrUnsupportedVersion, version.Major, version.Minor)
+		}
+		switch {
+		case version.Minor >= 8:
+			version.Minor = 8
+		case version.Minor == 7:
+		default:
+			version.Minor = 3
+		}
+		if err := version.Write(conn); err != nil {
+			return fmt.Errorf("write ProtocolVersion: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := phase("Authentication", func() error {
+		var err error
+		result.SecurityType, err = clientSecurity(conn, result.ProtocolVersion, bo, opts.Password)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := phase("ClientInitialisation", func() error {
+		clientInit := ClientInitialisationMessage{Shared: opts.Shared}
+		if err := clientInit.Write(conn); err != nil {
+			return fmt.Errorf("write ClientInitialisation: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := phase("ServerInitialisation", func() error {
+		if err := result.ServerInit.Read(conn, bo); err != nil {
+			return fmt.Errorf("read ServerInitialisation: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// clientSecurity runs the client side of security negotiation, through the security result, and returns the security type used.
+func clientSecurity(conn net.Conn, version ProtocolVersionMessage, bo binary.ByteOrder, password func() (string, error)) (SecurityType, error) {
+	var selected SecurityType
+	if version.Minor < 7 {
+		var m AuthenticationSchemeMessageRFB33
+		if err := m.Read(conn, bo); err != nil {
+			return 0, fmt.Errorf("read AuthenticationScheme: %w", err)
+		}
+		switch m.Scheme {
+		case AuthenticationSchemeInvalid:
+			return 0, &SecurityFailure{m.Reason}
+		case AuthenticationSchemeNone:
+			return SecurityTypeNone, nil
+		case AuthenticationSchemeVNC:
+			if password == nil {
+				return 0, errors.New("server requires VNC authentication, but no password is available")
+			}
+			selected = SecurityTypeVNC
+		default:
+			return 0, fmt.Errorf("unsupported authentication scheme %d", m.Scheme)
+		}
+	} else {
+		var m SecurityTypesMessageRFB37
+		if err := m.Read(conn, bo); err != nil {
+			return 0, fmt.Errorf("read SecurityTypes: %w", err)
+		}
+		if len(m.Types) == 0 {
+			return 0, &SecurityFailure{m.Reason}
+		}
+		for _, t := range m.Types {
+			if t == SecurityTypeNone || (t == SecurityTypeVNC && password != nil && selected != SecurityTypeNone) {
+				selected = t
+			}
+		}
+		if selected == SecurityTypeInvalid {
+			return 0, fmt.Errorf("none of the server's security types %v are supported", m.Types)
+		}
+		selection := SecurityTypeSelectionMessageRFB37{Type: selected}
+		if err := selection.Write(conn); err != nil {
+			return 0, fmt.Errorf("write SecurityTypeSelection: %w", err)
+		}
+	}
+
+	if selected == SecurityTypeVNC {
+		pw, err := password()
+		if err != nil {
+			return 0, fmt.Errorf("get password: %w", err)
+		}
+		var challenge VNCAuthenticationChallengeMessage
+		if err := challenge.Read(conn); err != nil {
+			return 0, fmt.Errorf("read VNC auth challenge: %w", err)
+		}
+		response, err := NewVNCAuthenticationResponse(challenge, pw)
+		if err != nil {
+			return 0, err
+		}
+		if err := response.Write(conn); err != nil {
+			return 0, fmt.Errorf("write VNC auth response: %w", err)
+		}
+	}
+
+	// Versions before 3.8 only send a result when there was authentication.
+	if version.Minor >= 8 {
+		var result SecurityResultMessageRFB38
+		if err := result.Read(conn, bo); err != nil {
+			return 0, fmt.Errorf("read SecurityResult: %w", err)
+		}
+		if result.Result != VNCAuthenticationResultOK {
+			return 0, &SecurityFailure{result.Reason}
+		}
+	} else if selected != SecurityTypeNone {
+		var result VNCAuthenticationResultMessage
+		if err := result.Read(conn, bo); err != nil {
+			return 0, fmt.Errorf("read VNCAuthenticationResult: %w", err)
+		}
+		if result.Result != VNCAuthenticationResultOK {
+			return 0, &SecurityFailure{"wrong password"}
+		}
+	}
+	return selected, nil
+}
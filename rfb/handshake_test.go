@@ -0,0 +1,206 @@
+package rfb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestServerHandshake(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// The client speaks 3.8 and chooses no authentication.
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- func() error {
+			bo := binary.BigEndian
+			var version ProtocolVersionMessage
+			if err := version.Read(client); err != nil {
+				return err
+			}
+			if err := version.Write(client); err != nil {
+				return err
+			}
+			var types SecurityTypesMessageRFB37
+			if err := types.Read(client, bo); err != nil {
+				return err
+			}
+			if err := (&SecurityTypeSelectionMessageRFB37{Type: SecurityTypeNone}).Write(client); err != nil {
+				return err
+			}
+			var result SecurityResultMessageRFB38
+			if err := result.Read(client, bo); err != nil {
+				return err
+			}
+			if err := (&ClientInitialisationMessage{Shared: true}).Write(client); err != nil {
+				return err
+			}
+			var serverInit ServerInitialisationMessage
+			return serverInit.Read(client, bo)
+		}()
+	}()
+
+	result, err := ServerHandshake(context.Background(), server, ServerHandshakeOptions{
+		Init: func(clientInit ClientInitialisationMessage) (ServerInitialisationMessage, error) {
+			return ServerInitialisationMessage{FramebufferWidth: 10, FramebufferHeight: 20, PixelFormat: pixelFormat, Name: "test"}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-clientErr; err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	if result.ProtocolVersion != (ProtocolVersionMessage{Major: 3, Minor: 8}) || !result.Shared || result.ServerInit.Name != "test" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestClientHandshake(t *testing.T) {
+	for _, password := range []string{"secret", "wrong"} {
+		server, client := net.Pipe()
+		security := &SecurityHandlers{}
+		security.Register(&VNCSecurityHandler{Password: "secret"})
+		serverErr := make(chan error, 1)
+		go func() {
+			_, err := ServerHandshake(context.Background(), server, ServerHandshakeOptions{
+				Security: security,
+				Init: func(ClientInitialisationMessage) (ServerInitialisationMessage, error) {
+					return ServerInitialisationMessage{FramebufferWidth: 10, FramebufferHeight: 20, PixelFormat: pixelFormat, Name: "test"}, nil
+				},
+			})
+			server.Close()
+			serverErr <- err
+		}()
+
+		result, err := ClientHandshake(context.Background(), client, ClientHandshakeOptions{
+			Password: func() (string, error) { return password, nil },
+		})
+		client.Close()
+		<-serverErr
+		if password == "wrong" {
+			var failure *SecurityFailure
+			if !errors.As(err, &failure) {
+				t.Errorf("expected a SecurityFailure with the wrong password, but got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.SecurityType != SecurityTypeVNC || result.ServerInit.Name != "test" || result.ServerInit.PixelFormat != pixelFormat {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+}
+
+func TestServerHandshakeFailureReasons(t *testing.T) {
+	bo := binary.BigEndian
+	security := &SecurityHandlers{}
+	security.Register(&MSLogonIISecurityHandler{})
+	tests := []struct {
+		minor  int
+		client func(conn net.Conn) (string, error)
+	}{
+		// Version 3.3 can't offer MS-Logon II, so the server fails with a reason instead of a scheme.
+		{3, func(conn net.Conn) (string, error) {
+			var m AuthenticationSchemeMessageRFB33
+			err := m.Read(conn, bo)
+			return m.Reason, err
+		}},
+		// Selecting a type that wasn't offered gets a failed security result.
+		{8, func(conn net.Conn) (string, error) {
+			var types SecurityTypesMessageRFB37
+			if err := types.Read(conn, bo); err != nil {
+				return "", err
+			}
+			if err := (&SecurityTypeSelectionMessageRFB37{Type: SecurityTypeVNC}).Write(conn); err != nil {
+				return "", err
+			}
+			var result SecurityResultMessageRFB38
+			err := result.Read(conn, bo)
+			return result.Reason, err
+		}},
+	}
+	for _, test := range tests {
+		server, client := net.Pipe()
+		reason := make(chan string, 1)
+		go func() {
+			defer close(reason)
+			var version ProtocolVersionMessage
+			if err := version.Read(client); err != nil {
+				t.Error(err)
+				return
+			}
+			version.Minor = test.minor
+			if err := version.Write(client); err != nil {
+				t.Error(err)
+				return
+			}
+			r, err := test.client(client)
+			if err != nil {
+				t.Error(err)
+			}
+			reason <- r
+		}()
+		_, err := ServerHandshake(context.Background(), server, ServerHandshakeOptions{Security: security})
+		if err == nil {
+			t.Errorf("3.%d: expected the handshake to fail", test.minor)
+		}
+		if r := <-reason; r == "" {
+			t.Errorf("3.%d: expected the client to be sent a failure reason", test.minor)
+		}
+		server.Close()
+		client.Close()
+	}
+}
+
+func TestServerHandshakeViewOnly(t *testing.T) {
+	for _, test := range []struct {
+		full, password string
+		ok, viewOnly   bool
+	}{
+		{"full", "full", true, false},
+		{"full", "watch", true, true},
+		{"full", "wrong", false, false},
+		// With only a view-only password, other passwords don't get full access.
+		{"", "watch", true, true},
+		{"", "wrong", false, false},
+	} {
+		server, client := net.Pipe()
+		security := &SecurityHandlers{}
+		security.Register(&VNCSecurityHandler{Password: test.full, ViewOnlyPassword: "watch"})
+		type serverResult struct {
+			result *ServerHandshakeResult
+			err    error
+		}
+		results := make(chan serverResult, 1)
+		go func() {
+			result, err := ServerHandshake(context.Background(), server, ServerHandshakeOptions{
+				Security: security,
+				Init: func(ClientInitialisationMessage) (ServerInitialisationMessage, error) {
+					return ServerInitialisationMessage{FramebufferWidth: 10, FramebufferHeight: 20, PixelFormat: pixelFormat}, nil
+				},
+			})
+			server.Close()
+			results <- serverResult{result, err}
+		}()
+
+		_, err := ClientHandshake(context.Background(), client, ClientHandshakeOptions{
+			Password: func() (string, error) { return test.password, nil },
+		})
+		client.Close()
+		r := <-results
+		if (err == nil) != test.ok || (r.err == nil) != test.ok {
+			t.Errorf("with password %q for %q, expected success to be %v, but the client got %v and the server got %v", test.password, test.full, test.ok, err, r.err)
+			continue
+		}
+		if test.ok && r.result.ViewOnly != test.viewOnly {
+			t.Errorf("with password %q for %q, expected ViewOnly to be %v", test.password, test.full, test.viewOnly)
+		}
+	}
+}
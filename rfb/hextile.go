@@ -0,0 +1,154 @@
+package rfb
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+)
+
+const hextileTileSize = 16
+
+// Hextile subencoding mask bits.
+const (
+	hextileRaw                 = 1
+	hextileBackgroundSpecified = 2
+	hextileForegroundSpecified = 4
+	hextileAnySubrects         = 8
+	hextileSubrectsColoured    = 16
+)
+
+// EncodeHextile encodes img as the payload of a FramebufferUpdateRect with EncodingTypeHextile.
+func EncodeHextile(img *PixelFormatImage) []byte {
+	var buf bytes.Buffer
+	var bg, fg uint32
+	bgValid, fgValid := false, false
+
+	r := img.Rect
+	for y := r.Min.Y; y < r.Max.Y; y += hextileTileSize {
+		for x := r.Min.X; x < r.Max.X; x += hextileTileSize {
+			tile := image.Rect(x, y, x+hextileTileSize, y+hextileTileSize).Intersect(r)
+			pixels := img.tilePixels(tile)
+			tileBg, tileFg, colors := dominantColors(pixels)
+
+			var mask uint8
+			var body bytes.Buffer
+			if !bgValid || tileBg != bg {
+				mask |= hextileBackgroundSpecified
+				img.writePixel(&body, tileBg)
+			}
+			if colors > 1 {
+				subrects := solidSubrects(pixels, tile.Dx(), tile.Dy(), tileBg)
+				mask |= hextileAnySubrects
+				if colors == 2 {
+					if !fgValid || tileFg != fg {
+						mask |= hextileForegroundSpecified
+						img.writePixel(&body, tileFg)
+					}
+				} else {
+					mask |= hextileSubrectsColoured
+				}
+				body.WriteByte(uint8(len(subrects)))
+				for _, sr := range subrects {
+					if colors > 2 {
+						img.writePixel(&body, sr.pixel)
+					}
+					body.WriteByte(uint8(sr.x<<4 | sr.y))
+					body.WriteByte(uint8((sr.w-1)<<4 | (sr.h - 1)))
+				}
+			}
+
+			if body.Len() >= len(pixels)*img.bytesPerPixel {
+				// Raw is smaller. The background and foreground are undefined after a raw tile.
+				buf.WriteByte(hextileRaw)
+				for _, p := range pixels {
+					img.writePixel(&buf, p)
+				}
+				bgValid, fgValid = false, false
+				continue
+			}
+			buf.WriteByte(mask)
+			buf.Write(body.Bytes())
+			bg, bgValid = tileBg, true
+			if mask&hextileForegroundSpecified != 0 {
+				fg, fgValid = tileFg, true
+			}
+			if mask&hextileSubrectsColoured != 0 {
+				fgValid = false
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// DecodeHextile reads a Hextile payload from r into img, whose bounds must be those of the FramebufferUpdateRect.
+func DecodeHextile(r io.Reader, img *PixelFormatImage) error {
+	var bg, fg uint32
+	var buf [2]byte
+	rect := img.Rect
+	for y := rect.Min.Y; y < rect.Max.Y; y += hextileTileSize {
+		for x := rect.Min.X; x < rect.Max.X; x += hextileTileSize {
+			tile := image.Rect(x, y, x+hextileTileSize, y+hextileTileSize).Intersect(rect)
+			if _, err := io.ReadFull(r, buf[:1]); err != nil {
+				return err
+			}
+			mask := buf[0]
+
+			if mask&hextileRaw != 0 {
+				for ty := tile.Min.Y; ty < tile.Max.Y; ty++ {
+					for tx := tile.Min.X; tx < tile.Max.X; tx++ {
+						p, err := img.readPixel(r)
+						if err != nil {
+							return err
+						}
+						img.putPixel(img.idx(tx, ty), p)
+					}
+				}
+				continue
+			}
+
+			if mask&hextileBackgroundSpecified != 0 {
+				p, err := img.readPixel(r)
+				if err != nil {
+					return err
+				}
+				bg = p
+			}
+			img.fill(tile, bg)
+			if mask&hextileForegroundSpecified != 0 {
+				p, err := img.readPixel(r)
+				if err != nil {
+					return err
+				}
+				fg = p
+			}
+			if mask&hextileAnySubrects == 0 {
+				continue
+			}
+			if _, err := io.ReadFull(r, buf[:1]); err != nil {
+				return err
+			}
+			count := int(buf[0])
+			for i := 0; i < count; i++ {
+				p := fg
+				if mask&hextileSubrectsColoured != 0 {
+					var err error
+					if p, err = img.readPixel(r); err != nil {
+						return err
+					}
+				}
+				if _, err := io.ReadFull(r, buf[:2]); err != nil {
+					return err
+				}
+				sx, sy := int(buf[0]>>4), int(buf[0]&0xf)
+				sw, sh := int(buf[1]>>4)+1, int(buf[1]&0xf)+1
+				sr := image.Rect(tile.Min.X+sx, tile.Min.Y+sy, tile.Min.X+sx+sw, tile.Min.Y+sy+sh)
+				if !sr.In(tile) {
+					return fmt.Errorf("hextile subrectangle %v is outside of tile %v", sr, tile)
+				}
+				img.fill(sr, p)
+			}
+		}
+	}
+	return nil
+}
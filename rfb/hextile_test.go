@@ -0,0 +1,76 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+var pixelFormat16 = PixelFormat{
+	BitsPerPixel: 16,
+	BitDepth:     16,
+	BigEndian:    false,
+	TrueColor:    true,
+	RedMax:       0x1f, GreenMax: 0x3f, BlueMax: 0x1f,
+	RedShift: 11, GreenShift: 5, BlueShift: 0,
+}
+
+// randomImage returns an image with blocks of a few colors, so that encoders have both flat and busy regions to work with.
+func randomImage(pf PixelFormat, r image.Rectangle, colors int, seed int64) *PixelFormatImage {
+	rnd := rand.New(rand.NewSource(seed))
+	palette := make([]color.Color, colors)
+	for i := range palette {
+		palette[i] = color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 0xff}
+	}
+	img, _ := NewPixelFormatImage(pf, r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := palette[0]
+			if (x/7+y/5)%3 != 0 {
+				c = palette[rnd.Intn(colors)]
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestHextileRoundTrip(t *testing.T) {
+	for _, pf := range []PixelFormat{pixelFormat, pixelFormatWeird, pixelFormat16} {
+		for _, colors := range []int{1, 2, 3, 50} {
+			r := image.Rect(3, 5, 3+45, 5+37)
+			src := randomImage(pf, r, colors, int64(colors))
+
+			rect := FramebufferUpdateRect{X: 3, Y: 5, Width: 45, Height: 37, EncodingType: EncodingTypeHextile, PixelData: EncodeHextile(src)}
+			var buf bytes.Buffer
+			if err := rect.Write(&buf, binary.BigEndian); err != nil {
+				t.Fatal(err)
+			}
+			var rect2 FramebufferUpdateRect
+			if err := rect2.Read(&buf, binary.BigEndian, pf, nil); err != nil {
+				t.Fatalf("read %d bpp, %d colors: %v", pf.BitsPerPixel, colors, err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("%d bpp, %d colors: expected Read to consume the whole payload, but %d bytes remain", pf.BitsPerPixel, colors, buf.Len())
+			}
+			dst, err := rect2.Decode(pf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(src.Pix, dst.Pix) {
+				t.Errorf("%d bpp, %d colors: decoded pixels differ from encoded pixels", pf.BitsPerPixel, colors)
+			}
+		}
+	}
+}
+
+func TestHextileFlatIsSmall(t *testing.T) {
+	img, _ := NewPixelFormatImage(pixelFormat, image.Rect(0, 0, 64, 64))
+	// 16 tiles: one with the background, then 15 that reuse it.
+	if n := len(EncodeHextile(img)); n != 1+4+15 {
+		t.Errorf("expected a flat image to encode to %d bytes, but got %d", 1+4+15, n)
+	}
+}
@@ -0,0 +1,97 @@
+package rfb
+
+import (
+	"context"
+	"image"
+)
+
+// Hooks observes protocol activity on a connection, for tracing and metrics.
+//
+// Each method is called when an operation starts and returns a function to call when it ends. Methods that return a context.Context return one to pass to the hooks called during the operation, so that implementations can nest them.
+type Hooks interface {
+	// Connection is called once per connection, around all other hooks.
+	Connection(ctx context.Context, remoteAddr string) (context.Context, func(err error))
+
+	// HandshakePhase is called for each step of the initial handshake, such as "ProtocolVersion" or "ClientInitialisation".
+	HandshakePhase(ctx context.Context, phase string) func(err error)
+
+	// DispatchMessage is called for each message received after the handshake. name is the message type without the "Message" suffix, such as "KeyEvent".
+	DispatchMessage(ctx context.Context, name string) (context.Context, func(err error))
+
+	// EncodeFrame is called for each FramebufferUpdate that is rendered and written. bytes is the number of bytes written.
+	EncodeFrame(ctx context.Context, rect image.Rectangle) func(bytes int, err error)
+}
+
+// NopHooks implements Hooks by doing nothing.
+type NopHooks struct{}
+
+func (NopHooks) Connection(ctx context.Context, remoteAddr string) (context.Context, func(err error)) {
+	return ctx, func(error) {}
+}
+
+func (NopHooks) HandshakePhase(ctx context.Context, phase string) func(err error) {
+	return func(error) {}
+}
+
+func (NopHooks) DispatchMessage(ctx context.Context, name string) (context.Context, func(err error)) {
+	return ctx, func(error) {}
+}
+
+func (NopHooks) EncodeFrame(ctx context.Context, rect image.Rectangle) func(bytes int, err error) {
+	return func(int, error) {}
+}
+
+// JoinHooks returns Hooks that call each of hooks in order, ending them in reverse order.
+func JoinHooks(hooks ...Hooks) Hooks {
+	return joinedHooks(hooks)
+}
+
+type joinedHooks []Hooks
+
+func (hooks joinedHooks) Connection(ctx context.Context, remoteAddr string) (context.Context, func(err error)) {
+	ends := make([]func(error), len(hooks))
+	for idx, h := range hooks {
+		ctx, ends[idx] = h.Connection(ctx, remoteAddr)
+	}
+	return ctx, func(err error) {
+		for idx := len(ends) - 1; idx >= 0; idx-- {
+			ends[idx](err)
+		}
+	}
+}
+
+func (hooks joinedHooks) HandshakePhase(ctx context.Context, phase string) func(err error) {
+	ends := make([]func(error), len(hooks))
+	for idx, h := range hooks {
+		ends[idx] = h.HandshakePhase(ctx, phase)
+	}
+	return func(err error) {
+		for idx := len(ends) - 1; idx >= 0; idx-- {
+			ends[idx](err)
+		}
+	}
+}
+
+func (hooks joinedHooks) DispatchMessage(ctx context.Context, name string) (context.Context, func(err error)) {
+	ends := make([]func(error), len(hooks))
+	for idx, h := range hooks {
+		ctx, ends[idx] = h.DispatchMessage(ctx, name)
+	}
+	return ctx, func(err error) {
+		for idx := len(ends) - 1; idx >= 0; idx-- {
+			ends[idx](err)
+		}
+	}
+}
+
+func (hooks joinedHooks) EncodeFrame(ctx context.Context, rect image.Rectangle) func(bytes int, err error) {
+	ends := make([]func(int, error), len(hooks))
+	for idx, h := range hooks {
+		ends[idx] = h.EncodeFrame(ctx, rect)
+	}
+	return func(bytes int, err error) {
+		for idx := len(ends) - 1; idx >= 0; idx-- {
+			ends[idx](bytes, err)
+		}
+	}
+}
@@ -1,20 +1,27 @@
 package rfb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+	"io"
 )
 
-// PixelFormatImage represents an image using the wire format specified by PixelFormat. Supports arbitrary drawing with At and Set, but for speed, use CopyToRGBA and CopyFromRGBA.
+// PixelFormatImage represents an image using the wire format specified by PixelFormat, and ColourMap if PixelFormat isn't TrueColor. Supports arbitrary drawing with At and Set, but for speed, use CopyToImage and CopyFromImage.
 type PixelFormatImage struct {
 	Pix         []uint8
 	Rect        image.Rectangle
 	PixelFormat PixelFormat
 
+	// ColourMap gives the colours of pixels if PixelFormat isn't TrueColor. NewPixelFormatImage sets it to DefaultColourMap.
+	ColourMap *ColourMap
+
 	bo            binary.ByteOrder
 	bytesPerPixel int
+	pool          *BufferPool // Where Pix came from, if anywhere.
 }
 
 // PixelFormatColor represents a color using the wire format specified by PixelFormat.
@@ -39,27 +46,43 @@ func (c PixelFormatColor) RGBA() (r, g, b, a uint32) {
 }
 
 func NewPixelFormatImage(pixelFormat PixelFormat, bounds image.Rectangle) (*PixelFormatImage, error) {
-	if pixelFormat.RedMax > 0xffff || pixelFormat.GreenMax > 0xffff || pixelFormat.BlueMax > 0xffff {
-		return nil, fmt.Errorf("RedMax, GreenMax, and BlueMax must be less than 0xffff, but were %x, %x, and %x", pixelFormat.RedMax, pixelFormat.GreenMax, pixelFormat.BlueMax)
-	}
-	if pixelFormat.BitsPerPixel != 32 && pixelFormat.BitsPerPixel != 16 && pixelFormat.BitsPerPixel != 8 {
-		return nil, fmt.Errorf("BitsPerPixel must be 32, 16, or 8, but it's %d", pixelFormat.BitsPerPixel)
+	return newPixelFormatImage(pixelFormat, bounds, nil)
+}
+
+func newPixelFormatImage(pixelFormat PixelFormat, bounds image.Rectangle, pool *BufferPool) (*PixelFormatImage, error) {
+	if err := pixelFormat.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pixel format: %w", err)
 	}
 
 	bytesPerPixel := int(pixelFormat.BitsPerPixel / 8)
-	var bo binary.ByteOrder = binary.BigEndian
-	if !pixelFormat.BigEndian {
-		bo = binary.LittleEndian
+	var colourMap *ColourMap
+	if !pixelFormat.TrueColor {
+		colourMap = DefaultColourMap()
+	}
+	pix := pool.Get(bytesPerPixel * bounds.Dx() * bounds.Dy())
+	if pool != nil {
+		for i := range pix {
+			pix[i] = 0
+		}
 	}
 	return &PixelFormatImage{
-		make([]uint8, bytesPerPixel*bounds.Dx()*bounds.Dy()),
+		pix,
 		bounds,
 		pixelFormat,
-		bo,
+		colourMap,
+		pixelFormat.byteOrder(),
 		bytesPerPixel,
+		pool,
 	}, nil
 }
 
+func (pf PixelFormat) byteOrder() binary.ByteOrder {
+	if pf.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
 func (img *PixelFormatImage) ColorModel() color.Model {
 	panic("not implemented")
 }
@@ -83,6 +106,9 @@ func (img *PixelFormatImage) At(x, y int) color.Color {
 		panic("unsupported BitsPerPixel")
 	}
 
+	if !img.PixelFormat.TrueColor {
+		return img.ColourMap.at(pixel)
+	}
 	return PixelFormatColor{pixel, img.PixelFormat}
 }
 
@@ -90,9 +116,13 @@ func (img *PixelFormatImage) Set(x, y int, c color.Color) {
 	r, g, b, _ := c.RGBA()
 
 	var pixel uint32
-	pixel |= (r * uint32(img.PixelFormat.RedMax) / 0xffff) << img.PixelFormat.RedShift
-	pixel |= (g * uint32(img.PixelFormat.GreenMax) / 0xffff) << img.PixelFormat.GreenShift
-	pixel |= (b * uint32(img.PixelFormat.BlueMax) / 0xffff) << img.PixelFormat.BlueShift
+	if !img.PixelFormat.TrueColor {
+		pixel = img.ColourMap.Index(c)
+	} else {
+		pixel |= (r * uint32(img.PixelFormat.RedMax) / 0xffff) << img.PixelFormat.RedShift
+		pixel |= (g * uint32(img.PixelFormat.GreenMax) / 0xffff) << img.PixelFormat.GreenShift
+		pixel |= (b * uint32(img.PixelFormat.BlueMax) / 0xffff) << img.PixelFormat.BlueShift
+	}
 
 	idx := img.idx(x, y)
 	switch img.PixelFormat.BitsPerPixel {
@@ -107,39 +137,12 @@ func (img *PixelFormatImage) Set(x, y int, c color.Color) {
 	}
 }
 
+// CopyToRGBA copies src into dst, returning an error if their bounds are not exactly equal.
 func (src *PixelFormatImage) CopyToRGBA(dst *image.RGBA) error {
 	if src.Bounds() != dst.Bounds() {
 		return fmt.Errorf("expected dst bounds to be %v, but was %v", src.Bounds(), dst.Bounds())
 	}
-
-	dstidx := 0
-	for srcidx := 0; srcidx < len(src.Pix); srcidx += src.bytesPerPixel {
-		var pixel uint32
-		switch src.PixelFormat.BitsPerPixel {
-		case 8:
-			pixel = uint32(src.Pix[srcidx])
-		case 16:
-			pixel = uint32(src.bo.Uint16(src.Pix[srcidx:]))
-		case 32:
-			pixel = src.bo.Uint32(src.Pix[srcidx:])
-		default:
-			// Checked in NewPixelFormatImage.
-			panic("unsupported BitsPerPixel")
-		}
-
-		// Extract components
-		r := (pixel >> src.PixelFormat.RedShift) & uint32(src.PixelFormat.RedMax)
-		g := (pixel >> src.PixelFormat.GreenShift) & uint32(src.PixelFormat.GreenMax)
-		b := (pixel >> src.PixelFormat.BlueShift) & uint32(src.PixelFormat.BlueMax)
-
-		// Scale to 0xff
-		dst.Pix[dstidx] = uint8((r * 0xff) / uint32(src.PixelFormat.RedMax))
-		dst.Pix[dstidx+1] = uint8((g * 0xff) / uint32(src.PixelFormat.GreenMax))
-		dst.Pix[dstidx+2] = uint8((b * 0xff) / uint32(src.PixelFormat.BlueMax))
-		dst.Pix[dstidx+3] = 0xff
-		dstidx += 4
-	}
-	return nil
+	return src.CopyToImage(dst, dst.Rect.Min)
 }
 
 // CopyFromRGBA copies src into dst, returning an error if their bounds are not exactly equal. The alpha channel is ignored.
@@ -147,30 +150,272 @@ func (dst *PixelFormatImage) CopyFromRGBA(src *image.RGBA) error {
 	if src.Bounds() != dst.Bounds() {
 		return fmt.Errorf("expected dst bounds to be %v, but was %v", src.Bounds(), dst.Bounds())
 	}
+	return dst.CopyFromImage(src, src.Rect.Min)
+}
+
+// CopyToImage copies src into the part of dst that starts at dp, like draw.Draw with draw.Src, returning an error if that part isn't within dst. *image.RGBA, *image.NRGBA, and *image.Gray are copied without going through color.Color.
+func (src *PixelFormatImage) CopyToImage(dst draw.Image, dp image.Point) error {
+	r := src.Rect.Sub(src.Rect.Min).Add(dp)
+	if !r.In(dst.Bounds()) {
+		return fmt.Errorf("expected dst bounds %v to contain %v", dst.Bounds(), r)
+	}
+	for y := 0; y < r.Dy(); y++ {
+		srcidx := src.idx(src.Rect.Min.X, src.Rect.Min.Y+y)
+		switch dst := dst.(type) {
+		case *image.RGBA:
+			row := dst.Pix[dst.PixOffset(r.Min.X, r.Min.Y+y):]
+			src.rgbaRow(row[:4*r.Dx()], srcidx)
+		case *image.NRGBA:
+			row := dst.Pix[dst.PixOffset(r.Min.X, r.Min.Y+y):]
+			src.rgbaRow(row[:4*r.Dx()], srcidx)
+		case *image.Gray:
+			row := dst.Pix[dst.PixOffset(r.Min.X, r.Min.Y+y):]
+			for i := 0; i < r.Dx(); i++ {
+				red, green, blue := src.rgb(srcidx)
+				// The same weights as color.GrayModel.
+				row[i] = uint8((19595*uint32(red) + 38470*uint32(green) + 7471*uint32(blue) + 1<<15) >> 16)
+				srcidx += src.bytesPerPixel
+			}
+		default:
+			for x := 0; x < r.Dx(); x++ {
+				red, green, blue := src.rgb(srcidx)
+				dst.Set(r.Min.X+x, r.Min.Y+y, color.RGBA{red, green, blue, 0xff})
+				srcidx += src.bytesPerPixel
+			}
+		}
+	}
+	return nil
+}
 
-	dstidx := 0
-	for srcidx := 0; srcidx < len(src.Pix); srcidx += 4 {
-		var pixel uint32
-		pixel |= ((uint32(src.Pix[srcidx]) * uint32(dst.PixelFormat.RedMax)) / 0xff) << dst.PixelFormat.RedShift
-		pixel |= ((uint32(src.Pix[srcidx+1]) * uint32(dst.PixelFormat.GreenMax)) / 0xff) << dst.PixelFormat.GreenShift
-		pixel |= ((uint32(src.Pix[srcidx+2]) * uint32(dst.PixelFormat.BlueMax)) / 0xff) << dst.PixelFormat.BlueShift
-
-		switch dst.PixelFormat.BitsPerPixel {
-		case 8:
-			dst.Pix[dstidx] = uint8(pixel)
-		case 16:
-			dst.bo.PutUint16(dst.Pix[dstidx:], uint16(pixel))
-		case 32:
-			dst.bo.PutUint32(dst.Pix[dstidx:], pixel)
+// CopyFromImage copies the part of src that starts at sp into dst, like draw.Draw with draw.Src, returning an error if that part isn't within src. Colours are composited onto black, ignoring alpha. *image.RGBA, *image.NRGBA, *image.Gray, and *image.YCbCr, which image/jpeg returns, are copied without going through color.Color.
+func (dst *PixelFormatImage) CopyFromImage(src image.Image, sp image.Point) error {
+	r := dst.Rect.Sub(dst.Rect.Min).Add(sp)
+	if !r.In(src.Bounds()) {
+		return fmt.Errorf("expected src bounds %v to contain %v", src.Bounds(), r)
+	}
+	for y := 0; y < r.Dy(); y++ {
+		dstidx := dst.idx(dst.Rect.Min.X, dst.Rect.Min.Y+y)
+		switch src := src.(type) {
+		case *image.RGBA:
+			row := src.Pix[src.PixOffset(r.Min.X, r.Min.Y+y):]
+			dst.setRGBARow(dstidx, row[:4*r.Dx()])
+		case *image.NRGBA:
+			row := src.Pix[src.PixOffset(r.Min.X, r.Min.Y+y):]
+			for i := 0; i < 4*r.Dx(); i += 4 {
+				if a := uint32(row[i+3]); a == 0xff {
+					dst.setRGB(dstidx, row[i], row[i+1], row[i+2])
+				} else {
+					dst.setRGB(dstidx, uint8(uint32(row[i])*a/0xff), uint8(uint32(row[i+1])*a/0xff), uint8(uint32(row[i+2])*a/0xff))
+				}
+				dstidx += dst.bytesPerPixel
+			}
+		case *image.Gray:
+			row := src.Pix[src.PixOffset(r.Min.X, r.Min.Y+y):]
+			for _, v := range row[:r.Dx()] {
+				dst.setRGB(dstidx, v, v, v)
+				dstidx += dst.bytesPerPixel
+			}
+		case *image.YCbCr:
+			for x := r.Min.X; x < r.Max.X; x++ {
+				yi, ci := src.YOffset(x, r.Min.Y+y), src.COffset(x, r.Min.Y+y)
+				red, green, blue := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+				dst.setRGB(dstidx, red, green, blue)
+				dstidx += dst.bytesPerPixel
+			}
 		default:
-			// Checked in NewPixelFormatImage.
-			panic("unsupported BitsPerPixel")
+			for x := r.Min.X; x < r.Max.X; x++ {
+				red, green, blue, _ := src.At(x, r.Min.Y+y).RGBA()
+				dst.setRGB(dstidx, uint8(red>>8), uint8(green>>8), uint8(blue>>8))
+				dstidx += dst.bytesPerPixel
+			}
 		}
-		dstidx += dst.bytesPerPixel
 	}
 	return nil
 }
 
+// rgbaRow sets row, in the layout of image.RGBA, to the opaque colours of the pixels starting at idx in Pix.
+func (img *PixelFormatImage) rgbaRow(row []uint8, idx int) {
+	if rOff, gOff, bOff, ok := img.PixelFormat.byteOffsets(); ok {
+		pix := img.Pix[idx : idx+len(row)]
+		if img.PixelFormat.Compatible(LayoutRGBA) {
+			copy(row, pix)
+			for i := 3; i < len(row); i += 4 {
+				row[i] = 0xff
+			}
+			return
+		}
+		for i := 0; i < len(row); i += 4 {
+			row[i], row[i+1], row[i+2], row[i+3] = pix[i+rOff], pix[i+gOff], pix[i+bOff], 0xff
+		}
+		return
+	}
+	for i := 0; i < len(row); i += 4 {
+		row[i], row[i+1], row[i+2] = img.rgb(idx)
+		row[i+3] = 0xff
+		idx += img.bytesPerPixel
+	}
+}
+
+// setRGBARow sets the pixels starting at idx in Pix to the colours in row, in the layout of image.RGBA, ignoring alpha.
+func (img *PixelFormatImage) setRGBARow(idx int, row []uint8) {
+	if rOff, gOff, bOff, ok := img.PixelFormat.byteOffsets(); ok {
+		pix := img.Pix[idx : idx+len(row)]
+		if img.PixelFormat.Compatible(LayoutRGBA) {
+			copy(pix, row)
+			for i := 3; i < len(pix); i += 4 {
+				pix[i] = 0
+			}
+			return
+		}
+		for i := 0; i < len(row); i += 4 {
+			pix[i], pix[i+1], pix[i+2], pix[i+3] = 0, 0, 0, 0
+			pix[i+rOff], pix[i+gOff], pix[i+bOff] = row[i], row[i+1], row[i+2]
+		}
+		return
+	}
+	for i := 0; i < len(row); i += 4 {
+		img.setRGB(idx, row[i], row[i+1], row[i+2])
+		idx += img.bytesPerPixel
+	}
+}
+
+// byteOffsets returns the offsets of the red, green, and blue bytes within each pixel, if pf has 32 bits per pixel and components of 8 bits that each fill a byte, so that converting to and from image.RGBA only moves bytes.
+func (pf PixelFormat) byteOffsets() (r, g, b int, ok bool) {
+	if !pf.TrueColor || pf.BitsPerPixel != 32 || pf.RedMax != 0xff || pf.GreenMax != 0xff || pf.BlueMax != 0xff {
+		return 0, 0, 0, false
+	}
+	offset := func(shift uint8) int {
+		if pf.BigEndian {
+			return 3 - int(shift/8)
+		}
+		return int(shift / 8)
+	}
+	for _, shift := range []uint8{pf.RedShift, pf.GreenShift, pf.BlueShift} {
+		if shift%8 != 0 || shift > 24 {
+			return 0, 0, 0, false
+		}
+	}
+	r, g, b = offset(pf.RedShift), offset(pf.GreenShift), offset(pf.BlueShift)
+	if r == g || g == b || r == b {
+		return 0, 0, 0, false
+	}
+	return r, g, b, true
+}
+
+// rgb returns the colour of the pixel at idx in Pix, with 8 bits per component.
+func (img *PixelFormatImage) rgb(idx int) (r, g, b uint8) {
+	pixel := img.getPixel(idx)
+	if !img.PixelFormat.TrueColor {
+		r, g, b, _ := img.ColourMap.at(pixel).RGBA()
+		return uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)
+	}
+	pf := &img.PixelFormat
+	r = uint8((pixel >> pf.RedShift & uint32(pf.RedMax)) * 0xff / uint32(pf.RedMax))
+	g = uint8((pixel >> pf.GreenShift & uint32(pf.GreenMax)) * 0xff / uint32(pf.GreenMax))
+	b = uint8((pixel >> pf.BlueShift & uint32(pf.BlueMax)) * 0xff / uint32(pf.BlueMax))
+	return r, g, b
+}
+
+// setRGB sets the pixel at idx in Pix to the colour nearest r, g, and b.
+func (img *PixelFormatImage) setRGB(idx int, r, g, b uint8) {
+	var pixel uint32
+	if !img.PixelFormat.TrueColor {
+		pixel = img.ColourMap.indexRGB(r, g, b)
+	} else {
+		pf := &img.PixelFormat
+		pixel |= (uint32(r) * uint32(pf.RedMax) / 0xff) << pf.RedShift
+		pixel |= (uint32(g) * uint32(pf.GreenMax) / 0xff) << pf.GreenShift
+		pixel |= (uint32(b) * uint32(pf.BlueMax) / 0xff) << pf.BlueShift
+	}
+	img.putPixel(idx, pixel)
+}
+
+func (img *PixelFormatImage) getPixel(idx int) uint32 {
+	switch img.bytesPerPixel {
+	case 1:
+		return uint32(img.Pix[idx])
+	case 2:
+		return uint32(img.bo.Uint16(img.Pix[idx:]))
+	default:
+		return img.bo.Uint32(img.Pix[idx:])
+	}
+}
+
+func (img *PixelFormatImage) putPixel(idx int, pixel uint32) {
+	switch img.bytesPerPixel {
+	case 1:
+		img.Pix[idx] = uint8(pixel)
+	case 2:
+		img.bo.PutUint16(img.Pix[idx:], uint16(pixel))
+	default:
+		img.bo.PutUint32(img.Pix[idx:], pixel)
+	}
+}
+
+// tilePixels returns the pixel values in r, which must be within the image, in row-major order.
+func (img *PixelFormatImage) tilePixels(r image.Rectangle) []uint32 {
+	pixels := make([]uint32, 0, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			pixels = append(pixels, img.getPixel(img.idx(x, y)))
+		}
+	}
+	return pixels
+}
+
+// rawPixels returns the pixels in r, which must be within the image, as a raw-encoded payload.
+func (img *PixelFormatImage) rawPixels(r image.Rectangle) []byte {
+	raw := make([]byte, 0, img.bytesPerPixel*r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		raw = append(raw, img.Pix[img.idx(r.Min.X, y):img.idx(r.Max.X, y)]...)
+	}
+	return raw
+}
+
+// crop returns a copy of the part of img in r, which must be within the image.
+func (img *PixelFormatImage) crop(r image.Rectangle) *PixelFormatImage {
+	return &PixelFormatImage{img.rawPixels(r), r, img.PixelFormat, img.ColourMap, img.bo, img.bytesPerPixel, nil}
+}
+
+// fill sets every pixel in r, which must be within the image, to pixel.
+func (img *PixelFormatImage) fill(r image.Rectangle, pixel uint32) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for idx := img.idx(r.Min.X, y); idx < img.idx(r.Max.X, y); idx += img.bytesPerPixel {
+			img.putPixel(idx, pixel)
+		}
+	}
+}
+
+// writePixel appends pixel to buf in the wire format.
+func (img *PixelFormatImage) writePixel(buf *bytes.Buffer, pixel uint32) {
+	var b [4]byte
+	switch img.bytesPerPixel {
+	case 1:
+		b[0] = uint8(pixel)
+	case 2:
+		img.bo.PutUint16(b[:], uint16(pixel))
+	default:
+		img.bo.PutUint32(b[:], pixel)
+	}
+	buf.Write(b[:img.bytesPerPixel])
+}
+
+// readPixel reads one pixel in the wire format.
+func (img *PixelFormatImage) readPixel(r io.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:img.bytesPerPixel]); err != nil {
+		return 0, err
+	}
+	switch img.bytesPerPixel {
+	case 1:
+		return uint32(b[0]), nil
+	case 2:
+		return uint32(img.bo.Uint16(b[:])), nil
+	default:
+		return img.bo.Uint32(b[:]), nil
+	}
+}
+
 func (img *PixelFormatImage) idx(x, y int) int {
 	return (img.bytesPerPixel*img.Rect.Dx())*(y-img.Rect.Min.Y) + img.bytesPerPixel*(x-img.Rect.Min.X)
 }
@@ -36,6 +36,7 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
+	"reflect"
 	"testing"
 )
 
@@ -77,6 +78,122 @@ func TestColor(t *testing.T) {
 	}
 }
 
+func TestCopyFromImage(t *testing.T) {
+	bounds := image.Rect(3, 5, 11, 9)
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 8, 4))
+	gray := image.NewGray(nrgba.Rect)
+	ycbcr := image.NewYCbCr(nrgba.Rect, image.YCbCrSubsampleRatio420)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			nrgba.Set(x, y, color.NRGBA{uint8(30 * x), uint8(60 * y), uint8(x * y), 0xff})
+			gray.Set(x, y, color.Gray{uint8(31 * x)})
+		}
+	}
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = uint8(7 * i)
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i], ycbcr.Cr[i] = uint8(40*i), uint8(255-40*i)
+	}
+
+	for _, pf := range []PixelFormat{pixelFormat, pixelFormatWeird} {
+		for _, src := range []image.Image{nrgba, gray, ycbcr} {
+			// Drawing into an RGBA first is the slow way to get the same result.
+			rgba := image.NewRGBA(bounds)
+			draw.Draw(rgba, bounds, src, image.Point{}, draw.Src)
+			want, _ := NewPixelFormatImage(pf, bounds)
+			if err := want.CopyFromRGBA(rgba); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := NewPixelFormatImage(pf, bounds)
+			if err := got.CopyFromImage(src, image.Point{}); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Pix, want.Pix) {
+				t.Errorf("%T into %v: expected %x, but got %x", src, pf, want.Pix, got.Pix)
+			}
+		}
+	}
+
+	img, _ := NewPixelFormatImage(pixelFormat, bounds)
+	if err := img.CopyFromImage(gray, image.Pt(1, 0)); err == nil {
+		t.Error("expected an error copying from outside src")
+	}
+}
+
+func TestCopyToImage(t *testing.T) {
+	src, _ := NewPixelFormatImage(pixelFormat, image.Rect(10, 10, 12, 11))
+	src.Set(10, 10, color.RGBA{0xff, 0, 0, 0xff})
+	src.Set(11, 10, color.RGBA{0xff, 0xff, 0xff, 0xff})
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	if err := src.CopyToImage(nrgba, image.Pt(2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if got := nrgba.NRGBAAt(2, 3); got != (color.NRGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("expected red at (2, 3), but got %v", got)
+	}
+	gray := image.NewGray(image.Rect(0, 0, 2, 1))
+	if err := src.CopyToImage(gray, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 2; x++ {
+		if want := color.GrayModel.Convert(src.At(10+x, 10)).(color.Gray); gray.GrayAt(x, 0) != want {
+			t.Errorf("expected %v at (%d, 0), but got %v", want, x, gray.GrayAt(x, 0))
+		}
+	}
+	if err := src.CopyToImage(gray, image.Pt(1, 0)); err == nil {
+		t.Error("expected an error copying to outside dst")
+	}
+}
+
+func TestCopyRGBAFastPath(t *testing.T) {
+	bounds := image.Rect(1, 2, 5, 4)
+	src := image.NewRGBA(bounds)
+	for i := range src.Pix {
+		src.Pix[i] = uint8(37 * i)
+	}
+	for _, pf := range []PixelFormat{
+		pixelFormat,
+		{BitsPerPixel: 32, BitDepth: 24, TrueColor: true, RedMax: 0xff, GreenMax: 0xff, BlueMax: 0xff, RedShift: 0, GreenShift: 8, BlueShift: 16},
+		{BitsPerPixel: 32, BitDepth: 24, TrueColor: true, RedMax: 0xff, GreenMax: 0xff, BlueMax: 0xff, RedShift: 16, GreenShift: 8, BlueShift: 0},
+	} {
+		if _, _, _, ok := pf.byteOffsets(); !ok {
+			t.Fatalf("expected %v to take the fast path", pf)
+		}
+		// Set goes through color.Color, so it's the slow way to get the same result.
+		want, _ := NewPixelFormatImage(pf, bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				want.Set(x, y, src.At(x, y))
+			}
+		}
+		got, _ := NewPixelFormatImage(pf, bounds)
+		if err := got.CopyFromRGBA(src); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Pix, want.Pix) {
+			t.Errorf("%v: expected %x, but got %x", pf, want.Pix, got.Pix)
+		}
+
+		dst := image.NewRGBA(bounds)
+		if err := got.CopyToRGBA(dst); err != nil {
+			t.Fatal(err)
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, _ := got.At(x, y).RGBA()
+				if c := dst.RGBAAt(x, y); c != (color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xff}) {
+					t.Errorf("%v: expected %v at (%d, %d), but got %v", pf, got.At(x, y), x, y, c)
+				}
+			}
+		}
+	}
+	if _, _, _, ok := pixelFormatWeird.byteOffsets(); ok {
+		t.Errorf("expected %v not to take the fast path", pixelFormatWeird)
+	}
+}
+
 func benchmarkDrawToRGBA(b *testing.B, width, height int) {
 	r := image.Rect(0, 0, width, height)
 	src, _ := NewPixelFormatImage(pixelFormat, r)
@@ -0,0 +1,393 @@
+// Package keysym names the X Window System keysyms that RFB key events carry, and converts them to and from runes.
+package keysym
+
+// NoSymbol is the keysym for no key.
+const NoSymbol = 0
+
+// Latin-1 keysyms are the same as the ISO 8859-1 characters they type. Lowercase letters are spelled with a Lower prefix.
+const (
+	Space            = 0x0020
+	Exclam           = 0x0021
+	QuoteDbl         = 0x0022
+	NumberSign       = 0x0023
+	Dollar           = 0x0024
+	Percent          = 0x0025
+	Ampersand        = 0x0026
+	Apostrophe       = 0x0027
+	ParenLeft        = 0x0028
+	ParenRight       = 0x0029
+	Asterisk         = 0x002a
+	Plus             = 0x002b
+	Comma            = 0x002c
+	Minus            = 0x002d
+	Period           = 0x002e
+	Slash            = 0x002f
+	Digit0           = 0x0030
+	Digit1           = 0x0031
+	Digit2           = 0x0032
+	Digit3           = 0x0033
+	Digit4           = 0x0034
+	Digit5           = 0x0035
+	Digit6           = 0x0036
+	Digit7           = 0x0037
+	Digit8           = 0x0038
+	Digit9           = 0x0039
+	Colon            = 0x003a
+	Semicolon        = 0x003b
+	Less             = 0x003c
+	Equal            = 0x003d
+	Greater          = 0x003e
+	Question         = 0x003f
+	At               = 0x0040
+	A                = 0x0041
+	B                = 0x0042
+	C                = 0x0043
+	D                = 0x0044
+	E                = 0x0045
+	F                = 0x0046
+	G                = 0x0047
+	H                = 0x0048
+	I                = 0x0049
+	J                = 0x004a
+	K                = 0x004b
+	L                = 0x004c
+	M                = 0x004d
+	N                = 0x004e
+	O                = 0x004f
+	P                = 0x0050
+	Q                = 0x0051
+	R                = 0x0052
+	S                = 0x0053
+	T                = 0x0054
+	U                = 0x0055
+	V                = 0x0056
+	W                = 0x0057
+	X                = 0x0058
+	Y                = 0x0059
+	Z                = 0x005a
+	BracketLeft      = 0x005b
+	Backslash        = 0x005c
+	BracketRight     = 0x005d
+	AsciiCircum      = 0x005e
+	Underscore       = 0x005f
+	Grave            = 0x0060
+	LowerA           = 0x0061
+	LowerB           = 0x0062
+	LowerC           = 0x0063
+	LowerD           = 0x0064
+	LowerE           = 0x0065
+	LowerF           = 0x0066
+	LowerG           = 0x0067
+	LowerH           = 0x0068
+	LowerI           = 0x0069
+	LowerJ           = 0x006a
+	LowerK           = 0x006b
+	LowerL           = 0x006c
+	LowerM           = 0x006d
+	LowerN           = 0x006e
+	LowerO           = 0x006f
+	LowerP           = 0x0070
+	LowerQ           = 0x0071
+	LowerR           = 0x0072
+	LowerS           = 0x0073
+	LowerT           = 0x0074
+	LowerU           = 0x0075
+	LowerV           = 0x0076
+	LowerW           = 0x0077
+	LowerX           = 0x0078
+	LowerY           = 0x0079
+	LowerZ           = 0x007a
+	BraceLeft        = 0x007b
+	Bar              = 0x007c
+	BraceRight       = 0x007d
+	AsciiTilde       = 0x007e
+	NoBreakSpace     = 0x00a0
+	ExclamDown       = 0x00a1
+	Cent             = 0x00a2
+	Sterling         = 0x00a3
+	Currency         = 0x00a4
+	Yen              = 0x00a5
+	BrokenBar        = 0x00a6
+	Section          = 0x00a7
+	Diaeresis        = 0x00a8
+	Copyright        = 0x00a9
+	OrdFeminine      = 0x00aa
+	GuillemotLeft    = 0x00ab
+	NotSign          = 0x00ac
+	Hyphen           = 0x00ad
+	Registered       = 0x00ae
+	Macron           = 0x00af
+	Degree           = 0x00b0
+	PlusMinus        = 0x00b1
+	TwoSuperior      = 0x00b2
+	ThreeSuperior    = 0x00b3
+	Acute            = 0x00b4
+	Mu               = 0x00b5
+	Paragraph        = 0x00b6
+	PeriodCentered   = 0x00b7
+	Cedilla          = 0x00b8
+	OneSuperior      = 0x00b9
+	Masculine        = 0x00ba
+	GuillemotRight   = 0x00bb
+	OneQuarter       = 0x00bc
+	OneHalf          = 0x00bd
+	ThreeQuarters    = 0x00be
+	QuestionDown     = 0x00bf
+	Agrave           = 0x00c0
+	Aacute           = 0x00c1
+	Acircumflex      = 0x00c2
+	Atilde           = 0x00c3
+	Adiaeresis       = 0x00c4
+	Aring            = 0x00c5
+	AE               = 0x00c6
+	Ccedilla         = 0x00c7
+	Egrave           = 0x00c8
+	Eacute           = 0x00c9
+	Ecircumflex      = 0x00ca
+	Ediaeresis       = 0x00cb
+	Igrave           = 0x00cc
+	Iacute           = 0x00cd
+	Icircumflex      = 0x00ce
+	Idiaeresis       = 0x00cf
+	ETH              = 0x00d0
+	Ntilde           = 0x00d1
+	Ograve           = 0x00d2
+	Oacute           = 0x00d3
+	Ocircumflex      = 0x00d4
+	Otilde           = 0x00d5
+	Odiaeresis       = 0x00d6
+	Multiply         = 0x00d7
+	Oslash           = 0x00d8
+	Ugrave           = 0x00d9
+	Uacute           = 0x00da
+	Ucircumflex      = 0x00db
+	Udiaeresis       = 0x00dc
+	Yacute           = 0x00dd
+	THORN            = 0x00de
+	Ssharp           = 0x00df
+	LowerAgrave      = 0x00e0
+	LowerAacute      = 0x00e1
+	LowerAcircumflex = 0x00e2
+	LowerAtilde      = 0x00e3
+	LowerAdiaeresis  = 0x00e4
+	LowerAring       = 0x00e5
+	LowerAE          = 0x00e6
+	LowerCcedilla    = 0x00e7
+	LowerEgrave      = 0x00e8
+	LowerEacute      = 0x00e9
+	LowerEcircumflex = 0x00ea
+	LowerEdiaeresis  = 0x00eb
+	LowerIgrave      = 0x00ec
+	LowerIacute      = 0x00ed
+	LowerIcircumflex = 0x00ee
+	LowerIdiaeresis  = 0x00ef
+	LowerETH         = 0x00f0
+	LowerNtilde      = 0x00f1
+	LowerOgrave      = 0x00f2
+	LowerOacute      = 0x00f3
+	LowerOcircumflex = 0x00f4
+	LowerOtilde      = 0x00f5
+	LowerOdiaeresis  = 0x00f6
+	Division         = 0x00f7
+	LowerOslash      = 0x00f8
+	LowerUgrave      = 0x00f9
+	LowerUacute      = 0x00fa
+	LowerUcircumflex = 0x00fb
+	LowerUdiaeresis  = 0x00fc
+	LowerYacute      = 0x00fd
+	LowerTHORN       = 0x00fe
+	LowerYdiaeresis  = 0x00ff
+)
+
+// Function and cursor keys.
+const (
+	BackSpace  = 0xff08
+	Tab        = 0xff09
+	Linefeed   = 0xff0a
+	Clear      = 0xff0b
+	Return     = 0xff0d
+	Pause      = 0xff13
+	ScrollLock = 0xff14
+	SysReq     = 0xff15
+	Escape     = 0xff1b
+	Delete     = 0xffff
+	Home       = 0xff50
+	Left       = 0xff51
+	Up         = 0xff52
+	Right      = 0xff53
+	Down       = 0xff54
+	PageUp     = 0xff55
+	PageDown   = 0xff56
+	End        = 0xff57
+	Begin      = 0xff58
+	Select     = 0xff60
+	Print      = 0xff61
+	Execute    = 0xff62
+	Insert     = 0xff63
+	Undo       = 0xff65
+	Redo       = 0xff66
+	Menu       = 0xff67
+	Find       = 0xff68
+	Cancel     = 0xff69
+	Help       = 0xff6a
+	Break      = 0xff6b
+	ModeSwitch = 0xff7e
+	NumLock    = 0xff7f
+	F1         = 0xffbe
+	F2         = 0xffbf
+	F3         = 0xffc0
+	F4         = 0xffc1
+	F5         = 0xffc2
+	F6         = 0xffc3
+	F7         = 0xffc4
+	F8         = 0xffc5
+	F9         = 0xffc6
+	F10        = 0xffc7
+	F11        = 0xffc8
+	F12        = 0xffc9
+	F13        = 0xffca
+	F14        = 0xffcb
+	F15        = 0xffcc
+	F16        = 0xffcd
+	F17        = 0xffce
+	F18        = 0xffcf
+	F19        = 0xffd0
+	F20        = 0xffd1
+	F21        = 0xffd2
+	F22        = 0xffd3
+	F23        = 0xffd4
+	F24        = 0xffd5
+	F25        = 0xffd6
+	F26        = 0xffd7
+	F27        = 0xffd8
+	F28        = 0xffd9
+	F29        = 0xffda
+	F30        = 0xffdb
+	F31        = 0xffdc
+	F32        = 0xffdd
+	F33        = 0xffde
+	F34        = 0xffdf
+	F35        = 0xffe0
+)
+
+// Modifier keys.
+const (
+	ShiftL    = 0xffe1
+	ShiftR    = 0xffe2
+	ControlL  = 0xffe3
+	ControlR  = 0xffe4
+	CapsLock  = 0xffe5
+	ShiftLock = 0xffe6
+	MetaL     = 0xffe7
+	MetaR     = 0xffe8
+	AltL      = 0xffe9
+	AltR      = 0xffea
+	SuperL    = 0xffeb
+	SuperR    = 0xffec
+	HyperL    = 0xffed
+	HyperR    = 0xffee
+
+	// ISOLevel3Shift is AltGr on many keyboards.
+	ISOLevel3Shift = 0xfe03
+)
+
+// Keypad keys.
+const (
+	KPSpace     = 0xff80
+	KPTab       = 0xff89
+	KPEnter     = 0xff8d
+	KPF1        = 0xff91
+	KPF2        = 0xff92
+	KPF3        = 0xff93
+	KPF4        = 0xff94
+	KPHome      = 0xff95
+	KPLeft      = 0xff96
+	KPUp        = 0xff97
+	KPRight     = 0xff98
+	KPDown      = 0xff99
+	KPPageUp    = 0xff9a
+	KPPageDown  = 0xff9b
+	KPEnd       = 0xff9c
+	KPBegin     = 0xff9d
+	KPInsert    = 0xff9e
+	KPDelete    = 0xff9f
+	KPMultiply  = 0xffaa
+	KPAdd       = 0xffab
+	KPSeparator = 0xffac
+	KPSubtract  = 0xffad
+	KPDecimal   = 0xffae
+	KPDivide    = 0xffaf
+	KP0         = 0xffb0
+	KP1         = 0xffb1
+	KP2         = 0xffb2
+	KP3         = 0xffb3
+	KP4         = 0xffb4
+	KP5         = 0xffb5
+	KP6         = 0xffb6
+	KP7         = 0xffb7
+	KP8         = 0xffb8
+	KP9         = 0xffb9
+	KPEqual     = 0xffbd
+)
+
+// unicodeOffset is added to code points beyond Latin-1 to make their keysyms.
+const unicodeOffset = 0x01000000
+
+// controls are the keysyms of keys that type control characters.
+var controls = map[uint32]rune{
+	BackSpace: '\b',
+	Tab:       '\t',
+	Linefeed:  '\n',
+	Return:    '\r',
+	Escape:    0x1b,
+	Delete:    0x7f,
+	KPTab:     '\t',
+	KPEnter:   '\r',
+	KPSpace:   ' ',
+}
+
+// KeysymToRune returns the character that keysym types: Latin-1 and Unicode keysyms, the keypad's digits and operators, and keys such as Return and Tab that type control characters. Other keysyms, including legacy ones for scripts such as Cyrillic, return false.
+func KeysymToRune(keysym uint32) (rune, bool) {
+	switch {
+	case keysym >= Space && keysym <= AsciiTilde, keysym >= NoBreakSpace && keysym <= LowerYdiaeresis:
+		return rune(keysym), true
+	case keysym >= KP0 && keysym <= KP9:
+		return '0' + rune(keysym-KP0), true
+	case keysym >= KPMultiply && keysym <= KPDivide:
+		return rune("*+,-./"[keysym-KPMultiply]), true
+	case keysym == KPEqual:
+		return '=', true
+	case keysym > unicodeOffset+0xff && keysym <= unicodeOffset+0x10ffff:
+		return rune(keysym - unicodeOffset), true
+	}
+	r, ok := controls[keysym]
+	return r, ok
+}
+
+// RuneToKeysym returns the keysym that types r, or NoSymbol if there is none, as for most control characters. Characters beyond Latin-1 have Unicode keysyms, which most clients and servers understand.
+func RuneToKeysym(r rune) uint32 {
+	switch r {
+	case '\b':
+		return BackSpace
+	case '\t':
+		return Tab
+	case '\n', '\r':
+		return Return
+	case 0x1b:
+		return Escape
+	case 0x7f:
+		return Delete
+	}
+	switch {
+	case r >= Space && r <= AsciiTilde, r >= NoBreakSpace && r <= LowerYdiaeresis:
+		return uint32(r)
+	case r > 0xff && r <= 0x10ffff && (r < 0xd800 || r > 0xdfff):
+		return unicodeOffset + uint32(r)
+	}
+	return NoSymbol
+}
+
+// IsModifier reports whether keysym is a modifier key, such as Shift, which changes what other keys do rather than typing anything.
+func IsModifier(keysym uint32) bool {
+	return keysym >= ShiftL && keysym <= HyperR || keysym == ISOLevel3Shift || keysym == ModeSwitch || keysym == NumLock
+}
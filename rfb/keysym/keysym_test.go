@@ -0,0 +1,44 @@
+package keysym
+
+import "testing"
+
+func TestConversions(t *testing.T) {
+	tests := []struct {
+		keysym uint32
+		r      rune
+	}{
+		{LowerW, 'w'},
+		{W, 'W'},
+		{Digit7, '7'},
+		{AsciiTilde, '~'},
+		{Eacute, 'É'},
+		{LowerYdiaeresis, 'ÿ'},
+		{0x010020ac, '€'},
+		{0x0101f600, '😀'},
+		{Return, '\r'},
+		{Tab, '\t'},
+	}
+	for _, test := range tests {
+		if r, ok := KeysymToRune(test.keysym); !ok || r != test.r {
+			t.Errorf("expected keysym %#x to be %q, but got %q, %v", test.keysym, test.r, r, ok)
+		}
+		if keysym := RuneToKeysym(test.r); keysym != test.keysym {
+			t.Errorf("expected %q to be keysym %#x, but got %#x", test.r, test.keysym, keysym)
+		}
+	}
+
+	if r, ok := KeysymToRune(KP5); !ok || r != '5' {
+		t.Errorf("expected KP5 to type '5', but got %q, %v", r, ok)
+	}
+	for _, keysym := range []uint32{ShiftL, F1, Left, 0x06c1} {
+		if r, ok := KeysymToRune(keysym); ok {
+			t.Errorf("expected keysym %#x to type nothing, but got %q", keysym, r)
+		}
+	}
+	if keysym := RuneToKeysym(0x01); keysym != NoSymbol {
+		t.Errorf("expected no keysym for a control character, but got %#x", keysym)
+	}
+	if !IsModifier(ShiftL) || !IsModifier(ISOLevel3Shift) || IsModifier(LowerA) {
+		t.Error("expected IsModifier to be true for exactly the modifiers")
+	}
+}
@@ -0,0 +1,229 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ClientMessage is a message that clients send after initialisation. ReadClientMessage returns one of the pointer types that implement it.
+type ClientMessage interface {
+	// MessageType is the first byte of the message.
+	MessageType() uint8
+	String() string
+	clientMessage()
+}
+
+// ServerMessage is a message that servers send after initialisation. ReadServerMessage returns one of the pointer types that implement it.
+type ServerMessage interface {
+	// MessageType is the first byte of the message.
+	MessageType() uint8
+	String() string
+	serverMessage()
+}
+
+// ReadClientMessage reads the next message sent by a client, choosing its type by its first byte.
+func ReadClientMessage(r io.Reader, bo binary.ByteOrder) (ClientMessage, error) {
+	messageType, r, err := peekMessageType(r)
+	if err != nil {
+		return nil, err
+	}
+	var m ClientMessage
+	switch messageType {
+	case 0:
+		m = &SetPixelFormatMessage{}
+	case 1:
+		m = &FixColourMapEntriesMessage{}
+	case 2:
+		m = &SetEncodingsMessage{}
+	case 3:
+		m = &FramebufferUpdateRequestMessage{}
+	case 4:
+		m = &KeyEventMessage{}
+	case 5:
+		m = &PointerEventMessage{}
+	case 6:
+		m = &ClientCutTextMessage{}
+	case 7:
+		m = &FileTransferMessage{}
+	case 150:
+		m = &EnableContinuousUpdatesMessage{}
+	case 248:
+		m = &FenceMessage{}
+	case 250:
+		m = &XVPMessage{}
+	case 251:
+		m = &SetDesktopSizeMessage{}
+	default:
+		return nil, protocolErrorf(messageType, 0, "unrecognized client message type")
+	}
+	if err := readMessage(m, r, bo, PixelFormat{}, nil); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReadServerMessage reads the next message sent by a server, choosing its type by its first byte. FramebufferUpdate rectangles are read with pixelFormat and encodings, as in FramebufferUpdateMessage.Read.
+func ReadServerMessage(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat, encodings *Encodings) (ServerMessage, error) {
+	messageType, r, err := peekMessageType(r)
+	if err != nil {
+		return nil, err
+	}
+	var m ServerMessage
+	switch messageType {
+	case 0:
+		m = &FramebufferUpdateMessage{}
+	case 1:
+		m = &SetColourMapEntriesMessage{}
+	case 2:
+		m = &BellMessage{}
+	case 3:
+		m = &ServerCutTextMessage{}
+	case 7:
+		m = &FileTransferMessage{}
+	case 150:
+		m = &EndOfContinuousUpdatesMessage{}
+	case 248:
+		m = &FenceMessage{}
+	case 250:
+		m = &XVPMessage{}
+	default:
+		return nil, protocolErrorf(messageType, 0, "unrecognized server message type")
+	}
+	if err := readMessage(m, r, bo, pixelFormat, encodings); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readMessage calls m's Read, whichever of their signatures it has.
+func readMessage(m interface{ MessageType() uint8 }, r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat, encodings *Encodings) error {
+	var err error
+	switch m := m.(type) {
+	case *FramebufferUpdateMessage:
+		err = m.Read(r, bo, pixelFormat, encodings)
+	case interface {
+		Read(r io.Reader, bo binary.ByteOrder) error
+	}:
+		err = m.Read(r, bo)
+	case interface{ Read(r io.Reader) error }:
+		err = m.Read(r)
+	default:
+		panic(fmt.Sprintf("%T has no Read method", m))
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", MessageName(m), err)
+	}
+	return nil
+}
+
+// writeMessage calls m's Write, whichever of their signatures it has.
+func writeMessage(m interface{ MessageType() uint8 }, w io.Writer, bo binary.ByteOrder) error {
+	var err error
+	switch m := m.(type) {
+	case interface {
+		Write(w io.Writer, bo binary.ByteOrder) error
+	}:
+		err = m.Write(w, bo)
+	case interface{ Write(w io.Writer) error }:
+		err = m.Write(w)
+	default:
+		panic(fmt.Sprintf("%T has no Write method", m))
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", MessageName(m), err)
+	}
+	return nil
+}
+
+// peekMessageType reads the first byte of a message, returning it along with a reader that starts with it again, since each message's Read expects the type byte.
+func peekMessageType(r io.Reader) (uint8, io.Reader, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, nil, fmt.Errorf("read message type: %w", err)
+	}
+	return b[0], io.MultiReader(bytes.NewReader(b[:]), r), nil
+}
+
+// MessageName returns the name of m's type without the "Message" suffix, such as "KeyEvent", or "xvp" for XVPMessage.
+func MessageName(m interface{ MessageType() uint8 }) string {
+	switch m.(type) {
+	case *SetPixelFormatMessage:
+		return "SetPixelFormat"
+	case *FixColourMapEntriesMessage:
+		return "FixColourMapEntries"
+	case *SetEncodingsMessage:
+		return "SetEncodings"
+	case *FramebufferUpdateRequestMessage:
+		return "FramebufferUpdateRequest"
+	case *KeyEventMessage:
+		return "KeyEvent"
+	case *PointerEventMessage:
+		return "PointerEvent"
+	case *ClientCutTextMessage:
+		return "ClientCutText"
+	case *FileTransferMessage:
+		return "FileTransfer"
+	case *EnableContinuousUpdatesMessage:
+		return "EnableContinuousUpdates"
+	case *FenceMessage:
+		return "Fence"
+	case *XVPMessage:
+		return "xvp"
+	case *SetDesktopSizeMessage:
+		return "SetDesktopSize"
+	case *FramebufferUpdateMessage:
+		return "FramebufferUpdate"
+	case *SetColourMapEntriesMessage:
+		return "SetColourMapEntries"
+	case *BellMessage:
+		return "Bell"
+	case *ServerCutTextMessage:
+		return "ServerCutText"
+	case *EndOfContinuousUpdatesMessage:
+		return "EndOfContinuousUpdates"
+	}
+	return fmt.Sprintf("message type %d", m.MessageType())
+}
+
+func (*SetPixelFormatMessage) MessageType() uint8           { return 0 }
+func (*FixColourMapEntriesMessage) MessageType() uint8      { return 1 }
+func (*SetEncodingsMessage) MessageType() uint8             { return 2 }
+func (*FramebufferUpdateRequestMessage) MessageType() uint8 { return 3 }
+func (*KeyEventMessage) MessageType() uint8                 { return 4 }
+func (*PointerEventMessage) MessageType() uint8             { return 5 }
+func (*ClientCutTextMessage) MessageType() uint8            { return 6 }
+func (*FileTransferMessage) MessageType() uint8             { return 7 }
+func (*EnableContinuousUpdatesMessage) MessageType() uint8  { return 150 }
+func (*FenceMessage) MessageType() uint8                    { return 248 }
+func (*XVPMessage) MessageType() uint8                      { return 250 }
+func (*SetDesktopSizeMessage) MessageType() uint8           { return 251 }
+
+func (*FramebufferUpdateMessage) MessageType() uint8      { return 0 }
+func (*SetColourMapEntriesMessage) MessageType() uint8    { return 1 }
+func (*BellMessage) MessageType() uint8                   { return 2 }
+func (*ServerCutTextMessage) MessageType() uint8          { return 3 }
+func (*EndOfContinuousUpdatesMessage) MessageType() uint8 { return 150 }
+
+func (*SetPixelFormatMessage) clientMessage()           {}
+func (*FixColourMapEntriesMessage) clientMessage()      {}
+func (*SetEncodingsMessage) clientMessage()             {}
+func (*FramebufferUpdateRequestMessage) clientMessage() {}
+func (*KeyEventMessage) clientMessage()                 {}
+func (*PointerEventMessage) clientMessage()             {}
+func (*ClientCutTextMessage) clientMessage()            {}
+func (*FileTransferMessage) clientMessage()             {}
+func (*EnableContinuousUpdatesMessage) clientMessage()  {}
+func (*FenceMessage) clientMessage()                    {}
+func (*XVPMessage) clientMessage()                      {}
+func (*SetDesktopSizeMessage) clientMessage()           {}
+
+func (*FramebufferUpdateMessage) serverMessage()      {}
+func (*SetColourMapEntriesMessage) serverMessage()    {}
+func (*BellMessage) serverMessage()                   {}
+func (*ServerCutTextMessage) serverMessage()          {}
+func (*FileTransferMessage) serverMessage()           {}
+func (*EndOfContinuousUpdatesMessage) serverMessage() {}
+func (*FenceMessage) serverMessage()                  {}
+func (*XVPMessage) serverMessage()                    {}
@@ -0,0 +1,73 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestReadClientMessage(t *testing.T) {
+	bo := binary.BigEndian
+	var buf bytes.Buffer
+	key := &KeyEventMessage{Pressed: true, KeySym: 0x61}
+	fence := &FenceMessage{Flags: FenceRequest, Data: []byte{1}}
+	xvp := &XVPMessage{Version: XVPVersion, Code: XVPReboot}
+	for _, err := range []error{key.Write(&buf, bo), fence.Write(&buf, bo), xvp.Write(&buf)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []ClientMessage{key, fence, xvp} {
+		m, err := ReadClientMessage(&buf, bo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, want) {
+			t.Errorf("expected %+v, but got %+v", want, m)
+		}
+	}
+
+	buf.Write([]byte{99})
+	if _, err := ReadClientMessage(&buf, bo); err == nil {
+		t.Errorf("expected an error for an unrecognized message type")
+	}
+}
+
+func TestReadServerMessage(t *testing.T) {
+	bo := binary.BigEndian
+	var buf bytes.Buffer
+	update := &FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{NewCopyRect(image.Rect(0, 0, 2, 2), image.Pt(3, 4))}}
+	bell := &BellMessage{}
+	for _, err := range []error{update.Write(&buf, bo), bell.Write(&buf)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []ServerMessage{update, bell} {
+		m, err := ReadServerMessage(&buf, bo, pixelFormat, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, want) {
+			t.Errorf("expected %+v, but got %+v", want, m)
+		}
+	}
+}
+
+func TestReadClientMessageProtocolError(t *testing.T) {
+	var protocolErr *ProtocolError
+	if _, err := ReadClientMessage(bytes.NewReader([]byte{99}), binary.BigEndian); !errors.As(err, &protocolErr) || protocolErr.MessageType != 99 {
+		t.Errorf("expected a ProtocolError for message type 99, but got %v", err)
+	}
+	// A fence with too much data.
+	if _, err := ReadClientMessage(bytes.NewReader([]byte{248, 0, 0, 0, 0, 0, 0, 0, 65}), binary.BigEndian); !errors.As(err, &protocolErr) || protocolErr.Offset != 8 {
+		t.Errorf("expected a ProtocolError at byte 8, but got %v", err)
+	}
+	// An I/O error isn't a protocol error.
+	if _, err := ReadClientMessage(bytes.NewReader([]byte{4, 1}), binary.BigEndian); err == nil || errors.As(err, &protocolErr) {
+		t.Errorf("expected a non-protocol error for a truncated message, but got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package rfb
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+)
+
+// SecurityTypeMSLogonII is UltraVNC's MS-Logon II, which sends a username and password encrypted with a key agreed on by Diffie-Hellman.
+const SecurityTypeMSLogonII = SecurityType(113)
+
+// MSLogonIISecurityHandler implements SecurityTypeMSLogonII. The keys are only 31 bits because that's all UltraVNC viewers support, so this is not much stronger than VNC authentication.
+type MSLogonIISecurityHandler struct {
+	// Verify is called with the client's credentials and reports whether they are valid, for example by checking them against a domain controller.
+	Verify func(username, password string) bool
+}
+
+const (
+	msLogonIIKeyBits        = 31
+	msLogonIIUsernameLength = 256
+	msLogonIIPasswordLength = 64
+)
+
+func (h *MSLogonIISecurityHandler) Type() SecurityType {
+	return SecurityTypeMSLogonII
+}
+
+func (h *MSLogonIISecurityHandler) Authenticate(conn net.Conn, version ProtocolVersionMessage, bo binary.ByteOrder) (net.Conn, error) {
+	modulus, err := rand.Prime(rand.Reader, msLogonIIKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("generate modulus: %w", err)
+	}
+	generator, err := randomBelow(modulus)
+	if err != nil {
+		return nil, fmt.Errorf("generate generator: %w", err)
+	}
+	private, err := randomBelow(modulus)
+	if err != nil {
+		return nil, fmt.Errorf("generate private key: %w", err)
+	}
+	public := new(big.Int).Exp(generator, private, modulus)
+
+	var buf [24]byte
+	bo.PutUint64(buf[0:], generator.Uint64())
+	bo.PutUint64(buf[8:], modulus.Uint64())
+	bo.PutUint64(buf[16:], public.Uint64())
+	if _, err := conn.Write(buf[:]); err != nil {
+		return nil, fmt.Errorf("write MS-Logon II parameters: %w", err)
+	}
+
+	credentials := make([]byte, 8+msLogonIIUsernameLength+msLogonIIPasswordLength)
+	if _, err := io.ReadFull(conn, credentials); err != nil {
+		return nil, fmt.Errorf("read MS-Logon II credentials: %w", err)
+	}
+	username, password, err := msLogonIICredentials(credentials, private, modulus, bo)
+	if err != nil {
+		return nil, err
+	}
+	if h.Verify == nil || !h.Verify(username, password) {
+		return conn, &SecurityFailure{"wrong username or password"}
+	}
+	return conn, nil
+}
+
+// msLogonIICredentials decrypts the username and password in credentials, which start with the client's public value, with the key agreed on from it, private, and modulus.
+func msLogonIICredentials(credentials []byte, private, modulus *big.Int, bo binary.ByteOrder) (username, password string, err error) {
+	clientPublic := new(big.Int).SetUint64(bo.Uint64(credentials))
+	shared := new(big.Int).Exp(clientPublic, private, modulus)
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], shared.Uint64())
+
+	u, err := msLogonIIDecrypt(credentials[8:8+msLogonIIUsernameLength], key)
+	if err != nil {
+		return "", "", fmt.Errorf("decrypt username: %w", err)
+	}
+	p, err := msLogonIIDecrypt(credentials[8+msLogonIIUsernameLength:], key)
+	if err != nil {
+		return "", "", fmt.Errorf("decrypt password: %w", err)
+	}
+	return cString(u), cString(p), nil
+}
+
+func randomBelow(n *big.Int) (*big.Int, error) {
+	// Avoid 0 and 1, which make for degenerate keys.
+	r, err := rand.Int(rand.Reader, new(big.Int).Sub(n, big.NewInt(2)))
+	if err != nil {
+		return nil, err
+	}
+	return r.Add(r, big.NewInt(2)), nil
+}
+
+func msLogonIIDecrypt(buf []byte, key [8]byte) ([]byte, error) {
+	block, err := msLogonIICipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(buf))
+	prev := key[:]
+	for i := 0; i+8 <= len(buf); i += 8 {
+		block.Decrypt(out[i:], buf[i:i+8])
+		for j := 0; j < 8; j++ {
+			out[i+j] ^= prev[j]
+		}
+		prev = buf[i : i+8]
+	}
+	return out, nil
+}
+
+func msLogonIICipher(key [8]byte) (cipher.Block, error) {
+	var reversed [8]byte
+	for i, b := range key {
+		reversed[i] = reverseBits(b)
+	}
+	block, err := des.NewCipher(reversed[:])
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	return block, nil
+}
+
+func cString(buf []byte) string {
+	if idx := bytes.IndexByte(buf, 0); idx >= 0 {
+		buf = buf[:idx]
+	}
+	return string(buf)
+}
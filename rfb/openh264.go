@@ -0,0 +1,170 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+const EncodingTypeOpenH264 = uint32(50)
+
+// Open H.264 flags, sent with each rectangle.
+const (
+	// OpenH264ResetContext discards the decoder for the rectangle before decoding it.
+	OpenH264ResetContext = uint32(1)
+
+	// OpenH264ResetAllContexts discards every decoder before decoding the rectangle.
+	OpenH264ResetAllContexts = uint32(2)
+)
+
+// maxOpenH264DataLength bounds the allocation for one rectangle's H.264 data.
+const maxOpenH264DataLength = 64 << 20
+
+// H264Codec creates H.264 encoders and decoders for OpenH264Encoder and OpenH264Decoder. This package doesn't include an implementation; plug in bindings to a library such as x264 or OpenH264, or a pure-Go codec.
+type H264Codec interface {
+	NewEncoder(width, height int) (H264Encoder, error)
+	NewDecoder(width, height int) (H264Decoder, error)
+}
+
+// H264Encoder compresses a sequence of same-sized frames into an H.264 stream.
+type H264Encoder interface {
+	// Encode compresses the next frame, returning NAL units in Annex B format.
+	Encode(img *image.RGBA) ([]byte, error)
+	Close() error
+}
+
+// H264Decoder decompresses an H.264 stream produced by an H264Encoder.
+type H264Decoder interface {
+	// Decode decompresses NAL units in Annex B format, returning the newest frame.
+	Decode(data []byte) (image.Image, error)
+	Close() error
+}
+
+// OpenH264Encoder encodes framebuffer rectangles with EncodingTypeOpenH264. Each distinct rectangle is its own H.264 stream, so updates work best when they keep covering the same rectangles, such as the whole framebuffer. Each connection needs its own encoder.
+type OpenH264Encoder struct {
+	Codec H264Codec
+
+	contexts map[image.Rectangle]H264Encoder
+	resetAll bool
+}
+
+// Encode returns a rectangle covering img.
+func (e *OpenH264Encoder) Encode(img *PixelFormatImage) (*FramebufferUpdateRect, error) {
+	var flags uint32
+	if e.resetAll {
+		flags |= OpenH264ResetAllContexts
+		e.resetAll = false
+	}
+	if e.contexts == nil {
+		e.contexts = make(map[image.Rectangle]H264Encoder)
+	}
+	enc, ok := e.contexts[img.Rect]
+	if !ok {
+		var err error
+		if enc, err = e.Codec.NewEncoder(img.Rect.Dx(), img.Rect.Dy()); err != nil {
+			return nil, fmt.Errorf("create H.264 encoder: %w", err)
+		}
+		e.contexts[img.Rect] = enc
+		flags |= OpenH264ResetContext
+	}
+
+	rgba := image.NewRGBA(img.Rect)
+	if err := img.CopyToRGBA(rgba); err != nil {
+		return nil, err
+	}
+	data, err := enc.Encode(rgba)
+	if err != nil {
+		return nil, fmt.Errorf("encode H.264: %w", err)
+	}
+
+	var buf bytes.Buffer
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[0:], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:], flags)
+	buf.Write(header[:])
+	buf.Write(data)
+	r := img.Rect
+	return &FramebufferUpdateRect{
+		X: uint16(r.Min.X), Y: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+		EncodingType: EncodingTypeOpenH264, PixelData: buf.Bytes(),
+	}, nil
+}
+
+// Reset closes every stream, so that the next rectangle tells the client to discard its decoders too. Call it when the client may have lost track, such as after it changes pixel format.
+func (e *OpenH264Encoder) Reset() error {
+	var firstErr error
+	for r, enc := range e.contexts {
+		if err := enc.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(e.contexts, r)
+	}
+	e.resetAll = true
+	return firstErr
+}
+
+// OpenH264Decoder decodes rectangles with EncodingTypeOpenH264. It keeps a decoder per rectangle for the life of a connection, so each connection needs its own, and rectangles must be decoded in the order they were received.
+type OpenH264Decoder struct {
+	Codec H264Codec
+
+	contexts map[image.Rectangle]H264Decoder
+}
+
+// Decode decodes rect, which must have EncodingTypeOpenH264.
+func (d *OpenH264Decoder) Decode(rect *FramebufferUpdateRect, pf PixelFormat) (*PixelFormatImage, error) {
+	data, flags, err := readOpenH264(bytes.NewReader(rect.PixelData))
+	if err != nil {
+		return nil, fmt.Errorf("parse Open H.264 rectangle: %w", err)
+	}
+	r := rect.Bounds()
+
+	if d.contexts == nil {
+		d.contexts = make(map[image.Rectangle]H264Decoder)
+	}
+	for cr, dec := range d.contexts {
+		if flags&OpenH264ResetAllContexts != 0 || flags&OpenH264ResetContext != 0 && cr == r {
+			dec.Close()
+			delete(d.contexts, cr)
+		}
+	}
+	dec, ok := d.contexts[r]
+	if !ok {
+		if dec, err = d.Codec.NewDecoder(r.Dx(), r.Dy()); err != nil {
+			return nil, fmt.Errorf("create H.264 decoder: %w", err)
+		}
+		d.contexts[r] = dec
+	}
+
+	frame, err := dec.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode H.264: %w", err)
+	}
+	img, err := NewPixelFormatImage(pf, r)
+	if err != nil {
+		return nil, err
+	}
+	if err := img.CopyFromImage(frame, frame.Bounds().Min); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// readOpenH264 reads one rectangle's payload: the length of the H.264 data, flags, and the data.
+func readOpenH264(r io.Reader) (data []byte, flags uint32, err error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:])
+	flags = binary.BigEndian.Uint32(header[4:])
+	if length > maxOpenH264DataLength {
+		return nil, 0, fmt.Errorf("H.264 data length %d exceeds maximum of %d", length, maxOpenH264DataLength)
+	}
+	data = make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, err
+	}
+	return data, flags, nil
+}
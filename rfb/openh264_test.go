@@ -0,0 +1,90 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"testing"
+)
+
+// rawH264Codec stands in for a real codec by sending each frame's pixels. Its decoders fail if they see a frame from a stream they didn't start with.
+type rawH264Codec struct {
+	encoders, decoders int
+}
+
+type rawH264Encoder struct {
+	stream int
+}
+
+type rawH264Decoder struct {
+	stream int
+}
+
+func (c *rawH264Codec) NewEncoder(width, height int) (H264Encoder, error) {
+	c.encoders++
+	return &rawH264Encoder{c.encoders}, nil
+}
+
+func (c *rawH264Codec) NewDecoder(width, height int) (H264Decoder, error) {
+	c.decoders++
+	return &rawH264Decoder{-1}, nil
+}
+
+func (e *rawH264Encoder) Encode(img *image.RGBA) ([]byte, error) {
+	return append([]byte{byte(e.stream)}, img.Pix...), nil
+}
+
+func (e *rawH264Encoder) Close() error {
+	return nil
+}
+
+func (d *rawH264Decoder) Decode(data []byte) (image.Image, error) {
+	if d.stream != -1 && d.stream != int(data[0]) {
+		return nil, errors.New("frame from another stream")
+	}
+	d.stream = int(data[0])
+	return &image.RGBA{Pix: data[1:], Stride: 4 * 30, Rect: image.Rect(0, 0, 30, 20)}, nil
+}
+
+func (d *rawH264Decoder) Close() error {
+	return nil
+}
+
+func TestOpenH264(t *testing.T) {
+	codec := &rawH264Codec{}
+	enc := OpenH264Encoder{Codec: codec}
+	dec := OpenH264Decoder{Codec: codec}
+	r := image.Rect(5, 5, 35, 25)
+
+	for i, reset := range []bool{false, false, true, false} {
+		if reset {
+			if err := enc.Reset(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		src := randomImage(pixelFormat, r, 50, int64(i))
+		rect, err := enc.Encode(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := rect.Write(&buf, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+		var rect2 FramebufferUpdateRect
+		if err := rect2.Read(&buf, binary.BigEndian, pixelFormat, nil); err != nil {
+			t.Fatal(err)
+		}
+		dst, err := dec.Decode(&rect2, pixelFormat)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(src.Pix, dst.Pix) {
+			t.Errorf("frame %d: decoded pixels differ from encoded pixels", i)
+		}
+	}
+	if codec.encoders != 2 || codec.decoders != 2 {
+		t.Errorf("expected one stream before the reset and one after, but got %d encoders and %d decoders", codec.encoders, codec.decoders)
+	}
+}
@@ -0,0 +1,58 @@
+// Package otelhooks adapts rfb.Hooks to OpenTelemetry tracing, so VNC latency can be correlated with other traces.
+package otelhooks
+
+import (
+	"context"
+	"github.com/alltom/vncfreethumb/rfb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"image"
+)
+
+// Hooks records a span for each rfb.Hooks operation.
+type Hooks struct {
+	tracer trace.Tracer
+}
+
+var _ rfb.Hooks = (*Hooks)(nil)
+
+func New(tracer trace.Tracer) *Hooks {
+	return &Hooks{tracer}
+}
+
+func (h *Hooks) Connection(ctx context.Context, remoteAddr string) (context.Context, func(err error)) {
+	ctx, span := h.tracer.Start(ctx, "rfb.Connection", trace.WithAttributes(attribute.String("net.peer.addr", remoteAddr)))
+	return ctx, func(err error) { end(span, err) }
+}
+
+func (h *Hooks) HandshakePhase(ctx context.Context, phase string) func(err error) {
+	_, span := h.tracer.Start(ctx, "rfb.Handshake."+phase)
+	return func(err error) { end(span, err) }
+}
+
+func (h *Hooks) DispatchMessage(ctx context.Context, name string) (context.Context, func(err error)) {
+	ctx, span := h.tracer.Start(ctx, "rfb."+name)
+	return ctx, func(err error) { end(span, err) }
+}
+
+func (h *Hooks) EncodeFrame(ctx context.Context, rect image.Rectangle) func(bytes int, err error) {
+	_, span := h.tracer.Start(ctx, "rfb.EncodeFrame", trace.WithAttributes(
+		attribute.Int("rfb.rect.x", rect.Min.X),
+		attribute.Int("rfb.rect.y", rect.Min.Y),
+		attribute.Int("rfb.rect.width", rect.Dx()),
+		attribute.Int("rfb.rect.height", rect.Dy()),
+	))
+	return func(bytes int, err error) {
+		span.SetAttributes(attribute.Int("rfb.bytes", bytes))
+		end(span, err)
+	}
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
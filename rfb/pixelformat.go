@@ -0,0 +1,104 @@
+package rfb
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Common pixel formats, named for their components from most to least significant, except that RGBA8888 is named for its bytes in memory. Its fourth byte is padding.
+var (
+	PixelFormatRGBA8888BigEndian = PixelFormat{
+		BitsPerPixel: 32, BitDepth: 24, BigEndian: true, TrueColor: true,
+		RedMax: 0xff, GreenMax: 0xff, BlueMax: 0xff,
+		RedShift: 24, GreenShift: 16, BlueShift: 8,
+	}
+	PixelFormatRGBA8888LittleEndian = PixelFormat{
+		BitsPerPixel: 32, BitDepth: 24, TrueColor: true,
+		RedMax: 0xff, GreenMax: 0xff, BlueMax: 0xff,
+		RedShift: 0, GreenShift: 8, BlueShift: 16,
+	}
+	PixelFormatRGB565 = PixelFormat{
+		BitsPerPixel: 16, BitDepth: 16, TrueColor: true,
+		RedMax: 0x1f, GreenMax: 0x3f, BlueMax: 0x1f,
+		RedShift: 11, GreenShift: 5, BlueShift: 0,
+	}
+	PixelFormatBGR233 = PixelFormat{
+		BitsPerPixel: 8, BitDepth: 8, TrueColor: true,
+		RedMax: 7, GreenMax: 7, BlueMax: 3,
+		RedShift: 0, GreenShift: 3, BlueShift: 6,
+	}
+)
+
+// Validate returns an error if pf can't describe pixels, such as if a component's maximum isn't one less than a power of two, or if components overlap or don't fit in BitsPerPixel.
+func (pf PixelFormat) Validate() error {
+	if pf.BitsPerPixel != 8 && pf.BitsPerPixel != 16 && pf.BitsPerPixel != 32 {
+		return fmt.Errorf("BitsPerPixel must be 8, 16, or 32, but it's %d", pf.BitsPerPixel)
+	}
+	if pf.BitDepth == 0 || pf.BitDepth > pf.BitsPerPixel {
+		return fmt.Errorf("BitDepth must be from 1 to BitsPerPixel, %d, but it's %d", pf.BitsPerPixel, pf.BitDepth)
+	}
+	if !pf.TrueColor {
+		if pf.BitsPerPixel > 16 {
+			return errors.New("colour map formats must have at most 16 bits per pixel")
+		}
+		return nil
+	}
+	var used uint32
+	for _, c := range []struct {
+		name  string
+		max   uint16
+		shift uint8
+	}{{"red", pf.RedMax, pf.RedShift}, {"green", pf.GreenMax, pf.GreenShift}, {"blue", pf.BlueMax, pf.BlueShift}} {
+		if c.max == 0 || c.max&(c.max+1) != 0 {
+			return fmt.Errorf("%s max must be one less than a power of two, but it's %d", c.name, c.max)
+		}
+		if int(c.shift)+bits.Len16(c.max) > int(pf.BitsPerPixel) {
+			return fmt.Errorf("%s component, with max %d and shift %d, doesn't fit in %d bits per pixel", c.name, c.max, c.shift, pf.BitsPerPixel)
+		}
+		mask := uint32(c.max) << c.shift
+		if used&mask != 0 {
+			return fmt.Errorf("%s component, with max %d and shift %d, overlaps another", c.name, c.max, c.shift)
+		}
+		used |= mask
+	}
+	return nil
+}
+
+// Equal reports whether pf and other encode every colour as the same bytes. Unlike ==, it ignores BitDepth, the byte order of 8-bit pixels, and the components of colour map formats.
+func (pf PixelFormat) Equal(other PixelFormat) bool {
+	if pf.BitsPerPixel != other.BitsPerPixel || pf.TrueColor != other.TrueColor {
+		return false
+	}
+	if pf.BitsPerPixel > 8 && pf.BigEndian != other.BigEndian {
+		return false
+	}
+	if !pf.TrueColor {
+		return true
+	}
+	return pf.RedMax == other.RedMax && pf.GreenMax == other.GreenMax && pf.BlueMax == other.BlueMax &&
+		pf.RedShift == other.RedShift && pf.GreenShift == other.GreenShift && pf.BlueShift == other.BlueShift
+}
+
+// Layout is an arrangement of 8-bit components in memory, four bytes per pixel.
+type Layout int
+
+const (
+	LayoutRGBA = Layout(iota) // Red, green, blue, then alpha, as in image.RGBA and image.NRGBA.
+	LayoutBGRA                // Blue, green, red, then alpha, as many screen capture APIs return.
+)
+
+// Compatible reports whether pixels in pf have the same bytes as pixels in layout, apart from alpha, which is padding in pf, so that they can be copied without conversion.
+func (pf PixelFormat) Compatible(layout Layout) bool {
+	r, g, b, ok := pf.byteOffsets()
+	if !ok {
+		return false
+	}
+	switch layout {
+	case LayoutRGBA:
+		return r == 0 && g == 1 && b == 2
+	case LayoutBGRA:
+		return r == 2 && g == 1 && b == 0
+	}
+	return false
+}
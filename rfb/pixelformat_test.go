@@ -0,0 +1,76 @@
+package rfb
+
+import "testing"
+
+func TestPixelFormatValidate(t *testing.T) {
+	for _, pf := range []PixelFormat{PixelFormatRGBA8888BigEndian, PixelFormatRGBA8888LittleEndian, PixelFormatRGB565, PixelFormatBGR233, pixelFormatWeird, {BitsPerPixel: 8, BitDepth: 8}} {
+		if err := pf.Validate(); err != nil {
+			t.Errorf("expected %v to be valid, but got %v", pf, err)
+		}
+	}
+
+	bad := func(f func(pf *PixelFormat)) PixelFormat {
+		pf := PixelFormatRGB565
+		f(&pf)
+		return pf
+	}
+	for _, pf := range []PixelFormat{
+		bad(func(pf *PixelFormat) { pf.BitsPerPixel = 24 }),
+		bad(func(pf *PixelFormat) { pf.BitDepth = 0 }),
+		bad(func(pf *PixelFormat) { pf.BitDepth = 17 }),
+		bad(func(pf *PixelFormat) { pf.RedMax = 0 }),
+		bad(func(pf *PixelFormat) { pf.GreenMax = 50 }),
+		bad(func(pf *PixelFormat) { pf.RedShift = 12 }),
+		bad(func(pf *PixelFormat) { pf.BlueShift = 5 }),
+		{BitsPerPixel: 32, BitDepth: 24},
+	} {
+		if err := pf.Validate(); err == nil {
+			t.Errorf("expected %v to be invalid", pf)
+		}
+	}
+}
+
+func TestPixelFormatEqual(t *testing.T) {
+	rgb565 := PixelFormatRGB565
+	rgb565.BitDepth = 15
+	bgr233 := PixelFormatBGR233
+	bgr233.BigEndian = true
+	colourMap := PixelFormat{BitsPerPixel: 8, BitDepth: 8, RedMax: 7}
+	for _, test := range []struct {
+		a, b PixelFormat
+		want bool
+	}{
+		{PixelFormatRGBA8888BigEndian, PixelFormatRGBA8888BigEndian, true},
+		{PixelFormatRGBA8888BigEndian, PixelFormatRGBA8888LittleEndian, false},
+		{PixelFormatRGB565, rgb565, true},
+		{PixelFormatBGR233, bgr233, true},
+		{colourMap, PixelFormat{BitsPerPixel: 8, BitDepth: 8}, true},
+		{colourMap, PixelFormatBGR233, false},
+	} {
+		if got := test.a.Equal(test.b); got != test.want {
+			t.Errorf("expected %v.Equal(%v) to be %t", test.a, test.b, test.want)
+		}
+	}
+}
+
+func TestPixelFormatCompatible(t *testing.T) {
+	bgrx := PixelFormatRGBA8888BigEndian
+	bgrx.BigEndian = false
+	for _, test := range []struct {
+		pf         PixelFormat
+		rgba, bgra bool
+	}{
+		{PixelFormatRGBA8888BigEndian, true, false},
+		{PixelFormatRGBA8888LittleEndian, true, false},
+		{bgrx, false, false},
+		{PixelFormat{BitsPerPixel: 32, BitDepth: 24, TrueColor: true, RedMax: 0xff, GreenMax: 0xff, BlueMax: 0xff, RedShift: 16, GreenShift: 8}, false, true},
+		{PixelFormatRGB565, false, false},
+	} {
+		if got := test.pf.Compatible(LayoutRGBA); got != test.rgba {
+			t.Errorf("expected %v.Compatible(LayoutRGBA) to be %t", test.pf, test.rgba)
+		}
+		if got := test.pf.Compatible(LayoutBGRA); got != test.bgra {
+			t.Errorf("expected %v.Compatible(LayoutBGRA) to be %t", test.pf, test.bgra)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package rfb
+
+import (
+	"bytes"
+	"image"
+	"math/bits"
+	"sync"
+)
+
+const (
+	minPooledBuffer = 1 << 12
+	maxPooledBuffer = 1 << 28
+)
+
+// BufferPool recycles the byte slices of rectangle payloads and images, so that sessions with many updates per second don't allocate a framebuffer's worth of memory for each. The zero value is ready to use, and a nil *BufferPool allocates without pooling.
+type BufferPool struct {
+	// classes[k] holds slices with capacities from 1<<k up to, but not including, 1<<(k+1).
+	classes [bits.UintSize]sync.Pool
+}
+
+// Get returns a slice of length n, whose contents are arbitrary.
+func (p *BufferPool) Get(n int) []byte {
+	if p == nil || n < minPooledBuffer || n > maxPooledBuffer {
+		return make([]byte, n)
+	}
+	k := bits.Len(uint(n - 1)) // The smallest class whose slices all have room for n.
+	if b, ok := p.classes[k].Get().(*[]byte); ok {
+		return (*b)[:n]
+	}
+	return make([]byte, n, 1<<k)
+}
+
+// Put makes b available to Get. b must not be used afterwards.
+func (p *BufferPool) Put(b []byte) {
+	if p == nil || cap(b) < minPooledBuffer || cap(b) > maxPooledBuffer {
+		return
+	}
+	b = b[:cap(b)]
+	p.classes[bits.Len(uint(cap(b)))-1].Put(&b)
+}
+
+// buffer returns an empty buffer with room for about n bytes.
+func (p *BufferPool) buffer(n int) *bytes.Buffer {
+	if p == nil {
+		return &bytes.Buffer{}
+	}
+	return bytes.NewBuffer(p.Get(n)[:0])
+}
+
+// NewPixelFormatImage is like the function NewPixelFormatImage, but takes Pix from p. Release returns it.
+func (p *BufferPool) NewPixelFormatImage(pixelFormat PixelFormat, bounds image.Rectangle) (*PixelFormatImage, error) {
+	return newPixelFormatImage(pixelFormat, bounds, p)
+}
+
+// Release returns Pix to the BufferPool that it came from, if any. img must not be used afterwards.
+func (img *PixelFormatImage) Release() {
+	img.pool.Put(img.Pix)
+	img.Pix, img.pool = nil, nil
+}
+
+// Release returns PixelData to the BufferPool that it was read with, if any. rect must not be used afterwards.
+func (rect *FramebufferUpdateRect) Release() {
+	rect.pool.Put(rect.PixelData)
+	rect.PixelData, rect.pool = nil, nil
+}
+
+// Release releases every rectangle in m.
+func (m *FramebufferUpdateMessage) Release() {
+	for _, rect := range m.Rectangles {
+		rect.Release()
+	}
+}
@@ -0,0 +1,90 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	var pool BufferPool
+	for _, n := range []int{0, 100, minPooledBuffer, minPooledBuffer + 1, 3 << 20} {
+		b := pool.Get(n)
+		if len(b) != n {
+			t.Errorf("expected Get(%d) to return %d bytes, but got %d", n, n, len(b))
+		}
+		pool.Put(b)
+	}
+	var nilPool *BufferPool
+	if b := nilPool.Get(10); len(b) != 10 {
+		t.Errorf("expected a nil pool to allocate, but got %d bytes", len(b))
+	}
+	nilPool.Put(nil)
+}
+
+func TestReadWithBufferPool(t *testing.T) {
+	img, _ := NewPixelFormatImage(pixelFormat, image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	rect, err := DefaultEncodings.Encode(EncodingTypeRaw, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for i := 0; i < 3; i++ {
+		if err := (&FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{rect}}).Write(&buf, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encodings := NewEncodings(RawEncoding{})
+	encodings.SetBufferPool(&BufferPool{})
+	for i := 0; i < 3; i++ {
+		var m FramebufferUpdateMessage
+		if err := m.Read(&buf, binary.BigEndian, pixelFormat, encodings); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(m.Rectangles[0].PixelData, img.Pix) {
+			t.Fatalf("update %d: expected the pixels that were written", i)
+		}
+		m.Release()
+		if m.Rectangles[0].PixelData != nil {
+			t.Error("expected Release to clear PixelData")
+		}
+	}
+
+	var pool BufferPool
+	pooled, err := pool.NewPixelFormatImage(pixelFormat, img.Rect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(pooled.Pix, img.Pix)
+	pooled.Release()
+	if pooled, _ = pool.NewPixelFormatImage(pixelFormat, img.Rect); !bytes.Equal(pooled.Pix, make([]byte, len(img.Pix))) {
+		t.Error("expected a pooled image to start out black")
+	}
+}
+
+func benchmarkReadRaw(b *testing.B, pool *BufferPool) {
+	img, _ := NewPixelFormatImage(pixelFormat, image.Rect(0, 0, 512, 512))
+	rect, _ := DefaultEncodings.Encode(EncodingTypeRaw, img)
+	var buf bytes.Buffer
+	(&FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{rect}}).Write(&buf, binary.BigEndian)
+	data := buf.Bytes()
+	encodings := NewEncodings(RawEncoding{})
+	encodings.SetBufferPool(pool)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var m FramebufferUpdateMessage
+		if err := m.Read(bytes.NewReader(data), binary.BigEndian, pixelFormat, encodings); err != nil {
+			b.Fatal(err)
+		}
+		m.Release()
+	}
+}
+
+func BenchmarkReadRaw512(b *testing.B)       { benchmarkReadRaw(b, nil) }
+func BenchmarkReadRaw512Pooled(b *testing.B) { benchmarkReadRaw(b, &BufferPool{}) }
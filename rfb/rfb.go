@@ -3,7 +3,7 @@ Package rfb defines representations and serialization for messages in the RFB (R
 
 Types that do not not have a protocol version suffix such as "RFB33" are appropriate for use with all known versions of the RFB protocol.
 
-See the RFCs for details, but the initial handshake goes like this:
+See the RFCs for details, but the initial handshake goes like this in version 3.3:
 
 	server sends ProtocolVersionMessage
 	client sends ProtocolVersionMessage
@@ -17,31 +17,59 @@ See the RFCs for details, but the initial handshake goes like this:
 	client sends ClientInitialisationMessage
 	server sends ServerInitialisationMessage
 
-Thereafter, client and server enter message processing loops. The first byte identifies the message type, which dictates the length of the payload, so all clients and servers must process all event types. Each message's Read function verifies the presence of the message type byte.
+Versions 3.7 and 3.8 let the client choose the security type:
+
+	server sends ProtocolVersionMessage
+	client sends ProtocolVersionMessage
+	server sends SecurityTypesMessageRFB37
+	client sends SecurityTypeSelectionMessageRFB37
+		If SecurityTypeNone:
+			server sends SecurityResultMessageRFB38 (3.8 only)
+		If SecurityTypeVNC:
+			server sends VNCAuthenticationChallengeMessage
+			client sends VNCAuthenticationResponseMessage
+			server sends VNCAuthenticationResultMessage (3.7) or SecurityResultMessageRFB38 (3.8)
+	client sends ClientInitialisationMessage
+	server sends ServerInitialisationMessage
+
+ServerHandshake and ClientHandshake run either side of the handshake. WithContext applies a context's deadline and cancellation to reads and writes. Afterwards, servers can exchange messages through a Conn, which is safe for concurrent sends.
+
+Thereafter, client and server enter message processing loops. The first byte identifies the message type, which dictates the length of the payload, so all clients and servers must process all event types. Each message's Read function verifies the presence of the message type byte. ReadClientMessage and ReadServerMessage read whichever message comes next. Every message has a String method for logging, and Dump describes one in more detail, eliding pixel data. Trace wraps a connection to log every message in both directions.
 
 Clients may send:
 
 	Type 0	SetPixelFormatMessage
-	Type 1	FixColourMapEntries — uncommon, not implemented by this library
+	Type 1	FixColourMapEntriesMessage — uncommon
 	Type 2	SetEncodingsMessage
 	Type 3	FramebufferUpdateRequestMessage
 	Type 4	KeyEventMessage
 	Type 5	PointerEventMessage
 	Type 6	ClientCutTextMessage
+	Type 7	FileTransferMessage — UltraVNC extension, answered with FileTransfer
+	Type 150	EnableContinuousUpdatesMessage — only if the server has sent EndOfContinuousUpdatesMessage
+	Type 248	FenceMessage — only if the server has sent one
+	Type 250	XVPMessage — only if the server has sent one with XVPInit
+	Type 251	SetDesktopSizeMessage — only if the server has sent an ExtendedDesktopSize rectangle
 
 Servers may send:
 
-	Type 0	FramebufferUpdateMessage — only in response to FramebufferUpdateRequestMessage
-	Type 1	SetColourMapEntries — uncommon, not implemented by this library
+	Type 0	FramebufferUpdateMessage — in response to FramebufferUpdateRequestMessage, or as the region enabled by EnableContinuousUpdatesMessage changes
+	Type 1	SetColourMapEntriesMessage — only to clients whose PixelFormat isn't TrueColor
 	Type 2	BellMessage
 	Type 3	ServerCutTextMessage
+	Type 7	FileTransferMessage — only in reply to one from the client
+	Type 150	EndOfContinuousUpdatesMessage — only to clients that list EncodingTypeContinuousUpdates
+	Type 248	FenceMessage — only to clients that list EncodingTypeFence
+	Type 250	XVPMessage — only to clients that list EncodingTypeXVP
 */
 package rfb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"golang.org/x/text/encoding/charmap"
+	"image"
 	"io"
 )
 
@@ -55,7 +83,7 @@ func (m *ProtocolVersionMessage) Read(r io.Reader) error {
 		return err
 	}
 	if _, err := fmt.Sscanf(string(buf[:]), "RFB %03d.%03d\n", &m.Major, &m.Minor); err != nil {
-		return fmt.Errorf("parse: %v", err)
+		return fmt.Errorf("parse: %w", err)
 	}
 	return nil
 }
@@ -71,8 +99,10 @@ func (m *ProtocolVersionMessage) Write(w io.Writer) error {
 	return nil
 }
 
+// AuthenticationSchemeMessageRFB33 is the server's choice of security type in version 3.3. If Scheme is AuthenticationSchemeInvalid, the connection failed for the given Reason and the server will close it.
 type AuthenticationSchemeMessageRFB33 struct {
 	Scheme AuthenticationScheme
+	Reason string // Only sent if Scheme is AuthenticationSchemeInvalid
 }
 
 type AuthenticationScheme uint32
@@ -89,6 +119,14 @@ func (m *AuthenticationSchemeMessageRFB33) Read(r io.Reader, bo binary.ByteOrder
 		return err
 	}
 	m.Scheme = AuthenticationScheme(bo.Uint32(buf[:]))
+	m.Reason = ""
+	if m.Scheme == AuthenticationSchemeInvalid {
+		reason, err := readReason(r, bo)
+		if err != nil {
+			return fmt.Errorf("read failure reason: %w", err)
+		}
+		m.Reason = reason
+	}
 	return nil
 }
 
@@ -98,6 +136,9 @@ func (m *AuthenticationSchemeMessageRFB33) Write(w io.Writer, bo binary.ByteOrde
 	if _, err := w.Write(buf[:]); err != nil {
 		return err
 	}
+	if m.Scheme == AuthenticationSchemeInvalid {
+		return writeReason(w, bo, m.Reason)
+	}
 	return nil
 }
 
@@ -153,6 +194,153 @@ func (m *VNCAuthenticationResultMessage) Write(w io.Writer, bo binary.ByteOrder)
 	return err
 }
 
+// SecurityTypesMessageRFB37 lists the security types the server supports. If Types is empty, the connection failed for the given Reason and the server will close it.
+type SecurityTypesMessageRFB37 struct {
+	Types  []SecurityType
+	Reason string
+}
+
+type SecurityType uint8
+
+const (
+	SecurityTypeInvalid = SecurityType(0)
+	SecurityTypeNone    = SecurityType(1)
+	SecurityTypeVNC     = SecurityType(2)
+
+	// SecurityTypeRA2 and SecurityTypeRA2ne are the RSA-AES schemes, which RSAAESSecurityHandler implements. With SecurityTypeRA2ne, only authentication is encrypted.
+	SecurityTypeRA2   = SecurityType(5)
+	SecurityTypeRA2ne = SecurityType(6)
+
+	// SecurityTypeTLS upgrades the connection with a TLS handshake, after which security negotiation starts over, beginning with SecurityTypesMessageRFB37.
+	SecurityTypeTLS = SecurityType(18)
+)
+
+func (m *SecurityTypesMessageRFB37) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [255]byte
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return err
+	}
+	count := int(buf[0])
+	if _, err := io.ReadFull(r, buf[:count]); err != nil {
+		return err
+	}
+	m.Types = nil
+	for _, t := range buf[:count] {
+		m.Types = append(m.Types, SecurityType(t))
+	}
+	m.Reason = ""
+	if count == 0 {
+		reason, err := readReason(r, bo)
+		if err != nil {
+			return fmt.Errorf("read failure reason: %w", err)
+		}
+		m.Reason = reason
+	}
+	return nil
+}
+
+func (m *SecurityTypesMessageRFB37) Write(w io.Writer, bo binary.ByteOrder) error {
+	if len(m.Types) > 255 {
+		return fmt.Errorf("too many security types: %d > 255", len(m.Types))
+	}
+	buf := make([]byte, 1+len(m.Types))
+	buf[0] = uint8(len(m.Types))
+	for idx, t := range m.Types {
+		buf[1+idx] = uint8(t)
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	if len(m.Types) == 0 {
+		return writeReason(w, bo, m.Reason)
+	}
+	return nil
+}
+
+type SecurityTypeSelectionMessageRFB37 struct {
+	Type SecurityType
+}
+
+func (m *SecurityTypeSelectionMessageRFB37) Read(r io.Reader) error {
+	var buf [1]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	m.Type = SecurityType(buf[0])
+	return nil
+}
+
+func (m *SecurityTypeSelectionMessageRFB37) Write(w io.Writer) error {
+	_, err := w.Write([]byte{uint8(m.Type)})
+	return err
+}
+
+// SecurityResultMessageRFB38 ends the security handshake for every security type in version 3.8. Unlike VNCAuthenticationResultMessage, failures carry a Reason.
+type SecurityResultMessageRFB38 struct {
+	Result VNCAuthenticationResult
+	Reason string // Only sent if Result is not VNCAuthenticationResultOK
+}
+
+func (m *SecurityResultMessageRFB38) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	m.Result = VNCAuthenticationResult(bo.Uint32(buf[:]))
+	m.Reason = ""
+	if m.Result != VNCAuthenticationResultOK {
+		reason, err := readReason(r, bo)
+		if err != nil {
+			return fmt.Errorf("read failure reason: %w", err)
+		}
+		m.Reason = reason
+	}
+	return nil
+}
+
+func (m *SecurityResultMessageRFB38) Write(w io.Writer, bo binary.ByteOrder) error {
+	var buf [4]byte
+	bo.PutUint32(buf[:], uint32(m.Result))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if m.Result != VNCAuthenticationResultOK {
+		return writeReason(w, bo, m.Reason)
+	}
+	return nil
+}
+
+// maxReasonLength bounds the failure reasons this library will read.
+const maxReasonLength = 4096
+
+func readReason(r io.Reader, bo binary.ByteOrder) (string, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return "", err
+	}
+	length := bo.Uint32(buf[:])
+	if length > maxReasonLength {
+		return "", fmt.Errorf("reason is too long: %d > %d", length, maxReasonLength)
+	}
+	reason := make([]byte, length)
+	if _, err := io.ReadFull(r, reason); err != nil {
+		return "", err
+	}
+	return string(reason), nil
+}
+
+func writeReason(w io.Writer, bo binary.ByteOrder, reason string) error {
+	var buf [4]byte
+	bo.PutUint32(buf[:], uint32(len(reason)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(reason)); err != nil {
+		return err
+	}
+	return nil
+}
+
 type ClientInitialisationMessage struct {
 	// If true, share the desktop with other clients.
 	// If false, disconnect all other clients.
@@ -186,22 +374,26 @@ type ServerInitialisationMessage struct {
 	Name              string
 }
 
+// maxNameLength bounds the desktop names this library will read.
+const maxNameLength = 1 << 16
+
 func (m *ServerInitialisationMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [255]byte
-	if _, err := io.ReadFull(r, buf[:24]); err != nil {
+	var buf [24]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
 		return err
 	}
 	m.FramebufferWidth = bo.Uint16(buf[0:])
 	m.FramebufferHeight = bo.Uint16(buf[2:])
 	m.PixelFormat.Read(buf[4:], bo)
 	nameLength := bo.Uint32(buf[20:])
-	if int(nameLength) > len(buf) {
-		return fmt.Errorf("name is too long: %d > %d", nameLength, len(buf))
+	if nameLength > maxNameLength {
+		return fmt.Errorf("name is too long: %d > %d", nameLength, maxNameLength)
 	}
-	if _, err := io.ReadFull(r, buf[:nameLength]); err != nil {
+	name := make([]byte, nameLength)
+	if _, err := io.ReadFull(r, name); err != nil {
 		return err
 	}
-	m.Name = string(buf[:nameLength])
+	m.Name = string(name)
 	return nil
 }
 
@@ -211,13 +403,9 @@ func (m *ServerInitialisationMessage) Write(w io.Writer, bo binary.ByteOrder) er
 	bo.PutUint16(buf[2:], m.FramebufferHeight)
 	m.PixelFormat.Write(buf[4:], bo)
 	bo.PutUint32(buf[20:], uint32(len([]byte(m.Name))))
-	if _, err := w.Write(buf[:]); err != nil {
-		return err
-	}
-	if _, err := w.Write([]byte(m.Name)); err != nil {
-		return err
-	}
-	return nil
+	// One write, since an empty one blocks on some connections, such as net.Pipe.
+	_, err := w.Write(append(buf[:], m.Name...))
+	return err
 }
 
 type SetPixelFormatMessage struct {
@@ -230,14 +418,14 @@ func (m *SetPixelFormatMessage) Read(r io.Reader, bo binary.ByteOrder) error {
 		return err
 	}
 	if buf[0] != 0 {
-		return fmt.Errorf("expected message type 0, but found %d", buf[0])
+		return protocolErrorf(buf[0], 0, "expected message type 0")
 	}
 	m.PixelFormat.Read(buf[4:], bo)
 	return nil
 }
 
 func (m *SetPixelFormatMessage) Write(w io.Writer, bo binary.ByteOrder) error {
-	var buf [16]byte
+	var buf [20]byte
 	m.PixelFormat.Write(buf[4:], bo)
 	if _, err := w.Write(buf[:]); err != nil {
 		return err
@@ -257,42 +445,44 @@ const (
 	EncodingTypeHextile       = uint32(5)
 )
 
+// maxEncodingCount bounds the encoding types this library will read from a SetEncodingsMessage. Real clients list a few dozen.
+const maxEncodingCount = 1024
+
 func (m *SetEncodingsMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [255]byte
-	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
 		return err
 	}
-	if buf[0] != 2 {
-		return fmt.Errorf("expected message type 2, but found %d", buf[0])
+	if header[0] != 2 {
+		return protocolErrorf(header[0], 0, "expected message type 2")
 	}
-	encodingCount := bo.Uint16(buf[2:])
-	if int(encodingCount) > len(buf)/4 {
-		return fmt.Errorf("too many encodings: %d > %d", encodingCount, len(buf)/4)
+	encodingCount := int(bo.Uint16(header[2:]))
+	if encodingCount > maxEncodingCount {
+		return protocolErrorf(header[0], 2, "too many encodings: %d > %d", encodingCount, maxEncodingCount)
 	}
-	if _, err := io.ReadFull(r, buf[:encodingCount*4]); err != nil {
+	buf := make([]byte, 4*encodingCount)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
-	m.EncodingTypes = nil
-	for i := uint16(0); i < encodingCount; i++ {
-		m.EncodingTypes = append(m.EncodingTypes, bo.Uint32(buf[i*4:]))
+	m.EncodingTypes = make([]uint32, encodingCount)
+	for i := range m.EncodingTypes {
+		m.EncodingTypes[i] = bo.Uint32(buf[i*4:])
 	}
 	return nil
 }
 
 func (m *SetEncodingsMessage) Write(w io.Writer, bo binary.ByteOrder) error {
-	var buf [255]byte
-
-	maxCount := len(buf[4:]) / 4
-	if len(m.EncodingTypes) > maxCount {
-		return fmt.Errorf("too many encoding types: %d > %d", len(m.EncodingTypes), maxCount)
+	if len(m.EncodingTypes) > maxEncodingCount {
+		return fmt.Errorf("too many encoding types: %d > %d", len(m.EncodingTypes), maxEncodingCount)
 	}
 
+	buf := make([]byte, 4+4*len(m.EncodingTypes))
 	buf[0] = 2
 	bo.PutUint16(buf[2:], uint16(len(m.EncodingTypes)))
 	for idx, encodingType := range m.EncodingTypes {
 		bo.PutUint32(buf[4+idx*4:], encodingType)
 	}
-	if _, err := w.Write(buf[:4+4*len(m.EncodingTypes)]); err != nil {
+	if _, err := w.Write(buf); err != nil {
 		return err
 	}
 	return nil
@@ -315,7 +505,7 @@ func (m *FramebufferUpdateRequestMessage) Read(r io.Reader, bo binary.ByteOrder)
 		return err
 	}
 	if buf[0] != 3 {
-		return fmt.Errorf("expected message type 3, but found %d", buf[0])
+		return protocolErrorf(buf[0], 0, "expected message type 3")
 	}
 
 	m.Incremental = buf[1] != 0
@@ -356,7 +546,7 @@ func (m *KeyEventMessage) Read(r io.Reader, bo binary.ByteOrder) error {
 		return err
 	}
 	if buf[0] != 4 {
-		return fmt.Errorf("expected message type 4, but found %d", buf[0])
+		return protocolErrorf(buf[0], 0, "expected message type 4")
 	}
 	m.Pressed = buf[1] != 0
 	m.KeySym = bo.Uint32(buf[4:])
@@ -388,7 +578,7 @@ func (m *PointerEventMessage) Read(r io.Reader, bo binary.ByteOrder) error {
 		return err
 	}
 	if buf[0] != 5 {
-		return fmt.Errorf("expected message type 5, but found %d", buf[0])
+		return protocolErrorf(buf[0], 0, "expected message type 5")
 	}
 	m.ButtonMask = buf[1]
 	m.X = bo.Uint16(buf[2:])
@@ -410,35 +600,60 @@ func (m *PointerEventMessage) Write(w io.Writer, bo binary.ByteOrder) error {
 
 type ClientCutTextMessage struct {
 	Text string
+
+	// Extended, if set, is sent instead of Text. Only use it with peers that list EncodingTypeExtendedClipboard.
+	Extended *ExtendedClipboard
 }
 
 func (m *ClientCutTextMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [255]byte
-	if _, err := io.ReadFull(r, buf[:8]); err != nil {
-		return err
+	var err error
+	m.Text, m.Extended, err = readCutText(r, bo, 6)
+	return err
+}
+
+// MaxCutTextLength bounds the text that ClientCutTextMessage and ServerCutTextMessage will read, so that a peer can't make them allocate without limit. Longer text is a *ProtocolError.
+var MaxCutTextLength = uint32(16 << 20)
+
+func readCutText(r io.Reader, bo binary.ByteOrder, messageType uint8) (string, *ExtendedClipboard, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return "", nil, err
 	}
-	if buf[0] != 6 {
-		return fmt.Errorf("expected message type 6, but found %d", buf[0])
+	if buf[0] != messageType {
+		return "", nil, protocolErrorf(buf[0], 0, "expected message type %d", messageType)
 	}
 	textLength := bo.Uint32(buf[4:])
-	if int(textLength) > len(buf) {
-		return fmt.Errorf("text length too long: %d > %d", textLength, len(buf))
+	if int32(textLength) < 0 {
+		extended, err := readExtendedClipboard(r, textLength)
+		if err != nil {
+			return "", nil, fmt.Errorf("read extended clipboard: %w", err)
+		}
+		return "", extended, nil
 	}
-	if _, err := io.ReadFull(r, buf[:textLength]); err != nil {
-		return err
+	if textLength > MaxCutTextLength {
+		return "", nil, protocolErrorf(buf[0], 4, "text length too long: %d > %d", textLength, MaxCutTextLength)
+	}
+	// The buffer grows as the text arrives, rather than trusting the length up front.
+	var text bytes.Buffer
+	if n, err := io.Copy(&text, io.LimitReader(r, int64(textLength))); err != nil {
+		return "", nil, err
+	} else if n < int64(textLength) {
+		return "", nil, io.ErrUnexpectedEOF
 	}
-	converted, err := charmap.ISO8859_1.NewDecoder().Bytes(buf[:textLength])
+	converted, err := charmap.ISO8859_1.NewDecoder().Bytes(text.Bytes())
 	if err != nil {
-		return fmt.Errorf("couldn't convert text to UTF-8 in ClientCutText: %v", err)
+		return "", nil, fmt.Errorf("convert text to UTF-8: %w", err)
 	}
-	m.Text = string(converted)
-	return nil
+	return string(converted), nil, nil
 }
 
 func (m *ClientCutTextMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if m.Extended != nil {
+		return writeExtendedClipboard(w, bo, 6, m.Extended)
+	}
 	converted, err := charmap.ISO8859_1.NewEncoder().Bytes([]byte(m.Text))
 	if err != nil {
-		return fmt.Errorf("encode text: %v", err)
+		return fmt.Errorf("encode text: %w", err)
 	}
 	if len(converted) > int(^uint32(0)) {
 		return fmt.Errorf("text too long: %d bytes > %d bytes", len(converted), ^uint32(0))
@@ -467,23 +682,27 @@ type FramebufferUpdateRect struct {
 	Height       uint16
 	EncodingType uint32 // Unsigned per spec, but often interpreted signed
 	PixelData    []byte
+
+	pool *BufferPool // Where PixelData came from, if anywhere.
 }
 
-func (m *FramebufferUpdateMessage) Read(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat) error {
+func (m *FramebufferUpdateMessage) Read(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat, encodings *Encodings) error {
 	var buf [4]byte
 	if _, err := io.ReadFull(r, buf[:]); err != nil {
 		return err
 	}
 	if buf[0] != 0 {
-		return fmt.Errorf("expected message type 0, but found %d", buf[0])
+		return protocolErrorf(buf[0], 0, "expected message type 0")
 	}
 	count := bo.Uint16(buf[2:])
 	m.Rectangles = nil
+	size := 0
 	for i := uint16(0); i < count; i++ {
 		rect := &FramebufferUpdateRect{}
-		if err := rect.Read(r, bo, pixelFormat); err != nil {
+		if err := rect.read(r, bo, pixelFormat, encodings, size); err != nil {
 			return err
 		}
+		size += len(rect.PixelData)
 		m.Rectangles = append(m.Rectangles, rect)
 	}
 	return nil
@@ -504,7 +723,13 @@ func (m *FramebufferUpdateMessage) Write(w io.Writer, bo binary.ByteOrder) error
 	return nil
 }
 
-func (rect *FramebufferUpdateRect) Read(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat) error {
+// Read reads a rectangle, using encodings, or DefaultEncodings if it's nil, to find the end of its payload. CopyRect, Tight, and Open H.264 rectangles, and those of the pseudo-encodings in this package, are read even if they're not registered. Rectangles are subject to the encodings' Limits, as if each were a FramebufferUpdate of its own.
+func (rect *FramebufferUpdateRect) Read(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat, encodings *Encodings) error {
+	return rect.read(r, bo, pixelFormat, encodings, 0)
+}
+
+// read is Read for a rectangle of an update whose earlier rectangles had size bytes of payload.
+func (rect *FramebufferUpdateRect) read(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat, encodings *Encodings, size int) error {
 	var buf [12]byte
 	if _, err := io.ReadFull(r, buf[:]); err != nil {
 		return err
@@ -514,17 +739,119 @@ func (rect *FramebufferUpdateRect) Read(r io.Reader, bo binary.ByteOrder, pixelF
 	rect.Width = bo.Uint16(buf[4:])
 	rect.Height = bo.Uint16(buf[6:])
 	rect.EncodingType = bo.Uint32(buf[8:])
-	if rect.EncodingType != 0 {
-		// TODO: Allow caller to provide additional decoders.
-		return fmt.Errorf("only raw encoding is supported, but found %d", rect.EncodingType)
+
+	// Payloads whose length follows from the header are checked before they're allocated, and the rest once they've been read.
+	encoding := encodings.Lookup(rect.EncodingType)
+	limits := encodings.currentLimits()
+	if err := limits.checkRect(int(rect.Width), int(rect.Height), size+knownPayloadLength(rect, encoding, pixelFormat)); err != nil {
+		return err
 	}
-	rect.PixelData = make([]byte, int(pixelFormat.BitsPerPixel/8)*int(rect.Width)*int(rect.Height))
-	if _, err := io.ReadFull(r, rect.PixelData); err != nil {
+	if err := rect.readPixelData(r, pixelFormat, encoding, encodings.bufferPool()); err != nil {
+		return err
+	}
+	if err := limits.checkUpdate(size + len(rect.PixelData)); err != nil {
+		rect.Release()
 		return err
 	}
 	return nil
 }
 
+// knownPayloadLength returns the length of rect's payload if it follows from its header, or 0.
+func knownPayloadLength(rect *FramebufferUpdateRect, encoding Encoding, pixelFormat PixelFormat) int {
+	if _, ok := encoding.(RawEncoding); ok {
+		return int(pixelFormat.BitsPerPixel/8) * int(rect.Width) * int(rect.Height)
+	}
+	if encoding != nil {
+		return 0
+	}
+	switch rect.EncodingType {
+	case EncodingTypeCursor:
+		return int(pixelFormat.BitsPerPixel/8)*int(rect.Width)*int(rect.Height) + cursorMaskLength(int(rect.Width), int(rect.Height))
+	case EncodingTypeXCursor:
+		return xCursorLength(int(rect.Width), int(rect.Height))
+	}
+	return 0
+}
+
+// readPixelData reads the payload that follows rect's header.
+func (rect *FramebufferUpdateRect) readPixelData(r io.Reader, pixelFormat PixelFormat, encoding Encoding, pool *BufferPool) error {
+	rect.pool = pool
+	if encoding != nil {
+		payload, err := readPayload(r, encoding, rect.Bounds(), pixelFormat, pool)
+		if err != nil {
+			return fmt.Errorf("read encoding type %d: %w", rect.EncodingType, err)
+		}
+		rect.PixelData = payload
+		return nil
+	}
+	switch rect.EncodingType {
+	case EncodingTypeCopyRectangle:
+		rect.PixelData = make([]byte, 4)
+		if _, err := io.ReadFull(r, rect.PixelData); err != nil {
+			return err
+		}
+	case EncodingTypeCursor, EncodingTypeXCursor:
+		rect.PixelData = pool.Get(knownPayloadLength(rect, nil, pixelFormat))
+		if _, err := io.ReadFull(r, rect.PixelData); err != nil {
+			return err
+		}
+	case EncodingTypeExtendedDesktopSize:
+		data, err := readExtendedDesktopSize(r)
+		if err != nil {
+			return fmt.Errorf("read ExtendedDesktopSize: %w", err)
+		}
+		rect.PixelData = data
+	case EncodingTypeTight:
+		payload := pool.buffer(int(pixelFormat.BitsPerPixel/8) * int(rect.Width) * int(rect.Height))
+		if _, err := readTight(io.TeeReader(r, payload), pixelFormat, pixelFormat.byteOrder(), int(rect.Width), int(rect.Height)); err != nil {
+			return fmt.Errorf("read Tight: %w", err)
+		}
+		rect.PixelData = payload.Bytes()
+	case EncodingTypeOpenH264:
+		payload := pool.buffer(int(pixelFormat.BitsPerPixel/8) * int(rect.Width) * int(rect.Height))
+		if _, _, err := readOpenH264(io.TeeReader(r, payload)); err != nil {
+			return fmt.Errorf("read Open H.264: %w", err)
+		}
+		rect.PixelData = payload.Bytes()
+	default:
+		return fmt.Errorf("%w: no encoding registered for encoding type %d", ErrUnsupportedEncoding, rect.EncodingType)
+	}
+	return nil
+}
+
+func (rect *FramebufferUpdateRect) Bounds() image.Rectangle {
+	return image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+}
+
+// Decode decodes PixelData with the encoding registered in encodings, or DefaultEncodings if it's nil.
+func (rect *FramebufferUpdateRect) Decode(pixelFormat PixelFormat, encodings *Encodings) (*PixelFormatImage, error) {
+	if encoding := encodings.Lookup(rect.EncodingType); encoding != nil {
+		r := bytes.NewReader(rect.PixelData)
+		img, err := encoding.Decode(r, rect.Bounds(), pixelFormat)
+		if err != nil {
+			return nil, fmt.Errorf("decode encoding type %d: %w", rect.EncodingType, err)
+		}
+		if r.Len() != 0 {
+			return nil, fmt.Errorf("decode encoding type %d: %d bytes of pixel data left over", rect.EncodingType, r.Len())
+		}
+		return img, nil
+	}
+	switch rect.EncodingType {
+	case EncodingTypeCopyRectangle:
+		return nil, fmt.Errorf("CopyRect rectangles refer to the client's framebuffer, so apply them with PixelFormatImage.CopyRect")
+	case EncodingTypeExtendedDesktopSize:
+		return nil, fmt.Errorf("ExtendedDesktopSize rectangles aren't part of the framebuffer, so decode them with ExtendedDesktopSize")
+	case EncodingTypeCursor, EncodingTypeXCursor:
+		return nil, fmt.Errorf("cursor rectangles aren't part of the framebuffer, so decode them with Cursor")
+	case EncodingTypeTight:
+		return nil, fmt.Errorf("Tight rectangles depend on earlier ones, so decode them with a TightDecoder")
+	case EncodingTypeOpenH264:
+		return nil, fmt.Errorf("Open H.264 rectangles depend on earlier ones, so decode them with an OpenH264Decoder")
+	default:
+		return nil, fmt.Errorf("%w: no encoding registered for encoding type %d", ErrUnsupportedEncoding, rect.EncodingType)
+	}
+}
+
 func (rect *FramebufferUpdateRect) Write(w io.Writer, bo binary.ByteOrder) error {
 	var buf [12]byte
 	bo.PutUint16(buf[0:], rect.X)
@@ -549,7 +876,7 @@ func (m *BellMessage) Read(r io.Reader) error {
 		return err
 	}
 	if buf[0] != 2 {
-		return fmt.Errorf("expected message type 2, but found %d", buf[0])
+		return protocolErrorf(buf[0], 0, "expected message type 2")
 	}
 	return nil
 }
@@ -561,35 +888,24 @@ func (m *BellMessage) Write(w io.Writer) error {
 
 type ServerCutTextMessage struct {
 	Text string
+
+	// Extended, if set, is sent instead of Text. Only use it with peers that list EncodingTypeExtendedClipboard.
+	Extended *ExtendedClipboard
 }
 
 func (m *ServerCutTextMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [255]byte
-	if _, err := io.ReadFull(r, buf[:8]); err != nil {
-		return err
-	}
-	if buf[0] != 3 {
-		return fmt.Errorf("expected message type 6, but found %d", buf[0])
-	}
-	textLength := bo.Uint32(buf[4:])
-	if int(textLength) > len(buf) {
-		return fmt.Errorf("text length too long: %d > %d", textLength, len(buf))
-	}
-	if _, err := io.ReadFull(r, buf[:textLength]); err != nil {
-		return err
-	}
-	converted, err := charmap.ISO8859_1.NewDecoder().Bytes(buf[:textLength])
-	if err != nil {
-		return fmt.Errorf("couldn't convert text to UTF-8 in ClientCutText: %v", err)
-	}
-	m.Text = string(converted)
-	return nil
+	var err error
+	m.Text, m.Extended, err = readCutText(r, bo, 3)
+	return err
 }
 
 func (m *ServerCutTextMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if m.Extended != nil {
+		return writeExtendedClipboard(w, bo, 3, m.Extended)
+	}
 	converted, err := charmap.ISO8859_1.NewEncoder().Bytes([]byte(m.Text))
 	if err != nil {
-		return fmt.Errorf("encode text: %v", err)
+		return fmt.Errorf("encode text: %w", err)
 	}
 	if len(converted) > int(^uint32(0)) {
 		return fmt.Errorf("text too long: %d bytes > %d bytes", len(converted), ^uint32(0))
@@ -613,7 +929,7 @@ type PixelFormat struct {
 	BigEndian    bool
 
 	// RGB definitions below are used if true.
-	// If false, palette mode is used, which is unsupported by this library.
+	// If false, pixels are indexes into a ColourMap.
 	TrueColor bool
 
 	RedMax     uint16
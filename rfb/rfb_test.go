@@ -0,0 +1,370 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSecurityTypesMessageRFB37(t *testing.T) {
+	tests := []struct {
+		m    SecurityTypesMessageRFB37
+		wire []byte
+	}{
+		{SecurityTypesMessageRFB37{Types: []SecurityType{SecurityTypeNone, SecurityTypeVNC}}, []byte{2, 1, 2}},
+		{SecurityTypesMessageRFB37{Reason: "no"}, []byte{0, 0, 0, 0, 2, 'n', 'o'}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := test.m.Write(&buf, binary.BigEndian); err != nil {
+			t.Fatalf("write %+v: %v", test.m, err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.wire) {
+			t.Errorf("expected %+v to be written as %v, but got %v", test.m, test.wire, buf.Bytes())
+		}
+		var m SecurityTypesMessageRFB37
+		if err := m.Read(&buf, binary.BigEndian); err != nil {
+			t.Fatalf("read %v: %v", test.wire, err)
+		}
+		if !reflect.DeepEqual(m, test.m) {
+			t.Errorf("expected %v to be read as %+v, but got %+v", test.wire, test.m, m)
+		}
+	}
+}
+
+func TestSecurityResultMessageRFB38(t *testing.T) {
+	tests := []struct {
+		m    SecurityResultMessageRFB38
+		wire []byte
+	}{
+		{SecurityResultMessageRFB38{Result: VNCAuthenticationResultOK}, []byte{0, 0, 0, 0}},
+		{SecurityResultMessageRFB38{Result: VNCAuthenticationResultFailed, Reason: "bad"}, []byte{0, 0, 0, 1, 0, 0, 0, 3, 'b', 'a', 'd'}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := test.m.Write(&buf, binary.BigEndian); err != nil {
+			t.Fatalf("write %+v: %v", test.m, err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.wire) {
+			t.Errorf("expected %+v to be written as %v, but got %v", test.m, test.wire, buf.Bytes())
+		}
+		var m SecurityResultMessageRFB38
+		if err := m.Read(&buf, binary.BigEndian); err != nil {
+			t.Fatalf("read %v: %v", test.wire, err)
+		}
+		if m != test.m {
+			t.Errorf("expected %v to be read as %+v, but got %+v", test.wire, test.m, m)
+		}
+	}
+}
+
+func TestVNCAuthentication(t *testing.T) {
+	challenge, err := NewVNCAuthenticationChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := NewVNCAuthenticationResponse(challenge, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Verify(challenge, "hunter2") {
+		t.Errorf("expected response to verify with the same password")
+	}
+	if response.Verify(challenge, "hunter3") {
+		t.Errorf("expected response not to verify with a different password")
+	}
+	if !response.Verify(challenge, "hunter2\x00") {
+		t.Errorf("expected passwords to be zero-padded")
+	}
+}
+
+func TestVNCAuthenticationResponseKnownValue(t *testing.T) {
+	// Computed with the key 0x0000000000000000, for which bit reversal is the identity.
+	var challenge VNCAuthenticationChallengeMessage
+	response, err := NewVNCAuthenticationResponse(challenge, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := VNCAuthenticationResponseMessage{0x8c, 0xa6, 0x4d, 0xe9, 0xc1, 0xb1, 0x23, 0xa7, 0x8c, 0xa6, 0x4d, 0xe9, 0xc1, 0xb1, 0x23, 0xa7}
+	if response != expected {
+		t.Errorf("expected %x, got %x", expected, response)
+	}
+}
+
+func TestReverseBits(t *testing.T) {
+	tests := []struct{ b, r byte }{
+		{0x00, 0x00},
+		{0x01, 0x80},
+		{0xd0, 0x0b},
+		{0xff, 0xff},
+	}
+	for _, test := range tests {
+		if r := reverseBits(test.b); r != test.r {
+			t.Errorf("expected reverseBits(%08b) to be %08b, got %08b", test.b, test.r, r)
+		}
+	}
+}
+
+type tokenSecurityHandler struct{ token byte }
+
+func (h tokenSecurityHandler) Type() SecurityType { return 200 }
+
+func (h tokenSecurityHandler) Authenticate(conn net.Conn, version ProtocolVersionMessage, bo binary.ByteOrder) (net.Conn, error) {
+	var buf [1]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		return nil, err
+	}
+	if buf[0] != h.token {
+		return conn, &SecurityFailure{"bad token"}
+	}
+	return conn, nil
+}
+
+func TestSecurityHandlersCustom(t *testing.T) {
+	for _, token := range []byte{42, 43} {
+		serverConn, clientConn := net.Pipe()
+		var handlers SecurityHandlers
+		handlers.Register(tokenSecurityHandler{42})
+		errc := make(chan error, 1)
+		go func() {
+			_, err := handlers.Serve(serverConn, ProtocolVersionMessage{3, 8}, binary.BigEndian)
+			serverConn.Close()
+			errc <- err
+		}()
+
+		var types SecurityTypesMessageRFB37
+		if err := types.Read(clientConn, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(types.Types, []SecurityType{200}) {
+			t.Fatalf("expected security types [200], got %v", types.Types)
+		}
+		if _, err := clientConn.Write([]byte{200, token}); err != nil {
+			t.Fatal(err)
+		}
+		var result SecurityResultMessageRFB38
+		if err := result.Read(clientConn, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+		err := <-errc
+		if token == 42 && (result.Result != VNCAuthenticationResultOK || err != nil) {
+			t.Errorf("expected token %d to be accepted, got %+v and %v", token, result, err)
+		}
+		if token != 42 && (result.Reason != "bad token" || err == nil) {
+			t.Errorf("expected token %d to be rejected, got %+v and %v", token, result, err)
+		}
+	}
+}
+
+func TestMSLogonIISecurityHandler(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	handler := &MSLogonIISecurityHandler{Verify: func(username, password string) bool {
+		return username == `DOMAIN\tom` && password == "hunter2"
+	}}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := handler.Authenticate(serverConn, ProtocolVersionMessage{3, 8}, binary.BigEndian)
+		errc <- err
+	}()
+
+	var params [24]byte
+	if _, err := io.ReadFull(clientConn, params[:]); err != nil {
+		t.Fatal(err)
+	}
+	generator := new(big.Int).SetUint64(binary.BigEndian.Uint64(params[0:]))
+	modulus := new(big.Int).SetUint64(binary.BigEndian.Uint64(params[8:]))
+	serverPublic := new(big.Int).SetUint64(binary.BigEndian.Uint64(params[16:]))
+	private := big.NewInt(12345)
+	public := new(big.Int).Exp(generator, private, modulus)
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], new(big.Int).Exp(serverPublic, private, modulus).Uint64())
+
+	username := make([]byte, 256)
+	copy(username, `DOMAIN\tom`)
+	password := make([]byte, 64)
+	copy(password, "hunter2")
+	username, _ = msLogonIIEncrypt(username, key)
+	password, _ = msLogonIIEncrypt(password, key)
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], public.Uint64())
+	if _, err := clientConn.Write(append(append(buf[:], username...), password...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("expected credentials to be accepted, got %v", err)
+	}
+}
+
+// TestMSLogonIIKnownAnswer checks the key agreement and decryption against credentials encrypted the way libvncclient's HandleMSLogonAuth does, with rfbClientEncryptBytes2, for fixed parameters and private values.
+func TestMSLogonIIKnownAnswer(t *testing.T) {
+	generator, modulus := big.NewInt(16807), big.NewInt(2147483647)
+	private := big.NewInt(123456789)
+	clientPrivate := new(big.Int).SetUint64(0x6b8b4567327b23c6)
+	if public := new(big.Int).Exp(generator, private, modulus); public.Uint64() != 2052144524 {
+		t.Errorf("expected the server's public value to be 2052144524, but got %v", public)
+	}
+	clientPublic := new(big.Int).Exp(generator, clientPrivate, modulus)
+	if clientPublic.Uint64() != 448308397 {
+		t.Errorf("expected the client's public value to be 448308397, but got %v", clientPublic)
+	}
+	key := [8]byte{0, 0, 0, 0, 0x56, 0x66, 0x9c, 0xac}
+
+	username, _ := hex.DecodeString(
+		"fe689fbe1cfb14f914949f6ff87d4768fc79dd454b1dbfd65abf37d700c4480d" +
+			"a2688b5832de0aca56433fea23045085aca014908306193738886d67fdfedf41" +
+			"968cade2a5d9273e7e132234a2c0d371ee6915e51ab011029e35374b92d11802" +
+			"5dc6ea8c5850b57b69d6b280e45015095738140a58acae9e7ac361e607260522" +
+			"af76cb3fb046b3c18bb40b323e22895d15050f958f2d00b5c3bef71af439d95b" +
+			"79f73b07ce8f2d52ad46f5fabd483a217acb877c2f1822f24987ef0bef427a71" +
+			"c533e0764ab8565b02c5d1709dc8ba5064d27aab28870bfafd4bdda4831107bb" +
+			"cf943ed02eace5086d7a51a0ba81bc7554ba06b3473889f4f33a2fc5d07a97d1")
+	password, _ := hex.DecodeString(
+		"d6b6082d93627e872dcd5cce485c110f00fa865eec91a19c54880f46be3d0450" +
+			"41702c142946ab4d4b0d4be8bd5967beeb4cbb72a7c288d43863714d7c2377d3")
+	for _, tt := range []struct {
+		plain, encrypted []byte
+	}{
+		{append([]byte(`DOMAIN\tom`), make([]byte, 246)...), username},
+		{append([]byte("hunter2"), make([]byte, 57)...), password},
+	} {
+		if got, err := msLogonIIEncrypt(tt.plain, key); err != nil || !bytes.Equal(got, tt.encrypted) {
+			t.Errorf("expected %q to be encrypted as %x, but got %x and %v", tt.plain, tt.encrypted, got, err)
+		}
+	}
+
+	credentials := make([]byte, 8)
+	binary.BigEndian.PutUint64(credentials, clientPublic.Uint64())
+	credentials = append(append(credentials, username...), password...)
+	if u, p, err := msLogonIICredentials(credentials, private, modulus, binary.BigEndian); err != nil || u != `DOMAIN\tom` || p != "hunter2" {
+		t.Errorf("expected DOMAIN\\tom and hunter2, but got %q, %q, and %v", u, p, err)
+	}
+}
+
+// msLogonIIEncrypt encrypts buf, whose length must be a multiple of 8, with DES in CBC mode. The initialization vector is the key, and the DES key is the key with the bits of each byte reversed, as in VNC authentication.
+func msLogonIIEncrypt(buf []byte, key [8]byte) ([]byte, error) {
+	block, err := msLogonIICipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(buf))
+	prev := key[:]
+	for i := 0; i+8 <= len(buf); i += 8 {
+		var in [8]byte
+		for j := range in {
+			in[j] = buf[i+j] ^ prev[j]
+		}
+		block.Encrypt(out[i:], in[:])
+		prev = out[i : i+8]
+	}
+	return out, nil
+}
+
+func TestXVPMessageRoundTrip(t *testing.T) {
+	m := XVPMessage{Version: XVPVersion, Code: XVPReboot}
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var m2 XVPMessage
+	if err := m2.Read(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if m2 != m {
+		t.Errorf("expected %+v, but got %+v", m, m2)
+	}
+}
+
+func TestCutTextLong(t *testing.T) {
+	text := strings.Repeat("pasté a paragraph\n", 1000)
+	var buf bytes.Buffer
+	if err := (&ClientCutTextMessage{Text: text}).Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&ServerCutTextMessage{Text: text}).Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	var m ClientCutTextMessage
+	if err := m.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	var m2 ServerCutTextMessage
+	if err := m2.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if m.Text != text || m2.Text != text {
+		t.Errorf("expected %d bytes of text back, but got %d and %d", len(text), len(m.Text), len(m2.Text))
+	}
+
+	// Lengths beyond the cap are rejected before any text is read.
+	header := []byte{6, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[4:], MaxCutTextLength+1)
+	var protocolErr *ProtocolError
+	if err := m.Read(bytes.NewReader(header), binary.BigEndian); !errors.As(err, &protocolErr) || protocolErr.Offset != 4 {
+		t.Errorf("expected a ProtocolError at byte 4, but got %v", err)
+	}
+	binary.BigEndian.PutUint32(header[4:], 10)
+	if err := m.Read(bytes.NewReader(append(header, "short"...)), binary.BigEndian); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for truncated text, but got %v", err)
+	}
+}
+
+func TestSetEncodingsAndNameBeyond255Bytes(t *testing.T) {
+	var encodings SetEncodingsMessage
+	for i := uint32(0); i < 100; i++ {
+		encodings.EncodingTypes = append(encodings.EncodingTypes, i)
+	}
+	serverInit := ServerInitialisationMessage{FramebufferWidth: 1, FramebufferHeight: 1, PixelFormat: pixelFormat, Name: strings.Repeat("name", 100)}
+	var buf bytes.Buffer
+	if err := encodings.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if err := serverInit.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	var encodings2 SetEncodingsMessage
+	if err := encodings2.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(encodings2, encodings) {
+		t.Errorf("expected %v, but got %v", &encodings, &encodings2)
+	}
+	var serverInit2 ServerInitialisationMessage
+	if err := serverInit2.Read(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if serverInit2 != serverInit {
+		t.Errorf("expected %v, but got %v", &serverInit, &serverInit2)
+	}
+}
+
+func TestFramebufferUpdateLimits(t *testing.T) {
+	// A 65535×65535 raw rectangle is rejected from its header alone.
+	header := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}
+	var m FramebufferUpdateMessage
+	var limitErr *LimitError
+	if err := m.Read(bytes.NewReader(header), binary.BigEndian, pixelFormat, nil); !errors.As(err, &limitErr) || limitErr.What != "rectangle pixels" {
+		t.Errorf("expected a LimitError for rectangle pixels, but got %v", err)
+	}
+
+	rect := &FramebufferUpdateRect{Width: 16, Height: 16, EncodingType: EncodingTypeRaw, PixelData: make([]byte, 16*16*4)}
+	var buf bytes.Buffer
+	if err := (&FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{rect, rect}}).Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	encodings := NewEncodings(RawEncoding{})
+	encodings.SetLimits(Limits{MaxUpdateBytes: 1500})
+	if err := m.Read(bytes.NewReader(buf.Bytes()), binary.BigEndian, pixelFormat, encodings); !errors.As(err, &limitErr) || limitErr.Size != 2048 || limitErr.Max != 1500 {
+		t.Errorf("expected a LimitError for 2048 of 1500 bytes, but got %v", err)
+	}
+	encodings.SetLimits(Limits{})
+	if err := m.Read(bytes.NewReader(buf.Bytes()), binary.BigEndian, pixelFormat, encodings); err != nil {
+		t.Errorf("expected no limits to read the update, but got %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+// Package rfbtest connects RFB clients and servers in memory, over net.Pipe, so that integration tests can script one side of a session and assert on the messages that the code under test sends, without real sockets.
+//
+// Connect runs a server under test and returns a fake Client that has finished the handshake with it; Accept does the opposite for clients under test. Either side's methods fail the test, rather than returning errors, and give up after Timeout, so that a server that never answers fails the test instead of hanging it.
+package rfbtest
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"image"
+	"image/draw"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Timeout bounds each read and write that helpers make, and how long Close waits for the other side to return.
+var Timeout = 10 * time.Second
+
+// Message is a message that either side can send: one with a Write method, with or without a byte order.
+type Message interface {
+	MessageType() uint8
+}
+
+// Pipe runs run on one end of a pipe and returns the other, and a channel that receives run's result once it returns, after which its end is closed. Use it to script a handshake byte by byte.
+func Pipe(t testing.TB, run func(conn net.Conn) error) (net.Conn, <-chan error) {
+	t.Helper()
+	near, far := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- run(far)
+		far.Close()
+	}()
+	return near, done
+}
+
+// Client is a fake client connected to a server under test.
+type Client struct {
+	t    testing.TB
+	done <-chan error
+
+	// Conn is the client's end of the pipe.
+	Conn net.Conn
+
+	// Handshake is what the handshake negotiated.
+	Handshake *rfb.ClientHandshakeResult
+
+	// PixelFormat is what updates are read with: the server's, until Send sends SetPixelFormat.
+	PixelFormat rfb.PixelFormat
+
+	// Framebuffer is what Apply has drawn, the size of the server's framebuffer.
+	Framebuffer *image.RGBA
+
+	tight rfb.TightDecoder
+}
+
+// Connect runs serve on one end of a pipe and the client side of the handshake on the other, with opts, failing t if the handshake fails.
+func Connect(t testing.TB, serve func(conn net.Conn) error, opts rfb.ClientHandshakeOptions) *Client {
+	t.Helper()
+	c, err := TryConnect(t, serve, opts)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	return c
+}
+
+// TryConnect is like Connect, but returns the handshake's error, with whatever serve returned, for tests of failed handshakes.
+func TryConnect(t testing.TB, serve func(conn net.Conn) error, opts rfb.ClientHandshakeOptions) (*Client, error) {
+	t.Helper()
+	conn, done := Pipe(t, serve)
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	handshake, err := rfb.ClientHandshake(ctx, conn, opts)
+	if err != nil {
+		conn.Close()
+		if serveErr := wait(done); serveErr != nil {
+			return nil, fmt.Errorf("%w (server: %v)", err, serveErr)
+		}
+		return nil, err
+	}
+	init := handshake.ServerInit
+	return &Client{
+		t:           t,
+		done:        done,
+		Conn:        conn,
+		Handshake:   handshake,
+		PixelFormat: init.PixelFormat,
+		Framebuffer: image.NewRGBA(image.Rect(0, 0, int(init.FramebufferWidth), int(init.FramebufferHeight))),
+	}, nil
+}
+
+// Send writes messages to the server, failing the test if any can't be written. It keeps PixelFormat up to date with any SetPixelFormat.
+func (c *Client) Send(messages ...Message) {
+	c.t.Helper()
+	for _, m := range messages {
+		if err := send(c.Conn, m); err != nil {
+			c.t.Fatal(err)
+		}
+		if m, ok := m.(*rfb.SetPixelFormatMessage); ok {
+			c.PixelFormat = m.PixelFormat
+		}
+	}
+}
+
+// Next reads the next message from the server, failing the test if there isn't one within Timeout.
+func (c *Client) Next() rfb.ServerMessage {
+	c.t.Helper()
+	c.Conn.SetReadDeadline(time.Now().Add(Timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	m, err := rfb.ReadServerMessage(c.Conn, binary.BigEndian, c.PixelFormat, nil)
+	if err != nil {
+		c.t.Fatalf("couldn't read server message: %v", err)
+	}
+	return m
+}
+
+// Expect reads the next message from the server and fails the test unless it's equal to want.
+func (c *Client) Expect(want rfb.ServerMessage) {
+	c.t.Helper()
+	if got := c.Next(); !reflect.DeepEqual(got, want) {
+		c.t.Fatalf("expected %v, but got %v", want, got)
+	}
+}
+
+// ExpectUpdate reads the next message from the server, fails the test unless it's a FramebufferUpdate, and applies it, as with Apply.
+func (c *Client) ExpectUpdate() *rfb.FramebufferUpdateMessage {
+	c.t.Helper()
+	m := c.Next()
+	u, ok := m.(*rfb.FramebufferUpdateMessage)
+	if !ok {
+		c.t.Fatalf("expected a FramebufferUpdate, but got %v", m)
+	}
+	c.Apply(u)
+	return u
+}
+
+// RequestUpdate asks for the whole framebuffer, or what changed in it if incremental, and returns the update, as with ExpectUpdate.
+func (c *Client) RequestUpdate(incremental bool) *rfb.FramebufferUpdateMessage {
+	c.t.Helper()
+	r := c.Framebuffer.Rect
+	c.Send(&rfb.FramebufferUpdateRequestMessage{Incremental: incremental, Width: uint16(r.Dx()), Height: uint16(r.Dy())})
+	return c.ExpectUpdate()
+}
+
+// Apply draws the rectangles of u into Framebuffer, failing the test if any can't be decoded or lie outside of it. Cursor shapes are checked but not drawn, and ExtendedDesktopSize rectangles resize Framebuffer.
+func (c *Client) Apply(u *rfb.FramebufferUpdateMessage) {
+	c.t.Helper()
+	for _, rect := range u.Rectangles {
+		r := rect.Bounds()
+		switch rect.EncodingType {
+		case rfb.EncodingTypeExtendedDesktopSize:
+			fb := image.NewRGBA(image.Rect(0, 0, int(rect.Width), int(rect.Height)))
+			draw.Draw(fb, fb.Rect, c.Framebuffer, image.ZP, draw.Src)
+			c.Framebuffer = fb
+		case rfb.EncodingTypeCursor, rfb.EncodingTypeXCursor:
+			if _, _, err := rect.Cursor(c.PixelFormat); err != nil {
+				c.t.Fatalf("couldn't decode cursor: %v", err)
+			}
+		case rfb.EncodingTypeCopyRectangle:
+			src, err := rect.CopyRectSource()
+			if err != nil {
+				c.t.Fatal(err)
+			}
+			if !r.In(c.Framebuffer.Rect) || !r.Sub(r.Min).Add(src).In(c.Framebuffer.Rect) {
+				c.t.Fatalf("CopyRect from %v to %v is outside of framebuffer %v", src, r, c.Framebuffer.Rect)
+			}
+			draw.Draw(c.Framebuffer, r, c.Framebuffer, src, draw.Src)
+		default:
+			if !r.In(c.Framebuffer.Rect) {
+				c.t.Fatalf("%s rectangle %v is outside of framebuffer %v", rfb.EncodingName(rect.EncodingType), r, c.Framebuffer.Rect)
+			}
+			var img *rfb.PixelFormatImage
+			var err error
+			if rect.EncodingType == rfb.EncodingTypeTight {
+				img, err = c.tight.Decode(rect, c.PixelFormat)
+			} else {
+				img, err = rect.Decode(c.PixelFormat, nil)
+			}
+			if err != nil {
+				c.t.Fatalf("couldn't decode %s rectangle %v: %v", rfb.EncodingName(rect.EncodingType), r, err)
+			}
+			err = img.CopyToImage(c.Framebuffer, r.Min)
+			img.Release()
+			if err != nil {
+				c.t.Fatal(err)
+			}
+		}
+	}
+}
+
+// Close disconnects from the server and returns what serve returned, failing the test if it doesn't return within Timeout.
+func (c *Client) Close() error {
+	c.t.Helper()
+	c.Conn.Close()
+	return c.Wait()
+}
+
+// Wait returns what serve returned, without disconnecting, failing the test if it doesn't return within Timeout.
+func (c *Client) Wait() error {
+	c.t.Helper()
+	select {
+	case err := <-c.done:
+		return err
+	case <-time.After(Timeout):
+		c.t.Fatalf("server didn't return within %v", Timeout)
+		return nil
+	}
+}
+
+// Server is a fake server connected to a client under test.
+type Server struct {
+	t    testing.TB
+	done <-chan error
+
+	// Conn is the server's end of the pipe.
+	Conn net.Conn
+
+	// Handshake is what the handshake negotiated.
+	Handshake *rfb.ServerHandshakeResult
+
+	// PixelFormat is what the client expects updates in: the one in ServerInit, until Next reads SetPixelFormat.
+	PixelFormat rfb.PixelFormat
+}
+
+// Accept runs client on one end of a pipe and the server side of the handshake on the other, with opts, failing t if the handshake fails. If opts.Init is nil, the framebuffer is 640x480 in rfb.PixelFormatRGBA8888LittleEndian, and named "rfbtest".
+func Accept(t testing.TB, client func(conn net.Conn) error, opts rfb.ServerHandshakeOptions) *Server {
+	t.Helper()
+	s, err := TryAccept(t, client, opts)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	return s
+}
+
+// TryAccept is like Accept, but returns the handshake's error, with whatever client returned, for tests of failed handshakes.
+func TryAccept(t testing.TB, client func(conn net.Conn) error, opts rfb.ServerHandshakeOptions) (*Server, error) {
+	t.Helper()
+	if opts.Init == nil {
+		opts.Init = func(rfb.ClientInitialisationMessage) (rfb.ServerInitialisationMessage, error) {
+			return rfb.ServerInitialisationMessage{FramebufferWidth: 640, FramebufferHeight: 480, PixelFormat: rfb.PixelFormatRGBA8888LittleEndian, Name: "rfbtest"}, nil
+		}
+	}
+	conn, done := Pipe(t, client)
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	handshake, err := rfb.ServerHandshake(ctx, conn, opts)
+	if err != nil {
+		conn.Close()
+		if clientErr := wait(done); clientErr != nil {
+			return nil, fmt.Errorf("%w (client: %v)", err, clientErr)
+		}
+		return nil, err
+	}
+	return &Server{t: t, done: done, Conn: handshake.Conn, Handshake: handshake, PixelFormat: handshake.ServerInit.PixelFormat}, nil
+}
+
+// Send writes messages to the client, failing the test if any can't be written.
+func (s *Server) Send(messages ...Message) {
+	s.t.Helper()
+	for _, m := range messages {
+		if err := send(s.Conn, m); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+}
+
+// SendImage encodes img in PixelFormat with each encoding type, as Raw if there are none, and sends it to the client in one update at img's bounds.
+func (s *Server) SendImage(img image.Image, encodingTypes ...uint32) {
+	s.t.Helper()
+	if len(encodingTypes) == 0 {
+		encodingTypes = []uint32{rfb.EncodingTypeRaw}
+	}
+	pfi, err := rfb.NewPixelFormatImage(s.PixelFormat, img.Bounds())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Rect, img, rgba.Rect.Min, draw.Src)
+	if err := pfi.CopyFromRGBA(rgba); err != nil {
+		s.t.Fatal(err)
+	}
+	u := &rfb.FramebufferUpdateMessage{}
+	for _, t := range encodingTypes {
+		rect, err := rfb.DefaultEncodings.Encode(t, pfi)
+		if err != nil {
+			s.t.Fatalf("couldn't encode %s: %v", rfb.EncodingName(t), err)
+		}
+		u.Rectangles = append(u.Rectangles, rect)
+	}
+	s.Send(u)
+}
+
+// Next reads the next message from the client, failing the test if there isn't one within Timeout. It keeps PixelFormat up to date with any SetPixelFormat.
+func (s *Server) Next() rfb.ClientMessage {
+	s.t.Helper()
+	s.Conn.SetReadDeadline(time.Now().Add(Timeout))
+	defer s.Conn.SetReadDeadline(time.Time{})
+	m, err := rfb.ReadClientMessage(s.Conn, binary.BigEndian)
+	if err != nil {
+		s.t.Fatalf("couldn't read client message: %v", err)
+	}
+	if m, ok := m.(*rfb.SetPixelFormatMessage); ok {
+		s.PixelFormat = m.PixelFormat
+	}
+	return m
+}
+
+// Expect reads the next message from the client and fails the test unless it's equal to want.
+func (s *Server) Expect(want rfb.ClientMessage) {
+	s.t.Helper()
+	if got := s.Next(); !reflect.DeepEqual(got, want) {
+		s.t.Fatalf("expected %v, but got %v", want, got)
+	}
+}
+
+// Close disconnects from the client and returns what client returned, failing the test if it doesn't return within Timeout.
+func (s *Server) Close() error {
+	s.t.Helper()
+	s.Conn.Close()
+	return s.Wait()
+}
+
+// Wait returns what client returned, without disconnecting, failing the test if it doesn't return within Timeout.
+func (s *Server) Wait() error {
+	s.t.Helper()
+	select {
+	case err := <-s.done:
+		return err
+	case <-time.After(Timeout):
+		s.t.Fatalf("client didn't return within %v", Timeout)
+		return nil
+	}
+}
+
+// wait returns what's sent to done, or nil if nothing is within Timeout.
+func wait(done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(Timeout):
+		return nil
+	}
+}
+
+// send writes m to conn, giving up after Timeout.
+func send(conn net.Conn, m Message) error {
+	conn.SetWriteDeadline(time.Now().Add(Timeout))
+	defer conn.SetWriteDeadline(time.Time{})
+	var err error
+	switch m := m.(type) {
+	case interface {
+		Write(io.Writer, binary.ByteOrder) error
+	}:
+		err = m.Write(conn, binary.BigEndian)
+	case interface{ Write(io.Writer) error }:
+		err = m.Write(conn)
+	default:
+		return fmt.Errorf("can't write %s", rfb.MessageName(m))
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't write %s: %w", rfb.MessageName(m), err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package rfbtest
+
+import (
+	"context"
+	"errors"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/rfbclient"
+	"github.com/alltom/vncfreethumb/vncserver"
+	"image"
+	"image/color"
+	"image/draw"
+	"net"
+	"testing"
+)
+
+// solidDesktop is a solid colour that turns blue once a key is pressed.
+type solidDesktop struct{ color color.RGBA }
+
+func (d *solidDesktop) Size() (width, height int) { return 4, 3 }
+
+func (d *solidDesktop) Render(r image.Rectangle) image.Image {
+	return image.NewUniform(d.color)
+}
+
+func (d *solidDesktop) HandleKey(event rfb.KeyEventMessage) {
+	d.color = color.RGBA{0, 0, 0xff, 0xff}
+}
+
+func (d *solidDesktop) HandlePointer(event rfb.PointerEventMessage) {}
+func (d *solidDesktop) HandleCutText(text string)                   {}
+
+func serveDesktop(d vncserver.Desktop, password string) func(conn net.Conn) error {
+	security := &rfb.SecurityHandlers{}
+	security.Register(&rfb.VNCSecurityHandler{Password: password})
+	return func(conn net.Conn) error {
+		return vncserver.ServeConn(context.Background(), conn, func() (vncserver.Desktop, error) { return d, nil }, &vncserver.Options{Name: "solid", Security: security})
+	}
+}
+
+func TestConnect(t *testing.T) {
+	d := &solidDesktop{color: color.RGBA{0xff, 0, 0, 0xff}}
+	c := Connect(t, serveDesktop(d, "secret"), rfb.ClientHandshakeOptions{Password: func() (string, error) { return "secret", nil }})
+	if got := c.Handshake.SecurityType; got != rfb.SecurityTypeVNC {
+		t.Errorf("expected VNC authentication, but got %v", got)
+	}
+	if got := c.Handshake.ServerInit.Name; got != "solid" {
+		t.Errorf("expected the desktop to be named solid, but got %q", got)
+	}
+
+	c.RequestUpdate(false)
+	if got, want := c.Framebuffer.RGBAAt(3, 2), d.color; got != want {
+		t.Errorf("expected %v, but got %v", want, got)
+	}
+
+	// Updates are read in the client's pixel format once it's sent.
+	c.Send(&rfb.SetPixelFormatMessage{PixelFormat: rfb.PixelFormatRGB565}, &rfb.KeyEventMessage{Pressed: true, KeySym: 'a'})
+	c.RequestUpdate(false)
+	if got, want := c.Framebuffer.RGBAAt(0, 0), (color.RGBA{0, 0, 0xff, 0xff}); got != want {
+		t.Errorf("expected %v after a key press, but got %v", want, got)
+	}
+
+	if err := c.Close(); err == nil {
+		t.Error("expected the session to fail once the client disconnected")
+	}
+}
+
+func TestTryConnectWrongPassword(t *testing.T) {
+	_, err := TryConnect(t, serveDesktop(&solidDesktop{}, "secret"), rfb.ClientHandshakeOptions{Password: func() (string, error) { return "guess", nil }})
+	var failure *rfb.SecurityFailure
+	if !errors.As(err, &failure) {
+		t.Errorf("expected a SecurityFailure, but got %v", err)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	updated := make(chan *image.RGBA, 1)
+	s := Accept(t, func(conn net.Conn) error {
+		var client *rfbclient.Client
+		var err error
+		client, err = rfbclient.NewClient(context.Background(), conn, &rfbclient.Options{
+			Encodings: []uint32{rfb.EncodingTypeRaw},
+			OnUpdate:  func([]image.Rectangle) { updated <- client.Image() },
+		})
+		if err != nil {
+			return err
+		}
+		return client.Run(context.Background())
+	}, rfb.ServerHandshakeOptions{})
+
+	s.Expect(&rfb.SetPixelFormatMessage{PixelFormat: rfb.PixelFormatRGBA8888LittleEndian})
+	s.Expect(&rfb.SetEncodingsMessage{EncodingTypes: []uint32{rfb.EncodingTypeRaw, rfb.EncodingTypeExtendedDesktopSize}})
+	s.Expect(&rfb.FramebufferUpdateRequestMessage{Width: 640, Height: 480})
+
+	green := color.RGBA{0, 0xff, 0, 0xff}
+	img := image.NewRGBA(image.Rect(10, 20, 30, 40))
+	draw.Draw(img, img.Rect, image.NewUniform(green), image.ZP, draw.Src)
+	s.SendImage(img)
+	if got := (<-updated).RGBAAt(15, 25); got != green {
+		t.Errorf("expected the client to draw %v, but got %v", green, got)
+	}
+	s.Expect(&rfb.FramebufferUpdateRequestMessage{Incremental: true, Width: 640, Height: 480})
+
+	if err := s.Close(); err == nil {
+		t.Error("expected the client to fail once the server disconnected")
+	}
+}
@@ -0,0 +1,175 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+// EncodeRRE encodes img as the payload of a FramebufferUpdateRect with EncodingTypeRRE: a background color and solid subrectangles. It suits flat regions; for busy ones, the payload can be larger than raw.
+func EncodeRRE(img *PixelFormatImage) []byte {
+	return encodeRRE(img, img.Rect, false)
+}
+
+// EncodeCoRRE returns rectangles that together cover img, split into tiles of at most 255×255 pixels. Tiles for which CoRRE would be larger than raw are sent raw.
+func EncodeCoRRE(img *PixelFormatImage) []*FramebufferUpdateRect {
+	var rects []*FramebufferUpdateRect
+	r := img.Rect
+	for y := r.Min.Y; y < r.Max.Y; y += coRRETileSize {
+		for x := r.Min.X; x < r.Max.X; x += coRRETileSize {
+			tile := image.Rect(x, y, x+coRRETileSize, y+coRRETileSize).Intersect(r)
+			rect := &FramebufferUpdateRect{
+				X: uint16(tile.Min.X), Y: uint16(tile.Min.Y), Width: uint16(tile.Dx()), Height: uint16(tile.Dy()),
+				EncodingType: EncodingTypeCoRRE, PixelData: encodeRRE(img, tile, true),
+			}
+			if raw := img.rawPixels(tile); len(rect.PixelData) >= len(raw) {
+				rect.EncodingType, rect.PixelData = EncodingTypeRaw, raw
+			}
+			rects = append(rects, rect)
+		}
+	}
+	return rects
+}
+
+// coRRETileSize is the largest width or height that CoRRE's one-byte subrectangle geometry can describe.
+const coRRETileSize = 255
+
+// encodeRRE encodes the part of img in r. Compact selects CoRRE's one-byte subrectangle geometry.
+func encodeRRE(img *PixelFormatImage, r image.Rectangle, compact bool) []byte {
+	pixels := img.tilePixels(r)
+	bg, _, _ := dominantColors(pixels)
+	subrects := solidSubrects(pixels, r.Dx(), r.Dy(), bg)
+
+	var buf bytes.Buffer
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(subrects)))
+	buf.Write(header[:])
+	img.writePixel(&buf, bg)
+	for _, sr := range subrects {
+		img.writePixel(&buf, sr.pixel)
+		if compact {
+			buf.Write([]byte{uint8(sr.x), uint8(sr.y), uint8(sr.w), uint8(sr.h)})
+			continue
+		}
+		var geometry [8]byte
+		binary.BigEndian.PutUint16(geometry[0:], uint16(sr.x))
+		binary.BigEndian.PutUint16(geometry[2:], uint16(sr.y))
+		binary.BigEndian.PutUint16(geometry[4:], uint16(sr.w))
+		binary.BigEndian.PutUint16(geometry[6:], uint16(sr.h))
+		buf.Write(geometry[:])
+	}
+	return buf.Bytes()
+}
+
+// DecodeRRE reads an RRE payload from r into img, whose bounds must be those of the FramebufferUpdateRect.
+func DecodeRRE(r io.Reader, img *PixelFormatImage) error {
+	return decodeRRE(r, img, false)
+}
+
+// DecodeCoRRE reads a CoRRE payload from r into img, whose bounds must be those of the FramebufferUpdateRect.
+func DecodeCoRRE(r io.Reader, img *PixelFormatImage) error {
+	return decodeRRE(r, img, true)
+}
+
+func decodeRRE(r io.Reader, img *PixelFormatImage, compact bool) error {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return err
+	}
+	count := binary.BigEndian.Uint32(buf[:])
+	bg, err := img.readPixel(r)
+	if err != nil {
+		return err
+	}
+	img.fill(img.Rect, bg)
+	for i := uint32(0); i < count; i++ {
+		p, err := img.readPixel(r)
+		if err != nil {
+			return err
+		}
+		var x, y, w, h int
+		if compact {
+			if _, err := io.ReadFull(r, buf[:4]); err != nil {
+				return err
+			}
+			x, y, w, h = int(buf[0]), int(buf[1]), int(buf[2]), int(buf[3])
+		} else {
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return err
+			}
+			x, y = int(binary.BigEndian.Uint16(buf[0:])), int(binary.BigEndian.Uint16(buf[2:]))
+			w, h = int(binary.BigEndian.Uint16(buf[4:])), int(binary.BigEndian.Uint16(buf[6:]))
+		}
+		sr := image.Rect(x, y, x+w, y+h).Add(img.Rect.Min)
+		if !sr.In(img.Rect) {
+			return fmt.Errorf("subrectangle %v is outside of rectangle %v", sr, img.Rect)
+		}
+		img.fill(sr, p)
+	}
+	return nil
+}
+
+// dominantColors returns the most common pixel value, another pixel value, and the number of distinct values.
+func dominantColors(pixels []uint32) (bg, fg uint32, count int) {
+	counts := make(map[uint32]int)
+	for _, p := range pixels {
+		counts[p]++
+	}
+	best := -1
+	for p, n := range counts {
+		if n > best || n == best && p < bg {
+			bg, best = p, n
+		}
+	}
+	for p := range counts {
+		if p != bg {
+			fg = p
+			break
+		}
+	}
+	return bg, fg, len(counts)
+}
+
+type subrect struct {
+	pixel      uint32
+	x, y, w, h int
+}
+
+// solidSubrects covers every pixel that isn't bg with solid rectangles, greedily.
+func solidSubrects(pixels []uint32, w, h int, bg uint32) []subrect {
+	covered := make([]bool, len(pixels))
+	var subrects []subrect
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := pixels[y*w+x]
+			if p == bg || covered[y*w+x] {
+				continue
+			}
+			// Extend right, then down as far as the whole row matches.
+			sw := 1
+			for x+sw < w && pixels[y*w+x+sw] == p && !covered[y*w+x+sw] {
+				sw++
+			}
+			sh := 1
+		rows:
+			for y+sh < h {
+				for i := 0; i < sw; i++ {
+					idx := (y+sh)*w + x + i
+					if pixels[idx] != p || covered[idx] {
+						break rows
+					}
+				}
+				sh++
+			}
+			for j := 0; j < sh; j++ {
+				for i := 0; i < sw; i++ {
+					covered[(y+j)*w+x+i] = true
+				}
+			}
+			subrects = append(subrects, subrect{p, x, y, sw, sh})
+		}
+	}
+	return subrects
+}
@@ -0,0 +1,82 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestRRERoundTrip(t *testing.T) {
+	for _, pf := range []PixelFormat{pixelFormat, pixelFormatWeird, pixelFormat16} {
+		for _, colors := range []int{1, 2, 50} {
+			r := image.Rect(3, 5, 3+45, 5+37)
+			src := randomImage(pf, r, colors, int64(colors))
+
+			rect := FramebufferUpdateRect{X: 3, Y: 5, Width: 45, Height: 37, EncodingType: EncodingTypeRRE, PixelData: EncodeRRE(src)}
+			var buf bytes.Buffer
+			if err := rect.Write(&buf, binary.BigEndian); err != nil {
+				t.Fatal(err)
+			}
+			var rect2 FramebufferUpdateRect
+			if err := rect2.Read(&buf, binary.BigEndian, pf, nil); err != nil {
+				t.Fatalf("read %d bpp, %d colors: %v", pf.BitsPerPixel, colors, err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("%d bpp, %d colors: expected Read to consume the whole payload, but %d bytes remain", pf.BitsPerPixel, colors, buf.Len())
+			}
+			dst, err := rect2.Decode(pf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(src.Pix, dst.Pix) {
+				t.Errorf("%d bpp, %d colors: decoded pixels differ from encoded pixels", pf.BitsPerPixel, colors)
+			}
+		}
+	}
+}
+
+func TestCoRRERoundTrip(t *testing.T) {
+	pf := pixelFormat16
+	// Large enough to need several tiles, with a busy corner that should fall back to raw.
+	r := image.Rect(0, 0, 600, 300)
+	src := randomImage(pf, r, 3, 1)
+	busy := randomImage(pf, image.Rect(0, 0, 255, 255), 1<<16, 2)
+	for y := 0; y < 255; y++ {
+		copy(src.Pix[src.idx(0, y):src.idx(255, y)], busy.Pix[busy.idx(0, y):busy.idx(255, y)])
+	}
+
+	rects := EncodeCoRRE(src)
+	if len(rects) != 6 {
+		t.Errorf("expected 6 tiles, but got %d", len(rects))
+	}
+	if rects[0].EncodingType != EncodingTypeRaw {
+		t.Errorf("expected the busy tile to be raw, but it had encoding %d", rects[0].EncodingType)
+	}
+
+	dst, err := NewPixelFormatImage(pf, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rect := range rects {
+		var buf bytes.Buffer
+		if err := rect.Write(&buf, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+		var rect2 FramebufferUpdateRect
+		if err := rect2.Read(&buf, binary.BigEndian, pf, nil); err != nil {
+			t.Fatalf("read %v: %v", rect2.Bounds(), err)
+		}
+		tile, err := rect2.Decode(pf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := tile.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			copy(dst.Pix[dst.idx(b.Min.X, y):dst.idx(b.Max.X, y)], tile.Pix[tile.idx(b.Min.X, y):tile.idx(b.Max.X, y)])
+		}
+	}
+	if !bytes.Equal(src.Pix, dst.Pix) {
+		t.Errorf("decoded pixels differ from encoded pixels")
+	}
+}
@@ -0,0 +1,300 @@
+package rfb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+)
+
+// SecurityTypeRA256 and SecurityTypeRAne256 are TigerVNC's variants of SecurityTypeRA2 and SecurityTypeRA2ne with 256-bit AES keys, derived with SHA-256 instead of SHA-1.
+const (
+	SecurityTypeRA256   = SecurityType(129)
+	SecurityTypeRAne256 = SecurityType(130)
+)
+
+// RSAAESSecurityHandler implements SecurityTypeRA2, SecurityTypeRA2ne, SecurityTypeRA256, and SecurityTypeRAne256, as TigerVNC does: the server and client exchange RSA public keys and random keys encrypted with them, prove they agree with a hash of both public keys, and then the client sends its credentials, all over AES in EAX mode. With SecurityTypeRA2 and SecurityTypeRA256, the rest of the session stays encrypted too.
+type RSAAESSecurityHandler struct {
+	// SecurityType is the one of the four types to implement. Register a handler for each type to offer several.
+	SecurityType SecurityType
+
+	// Key is the server's key, which clients can check is the one they saw before, as with SSH. It's required. Generating a new one each time the server starts makes them warn that it changed.
+	Key *rsa.PrivateKey
+
+	// Verify, if set, is called with the client's username and password and reports whether they are valid. Otherwise, only a password is asked for, and checked against Password and ViewOnlyPassword.
+	Verify func(username, password string) bool
+
+	// Password and ViewOnlyPassword are as for VNCSecurityHandler, except that every byte is significant: with neither set, any password is accepted, and ViewOnlyPassword authenticates clients as view-only, as with ViewOnly.
+	Password         string
+	ViewOnlyPassword string
+}
+
+const (
+	rsaAESMinKeyBits = 1024
+	rsaAESMaxKeyBits = 8192
+
+	// rsaAESMaxMessage is the most plaintext that each encrypted message carries, as in TigerVNC.
+	rsaAESMaxMessage = 8192
+
+	rsaAESSubtypeUserPass = 1
+	rsaAESSubtypePass     = 2
+)
+
+func (h *RSAAESSecurityHandler) Type() SecurityType {
+	return h.SecurityType
+}
+
+func (h *RSAAESSecurityHandler) Authenticate(conn net.Conn, version ProtocolVersionMessage, bo binary.ByteOrder) (net.Conn, error) {
+	keySize, newHash := 16, sha1.New
+	switch h.SecurityType {
+	case SecurityTypeRA2, SecurityTypeRA2ne:
+	case SecurityTypeRA256, SecurityTypeRAne256:
+		keySize, newHash = 32, sha256.New
+	default:
+		return nil, fmt.Errorf("%s isn't an RSA-AES security type", h.SecurityType)
+	}
+
+	serverKey := rsaAESPublicKey(&h.Key.PublicKey)
+	if _, err := conn.Write(serverKey); err != nil {
+		return nil, fmt.Errorf("write RSA-AES server key: %w", err)
+	}
+	clientKey, clientPublic, err := readRSAAESPublicKey(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	serverRandom := make([]byte, keySize)
+	if _, err := rand.Read(serverRandom); err != nil {
+		return nil, err
+	}
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, clientPublic, serverRandom)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt RSA-AES server random: %w", err)
+	}
+	if _, err := conn.Write(append([]byte{byte(len(encrypted) >> 8), byte(len(encrypted))}, encrypted...)); err != nil {
+		return nil, fmt.Errorf("write RSA-AES server random: %w", err)
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, fmt.Errorf("read RSA-AES client random: %w", err)
+	}
+	if n := int(binary.BigEndian.Uint16(length[:])); n != h.Key.Size() {
+		return nil, fmt.Errorf("RSA-AES client random is encrypted to %d bytes, but the server key is %d", n, h.Key.Size())
+	}
+	encrypted = make([]byte, h.Key.Size())
+	if _, err := io.ReadFull(conn, encrypted); err != nil {
+		return nil, fmt.Errorf("read RSA-AES client random: %w", err)
+	}
+	// If the client random doesn't decrypt, a random one stands in for it, so that the failure only shows when the hashes don't match, and reveals nothing about why.
+	clientRandom := make([]byte, keySize)
+	if _, err := rand.Read(clientRandom); err != nil {
+		return nil, err
+	}
+	if err := rsa.DecryptPKCS1v15SessionKey(rand.Reader, h.Key, encrypted, clientRandom); err != nil {
+		return nil, fmt.Errorf("decrypt RSA-AES client random: %w", err)
+	}
+
+	// Each direction has its own key, hashed from both randoms, the sender's first.
+	secure, err := newRSAAESConn(conn, rsaAESKey(newHash, clientRandom, serverRandom, keySize), rsaAESKey(newHash, serverRandom, clientRandom, keySize))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := secure.Write(rsaAESHash(newHash, serverKey, clientKey)); err != nil {
+		return nil, fmt.Errorf("write RSA-AES server hash: %w", err)
+	}
+	clientHash := make([]byte, newHash().Size())
+	if _, err := io.ReadFull(secure, clientHash); err != nil {
+		return nil, fmt.Errorf("read RSA-AES client hash: %w", err)
+	}
+	if subtle.ConstantTimeCompare(clientHash, rsaAESHash(newHash, clientKey, serverKey)) != 1 {
+		return nil, errors.New("RSA-AES client hash doesn't match the keys, so something between the client and server has replaced them")
+	}
+
+	subtype := byte(rsaAESSubtypePass)
+	if h.Verify != nil {
+		subtype = rsaAESSubtypeUserPass
+	}
+	if _, err := secure.Write([]byte{subtype}); err != nil {
+		return nil, fmt.Errorf("write RSA-AES subtype: %w", err)
+	}
+	username, err := readRSAAESCredential(secure)
+	if err != nil {
+		return nil, fmt.Errorf("read RSA-AES username: %w", err)
+	}
+	password, err := readRSAAESCredential(secure)
+	if err != nil {
+		return nil, fmt.Errorf("read RSA-AES password: %w", err)
+	}
+
+	// The security result, and what follows, is only encrypted with RA2 and RA256.
+	result := net.Conn(secure)
+	if h.SecurityType == SecurityTypeRA2ne || h.SecurityType == SecurityTypeRAne256 {
+		result = conn
+	}
+	equal := func(expected string) bool {
+		return expected != "" && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+	}
+	switch {
+	case h.Verify != nil:
+		if !h.Verify(username, password) {
+			return result, &SecurityFailure{"wrong username or password"}
+		}
+	case equal(h.Password):
+	case equal(h.ViewOnlyPassword):
+		return ViewOnly(result), nil
+	case h.Password != "" || h.ViewOnlyPassword != "":
+		return result, &SecurityFailure{"wrong password"}
+	}
+	return result, nil
+}
+
+// rsaAESPublicKey encodes key as the RSA-AES security types send it: its length in bits, then the modulus and the exponent, each big-endian in as many bytes as the modulus takes.
+func rsaAESPublicKey(key *rsa.PublicKey) []byte {
+	size := key.Size()
+	buf := make([]byte, 4+2*size)
+	binary.BigEndian.PutUint32(buf, uint32(key.N.BitLen()))
+	key.N.FillBytes(buf[4 : 4+size])
+	big.NewInt(int64(key.E)).FillBytes(buf[4+size:])
+	return buf
+}
+
+// readRSAAESPublicKey reads the client's public key, returning it both as sent, for hashing, and parsed.
+func readRSAAESPublicKey(r io.Reader) ([]byte, *rsa.PublicKey, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, nil, fmt.Errorf("read RSA-AES client key: %w", err)
+	}
+	bits := binary.BigEndian.Uint32(buf)
+	if bits < rsaAESMinKeyBits || bits > rsaAESMaxKeyBits {
+		return nil, nil, fmt.Errorf("RSA-AES client key is %d bits, but must be from %d to %d", bits, rsaAESMinKeyBits, rsaAESMaxKeyBits)
+	}
+	size := int(bits+7) / 8
+	buf = append(buf, make([]byte, 2*size)...)
+	if _, err := io.ReadFull(r, buf[4:]); err != nil {
+		return nil, nil, fmt.Errorf("read RSA-AES client key: %w", err)
+	}
+	n := new(big.Int).SetBytes(buf[4 : 4+size])
+	e := new(big.Int).SetBytes(buf[4+size:])
+	if n.BitLen() != int(bits) || e.BitLen() > 31 || e.Int64() < 3 || e.Bit(0) == 0 {
+		return nil, nil, errors.New("RSA-AES client key is invalid")
+	}
+	return buf, &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+// rsaAESKey derives an AES key from the randoms, the sender's first.
+func rsaAESKey(newHash func() hash.Hash, first, second []byte, size int) []byte {
+	h := newHash()
+	h.Write(first)
+	h.Write(second)
+	return h.Sum(nil)[:size]
+}
+
+// rsaAESHash is what each side sends to prove it has the same keys, as encoded by rsaAESPublicKey, its own first.
+func rsaAESHash(newHash func() hash.Hash, own, other []byte) []byte {
+	h := newHash()
+	h.Write(own)
+	h.Write(other)
+	return h.Sum(nil)
+}
+
+// readRSAAESCredential reads a username or password, which is preceded by its length in a byte.
+func readRSAAESCredential(r io.Reader) (string, error) {
+	var length [1]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", err
+	}
+	buf := make([]byte, length[0])
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// rsaAESConn is a connection encrypted as the RSA-AES security types do it, in messages of a two-byte length, then that many bytes of AES-EAX ciphertext, then the tag. The length is the additional data, and the nonce counts messages in each direction, little-endian from zero.
+type rsaAESConn struct {
+	net.Conn
+
+	in      cipher.AEAD
+	inNonce [eaxTagSize]byte
+	unread  []byte // Decrypted, but not yet read.
+
+	out      cipher.AEAD
+	outNonce [eaxTagSize]byte
+	outMu    sync.Mutex // Keeps messages whole, and in nonce order, when written concurrently.
+}
+
+// newRSAAESConn returns conn, decrypting what it reads with inKey, and encrypting what it writes with outKey.
+func newRSAAESConn(conn net.Conn, inKey, outKey []byte) (*rsaAESConn, error) {
+	inBlock, err := aes.NewCipher(inKey)
+	if err != nil {
+		return nil, err
+	}
+	outBlock, err := aes.NewCipher(outKey)
+	if err != nil {
+		return nil, err
+	}
+	return &rsaAESConn{Conn: conn, in: newEAX(inBlock), out: newEAX(outBlock)}, nil
+}
+
+// increment adds one to nonce, a little-endian counter.
+func increment(nonce *[eaxTagSize]byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+func (c *rsaAESConn) Read(p []byte) (int, error) {
+	for len(c.unread) == 0 {
+		var length [2]byte
+		if _, err := io.ReadFull(c.Conn, length[:]); err != nil {
+			return 0, err
+		}
+		message := make([]byte, int(binary.BigEndian.Uint16(length[:]))+eaxTagSize)
+		if _, err := io.ReadFull(c.Conn, message); err != nil {
+			return 0, err
+		}
+		plaintext, err := c.in.Open(message[:0], c.inNonce[:], message, length[:])
+		if err != nil {
+			return 0, fmt.Errorf("RSA-AES: %w", err)
+		}
+		increment(&c.inNonce)
+		c.unread = plaintext
+	}
+	n := copy(p, c.unread)
+	c.unread = c.unread[n:]
+	return n, nil
+}
+
+func (c *rsaAESConn) Write(p []byte) (int, error) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > rsaAESMaxMessage {
+			n = rsaAESMaxMessage
+		}
+		message := c.out.Seal([]byte{byte(n >> 8), byte(n)}, c.outNonce[:], p[:n], []byte{byte(n >> 8), byte(n)})
+		increment(&c.outNonce)
+		if _, err := c.Conn.Write(message); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
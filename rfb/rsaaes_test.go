@@ -0,0 +1,283 @@
+package rfb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// TestEAX checks EAX against values computed with OpenSSL's CMAC and AES-CTR, as N = OMAC0(nonce), H = OMAC1(header), C = CTR from N, and tag N ^ H ^ OMAC2(C), with the nonce 01 00 … 00 and the two-byte length as the header, as the RSA-AES security types use them.
+func TestEAX(t *testing.T) {
+	tests := []struct {
+		keySize, length int
+		sealed          string
+	}{
+		{16, 0, "3c5e3dfc7e7dc27f2b2ae1020c7b6cb2"},
+		{16, 5, "e0039ef493aec2c700a9e654e79a302c9b30bf62ed"},
+		{16, 16, "e0039ef493f1e6ae9a7a90676b6384151007b0d23729c79daad125d5b75ac61a"},
+		{16, 37, "e0039ef493f1e6ae9a7a90676b63841581ac4dae3c112fcda7bdd463438091936df47b39b624e81092cb27729596d10b6ca819a89c"},
+		{32, 0, "14b2dc78181bfad5ad47586db08bcb37"},
+		{32, 5, "2143ba06eb1727bec8f1e87f228df5f336d9f24599"},
+		{32, 16, "2143ba06eb7c02d0adb957373b205acf311e5eae91e47d85be153c9aedf86e61"},
+		{32, 37, "2143ba06eb7c02d0adb957373b205acfddd1fc5ca403d427b96454de45d7bad2d458ae627a72030fe0120ec32d5bb5e2634add6a02"},
+	}
+	for _, test := range tests {
+		key := make([]byte, test.keySize)
+		for i := range key {
+			key[i] = byte(i)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead := newEAX(block)
+		nonce := make([]byte, 16)
+		nonce[0] = 1
+		header := []byte{0, byte(test.length)}
+		plaintext := make([]byte, test.length)
+		for i := range plaintext {
+			plaintext[i] = byte(i * 7)
+		}
+
+		sealed := aead.Seal(nil, nonce, plaintext, header)
+		if hex.EncodeToString(sealed) != test.sealed {
+			t.Errorf("%d-byte key, %d bytes: expected %s, but got %x", test.keySize, test.length, test.sealed, sealed)
+		}
+		if opened, err := aead.Open(nil, nonce, sealed, header); err != nil || !bytes.Equal(opened, plaintext) {
+			t.Errorf("%d-byte key, %d bytes: expected to open %x, but got %x and %v", test.keySize, test.length, plaintext, opened, err)
+		}
+		sealed[len(sealed)-1] ^= 1
+		if _, err := aead.Open(nil, nonce, sealed, header); err == nil {
+			t.Errorf("%d-byte key, %d bytes: expected a tampered message not to open", test.keySize, test.length)
+		}
+	}
+}
+
+// rsaAESClient authenticates over conn as TigerVNC's viewer does: it sends its public key and its encrypted random together, checks the server's hash, and sends username and password. It returns the connection to read the security result from, which is encrypted only without "ne".
+func rsaAESClient(conn net.Conn, securityType SecurityType, key *rsa.PrivateKey, username, password string) (net.Conn, error) {
+	keySize, newHash := 16, sha1.New
+	if securityType == SecurityTypeRA256 || securityType == SecurityTypeRAne256 {
+		keySize, newHash = 32, sha256.New
+	}
+	var bits [4]byte
+	if _, err := io.ReadFull(conn, bits[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint32(bits[:])+7) / 8
+	serverKey := append(bits[:], make([]byte, 2*size)...)
+	if _, err := io.ReadFull(conn, serverKey[4:]); err != nil {
+		return nil, err
+	}
+	_, serverPublic, err := readRSAAESPublicKey(bytes.NewReader(serverKey))
+	if err != nil {
+		return nil, err
+	}
+
+	clientKey := rsaAESPublicKey(&key.PublicKey)
+	clientRandom := make([]byte, keySize)
+	rand.Read(clientRandom)
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, serverPublic, clientRandom)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(append(clientKey, byte(len(encrypted)>>8), byte(len(encrypted))), encrypted...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	encrypted = make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, encrypted); err != nil {
+		return nil, err
+	}
+	serverRandom, err := rsa.DecryptPKCS1v15(rand.Reader, key, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := func(parts ...[]byte) []byte {
+		h := newHash()
+		for _, part := range parts {
+			h.Write(part)
+		}
+		return h.Sum(nil)
+	}
+	secure, err := newRSAAESConn(conn, sum(serverRandom, clientRandom)[:keySize], sum(clientRandom, serverRandom)[:keySize])
+	if err != nil {
+		return nil, err
+	}
+	serverHash := make([]byte, newHash().Size())
+	if _, err := io.ReadFull(secure, serverHash); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(serverHash, sum(serverKey, clientKey)) {
+		return nil, errors.New("server hash doesn't match")
+	}
+	if _, err := secure.Write(sum(clientKey, serverKey)); err != nil {
+		return nil, err
+	}
+	var subtype [1]byte
+	if _, err := io.ReadFull(secure, subtype[:]); err != nil {
+		return nil, err
+	}
+	if subtype[0] == rsaAESSubtypePass {
+		username = ""
+	}
+	credentials := append(append([]byte{byte(len(username))}, username...), byte(len(password)))
+	if _, err := secure.Write(append(credentials, password...)); err != nil {
+		return nil, err
+	}
+	if securityType == SecurityTypeRA2ne || securityType == SecurityTypeRAne256 {
+		return conn, nil
+	}
+	return secure, nil
+}
+
+func TestRSAAESSecurityHandler(t *testing.T) {
+	serverKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(username, password string) bool {
+		return username == "tom" && password == "hunter2"
+	}
+	tests := []struct {
+		securityType       SecurityType
+		verify             func(username, password string) bool
+		username, password string
+		wantReason         string
+		wantViewOnly       bool
+	}{
+		{securityType: SecurityTypeRA2, password: "correct horse"},
+		{securityType: SecurityTypeRA2ne, password: "correct horse"},
+		{securityType: SecurityTypeRA256, password: "correct horse"},
+		{securityType: SecurityTypeRAne256, password: "correct horse"},
+		{securityType: SecurityTypeRA2, password: "just looking", wantViewOnly: true},
+		// Every byte is significant, unlike with VNC authentication.
+		{securityType: SecurityTypeRA2, password: "correct h", wantReason: "wrong password"},
+		{securityType: SecurityTypeRA256, verify: verify, username: "tom", password: "hunter2"},
+		{securityType: SecurityTypeRA256, verify: verify, username: "tim", password: "hunter2", wantReason: "wrong username or password"},
+	}
+	for _, test := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		security := &SecurityHandlers{}
+		security.Register(&RSAAESSecurityHandler{SecurityType: test.securityType, Key: serverKey, Verify: test.verify, Password: "correct horse", ViewOnlyPassword: "just looking"})
+		type served struct {
+			conn net.Conn
+			err  error
+		}
+		servedc := make(chan served, 1)
+		go func() {
+			conn, err := ln.Accept()
+			ln.Close()
+			if err != nil {
+				servedc <- served{nil, err}
+				return
+			}
+			conn, err = security.Serve(conn, ProtocolVersionMessage{3, 8}, binary.BigEndian)
+			if err == nil {
+				_, err = io.WriteString(conn, "hello")
+			}
+			servedc <- served{conn, err}
+		}()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types SecurityTypesMessageRFB37
+		if err := types.Read(conn, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&SecurityTypeSelectionMessageRFB37{Type: test.securityType}).Write(conn); err != nil {
+			t.Fatal(err)
+		}
+		after, err := rsaAESClient(conn, test.securityType, clientKey, test.username, test.password)
+		if err != nil {
+			t.Fatalf("%s: %v", test.securityType, err)
+		}
+		var result SecurityResultMessageRFB38
+		if err := result.Read(after, binary.BigEndian); err != nil {
+			t.Fatalf("%s: read SecurityResult: %v", test.securityType, err)
+		}
+		s := <-servedc
+		if test.wantReason != "" {
+			if result.Result != VNCAuthenticationResultFailed || result.Reason != test.wantReason || s.err == nil {
+				t.Errorf("%s: expected %q and %q to fail with %q, but got %+v and %v", test.securityType, test.username, test.password, test.wantReason, result, s.err)
+			}
+			conn.Close()
+			continue
+		}
+		if result.Result != VNCAuthenticationResultOK || s.err != nil {
+			t.Errorf("%s: expected %q and %q to be accepted, but got %+v and %v", test.securityType, test.username, test.password, result, s.err)
+			conn.Close()
+			continue
+		}
+		if IsViewOnly(s.conn) != test.wantViewOnly {
+			t.Errorf("%s: expected view-only to be %v", test.securityType, test.wantViewOnly)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(after, buf); err != nil || string(buf) != "hello" {
+			t.Errorf("%s: expected hello after the security result, but got %q and %v", test.securityType, buf, err)
+		}
+		conn.Close()
+		s.conn.Close()
+	}
+}
+
+func TestRSAAESConn(t *testing.T) {
+	key := make([]byte, 16)
+	serverConn, clientConn := net.Pipe()
+	server, _ := newRSAAESConn(serverConn, key, key)
+	client, _ := newRSAAESConn(clientConn, key, key)
+
+	// Writes are split into messages of at most rsaAESMaxMessage bytes, each with its own nonce.
+	payload := bytes.Repeat([]byte("0123456789"), 2000)
+	go client.Write(payload)
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(server, got); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("expected %d bytes to arrive intact, but got %v", len(payload), err)
+	}
+	if server.inNonce[0] != 3 {
+		t.Errorf("expected %d bytes to take 3 messages, but took %d", len(payload), server.inNonce[0])
+	}
+
+	// A message that's been tampered with is refused.
+	var message bytes.Buffer
+	sender, _ := newRSAAESConn(writerConn{w: &message}, key, key)
+	sender.outNonce = server.inNonce
+	sender.Write([]byte("hello"))
+	tampered := message.Bytes()
+	tampered[3] ^= 1
+	go clientConn.Write(tampered)
+	if _, err := server.Read(got); err == nil {
+		t.Error("expected a tampered message to be refused")
+	}
+}
+
+// writerConn is a connection that only writes, to w.
+type writerConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (c writerConn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
@@ -0,0 +1,248 @@
+package rfb
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb/anontls"
+	"net"
+)
+
+// SecurityHandler implements the server side of a security type.
+type SecurityHandler interface {
+	Type() SecurityType
+
+	// Authenticate runs the security type's part of the handshake, after the client selects it and before the server sends the security result. It returns the connection to use from then on, which is conn unless the security type wraps it, as TLS does.
+	//
+	// Return a *SecurityFailure, along with the connection, to have the client told why authentication failed.
+	Authenticate(conn net.Conn, version ProtocolVersionMessage, bo binary.ByteOrder) (net.Conn, error)
+}
+
+// SecurityFailure is an error that rejects the client for Reason.
+type SecurityFailure struct {
+	Reason string
+}
+
+func (f *SecurityFailure) Error() string {
+	return "authentication failed: " + f.Reason
+}
+
+// SecurityHandlers is a registry of security types that a server offers.
+type SecurityHandlers struct {
+	handlers []SecurityHandler
+}
+
+// Register adds handler, replacing any handler for the same security type. Handlers are offered to clients in the order they were first registered.
+func (s *SecurityHandlers) Register(handler SecurityHandler) {
+	for idx, h := range s.handlers {
+		if h.Type() == handler.Type() {
+			s.handlers[idx] = handler
+			return
+		}
+	}
+	s.handlers = append(s.handlers, handler)
+}
+
+func (s *SecurityHandlers) lookup(t SecurityType) SecurityHandler {
+	for _, h := range s.handlers {
+		if h.Type() == t {
+			return h
+		}
+	}
+	return nil
+}
+
+// Serve runs the server side of security negotiation, from after the ProtocolVersion exchange through the security result. It returns the connection to use for the rest of the session.
+//
+// Version 3.3 clients cannot choose, so they are offered the first of SecurityTypeNone and SecurityTypeVNC that is registered.
+func (s *SecurityHandlers) Serve(conn net.Conn, version ProtocolVersionMessage, bo binary.ByteOrder) (net.Conn, error) {
+	var handler SecurityHandler
+	if version.Minor < 7 {
+		for _, h := range s.handlers {
+			if h.Type() == SecurityTypeNone || h.Type() == SecurityTypeVNC {
+				handler = h
+				break
+			}
+		}
+		if handler == nil {
+			m := AuthenticationSchemeMessageRFB33{AuthenticationSchemeInvalid, "no security types supported by version 3.3 are available"}
+			if err := m.Write(conn, bo); err != nil {
+				return nil, fmt.Errorf("write AuthenticationScheme: %w", err)
+			}
+			return nil, errors.New(m.Reason)
+		}
+		m := AuthenticationSchemeMessageRFB33{Scheme: AuthenticationScheme(handler.Type())}
+		if err := m.Write(conn, bo); err != nil {
+			return nil, fmt.Errorf("write AuthenticationScheme: %w", err)
+		}
+	}
+
+	var err error
+	var selected SecurityType
+	if handler != nil {
+		selected = handler.Type()
+		conn, err = handler.Authenticate(conn, version, bo)
+	} else {
+		conn, selected, err = s.negotiate(conn, version, bo)
+	}
+
+	var failure *SecurityFailure
+	if err != nil && !errors.As(err, &failure) {
+		return nil, err
+	}
+	// Versions before 3.8 only send a result when there was authentication.
+	if version.Minor >= 8 {
+		result := SecurityResultMessageRFB38{Result: VNCAuthenticationResultOK}
+		if failure != nil {
+			result = SecurityResultMessageRFB38{VNCAuthenticationResultFailed, failure.Reason}
+		}
+		if err := result.Write(conn, bo); err != nil {
+			return nil, fmt.Errorf("write SecurityResult: %w", err)
+		}
+	} else if selected != SecurityTypeNone {
+		result := VNCAuthenticationResultMessage{VNCAuthenticationResultOK}
+		if failure != nil {
+			result.Result = VNCAuthenticationResultFailed
+		}
+		if err := result.Write(conn, bo); err != nil {
+			return nil, fmt.Errorf("write VNCAuthenticationResult: %w", err)
+		}
+	}
+	if failure != nil {
+		return nil, failure
+	}
+	return conn, nil
+}
+
+// negotiate offers the registered security types, lets the client select one, and runs its handler. It returns the type that ultimately authenticated the client, which differs from the selection if the selected type negotiates again.
+func (s *SecurityHandlers) negotiate(conn net.Conn, version ProtocolVersionMessage, bo binary.ByteOrder) (net.Conn, SecurityType, error) {
+	var types SecurityTypesMessageRFB37
+	for _, h := range s.handlers {
+		types.Types = append(types.Types, h.Type())
+	}
+	if len(types.Types) == 0 {
+		types.Reason = "no security types are available"
+	}
+	if err := types.Write(conn, bo); err != nil {
+		return nil, SecurityTypeInvalid, fmt.Errorf("write SecurityTypes: %w", err)
+	}
+	if len(types.Types) == 0 {
+		return nil, SecurityTypeInvalid, errors.New(types.Reason)
+	}
+
+	var selection SecurityTypeSelectionMessageRFB37
+	if err := selection.Read(conn); err != nil {
+		return nil, SecurityTypeInvalid, fmt.Errorf("read SecurityTypeSelection: %w", err)
+	}
+	handler := s.lookup(selection.Type)
+	if handler == nil {
+		// Fail with a security result, so that the client learns why.
+		return conn, selection.Type, &SecurityFailure{fmt.Sprintf("security type %s was not offered", selection.Type)}
+	}
+
+	if tlsHandler, ok := handler.(*TLSSecurityHandler); ok {
+		tlsConn, err := tlsHandler.handshake(conn)
+		if err != nil {
+			return nil, SecurityTypeInvalid, err
+		}
+		return tlsHandler.Inner.negotiate(tlsConn, version, bo)
+	}
+	conn, err := handler.Authenticate(conn, version, bo)
+	return conn, selection.Type, err
+}
+
+// NoneSecurityHandler implements SecurityTypeNone, which accepts every client.
+type NoneSecurityHandler struct{}
+
+func (NoneSecurityHandler) Type() SecurityType {
+	return SecurityTypeNone
+}
+
+func (NoneSecurityHandler) Authenticate(conn net.Conn, version ProtocolVersionMessage, bo binary.ByteOrder) (net.Conn, error) {
+	return conn, nil
+}
+
+// viewOnlyConn marks the connection of a client that authenticated as view-only.
+type viewOnlyConn struct {
+	net.Conn
+}
+
+// ViewOnly marks conn as belonging to a client that may watch the desktop but not control it. SecurityHandlers return it from Authenticate, and servers check for it with IsViewOnly.
+func ViewOnly(conn net.Conn) net.Conn {
+	return viewOnlyConn{conn}
+}
+
+// IsViewOnly reports whether conn was marked with ViewOnly.
+func IsViewOnly(conn net.Conn) bool {
+	_, ok := conn.(viewOnlyConn)
+	return ok
+}
+
+// VNCSecurityHandler implements SecurityTypeVNC, the DES challenge-response scheme.
+type VNCSecurityHandler struct {
+	// If empty, as is ViewOnlyPassword, any response is accepted. Some clients, such as the one built into macOS, only connect to servers that use VNC authentication, so this is useful even without a password.
+	Password string
+
+	// ViewOnlyPassword, if set, is a second password, which authenticates clients as view-only, as with ViewOnly. If Password is empty, it's the only password, and no client gets full access.
+	ViewOnlyPassword string
+}
+
+func (h *VNCSecurityHandler) Type() SecurityType {
+	return SecurityTypeVNC
+}
+
+func (h *VNCSecurityHandler) Authenticate(conn net.Conn, version ProtocolVersionMessage, bo binary.ByteOrder) (net.Conn, error) {
+	var challenge VNCAuthenticationChallengeMessage
+	if h.Password != "" || h.ViewOnlyPassword != "" {
+		var err error
+		if challenge, err = NewVNCAuthenticationChallenge(); err != nil {
+			return nil, err
+		}
+	}
+	if err := challenge.Write(conn); err != nil {
+		return nil, fmt.Errorf("write VNC auth challenge: %w", err)
+	}
+	var response VNCAuthenticationResponseMessage
+	if err := response.Read(conn); err != nil {
+		return nil, fmt.Errorf("read VNC auth response: %w", err)
+	}
+	switch {
+	case h.Password != "" && response.Verify(challenge, h.Password):
+		return conn, nil
+	case h.ViewOnlyPassword != "" && response.Verify(challenge, h.ViewOnlyPassword):
+		return ViewOnly(conn), nil
+	case h.Password != "" || h.ViewOnlyPassword != "":
+		return conn, &SecurityFailure{"wrong password"}
+	}
+	return conn, nil
+}
+
+// TLSSecurityHandler implements SecurityTypeTLS, which is anonymous TLS, with the cipher suites in package anontls. After the TLS handshake, the security types in Inner are negotiated inside the tunnel.
+type TLSSecurityHandler struct {
+	// Config, if set, is for clients that offer no anonymous cipher suites, which are given its certificate instead. Without it, they're refused.
+	Config *tls.Config
+	Inner  *SecurityHandlers
+}
+
+func (h *TLSSecurityHandler) Type() SecurityType {
+	return SecurityTypeTLS
+}
+
+// Authenticate upgrades conn to TLS and negotiates again with Inner. The security result is left to the caller.
+func (h *TLSSecurityHandler) Authenticate(conn net.Conn, version ProtocolVersionMessage, bo binary.ByteOrder) (net.Conn, error) {
+	tlsConn, err := h.handshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err = h.Inner.negotiate(tlsConn, version, bo)
+	return conn, err
+}
+
+func (h *TLSSecurityHandler) handshake(conn net.Conn) (net.Conn, error) {
+	tlsConn, err := anontls.Server(conn, h.Config)
+	if err != nil {
+		return nil, fmt.Errorf("TLS handshake: %w", err)
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,47 @@
+package rfb
+
+// Compression level and JPEG quality pseudo-encodings. Clients list one of each in SetEncodings to choose a tradeoff between bandwidth, CPU, and fidelity.
+const (
+	EncodingTypeCompressLevel0 = uint32(0xffffff00) // -256
+	EncodingTypeCompressLevel9 = uint32(0xffffff09) // -247
+	EncodingTypeQualityLevel0  = uint32(0xffffffe0) // -32
+	EncodingTypeQualityLevel9  = uint32(0xffffffe9) // -23
+)
+
+// jpegQualities maps quality levels to JPEG qualities, as TigerVNC does.
+var jpegQualities = [10]int{15, 29, 41, 42, 62, 77, 79, 86, 92, 100}
+
+// EncodingSettings are the tradeoffs that a client asked for with pseudo-encodings.
+type EncodingSettings struct {
+	// CompressionLevel is from 0, for the least CPU, to 9, for the least bandwidth, or -1 if the client didn't say.
+	CompressionLevel int
+
+	// QualityLevel is from 0, for the least bandwidth, to 9, for the best image, or -1 if the client didn't say.
+	QualityLevel int
+}
+
+// Settings returns the compression and quality levels in m. If m lists more than one of either, the first wins.
+func (m *SetEncodingsMessage) Settings() EncodingSettings {
+	s := EncodingSettings{CompressionLevel: -1, QualityLevel: -1}
+	for _, encodingType := range m.EncodingTypes {
+		switch {
+		case encodingType >= EncodingTypeCompressLevel0 && encodingType <= EncodingTypeCompressLevel9:
+			if s.CompressionLevel < 0 {
+				s.CompressionLevel = int(encodingType - EncodingTypeCompressLevel0)
+			}
+		case encodingType >= EncodingTypeQualityLevel0 && encodingType <= EncodingTypeQualityLevel9:
+			if s.QualityLevel < 0 {
+				s.QualityLevel = int(encodingType - EncodingTypeQualityLevel0)
+			}
+		}
+	}
+	return s
+}
+
+// JPEGQuality returns the JPEG quality, from 1 to 100, for QualityLevel, or 0 if the client didn't say.
+func (s EncodingSettings) JPEGQuality() int {
+	if s.QualityLevel < 0 || s.QualityLevel >= len(jpegQualities) {
+		return 0
+	}
+	return jpegQualities[s.QualityLevel]
+}
@@ -0,0 +1,21 @@
+package rfb
+
+import (
+	"testing"
+)
+
+func TestSetEncodingsSettings(t *testing.T) {
+	m := SetEncodingsMessage{EncodingTypes: []uint32{EncodingTypeTight, EncodingTypeQualityLevel0 + 8, EncodingTypeCompressLevel0 + 2, EncodingTypeCompressLevel9}}
+	s := m.Settings()
+	if s.CompressionLevel != 2 || s.QualityLevel != 8 {
+		t.Errorf("expected compression level 2 and quality level 8, but got %+v", s)
+	}
+	if s.JPEGQuality() != 92 {
+		t.Errorf("expected JPEG quality 92 for quality level 8, but got %d", s.JPEGQuality())
+	}
+
+	m = SetEncodingsMessage{EncodingTypes: []uint32{EncodingTypeRaw}}
+	if s := m.Settings(); s.CompressionLevel != -1 || s.QualityLevel != -1 || s.JPEGQuality() != 0 {
+		t.Errorf("expected no settings, but got %+v", s)
+	}
+}
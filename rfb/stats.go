@@ -0,0 +1,155 @@
+package rfb
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats collects statistics about one connection, for servers to expose throughput and find slow clients. A Conn feeds it messages with SetStats, and as Hooks, it times the frames that EncodeFrame observes. Its methods are safe for concurrent use.
+type Stats struct {
+	NopHooks
+
+	mu       sync.Mutex
+	snapshot StatsSnapshot
+}
+
+// StatsSnapshot is the statistics collected by a Stats so far.
+type StatsSnapshot struct {
+	// Received and Sent are keyed by message name, as returned by MessageName.
+	Received, Sent map[string]MessageStats
+
+	// Rectangles counts the FramebufferUpdate rectangles sent, by encoding type, and RectangleBytes counts their bytes, headers included.
+	Rectangles     map[uint32]int
+	RectangleBytes map[uint32]int64
+
+	// Frames is the number of frames observed by EncodeFrame, and EncodeTime is how long they took to render, encode, and write.
+	Frames     int
+	EncodeTime time.Duration
+
+	// SendTime is how long sends spent writing, which grows when the client reads slowly.
+	SendTime time.Duration
+}
+
+// MessageStats counts the messages of one type.
+type MessageStats struct {
+	Count int
+	Bytes int64
+}
+
+func (s *Stats) received(name string, bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addMessage(&s.snapshot.Received, name, bytes)
+}
+
+func (s *Stats) sent(m ServerMessage, bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addMessage(&s.snapshot.Sent, MessageName(m), bytes)
+	if m, ok := m.(*FramebufferUpdateMessage); ok {
+		if s.snapshot.Rectangles == nil {
+			s.snapshot.Rectangles = make(map[uint32]int)
+			s.snapshot.RectangleBytes = make(map[uint32]int64)
+		}
+		for _, rect := range m.Rectangles {
+			s.snapshot.Rectangles[rect.EncodingType]++
+			s.snapshot.RectangleBytes[rect.EncodingType] += int64(12 + len(rect.PixelData))
+		}
+	}
+}
+
+func (s *Stats) sending(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.SendTime += d
+}
+
+func addMessage(messages *map[string]MessageStats, name string, bytes int) {
+	if *messages == nil {
+		*messages = make(map[string]MessageStats)
+	}
+	m := (*messages)[name]
+	m.Count++
+	m.Bytes += int64(bytes)
+	(*messages)[name] = m
+}
+
+// EncodeFrame times a frame, as part of Hooks.
+func (s *Stats) EncodeFrame(ctx context.Context, rect image.Rectangle) func(bytes int, err error) {
+	start := time.Now()
+	return func(int, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.snapshot.Frames++
+		s.snapshot.EncodeTime += time.Since(start)
+	}
+}
+
+// Snapshot returns a copy of the statistics collected so far.
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := s.snapshot
+	snapshot.Received = copyMessageStats(s.snapshot.Received)
+	snapshot.Sent = copyMessageStats(s.snapshot.Sent)
+	snapshot.Rectangles = make(map[uint32]int, len(s.snapshot.Rectangles))
+	snapshot.RectangleBytes = make(map[uint32]int64, len(s.snapshot.RectangleBytes))
+	for encodingType, n := range s.snapshot.Rectangles {
+		snapshot.Rectangles[encodingType] = n
+		snapshot.RectangleBytes[encodingType] = s.snapshot.RectangleBytes[encodingType]
+	}
+	return snapshot
+}
+
+func copyMessageStats(m map[string]MessageStats) map[string]MessageStats {
+	copied := make(map[string]MessageStats, len(m))
+	for name, stats := range m {
+		copied[name] = stats
+	}
+	return copied
+}
+
+// TotalReceived returns the number of messages received and their bytes.
+func (s StatsSnapshot) TotalReceived() MessageStats {
+	return totalMessageStats(s.Received)
+}
+
+// TotalSent returns the number of messages sent and their bytes.
+func (s StatsSnapshot) TotalSent() MessageStats {
+	return totalMessageStats(s.Sent)
+}
+
+func totalMessageStats(messages map[string]MessageStats) MessageStats {
+	var total MessageStats
+	for _, m := range messages {
+		total.Count += m.Count
+		total.Bytes += m.Bytes
+	}
+	return total
+}
+
+// String summarizes the statistics on one line, such as "received 12 messages (240 bytes), sent 3 messages (1048576 bytes) with 2 Raw rectangles, 2 frames in 10ms, 5ms sending".
+func (s StatsSnapshot) String() string {
+	received, sent := s.TotalReceived(), s.TotalSent()
+	var b strings.Builder
+	fmt.Fprintf(&b, "received %d messages (%d bytes), sent %d messages (%d bytes)", received.Count, received.Bytes, sent.Count, sent.Bytes)
+	var encodingTypes []uint32
+	for encodingType := range s.Rectangles {
+		encodingTypes = append(encodingTypes, encodingType)
+	}
+	sort.Slice(encodingTypes, func(i, j int) bool { return s.Rectangles[encodingTypes[i]] > s.Rectangles[encodingTypes[j]] })
+	for idx, encodingType := range encodingTypes {
+		sep := ","
+		if idx == 0 {
+			sep = " with"
+		}
+		fmt.Fprintf(&b, "%s %d %s rectangles", sep, s.Rectangles[encodingType], EncodingName(encodingType))
+	}
+	fmt.Fprintf(&b, ", %d frames in %s, %s sending", s.Frames, s.EncodeTime.Round(time.Millisecond), s.SendTime.Round(time.Millisecond))
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package rfb
+
+import (
+	"context"
+	"encoding/binary"
+	"image"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewConn(server, pixelFormat)
+	defer c.Close()
+	stats := &Stats{}
+	c.SetStats(stats)
+
+	go func() {
+		(&KeyEventMessage{Pressed: true, KeySym: 'a'}).Write(client, binary.BigEndian)
+		(&KeyEventMessage{KeySym: 'a'}).Write(client, binary.BigEndian)
+		io.Copy(ioutil.Discard, client)
+	}()
+	for i := 0; i < 2; i++ {
+		if _, err := c.Receive(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hooks := JoinHooks(NopHooks{}, stats)
+	end := hooks.EncodeFrame(context.Background(), image.Rect(0, 0, 2, 2))
+	update := &FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{
+		{Width: 2, Height: 2, EncodingType: EncodingTypeRaw, PixelData: make([]byte, 16)},
+		{Width: 2, Height: 2, EncodingType: EncodingTypeRaw, PixelData: make([]byte, 16)},
+	}}
+	if err := c.Send(update, &BellMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	end(0, nil)
+
+	snapshot := stats.Snapshot()
+	if m := snapshot.Received["KeyEvent"]; m.Count != 2 || m.Bytes != 16 {
+		t.Errorf("expected 2 KeyEvents of 16 bytes, but got %+v", m)
+	}
+	if m := snapshot.Sent["FramebufferUpdate"]; m.Count != 1 || m.Bytes != 4+2*(12+16) {
+		t.Errorf("expected a FramebufferUpdate of %d bytes, but got %+v", 4+2*(12+16), m)
+	}
+	if total := snapshot.TotalSent(); total.Count != 2 || total.Bytes != 4+2*(12+16)+1 {
+		t.Errorf("expected the Bell in the total, but got %+v", total)
+	}
+	if snapshot.Rectangles[EncodingTypeRaw] != 2 || snapshot.Frames != 1 {
+		t.Errorf("expected 2 Raw rectangles in 1 frame, but got %v", snapshot)
+	}
+	if n := snapshot.RectangleBytes[EncodingTypeRaw]; n != 2*(12+16) {
+		t.Errorf("expected %d bytes of Raw rectangles, but got %d", 2*(12+16), n)
+	}
+}
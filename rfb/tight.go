@@ -0,0 +1,522 @@
+package rfb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+const EncodingTypeTight = uint32(7)
+
+// Tight compression control values, in the high four bits of the first byte of each rectangle.
+const (
+	tightFill = 0x8
+	tightJPEG = 0x9
+
+	tightExplicitFilter = 0x4
+)
+
+// Tight filter IDs.
+const (
+	tightFilterCopy     = 0
+	tightFilterPalette  = 1
+	tightFilterGradient = 2
+)
+
+const (
+	// Tight limits rectangles to this width.
+	tightMaxWidth = 2048
+
+	// Rectangles are also split to keep each one's pixel data reasonably small, as real servers do.
+	tightMaxArea = 65536
+
+	// Data smaller than this is sent without compression.
+	tightMinToCompress = 12
+
+	tightMaxPaletteColors = 256
+)
+
+// TightEncoder encodes framebuffer rectangles with EncodingTypeTight. It keeps zlib streams that persist for the life of a connection, so each connection needs its own encoder.
+type TightEncoder struct {
+	// CompressionLevel is the zlib compression level, from 0 to 9.
+	CompressionLevel int
+
+	// JPEGQuality is the quality, from 1 to 100, of JPEG-encoded rectangles, which are used for photographic content. If 0, JPEG is never used and encoding is lossless.
+	JPEGQuality int
+
+	streams [4]*tightStream
+
+	// resets has a bit for each stream that the client must reset before the next rectangle.
+	resets uint8
+}
+
+type tightStream struct {
+	buf bytes.Buffer
+	w   *zlib.Writer
+}
+
+// Stream IDs used by TightEncoder for each kind of data.
+const (
+	tightStreamFullColor = 0
+	tightStreamMono      = 1
+	tightStreamIndexed   = 2
+)
+
+// Encode returns rectangles that together cover img. Rectangles wider than 2048 pixels, or large in area, are split.
+func (e *TightEncoder) Encode(img *PixelFormatImage) ([]*FramebufferUpdateRect, error) {
+	var rects []*FramebufferUpdateRect
+	r := img.Rect
+	for x := r.Min.X; x < r.Max.X; x += tightMaxWidth {
+		width := r.Max.X - x
+		if width > tightMaxWidth {
+			width = tightMaxWidth
+		}
+		rows := tightMaxArea / width
+		for y := r.Min.Y; y < r.Max.Y; y += rows {
+			sub := image.Rect(x, y, x+width, y+rows).Intersect(r)
+			data, err := e.encodeRect(img, sub)
+			if err != nil {
+				return nil, err
+			}
+			// Resets are in the low bits of the compression control byte.
+			data[0] |= e.resets
+			e.resets = 0
+			rects = append(rects, &FramebufferUpdateRect{
+				X: uint16(sub.Min.X), Y: uint16(sub.Min.Y), Width: uint16(sub.Dx()), Height: uint16(sub.Dy()),
+				EncodingType: EncodingTypeTight, PixelData: data,
+			})
+		}
+	}
+	return rects, nil
+}
+
+// SetCompressionLevel changes CompressionLevel. Since zlib streams can't change level, they're restarted, and the client is told to do the same.
+func (e *TightEncoder) SetCompressionLevel(level int) {
+	if level == e.CompressionLevel {
+		return
+	}
+	e.CompressionLevel = level
+	for i, s := range e.streams {
+		if s != nil {
+			e.streams[i] = nil
+			e.resets |= 1 << uint(i)
+		}
+	}
+}
+
+func (e *TightEncoder) encodeRect(img *PixelFormatImage, r image.Rectangle) ([]byte, error) {
+	var buf bytes.Buffer
+	pixels := img.tilePixels(r)
+	palette, indexes := tightPalette(pixels)
+
+	switch {
+	case len(palette) == 1:
+		buf.WriteByte(tightFill << 4)
+		writeTPixel(&buf, img.PixelFormat, img.bo, palette[0])
+		return buf.Bytes(), nil
+
+	case palette != nil && len(palette) <= len(pixels)/4:
+		stream := tightStreamIndexed
+		var data []byte
+		if len(palette) == 2 {
+			stream = tightStreamMono
+			// One bit per pixel, most significant first, with each row padded to a byte.
+			stride := (r.Dx() + 7) / 8
+			data = make([]byte, stride*r.Dy())
+			for idx, colorIdx := range indexes {
+				if colorIdx == 1 {
+					x, y := idx%r.Dx(), idx/r.Dx()
+					data[y*stride+x/8] |= 0x80 >> uint(x%8)
+				}
+			}
+		} else {
+			data = indexes
+		}
+		buf.WriteByte(uint8(stream<<4 | tightExplicitFilter<<4))
+		buf.WriteByte(tightFilterPalette)
+		buf.WriteByte(uint8(len(palette) - 1))
+		for _, p := range palette {
+			writeTPixel(&buf, img.PixelFormat, img.bo, p)
+		}
+		if err := e.compress(&buf, stream, data); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case e.JPEGQuality > 0 && img.bytesPerPixel > 1:
+		rgba := image.NewRGBA(r)
+		if err := img.crop(r).CopyToImage(rgba, r.Min); err != nil {
+			return nil, err
+		}
+		var jpg bytes.Buffer
+		if err := jpeg.Encode(&jpg, rgba, &jpeg.Options{Quality: e.JPEGQuality}); err != nil {
+			return nil, fmt.Errorf("encode JPEG: %w", err)
+		}
+		buf.WriteByte(tightJPEG << 4)
+		writeCompactLength(&buf, jpg.Len())
+		buf.Write(jpg.Bytes())
+		return buf.Bytes(), nil
+
+	default:
+		var data bytes.Buffer
+		for _, p := range pixels {
+			writeTPixel(&data, img.PixelFormat, img.bo, p)
+		}
+		buf.WriteByte(tightStreamFullColor << 4)
+		if err := e.compress(&buf, tightStreamFullColor, data.Bytes()); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// compress appends data to buf, compressed with the given stream unless it's too short to bother.
+func (e *TightEncoder) compress(buf *bytes.Buffer, stream int, data []byte) error {
+	if len(data) < tightMinToCompress {
+		buf.Write(data)
+		return nil
+	}
+	s := e.streams[stream]
+	if s == nil {
+		s = &tightStream{}
+		w, err := zlib.NewWriterLevel(&s.buf, e.CompressionLevel)
+		if err != nil {
+			return fmt.Errorf("create zlib stream: %w", err)
+		}
+		s.w = w
+		e.streams[stream] = s
+	}
+	if _, err := s.w.Write(data); err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
+	writeCompactLength(buf, s.buf.Len())
+	buf.Write(s.buf.Bytes())
+	s.buf.Reset()
+	return nil
+}
+
+// tightPalette returns the distinct pixel values in pixels, in order of first appearance, with each pixel's index into them. If there are more than tightMaxPaletteColors, it returns nil.
+func tightPalette(pixels []uint32) ([]uint32, []byte) {
+	var palette []uint32
+	positions := make(map[uint32]byte)
+	indexes := make([]byte, len(pixels))
+	for idx, p := range pixels {
+		pos, ok := positions[p]
+		if !ok {
+			if len(palette) == tightMaxPaletteColors {
+				return nil, nil
+			}
+			pos = byte(len(palette))
+			positions[p] = pos
+			palette = append(palette, p)
+		}
+		indexes[idx] = pos
+	}
+	return palette, indexes
+}
+
+// tightCompactPixels reports whether pixels are sent as 3-byte TPIXELs rather than in the pixel format.
+func tightCompactPixels(pf PixelFormat) bool {
+	return pf.TrueColor && pf.BitsPerPixel == 32 && pf.BitDepth == 24 && pf.RedMax == 0xff && pf.GreenMax == 0xff && pf.BlueMax == 0xff
+}
+
+func tightPixelSize(pf PixelFormat) int {
+	if tightCompactPixels(pf) {
+		return 3
+	}
+	return int(pf.BitsPerPixel / 8)
+}
+
+func writeTPixel(buf *bytes.Buffer, pf PixelFormat, bo binary.ByteOrder, pixel uint32) {
+	if tightCompactPixels(pf) {
+		buf.WriteByte(uint8(pixel >> pf.RedShift))
+		buf.WriteByte(uint8(pixel >> pf.GreenShift))
+		buf.WriteByte(uint8(pixel >> pf.BlueShift))
+		return
+	}
+	var b [4]byte
+	switch pf.BitsPerPixel {
+	case 8:
+		b[0] = uint8(pixel)
+	case 16:
+		bo.PutUint16(b[:], uint16(pixel))
+	default:
+		bo.PutUint32(b[:], pixel)
+	}
+	buf.Write(b[:pf.BitsPerPixel/8])
+}
+
+func tpixel(pf PixelFormat, bo binary.ByteOrder, b []byte) uint32 {
+	if tightCompactPixels(pf) {
+		return uint32(b[0])<<pf.RedShift | uint32(b[1])<<pf.GreenShift | uint32(b[2])<<pf.BlueShift
+	}
+	switch pf.BitsPerPixel {
+	case 8:
+		return uint32(b[0])
+	case 16:
+		return uint32(bo.Uint16(b))
+	default:
+		return bo.Uint32(b)
+	}
+}
+
+// writeCompactLength writes n in 1 to 3 bytes, 7 bits at a time, least significant first.
+func writeCompactLength(buf *bytes.Buffer, n int) {
+	for i := 0; i < 2 && n > 0x7f; i++ {
+		buf.WriteByte(uint8(n&0x7f | 0x80))
+		n >>= 7
+	}
+	buf.WriteByte(uint8(n))
+}
+
+func readCompactLength(r io.Reader) (int, error) {
+	var b [1]byte
+	n := 0
+	for i := 0; i < 3; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		if i == 2 {
+			n |= int(b[0]) << 14
+			break
+		}
+		n |= int(b[0]&0x7f) << uint(7*i)
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+	return n, nil
+}
+
+// tightRect is a parsed Tight payload. data is still compressed if compressed is true.
+type tightRect struct {
+	control    uint8
+	filter     uint8
+	palette    []uint32
+	data       []byte
+	compressed bool
+}
+
+// readTight parses a Tight payload from r, without decompressing it.
+func readTight(r io.Reader, pf PixelFormat, bo binary.ByteOrder, width, height int) (*tightRect, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+	t := &tightRect{control: b[0]}
+	pixelSize := tightPixelSize(pf)
+
+	switch t.control >> 4 {
+	case tightFill:
+		t.data = make([]byte, pixelSize)
+		if _, err := io.ReadFull(r, t.data); err != nil {
+			return nil, err
+		}
+		return t, nil
+	case tightJPEG:
+		length, err := readCompactLength(r)
+		if err != nil {
+			return nil, err
+		}
+		t.data = make([]byte, length)
+		if _, err := io.ReadFull(r, t.data); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	if t.control>>4 > 0x7 {
+		return nil, fmt.Errorf("unsupported Tight compression control %#x", t.control)
+	}
+
+	t.filter = tightFilterCopy
+	if t.control&(tightExplicitFilter<<4) != 0 {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		t.filter = b[0]
+	}
+	dataSize := width * height * pixelSize
+	switch t.filter {
+	case tightFilterCopy, tightFilterGradient:
+	case tightFilterPalette:
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, (int(b[0])+1)*pixelSize)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(buf); i += pixelSize {
+			t.palette = append(t.palette, tpixel(pf, bo, buf[i:]))
+		}
+		if len(t.palette) == 2 {
+			dataSize = (width + 7) / 8 * height
+		} else {
+			dataSize = width * height
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Tight filter %d", t.filter)
+	}
+
+	if dataSize < tightMinToCompress {
+		t.data = make([]byte, dataSize)
+	} else {
+		length, err := readCompactLength(r)
+		if err != nil {
+			return nil, err
+		}
+		t.data = make([]byte, length)
+		t.compressed = true
+	}
+	if _, err := io.ReadFull(r, t.data); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TightDecoder decodes rectangles with EncodingTypeTight. It keeps zlib streams that persist for the life of a connection, so each connection needs its own decoder, and rectangles must be decoded in the order they were received.
+type TightDecoder struct {
+	streams [4]*tightInflater
+}
+
+type tightInflater struct {
+	in bytes.Buffer
+	r  io.ReadCloser
+}
+
+// Decode decodes rect, which must have EncodingTypeTight.
+func (d *TightDecoder) Decode(rect *FramebufferUpdateRect, pf PixelFormat) (*PixelFormatImage, error) {
+	img, err := NewPixelFormatImage(pf, rect.Bounds())
+	if err != nil {
+		return nil, err
+	}
+	t, err := readTight(bytes.NewReader(rect.PixelData), pf, img.bo, int(rect.Width), int(rect.Height))
+	if err != nil {
+		return nil, fmt.Errorf("parse Tight rectangle: %w", err)
+	}
+
+	for stream := range d.streams {
+		if t.control&(1<<uint(stream)) != 0 {
+			d.streams[stream] = nil
+		}
+	}
+
+	switch t.control >> 4 {
+	case tightFill:
+		img.fill(img.Rect, tpixel(pf, img.bo, t.data))
+		return img, nil
+	case tightJPEG:
+		jpg, err := jpeg.Decode(bytes.NewReader(t.data))
+		if err != nil {
+			return nil, fmt.Errorf("decode JPEG: %w", err)
+		}
+		if err := img.CopyFromImage(jpg, jpg.Bounds().Min); err != nil {
+			return nil, err
+		}
+		return img, nil
+	}
+
+	data := t.data
+	if t.compressed {
+		stream := int(t.control>>4) & 0x3
+		expected := int(rect.Width) * int(rect.Height) * tightPixelSize(pf)
+		if t.filter == tightFilterPalette {
+			if len(t.palette) == 2 {
+				expected = (int(rect.Width) + 7) / 8 * int(rect.Height)
+			} else {
+				expected = int(rect.Width) * int(rect.Height)
+			}
+		}
+		if data, err = d.inflate(stream, t.data, expected); err != nil {
+			return nil, fmt.Errorf("decompress Tight stream %d: %w", stream, err)
+		}
+	}
+
+	width := int(rect.Width)
+	switch t.filter {
+	case tightFilterCopy:
+		size := tightPixelSize(pf)
+		for idx := 0; idx < len(img.Pix)/img.bytesPerPixel; idx++ {
+			img.putPixel(idx*img.bytesPerPixel, tpixel(pf, img.bo, data[idx*size:]))
+		}
+	case tightFilterPalette:
+		for idx := 0; idx < len(img.Pix)/img.bytesPerPixel; idx++ {
+			var colorIdx int
+			if len(t.palette) == 2 {
+				x, y := idx%width, idx/width
+				stride := (width + 7) / 8
+				colorIdx = int(data[y*stride+x/8]>>uint(7-x%8)) & 1
+			} else {
+				colorIdx = int(data[idx])
+			}
+			if colorIdx >= len(t.palette) {
+				return nil, fmt.Errorf("palette index %d is out of range", colorIdx)
+			}
+			img.putPixel(idx*img.bytesPerPixel, t.palette[colorIdx])
+		}
+	case tightFilterGradient:
+		tightUngradient(img, pf, data)
+	}
+	return img, nil
+}
+
+func (d *TightDecoder) inflate(stream int, compressed []byte, expected int) ([]byte, error) {
+	s := d.streams[stream]
+	if s == nil {
+		s = &tightInflater{}
+		d.streams[stream] = s
+	}
+	s.in.Write(compressed)
+	if s.r == nil {
+		r, err := zlib.NewReader(&s.in)
+		if err != nil {
+			return nil, err
+		}
+		s.r = r
+	}
+	data := make([]byte, expected)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// tightUngradient reverses the gradient filter, which predicts each color component from the pixels to the left, above, and above-left.
+func tightUngradient(img *PixelFormatImage, pf PixelFormat, data []byte) {
+	size := tightPixelSize(pf)
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	shifts := [3]uint8{pf.RedShift, pf.GreenShift, pf.BlueShift}
+	maxes := [3]uint32{uint32(pf.RedMax), uint32(pf.GreenMax), uint32(pf.BlueMax)}
+	component := func(pixel uint32, c int) int { return int(pixel >> shifts[c] & maxes[c]) }
+	at := func(x, y int) uint32 {
+		if x < 0 || y < 0 {
+			return 0
+		}
+		return img.getPixel(y*width*img.bytesPerPixel + x*img.bytesPerPixel)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			diff := tpixel(pf, img.bo, data[(y*width+x)*size:])
+			left, above, aboveLeft := at(x-1, y), at(x, y-1), at(x-1, y-1)
+			var pixel uint32
+			for c := 0; c < 3; c++ {
+				predicted := component(left, c) + component(above, c) - component(aboveLeft, c)
+				if predicted < 0 {
+					predicted = 0
+				} else if predicted > int(maxes[c]) {
+					predicted = int(maxes[c])
+				}
+				value := (uint32(predicted) + uint32(component(diff, c))) & maxes[c]
+				pixel |= value << shifts[c]
+			}
+			img.putPixel(y*width*img.bytesPerPixel+x*img.bytesPerPixel, pixel)
+		}
+	}
+}
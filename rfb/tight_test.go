@@ -0,0 +1,105 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestTightRoundTrip(t *testing.T) {
+	for _, pf := range []PixelFormat{pixelFormat, pixelFormatWeird, pixelFormat16} {
+		var encoder TightEncoder
+		var decoder TightDecoder
+		// Several images in a row exercise the persistent zlib streams.
+		for _, colors := range []int{1, 2, 3, 50, 300, 2} {
+			r := image.Rect(3, 5, 3+300, 5+250)
+			src := randomImage(pf, r, colors, int64(colors))
+
+			rects, err := encoder.Encode(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rects) < 2 {
+				t.Errorf("expected a %v rectangle to be split, but got %d rectangles", r, len(rects))
+			}
+
+			var update bytes.Buffer
+			if err := (&FramebufferUpdateMessage{rects}).Write(&update, binary.BigEndian); err != nil {
+				t.Fatal(err)
+			}
+			var m FramebufferUpdateMessage
+			if err := m.Read(&update, binary.BigEndian, pf, nil); err != nil {
+				t.Fatalf("read %d bpp, %d colors: %v", pf.BitsPerPixel, colors, err)
+			}
+			if update.Len() != 0 {
+				t.Errorf("%d bpp, %d colors: expected Read to consume the whole update, but %d bytes remain", pf.BitsPerPixel, colors, update.Len())
+			}
+
+			for _, rect := range m.Rectangles {
+				dst, err := decoder.Decode(rect, pf)
+				if err != nil {
+					t.Fatalf("decode %d bpp, %d colors: %v", pf.BitsPerPixel, colors, err)
+				}
+				if !reflect.DeepEqual(src.tilePixels(rect.Bounds()), dst.tilePixels(dst.Rect)) {
+					t.Errorf("%d bpp, %d colors: decoded pixels of %v differ from encoded pixels", pf.BitsPerPixel, colors, rect.Bounds())
+				}
+			}
+		}
+	}
+}
+
+func TestTightSetCompressionLevel(t *testing.T) {
+	encoder := TightEncoder{CompressionLevel: 9}
+	var decoder TightDecoder
+	r := image.Rect(0, 0, 100, 100)
+	for i, level := range []int{9, 1, 1, 6} {
+		encoder.SetCompressionLevel(level)
+		src := randomImage(pixelFormat, r, 300, int64(i))
+		rects, err := encoder.Encode(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rect := range rects {
+			dst, err := decoder.Decode(rect, pixelFormat)
+			if err != nil {
+				t.Fatalf("decode after changing to level %d: %v", level, err)
+			}
+			if !reflect.DeepEqual(src.tilePixels(rect.Bounds()), dst.tilePixels(dst.Rect)) {
+				t.Errorf("level %d: decoded pixels of %v differ from encoded pixels", level, rect.Bounds())
+			}
+		}
+	}
+}
+
+func TestTightJPEG(t *testing.T) {
+	encoder := TightEncoder{JPEGQuality: 90}
+	var decoder TightDecoder
+	r := image.Rect(0, 0, 64, 64)
+	src := randomImage(pixelFormat, r, 1000, 1)
+	rects, err := encoder.Encode(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rects) != 1 || rects[0].PixelData[0]>>4 != tightJPEG {
+		t.Fatalf("expected a single JPEG rectangle")
+	}
+	if _, err := decoder.Decode(rects[0], pixelFormat); err != nil {
+		t.Errorf("decode: %v", err)
+	}
+}
+
+func TestCompactLength(t *testing.T) {
+	for _, n := range []int{0, 1, 0x7f, 0x80, 0x3fff, 0x4000, 0x3fffff} {
+		var buf bytes.Buffer
+		writeCompactLength(&buf, n)
+		n2, err := readCompactLength(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n2 != n {
+			t.Errorf("expected %d, got %d", n, n2)
+		}
+	}
+}
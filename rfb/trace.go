@@ -0,0 +1,389 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Tracer passes reads and writes through to a connection, logging each message that flows in either direction. It works out whether it's on the client or server side from which side sends first.
+type Tracer struct {
+	rw     io.ReadWriter
+	logger *log.Logger
+	now    func() time.Time
+	start  time.Time
+
+	once           sync.Once
+	readsAreClient bool
+	decoded        chan struct{} // Closed once decode returns, if it started
+	client, server *tracePipe    // Bytes sent by each side
+
+	mu          sync.Mutex
+	pixelFormat PixelFormat
+}
+
+// Trace returns a Tracer that logs the messages read from and written to rw. It must start at the beginning of the connection. It decodes the handshake for SecurityTypeNone and SecurityTypeVNC; after any other security type, it only passes data through. Read and Write wait for the message they complete to be logged, so that the log follows the order in which each side saw messages.
+func Trace(rw io.ReadWriter, logger *log.Logger) *Tracer {
+	t := &Tracer{rw: rw, logger: logger, now: time.Now, start: time.Now()}
+	t.client, t.server = newTracePipes()
+	return t
+}
+
+func (t *Tracer) Read(p []byte) (int, error) {
+	n, err := t.rw.Read(p)
+	if n > 0 {
+		t.begin(true)
+		if t.readsAreClient {
+			t.client.feed(p[:n])
+		} else {
+			t.server.feed(p[:n])
+		}
+	}
+	return n, err
+}
+
+func (t *Tracer) Write(p []byte) (int, error) {
+	n, err := t.rw.Write(p)
+	if n > 0 {
+		t.begin(false)
+		if t.readsAreClient {
+			t.server.feed(p[:n])
+		} else {
+			t.client.feed(p[:n])
+		}
+	}
+	return n, err
+}
+
+// SetClock makes the Tracer stamp messages with the times that now returns, rather than the time they flowed, as when decoding a capture. The first time is the start of the log. It must be called before any data flows.
+func (t *Tracer) SetClock(now func() time.Time) {
+	t.now = now
+	t.start = now()
+}
+
+// Close stops decoding, once any message that has flowed is logged, and closes the underlying ReadWriter if it's an io.Closer.
+func (t *Tracer) Close() error {
+	t.client.close()
+	t.server.close()
+	// Decoding can't start after this.
+	t.once.Do(func() {})
+	if t.decoded != nil {
+		<-t.decoded
+	}
+	if c, ok := t.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// begin starts decoding when the first bytes flow. Servers speak first, so if they were read, this is the client side.
+func (t *Tracer) begin(read bool) {
+	t.once.Do(func() {
+		t.readsAreClient = !read
+		t.decoded = make(chan struct{})
+		go func() {
+			defer close(t.decoded)
+			t.decode()
+		}()
+	})
+}
+
+type traceConn struct {
+	net.Conn
+	tracer *Tracer
+}
+
+// TraceConn is like Trace, but for a net.Conn.
+func TraceConn(conn net.Conn, logger *log.Logger) net.Conn {
+	return &traceConn{conn, Trace(conn, logger)}
+}
+
+func (c *traceConn) Read(p []byte) (int, error)  { return c.tracer.Read(p) }
+func (c *traceConn) Write(p []byte) (int, error) { return c.tracer.Write(p) }
+func (c *traceConn) Close() error                { return c.tracer.Close() }
+
+func (t *Tracer) decode() {
+	defer t.client.close()
+	defer t.server.close()
+	client, server := &traceReader{pipe: t.client, now: t.now}, &traceReader{pipe: t.server, now: t.now}
+	ok, err := t.decodeHandshake(client, server)
+	if err != nil {
+		t.logger.Printf("trace: stopped decoding: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	t.server.split()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer t.server.close()
+		for {
+			// Wait for the message to start, and for the client's messages so far to be decoded, before looking up the pixel format, which the client may have just changed.
+			_, r, err := peekMessageType(server)
+			if err != nil {
+				t.logEnd("server", err)
+				return
+			}
+			t.client.waitIdle()
+			m, err := ReadServerMessage(r, binary.BigEndian, t.currentPixelFormat(), nil)
+			if err != nil {
+				t.logEnd("server", err)
+				return
+			}
+			t.log("server", server, m)
+		}
+	}()
+	for {
+		m, err := ReadClientMessage(client, binary.BigEndian)
+		if err != nil {
+			t.logEnd("client", err)
+			break
+		}
+		if m, ok := m.(*SetPixelFormatMessage); ok {
+			t.mu.Lock()
+			t.pixelFormat = m.PixelFormat
+			t.mu.Unlock()
+		}
+		t.log("client", client, m)
+	}
+	t.client.close()
+	<-done
+}
+
+// decodeHandshake logs the handshake, mirroring ClientHandshake. It returns false if the rest of the connection can't be decoded.
+func (t *Tracer) decodeHandshake(client, server *traceReader) (bool, error) {
+	bo := binary.BigEndian
+	var serverVersion, clientVersion ProtocolVersionMessage
+	if err := serverVersion.Read(server); err != nil {
+		return false, fmt.Errorf("read server ProtocolVersion: %w", err)
+	}
+	t.log("server", server, &serverVersion)
+	if err := clientVersion.Read(client); err != nil {
+		return false, fmt.Errorf("read client ProtocolVersion: %w", err)
+	}
+	t.log("client", client, &clientVersion)
+	// Clients choose the version, and treat unknown ones as 3.3, like servers do.
+	minor := clientVersion.Minor
+	switch {
+	case minor >= 8:
+		minor = 8
+	case minor == 7:
+	default:
+		minor = 3
+	}
+
+	var selected SecurityType
+	if minor < 7 {
+		var m AuthenticationSchemeMessageRFB33
+		if err := m.Read(server, bo); err != nil {
+			return false, fmt.Errorf("read AuthenticationScheme: %w", err)
+		}
+		t.log("server", server, &m)
+		switch m.Scheme {
+		case AuthenticationSchemeInvalid:
+			return false, nil
+		case AuthenticationSchemeNone:
+			selected = SecurityTypeNone
+		case AuthenticationSchemeVNC:
+			selected = SecurityTypeVNC
+		default:
+			t.logger.Printf("trace: not decoding authentication scheme %s", m.Scheme)
+			return false, nil
+		}
+	} else {
+		var types SecurityTypesMessageRFB37
+		if err := types.Read(server, bo); err != nil {
+			return false, fmt.Errorf("read SecurityTypes: %w", err)
+		}
+		t.log("server", server, &types)
+		if len(types.Types) == 0 {
+			return false, nil
+		}
+		var selection SecurityTypeSelectionMessageRFB37
+		if err := selection.Read(client); err != nil {
+			return false, fmt.Errorf("read SecurityTypeSelection: %w", err)
+		}
+		t.log("client", client, &selection)
+		selected = selection.Type
+		if selected != SecurityTypeNone && selected != SecurityTypeVNC {
+			t.logger.Printf("trace: not decoding security type %s", selected)
+			return false, nil
+		}
+	}
+
+	if selected == SecurityTypeVNC {
+		var challenge VNCAuthenticationChallengeMessage
+		if err := challenge.Read(server); err != nil {
+			return false, fmt.Errorf("read VNC auth challenge: %w", err)
+		}
+		t.log("server", server, &challenge)
+		var response VNCAuthenticationResponseMessage
+		if err := response.Read(client); err != nil {
+			return false, fmt.Errorf("read VNC auth response: %w", err)
+		}
+		t.log("client", client, &response)
+	}
+	if minor >= 8 {
+		var result SecurityResultMessageRFB38
+		if err := result.Read(server, bo); err != nil {
+			return false, fmt.Errorf("read SecurityResult: %w", err)
+		}
+		t.log("server", server, &result)
+		if result.Result != VNCAuthenticationResultOK {
+			return false, nil
+		}
+	} else if selected != SecurityTypeNone {
+		var result VNCAuthenticationResultMessage
+		if err := result.Read(server, bo); err != nil {
+			return false, fmt.Errorf("read VNCAuthenticationResult: %w", err)
+		}
+		t.log("server", server, &result)
+		if result.Result != VNCAuthenticationResultOK {
+			return false, nil
+		}
+	}
+
+	var clientInit ClientInitialisationMessage
+	if err := clientInit.Read(client); err != nil {
+		return false, fmt.Errorf("read ClientInitialisation: %w", err)
+	}
+	t.log("client", client, &clientInit)
+	var serverInit ServerInitialisationMessage
+	if err := serverInit.Read(server, bo); err != nil {
+		return false, fmt.Errorf("read ServerInitialisation: %w", err)
+	}
+	t.log("server", server, &serverInit)
+	t.mu.Lock()
+	t.pixelFormat = serverInit.PixelFormat
+	t.mu.Unlock()
+	return true, nil
+}
+
+func (t *Tracer) currentPixelFormat() PixelFormat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pixelFormat
+}
+
+func (t *Tracer) log(sender string, r *traceReader, m fmt.Stringer) {
+	t.logf(sender, r, "%s", m)
+}
+
+// logf logs a message sent by sender, along with how many bytes it took and when they arrived, then resets r's count for the next message.
+func (t *Tracer) logf(sender string, r *traceReader, format string, args ...interface{}) {
+	now := t.now()
+	t.logger.Printf("%s: %d bytes at +%s in %s: %s", sender, r.n, r.first.Sub(t.start).Round(time.Millisecond), now.Sub(r.first).Round(time.Microsecond), fmt.Sprintf(format, args...))
+	r.n = 0
+}
+
+func (t *Tracer) logEnd(sender string, err error) {
+	if !errors.Is(err, io.EOF) {
+		t.logger.Printf("trace: stopped decoding %s messages: %v", sender, err)
+	}
+}
+
+// traceReader counts the bytes of a message and notes when the first one was decoded.
+type traceReader struct {
+	pipe  *tracePipe
+	now   func() time.Time
+	n     int
+	first time.Time
+}
+
+func (r *traceReader) Read(p []byte) (int, error) {
+	n, err := r.pipe.Read(p)
+	if n > 0 && r.n == 0 {
+		r.first = r.now()
+	}
+	r.n += n
+	return n, err
+}
+
+// tracePipe carries the bytes sent by one side to the goroutine that decodes them. The Tracer's pipes share a lock, so that feed can wait for the decoder to go idle.
+type tracePipe struct {
+	mu      *sync.Mutex
+	cond    *sync.Cond
+	buf     []byte
+	decoder *traceDecoder
+	closed  bool
+}
+
+// traceDecoder is the state of a goroutine that reads from tracePipes. One decodes both sides during the handshake, after which each side has its own.
+type traceDecoder struct {
+	blockedOn *tracePipe // The empty pipe that the decoder is waiting on, or nil while it's busy.
+}
+
+func newTracePipes() (client, server *tracePipe) {
+	mu := &sync.Mutex{}
+	cond := sync.NewCond(mu)
+	decoder := &traceDecoder{}
+	return &tracePipe{mu: mu, cond: cond, decoder: decoder}, &tracePipe{mu: mu, cond: cond, decoder: decoder}
+}
+
+// feed adds b to the pipe and waits until the decoder is idle, waiting on an empty pipe, so that it has logged any message that b completed, unless it's waiting on the other side.
+func (p *tracePipe) feed(b []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.buf = append(p.buf, b...)
+	p.cond.Broadcast()
+	for !p.closed {
+		if blocked := p.decoder.blockedOn; blocked != nil && len(blocked.buf) == 0 {
+			return
+		}
+		p.cond.Wait()
+	}
+}
+
+func (p *tracePipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.buf) == 0 && !p.closed {
+		p.decoder.blockedOn = p
+		p.cond.Broadcast()
+		p.cond.Wait()
+	}
+	p.decoder.blockedOn = nil
+	if len(p.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+// waitIdle waits until the pipe's decoder is waiting on an empty pipe, or the pipe is closed.
+func (p *tracePipe) waitIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for !p.closed {
+		if blocked := p.decoder.blockedOn; blocked != nil && len(blocked.buf) == 0 {
+			return
+		}
+		p.cond.Wait()
+	}
+}
+
+// split gives the pipe its own decoder.
+func (p *tracePipe) split() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.decoder = &traceDecoder{}
+}
+
+func (p *tracePipe) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+}
@@ -0,0 +1,151 @@
+package rfb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that's safe for the tracer's goroutines to log to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTrace(t *testing.T) {
+	serverConn, client := net.Pipe()
+	defer client.Close()
+	var logs syncBuffer
+	server := TraceConn(serverConn, log.New(&logs, "", 0))
+	defer server.Close()
+
+	bo := binary.BigEndian
+	pf8 := PixelFormat{BitsPerPixel: 8, BitDepth: 8, TrueColor: true, RedMax: 7, GreenMax: 7, BlueMax: 3, RedShift: 5, GreenShift: 2}
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- func() error {
+			if _, err := ClientHandshake(context.Background(), client, ClientHandshakeOptions{}); err != nil {
+				return err
+			}
+			if err := (&SetPixelFormatMessage{PixelFormat: pf8}).Write(client, bo); err != nil {
+				return err
+			}
+			if err := (&FramebufferUpdateRequestMessage{Width: 2, Height: 2}).Write(client, bo); err != nil {
+				return err
+			}
+			var update FramebufferUpdateMessage
+			return update.Read(client, bo, pf8, nil)
+		}()
+	}()
+
+	if _, err := ServerHandshake(context.Background(), server, ServerHandshakeOptions{
+		Init: func(ClientInitialisationMessage) (ServerInitialisationMessage, error) {
+			return ServerInitialisationMessage{FramebufferWidth: 2, FramebufferHeight: 2, PixelFormat: pixelFormat, Name: "test"}, nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(server)
+	if _, err := ReadClientMessage(r, bo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadClientMessage(r, bo); err != nil {
+		t.Fatal(err)
+	}
+	img, err := NewPixelFormatImage(pf8, image.Rect(0, 0, 2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rect, err := DefaultEncodings.Encode(EncodingTypeRaw, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{rect}}).Write(server, bo); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-clientErr; err != nil {
+		t.Fatalf("client: %v", err)
+	}
+
+	got := logs.String()
+	for _, want := range []string{
+		"server: 12 bytes", "ProtocolVersion{3.8}",
+		"client: 1 bytes", "SecurityTypeSelection{None}",
+		"ServerInitialisation{2x2,",
+		"client: 20 bytes", "SetPixelFormat{8 bpp",
+		"FramebufferUpdateRequest{Incremental: false, 2x2 at (0, 0)}",
+		"FramebufferUpdate{1 rectangles}",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected log to contain %q, but got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "stopped decoding") {
+		t.Errorf("expected every message to be decoded, but got:\n%s", got)
+	}
+}
+
+// replayReadWriter reads whatever's in next and discards writes, so that a test can play both sides of a session through a Tracer.
+type replayReadWriter struct{ next bytes.Buffer }
+
+func (rw *replayReadWriter) Read(p []byte) (int, error)  { return rw.next.Read(p) }
+func (rw *replayReadWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestTraceSetClock(t *testing.T) {
+	bo := binary.BigEndian
+	pf8 := PixelFormat{BitsPerPixel: 8, BitDepth: 8, TrueColor: true, RedMax: 7, GreenMax: 7, BlueMax: 3, RedShift: 5, GreenShift: 2}
+	var server, client bytes.Buffer
+	(&ProtocolVersionMessage{Major: 3, Minor: 8}).Write(&server)
+	(&SecurityTypesMessageRFB37{Types: []SecurityType{SecurityTypeNone}}).Write(&server, bo)
+	(&SecurityResultMessageRFB38{}).Write(&server, bo)
+	(&ServerInitialisationMessage{FramebufferWidth: 1, FramebufferHeight: 1, PixelFormat: pixelFormat}).Write(&server, bo)
+	// One pixel, which is only one byte once the client asks for pf8.
+	(&FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{{Width: 1, Height: 1, PixelData: []byte{0xff}}}}).Write(&server, bo)
+	(&ProtocolVersionMessage{Major: 3, Minor: 8}).Write(&client)
+	(&SecurityTypeSelectionMessageRFB37{Type: SecurityTypeNone}).Write(&client)
+	(&ClientInitialisationMessage{Shared: true}).Write(&client)
+	(&SetPixelFormatMessage{PixelFormat: pf8}).Write(&client, bo)
+
+	var logs syncBuffer
+	rw := &replayReadWriter{}
+	tracer := Trace(rw, log.New(&logs, "", 0))
+	start := time.Unix(1000, 0)
+	now := start
+	tracer.SetClock(func() time.Time { return now })
+	// Everything the server sent arrives first, so the update is only decoded correctly if the tracer waits for the client's SetPixelFormat.
+	now = start.Add(1500 * time.Millisecond)
+	rw.next.Write(server.Bytes())
+	tracer.Read(make([]byte, server.Len()))
+	tracer.Write(client.Bytes())
+	tracer.Close()
+
+	got := logs.String()
+	for _, want := range []string{"server: 12 bytes at +1.5s", "client: 20 bytes at +1.5s", "server: 17 bytes at +1.5s in 0s: FramebufferUpdate{1 rectangles}"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected log to contain %q, but got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "stopped decoding") {
+		t.Errorf("expected every message to be decoded, but got:\n%s", got)
+	}
+}
@@ -0,0 +1,55 @@
+package rfb
+
+import (
+	"crypto/des"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+)
+
+// NewVNCAuthenticationChallenge returns a random challenge for VNC authentication.
+func NewVNCAuthenticationChallenge() (VNCAuthenticationChallengeMessage, error) {
+	var m VNCAuthenticationChallengeMessage
+	if _, err := rand.Read(m[:]); err != nil {
+		return m, fmt.Errorf("generate challenge: %w", err)
+	}
+	return m, nil
+}
+
+// NewVNCAuthenticationResponse encrypts challenge with password, as a client does for VNC authentication. Only the first 8 bytes of password are significant.
+func NewVNCAuthenticationResponse(challenge VNCAuthenticationChallengeMessage, password string) (VNCAuthenticationResponseMessage, error) {
+	var m VNCAuthenticationResponseMessage
+
+	// The key is the password, truncated or zero-padded to 8 bytes, with the bits of each byte reversed.
+	var key [8]byte
+	copy(key[:], password)
+	for i, b := range key {
+		key[i] = reverseBits(b)
+	}
+
+	cipher, err := des.NewCipher(key[:])
+	if err != nil {
+		return m, fmt.Errorf("create cipher: %w", err)
+	}
+	cipher.Encrypt(m[:8], challenge[:8])
+	cipher.Encrypt(m[8:], challenge[8:])
+	return m, nil
+}
+
+// Verify reports whether m is the correct response to challenge for password.
+func (m *VNCAuthenticationResponseMessage) Verify(challenge VNCAuthenticationChallengeMessage, password string) bool {
+	expected, err := NewVNCAuthenticationResponse(challenge, password)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(m[:], expected[:]) == 1
+}
+
+func reverseBits(b byte) byte {
+	var r byte
+	for i := 0; i < 8; i++ {
+		r = r<<1 | b&1
+		b >>= 1
+	}
+	return r
+}
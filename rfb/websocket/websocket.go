@@ -0,0 +1,275 @@
+// Package websocket carries RFB over WebSocket (RFC 6455), the transport of browser clients such as noVNC. Each binary message holds some of the byte stream, with no regard for where RFB messages begin and end, so a WebSocket connection is just a net.Conn to the server.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// guid is appended to the client's key to make the Sec-WebSocket-Accept header.
+const guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxFrameLength bounds the frames that Conn will read. Browsers split long messages into frames far shorter.
+const maxFrameLength = 16 << 20
+
+// Frame opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Handler returns an http.Handler that upgrades each request to a WebSocket connection and calls serve with it, which should close it when it's done.
+func Handler(serve func(conn net.Conn)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		serve(conn)
+	})
+}
+
+// Upgrade finishes the WebSocket handshake for r and returns the connection. If r isn't a WebSocket handshake that this package supports, Upgrade answers it with an HTTP error and returns why.
+func Upgrade(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+	fail := func(status int, err error) (net.Conn, error) {
+		http.Error(w, err.Error(), status)
+		return nil, err
+	}
+	if r.Method != "GET" {
+		return fail(http.StatusMethodNotAllowed, fmt.Errorf("expected a GET, but got %s", r.Method))
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, errors.New("not a WebSocket handshake"))
+	}
+	if v := r.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusBadRequest, fmt.Errorf("unsupported WebSocket version %q", v))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return fail(http.StatusBadRequest, errors.New("missing Sec-WebSocket-Key"))
+	}
+	// Clients that ask for subprotocols, as noVNC does, expect one to be chosen, and only binary is raw bytes; base64 is for browsers too old to matter.
+	protocols := headerValues(r.Header, "Sec-WebSocket-Protocol")
+	protocol := ""
+	for _, p := range protocols {
+		if p == "binary" {
+			protocol = p
+		}
+	}
+	if len(protocols) > 0 && protocol == "" {
+		return fail(http.StatusBadRequest, fmt.Errorf("unsupported subprotocols %q", protocols))
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, errors.New("connection can't be taken over"))
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, err)
+	}
+
+	accept := sha1.Sum([]byte(key + guid))
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n"
+	if protocol != "" {
+		response += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	if _, err := io.WriteString(netConn, response+"\r\n"); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	// The client may have started sending frames already.
+	return &Conn{Conn: netConn, r: rw.Reader}, nil
+}
+
+// headerContains reports whether any of the comma-separated values of the header is value, ignoring case.
+func headerContains(h http.Header, key, value string) bool {
+	for _, v := range headerValues(h, key) {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func headerValues(h http.Header, key string) []string {
+	var values []string
+	for _, line := range h[http.CanonicalHeaderKey(key)] {
+		for _, v := range strings.Split(line, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// Conn is the server's end of a WebSocket connection. Reads return the payloads of the client's messages, and each Write sends a binary message. Pings are answered while reading.
+type Conn struct {
+	net.Conn
+
+	r         *bufio.Reader
+	remaining int64 // Bytes left in the data frame being read
+	mask      [4]byte
+	maskAt    int
+	readErr   error
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if c.readErr = c.nextFrame(); c.readErr != nil {
+			return 0, c.readErr
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= c.mask[c.maskAt%4]
+		c.maskAt++
+	}
+	c.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextFrame reads frame headers until one starts data, handling control frames on the way. It returns io.EOF once the client closes the connection.
+func (c *Conn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return err
+	}
+	opcode := header[0] & 0xf
+	if header[0]&0x70 != 0 {
+		return c.protocolError("reserved bits are set, but no extension was negotiated")
+	}
+	if header[1]&0x80 == 0 {
+		return c.protocolError("client frame isn't masked")
+	}
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(b[:]) & (1<<63 - 1))
+	}
+	if length > maxFrameLength {
+		return c.protocolError(fmt.Sprintf("frame of %d bytes is longer than %d", length, maxFrameLength))
+	}
+	if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+		return err
+	}
+	c.maskAt = 0
+
+	switch opcode {
+	case opContinuation, opBinary, opText:
+		// Text frames hold the same bytes, as UTF-8, which is all that RFB clients that send them can manage.
+		c.remaining = length
+		return nil
+	case opClose, opPing, opPong:
+		if length > 125 {
+			return c.protocolError("control frame is longer than 125 bytes")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= c.mask[i%4]
+		}
+		switch opcode {
+		case opClose:
+			// Echo the status code, as the close handshake requires.
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			c.closeWith(payload)
+			return io.EOF
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return c.protocolError(fmt.Sprintf("unknown opcode %#x", opcode))
+}
+
+// protocolError closes the connection with status 1002 and returns an error saying why.
+func (c *Conn) protocolError(reason string) error {
+	c.closeWith([]byte{0x03, 0xea})
+	return fmt.Errorf("websocket: %s", reason)
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, byte(n>>8), byte(n))
+	default:
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := (&net.Buffers{header, payload}).WriteTo(c.Conn)
+	return err
+}
+
+// Close sends a close frame, without waiting for the client's, and closes the connection.
+func (c *Conn) Close() error {
+	c.closeWith([]byte{0x03, 0xe8}) // 1000, normal closure
+	return c.Conn.Close()
+}
+
+// closeWith sends a close frame with payload, once.
+func (c *Conn) closeWith(payload []byte) {
+	c.closeOnce.Do(func() {
+		// A client that stopped reading shouldn't hold up the close.
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(opClose, payload)
+	})
+}
@@ -0,0 +1,183 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testClient is the browser's end of a connection, which masks what it sends.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, url string, protocols string) (*testClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := "GET /websockify HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	if protocols != "" {
+		req += "Sec-WebSocket-Protocol: " + protocols + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{t, conn, r}, resp
+}
+
+func (c *testClient) send(opcode byte, fin bool, payload []byte) {
+	c.t.Helper()
+	header := []byte{opcode, 0x80 | byte(len(payload))}
+	if fin {
+		header[0] |= 0x80
+	}
+	if len(payload) >= 126 {
+		header[1] = 0x80 | 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	if _, err := c.conn.Write(append(append(header, mask...), masked...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) receive() (opcode byte, payload []byte) {
+	c.t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		c.t.Fatal("server frame is masked")
+	}
+	length := int(header[1])
+	if length == 126 {
+		var b [2]byte
+		io.ReadFull(c.r, b[:])
+		length = int(binary.BigEndian.Uint16(b[:]))
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return header[0] & 0xf, payload
+}
+
+// echo serves connections by writing back what they read, in whatever chunks Read returns.
+func echo(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 5)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		conn.Write(buf[:n])
+	}
+}
+
+func TestConn(t *testing.T) {
+	srv := httptest.NewServer(Handler(echo))
+	defer srv.Close()
+	c, resp := dial(t, srv.URL, "base64, binary")
+	defer c.conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101 Switching Protocols, but got %s", resp.Status)
+	}
+	// The example from RFC 6455, section 1.3.
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("expected Sec-WebSocket-Accept %q, but got %q", want, got)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "binary" {
+		t.Errorf("expected the binary subprotocol, but got %q", got)
+	}
+
+	// A message split across frames, with a ping between them, reads as one stream. The echo of what came before the ping may come before or after the pong.
+	c.send(opBinary, false, []byte("RFB 003"))
+	c.send(opPing, true, []byte("hi"))
+	c.send(opContinuation, true, []byte(".008\n"))
+	var got []byte
+	ponged := false
+	for len(got) < 12 || !ponged {
+		switch opcode, payload := c.receive(); opcode {
+		case opBinary:
+			got = append(got, payload...)
+		case opPong:
+			if string(payload) != "hi" {
+				t.Errorf("expected the pong to echo the ping, but got %q", payload)
+			}
+			ponged = true
+		default:
+			t.Fatalf("expected binary frames and a pong, but got opcode %#x", opcode)
+		}
+	}
+	if string(got) != "RFB 003.008\n" {
+		t.Errorf("expected the echo, but got %q", got)
+	}
+
+	long := bytes.Repeat([]byte("x"), 300)
+	c.send(opBinary, true, long)
+	got = nil
+	for len(got) < len(long) {
+		_, payload := c.receive()
+		got = append(got, payload...)
+	}
+	if !bytes.Equal(got, long) {
+		t.Errorf("expected %d bytes back, but got %d", len(long), len(got))
+	}
+
+	// Closing ends the server's reads, and the server answers with a close frame of its own.
+	c.send(opClose, true, []byte{0x03, 0xe8})
+	if opcode, payload := c.receive(); opcode != opClose || !bytes.Equal(payload, []byte{0x03, 0xe8}) {
+		t.Errorf("expected a close frame with status 1000, but got opcode %#x with %v", opcode, payload)
+	}
+}
+
+func TestUnmaskedFrame(t *testing.T) {
+	srv := httptest.NewServer(Handler(echo))
+	defer srv.Close()
+	c, _ := dial(t, srv.URL, "")
+	defer c.conn.Close()
+	c.conn.Write([]byte{0x82, 1, 'x'})
+	if opcode, payload := c.receive(); opcode != opClose || !bytes.Equal(payload, []byte{0x03, 0xea}) {
+		t.Errorf("expected a close frame with status 1002, but got opcode %#x with %v", opcode, payload)
+	}
+}
+
+func TestUpgradeErrors(t *testing.T) {
+	srv := httptest.NewServer(Handler(echo))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a plain GET to fail with 400 Bad Request, but got %s", resp.Status)
+	}
+	c, resp := dial(t, srv.URL, "base64")
+	defer c.conn.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected only base64 to fail with 400 Bad Request, but got %s", resp.Status)
+	}
+}
@@ -0,0 +1,44 @@
+package rfb
+
+import (
+	"io"
+)
+
+// EncodingTypeXVP is the xvp pseudo-encoding, -309. Servers that support power control reply to clients that list it with an XVPMessage with XVPInit.
+const EncodingTypeXVP = uint32(0xfffffecb)
+
+// XVPVersion is the version of the xvp extension implemented here.
+const XVPVersion = uint8(1)
+
+// xvp message codes.
+const (
+	XVPFail     = uint8(0) // Sent by servers when an operation fails or isn't supported.
+	XVPInit     = uint8(1) // Sent by servers to say that xvp is supported.
+	XVPShutdown = uint8(2)
+	XVPReboot   = uint8(3)
+	XVPReset    = uint8(4)
+)
+
+// XVPMessage asks for or reports on a power-control operation. It has the same format in both directions.
+type XVPMessage struct {
+	Version uint8
+	Code    uint8
+}
+
+func (m *XVPMessage) Read(r io.Reader) error {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	if buf[0] != 250 {
+		return protocolErrorf(buf[0], 0, "expected message type 250")
+	}
+	m.Version = buf[2]
+	m.Code = buf[3]
+	return nil
+}
+
+func (m *XVPMessage) Write(w io.Writer) error {
+	_, err := w.Write([]byte{250, 0, m.Version, m.Code})
+	return err
+}
@@ -0,0 +1,295 @@
+// Package rfbclient connects to VNC servers and keeps a copy of their framebuffers, decoded from the updates they send, so that they can be automated and tested as well as served.
+package rfbclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncfreethumb/rfb"
+	"image"
+	"image/draw"
+	"io"
+	"net"
+	"sync"
+)
+
+// DefaultEncodings are the encoding types that clients ask for if Options doesn't list any, most preferred first. These are all the encodings that Client can decode without an H.264 codec.
+var DefaultEncodings = []uint32{
+	rfb.EncodingTypeCopyRectangle,
+	rfb.EncodingTypeTight,
+	rfb.EncodingTypeHextile,
+	rfb.EncodingTypeRRE,
+	rfb.EncodingTypeCoRRE,
+	rfb.EncodingTypeRaw,
+}
+
+// Options configures a Client.
+type Options struct {
+	// Password is called for the password if the server requires VNC authentication. If nil, only servers that offer SecurityTypeNone can be used.
+	Password func() (string, error)
+
+	// Shared asks the server to leave other clients connected.
+	Shared bool
+
+	// Hooks observes each phase of the handshake. If nil, rfb.NopHooks is used.
+	Hooks rfb.Hooks
+
+	// PixelFormat is the true-colour format that the server is asked to send pixels in. If nil, rfb.PixelFormatRGBA8888LittleEndian is used, which is the quickest to decode.
+	PixelFormat *rfb.PixelFormat
+
+	// Encodings are the encoding types that the server is asked to use, most preferred first. If nil, DefaultEncodings is used. The pseudo-encodings that Client handles are added to them.
+	Encodings []uint32
+
+	// ManualUpdates stops Run from requesting updates, so that they're only sent in answer to RequestUpdate.
+	ManualUpdates bool
+
+	// OnUpdate is called by Run after each FramebufferUpdate is applied, with the regions that changed. After the framebuffer is resized, the region is the whole framebuffer.
+	OnUpdate func(rects []image.Rectangle)
+
+	// OnBell is called by Run when the server rings the bell.
+	OnBell func()
+
+	// OnCutText is called by Run with text that the server put on its clipboard.
+	OnCutText func(text string)
+
+	// OnCursor, if set, asks the server to send the cursor's shape rather than drawing it into the framebuffer, and is called by Run with each shape and its hotspot.
+	OnCursor func(img *image.NRGBA, hotspot image.Point)
+}
+
+// Client is a connection to a VNC server. Its methods are safe to call concurrently with Run.
+type Client struct {
+	conn        net.Conn
+	opts        Options
+	bo          binary.ByteOrder
+	r           *bufio.Reader
+	name        string
+	pixelFormat rfb.PixelFormat
+	tight       rfb.TightDecoder
+
+	wmu sync.Mutex // Held while writing, so that messages aren't interleaved
+	w   *bufio.Writer
+
+	mu sync.Mutex
+	fb *image.RGBA
+}
+
+// NewClient runs the handshake on conn and asks the server for the pixel format and encodings in opts. It gives up when ctx ends, as in rfb.WithContext. Call Run to receive updates.
+func NewClient(ctx context.Context, conn net.Conn, opts *Options) (*Client, error) {
+	c := &Client{conn: conn, bo: binary.BigEndian, w: bufio.NewWriter(conn)}
+	if opts != nil {
+		c.opts = *opts
+	}
+	handshake, err := rfb.ClientHandshake(ctx, conn, rfb.ClientHandshakeOptions{
+		Password: c.opts.Password,
+		Shared:   c.opts.Shared,
+		Hooks:    c.opts.Hooks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	init := handshake.ServerInit
+	c.name = init.Name
+	c.fb = image.NewRGBA(image.Rect(0, 0, int(init.FramebufferWidth), int(init.FramebufferHeight)))
+	// The client already read ServerInitialisation, so nothing the server sent since is lost.
+	c.r = bufio.NewReader(conn)
+
+	c.pixelFormat = rfb.PixelFormatRGBA8888LittleEndian
+	if c.opts.PixelFormat != nil {
+		c.pixelFormat = *c.opts.PixelFormat
+	}
+	if err := c.pixelFormat.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pixel format: %w", err)
+	}
+	if !c.pixelFormat.TrueColor {
+		return nil, errors.New("colour-mapped pixel formats aren't supported")
+	}
+	encodings := c.opts.Encodings
+	if encodings == nil {
+		encodings = DefaultEncodings
+	}
+	encodings = append(append([]uint32(nil), encodings...), rfb.EncodingTypeExtendedDesktopSize)
+	if c.opts.OnCursor != nil {
+		encodings = append(encodings, rfb.EncodingTypeCursor)
+	}
+	if err := c.send(&rfb.SetPixelFormatMessage{PixelFormat: c.pixelFormat}, &rfb.SetEncodingsMessage{EncodingTypes: encodings}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Name returns the desktop's name, as the server sent it.
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Image returns a copy of the framebuffer.
+func (c *Client) Image() *image.RGBA {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	img := image.NewRGBA(c.fb.Rect)
+	copy(img.Pix, c.fb.Pix)
+	return img
+}
+
+// Run requests the whole framebuffer, then receives messages from the server, applying each update and requesting the next, until the connection fails or ctx ends. With ManualUpdates, it only receives them.
+func (c *Client) Run(ctx context.Context) error {
+	return rfb.WithContext(ctx, c.conn, func() error {
+		if !c.opts.ManualUpdates {
+			if err := c.RequestUpdate(false); err != nil {
+				return err
+			}
+		}
+		for {
+			m, err := rfb.ReadServerMessage(c.r, c.bo, c.pixelFormat, nil)
+			if err != nil {
+				return err
+			}
+			switch m := m.(type) {
+			case *rfb.FramebufferUpdateMessage:
+				rects, cursors, err := c.apply(m)
+				m.Release()
+				if err != nil {
+					return err
+				}
+				for _, cursor := range cursors {
+					c.opts.OnCursor(cursor.img, cursor.hotspot)
+				}
+				if c.opts.OnUpdate != nil {
+					c.opts.OnUpdate(rects)
+				}
+				if c.opts.ManualUpdates {
+					continue
+				}
+				if err := c.RequestUpdate(true); err != nil {
+					return err
+				}
+			case *rfb.BellMessage:
+				if c.opts.OnBell != nil {
+					c.opts.OnBell()
+				}
+			case *rfb.ServerCutTextMessage:
+				if c.opts.OnCutText != nil {
+					c.opts.OnCutText(m.Text)
+				}
+			}
+		}
+	})
+}
+
+type cursorShape struct {
+	img     *image.NRGBA
+	hotspot image.Point
+}
+
+// apply draws the rectangles of m into the framebuffer, returning the regions that changed and any cursor shapes.
+func (c *Client) apply(m *rfb.FramebufferUpdateMessage) ([]image.Rectangle, []cursorShape, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var changed []image.Rectangle
+	var cursors []cursorShape
+	resized := false
+	for _, rect := range m.Rectangles {
+		r := rect.Bounds()
+		switch rect.EncodingType {
+		case rfb.EncodingTypeExtendedDesktopSize:
+			fb := image.NewRGBA(image.Rect(0, 0, int(rect.Width), int(rect.Height)))
+			draw.Draw(fb, fb.Rect, c.fb, image.ZP, draw.Src)
+			c.fb = fb
+			resized = true
+			continue
+		case rfb.EncodingTypeCursor, rfb.EncodingTypeXCursor:
+			img, hotspot, err := rect.Cursor(c.pixelFormat)
+			if err != nil {
+				return nil, nil, fmt.Errorf("decode cursor: %w", err)
+			}
+			if c.opts.OnCursor != nil {
+				cursors = append(cursors, cursorShape{img, hotspot})
+			}
+			continue
+		case rfb.EncodingTypeCopyRectangle:
+			src, err := rect.CopyRectSource()
+			if err != nil {
+				return nil, nil, err
+			}
+			if !r.In(c.fb.Rect) || !r.Sub(r.Min).Add(src).In(c.fb.Rect) {
+				return nil, nil, fmt.Errorf("CopyRect from %v to %v is outside of framebuffer %v", src, r, c.fb.Rect)
+			}
+			// draw.Draw copies overlapping regions of the same image correctly.
+			draw.Draw(c.fb, r, c.fb, src, draw.Src)
+		default:
+			if !r.In(c.fb.Rect) {
+				return nil, nil, fmt.Errorf("%s rectangle %v is outside of framebuffer %v", rfb.EncodingName(rect.EncodingType), r, c.fb.Rect)
+			}
+			var img *rfb.PixelFormatImage
+			var err error
+			if rect.EncodingType == rfb.EncodingTypeTight {
+				img, err = c.tight.Decode(rect, c.pixelFormat)
+			} else {
+				img, err = rect.Decode(c.pixelFormat, nil)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			err = img.CopyToImage(c.fb, r.Min)
+			img.Release()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		changed = append(changed, r)
+	}
+	if resized {
+		changed = []image.Rectangle{c.fb.Rect}
+	}
+	return changed, cursors, nil
+}
+
+// RequestUpdate asks for the whole framebuffer, or only what changed since the last update if incremental. Run does this after every update, unless ManualUpdates is set.
+func (c *Client) RequestUpdate(incremental bool) error {
+	c.mu.Lock()
+	r := c.fb.Rect
+	c.mu.Unlock()
+	return c.send(&rfb.FramebufferUpdateRequestMessage{Incremental: incremental, Width: uint16(r.Dx()), Height: uint16(r.Dy())})
+}
+
+// KeyEvent presses or releases the key with keysym, as defined in package keysym.
+func (c *Client) KeyEvent(keysym uint32, pressed bool) error {
+	return c.send(&rfb.KeyEventMessage{Pressed: pressed, KeySym: keysym})
+}
+
+// PointerEvent moves the pointer to pt with the buttons in buttonMask held, where bit 0 is the left button, bit 1 the middle, and bit 2 the right.
+func (c *Client) PointerEvent(pt image.Point, buttonMask uint8) error {
+	return c.send(&rfb.PointerEventMessage{ButtonMask: buttonMask, X: uint16(pt.X), Y: uint16(pt.Y)})
+}
+
+// CutText puts text on the server's clipboard.
+func (c *Client) CutText(text string) error {
+	return c.send(&rfb.ClientCutTextMessage{Text: text})
+}
+
+// Close closes the connection, which ends Run.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+type clientMessage interface {
+	rfb.ClientMessage
+	Write(w io.Writer, bo binary.ByteOrder) error
+}
+
+// send writes messages to the server and flushes them.
+func (c *Client) send(messages ...clientMessage) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for _, m := range messages {
+		if err := m.Write(c.w, c.bo); err != nil {
+			return fmt.Errorf("write %s: %w", rfb.MessageName(m), err)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
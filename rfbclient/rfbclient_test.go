@@ -0,0 +1,212 @@
+package rfbclient
+
+import (
+	"context"
+	"github.com/alltom/vncfreethumb/extension"
+	"github.com/alltom/vncfreethumb/rfb"
+	"github.com/alltom/vncfreethumb/vncserver"
+	"image"
+	"image/color"
+	"net"
+	"testing"
+	"time"
+)
+
+// gradientDesktop is a gradient, shifted by one for each key press, and records the events it receives.
+type gradientDesktop struct {
+	offset  uint8
+	keys    chan rfb.KeyEventMessage
+	pointer chan rfb.PointerEventMessage
+	text    chan string
+}
+
+func newGradientDesktop() *gradientDesktop {
+	return &gradientDesktop{keys: make(chan rfb.KeyEventMessage, 10), pointer: make(chan rfb.PointerEventMessage, 10), text: make(chan string, 10)}
+}
+
+func (d *gradientDesktop) Size() (width, height int) { return 40, 30 }
+
+func (d *gradientDesktop) at(x, y int) color.RGBA {
+	return color.RGBA{uint8(x*6) + d.offset, uint8(y * 8), 0x80, 0xff}
+}
+
+func (d *gradientDesktop) Render(r image.Rectangle) image.Image {
+	img := image.NewRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, d.at(x, y))
+		}
+	}
+	return img
+}
+
+func (d *gradientDesktop) HandleKey(event rfb.KeyEventMessage) {
+	if event.Pressed {
+		d.offset++
+	}
+	d.keys <- event
+}
+
+func (d *gradientDesktop) HandlePointer(event rfb.PointerEventMessage) { d.pointer <- event }
+
+func (d *gradientDesktop) HandleCutText(text string) { d.text <- text }
+
+type tightEncoder struct{ rfb.TightEncoder }
+
+func (*tightEncoder) EncodingType() uint32 { return rfb.EncodingTypeTight }
+
+type hextileEncoder struct{}
+
+func (hextileEncoder) EncodingType() uint32 { return rfb.EncodingTypeHextile }
+
+func (hextileEncoder) Encode(img *rfb.PixelFormatImage) ([]*rfb.FramebufferUpdateRect, error) {
+	r := img.Bounds()
+	return []*rfb.FramebufferUpdateRect{{
+		X: uint16(r.Min.X), Y: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+		EncodingType: rfb.EncodingTypeHextile, PixelData: rfb.EncodeHextile(img),
+	}}, nil
+}
+
+// connect serves d on one end of a pipe and returns a client connected to the other, running, whose updates are sent to the returned channel.
+func connect(t *testing.T, d *gradientDesktop, opts *Options) (*Client, chan []image.Rectangle) {
+	t.Helper()
+	server, client := net.Pipe()
+	serverOpts := &vncserver.Options{
+		Name: "gradient",
+		Encoders: map[uint32]func() extension.Encoder{
+			rfb.EncodingTypeTight:   func() extension.Encoder { return &tightEncoder{} },
+			rfb.EncodingTypeHextile: func() extension.Encoder { return hextileEncoder{} },
+		},
+	}
+	go func() {
+		vncserver.ServeConn(context.Background(), server, func() (vncserver.Desktop, error) { return d, nil }, serverOpts)
+		server.Close()
+	}()
+
+	updates := make(chan []image.Rectangle, 10)
+	if opts == nil {
+		opts = &Options{}
+	}
+	opts.OnUpdate = func(rects []image.Rectangle) { updates <- rects }
+	c, err := NewClient(context.Background(), client, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run(context.Background())
+	t.Cleanup(func() { c.Close() })
+	return c, updates
+}
+
+func wait(t *testing.T, updates chan []image.Rectangle) []image.Rectangle {
+	t.Helper()
+	select {
+	case rects := <-updates:
+		return rects
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an update")
+		return nil
+	}
+}
+
+func checkImage(t *testing.T, img *image.RGBA, d *gradientDesktop) {
+	t.Helper()
+	if img.Rect != image.Rect(0, 0, 40, 30) {
+		t.Fatalf("expected a 40x30 framebuffer, but got %v", img.Rect)
+	}
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			if got, want := img.RGBAAt(x, y), d.at(x, y); got != want {
+				t.Fatalf("expected %v at (%d, %d), but got %v", want, x, y, got)
+			}
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		encodings   []uint32
+		pixelFormat *rfb.PixelFormat
+	}{
+		{"raw", []uint32{rfb.EncodingTypeRaw}, nil},
+		{"hextile", []uint32{rfb.EncodingTypeHextile}, nil},
+		{"tight", []uint32{rfb.EncodingTypeTight}, nil},
+		{"big-endian", []uint32{rfb.EncodingTypeTight}, &rfb.PixelFormatRGBA8888BigEndian},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			d := newGradientDesktop()
+			c, updates := connect(t, d, &Options{Encodings: test.encodings, PixelFormat: test.pixelFormat})
+			if c.Name() != "gradient" {
+				t.Errorf("expected the desktop to be named gradient, but got %q", c.Name())
+			}
+			if rects := wait(t, updates); len(rects) == 0 {
+				t.Errorf("expected the first update to change something")
+			}
+			checkImage(t, c.Image(), d)
+
+			// Key presses change the desktop, so the next update catches the client up.
+			if err := c.KeyEvent('a', true); err != nil {
+				t.Fatal(err)
+			}
+			if event := <-d.keys; event.KeySym != 'a' || !event.Pressed {
+				t.Errorf("expected the desktop to get a press of a, but got %v", event)
+			}
+			wait(t, updates)
+			checkImage(t, c.Image(), d)
+		})
+	}
+}
+
+func TestClientInput(t *testing.T) {
+	d := newGradientDesktop()
+	c, updates := connect(t, d, nil)
+	wait(t, updates)
+	if err := c.PointerEvent(image.Pt(3, 4), 1); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-d.pointer; event.X != 3 || event.Y != 4 || event.ButtonMask != 1 {
+		t.Errorf("expected a click at (3, 4), but got %v", event)
+	}
+	if err := c.CutText("copied"); err != nil {
+		t.Fatal(err)
+	}
+	if text := <-d.text; text != "copied" {
+		t.Errorf("expected the desktop to get \"copied\", but got %q", text)
+	}
+}
+
+func TestClientManualUpdates(t *testing.T) {
+	d := newGradientDesktop()
+	c, updates := connect(t, d, &Options{ManualUpdates: true})
+	select {
+	case <-updates:
+		t.Fatal("expected no update before one was requested")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := c.RequestUpdate(false); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, updates)
+	checkImage(t, c.Image(), d)
+}
+
+func TestClientCopyRect(t *testing.T) {
+	c := &Client{fb: image.NewRGBA(image.Rect(0, 0, 4, 1))}
+	copy(c.fb.Pix, []byte{1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4})
+	// An overlapping copy one pixel to the right.
+	rects, _, err := c.apply(&rfb.FramebufferUpdateMessage{Rectangles: []*rfb.FramebufferUpdateRect{rfb.NewCopyRect(image.Rect(1, 0, 4, 1), image.Pt(0, 0))}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rects) != 1 || rects[0] != image.Rect(1, 0, 4, 1) {
+		t.Errorf("expected the copy's destination to change, but got %v", rects)
+	}
+	want := []byte{1, 1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3}
+	if string(c.fb.Pix) != string(want) {
+		t.Errorf("expected %v, but got %v", want, c.fb.Pix)
+	}
+
+	if _, _, err := c.apply(&rfb.FramebufferUpdateMessage{Rectangles: []*rfb.FramebufferUpdateRect{rfb.NewCopyRect(image.Rect(2, 0, 4, 1), image.Pt(3, 0))}}); err == nil {
+		t.Errorf("expected a CopyRect from outside the framebuffer to fail")
+	}
+}
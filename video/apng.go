@@ -0,0 +1,218 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"time"
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// APNGWriter writes animated PNG, which viewers without APNG support show as its first frame. Each frame after the first only holds the rectangle that changed, and frames that change nothing only lengthen the one before them.
+type APNGWriter struct {
+	w      io.WriteSeeker
+	err    error
+	enc    png.Encoder
+	actlAt int64 // Offset of the acTL chunk, which is rewritten once the number of frames is known
+
+	frame   *image.RGBA // The frame that's being shown
+	pending *image.RGBA // The frame to write once its duration is known, or nil
+	changed image.Rectangle
+	since   time.Duration // When pending is shown from
+	frames  uint32
+	seq     uint32 // Sequence number of the next fcTL or fdAT chunk
+}
+
+// NewAPNGWriter returns an APNGWriter to w, which must be empty. It seeks back to the beginning of w when it's closed, to record how many frames were written.
+func NewAPNGWriter(w io.WriteSeeker) *APNGWriter {
+	return &APNGWriter{w: w, enc: png.Encoder{CompressionLevel: png.BestSpeed}}
+}
+
+func (w *APNGWriter) WriteFrame(img image.Image, at time.Duration) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.frame == nil {
+		w.frame = image.NewRGBA(img.Bounds().Sub(img.Bounds().Min))
+		canvas(w.frame, img)
+		w.pending, w.changed, w.since = w.frame, w.frame.Rect, at
+		return nil
+	}
+	if at < w.since {
+		return fmt.Errorf("frame at %v is before the last, at %v", at, w.since)
+	}
+	next := image.NewRGBA(w.frame.Rect)
+	canvas(next, img)
+	changed := diff(w.frame, next)
+	if changed.Empty() {
+		return nil
+	}
+	if w.pending != nil && at == w.since {
+		// Frames shown for no time at all are merged, since viewers show them for longer.
+		w.frame, w.pending, w.changed = next, next, w.changed.Union(changed)
+		return nil
+	}
+	if err := w.flush(at); err != nil {
+		return err
+	}
+	w.frame, w.pending, w.changed, w.since = next, next, changed, at
+	return nil
+}
+
+func (w *APNGWriter) Close(end time.Duration) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.frame == nil {
+		return errors.New("no frames were written")
+	}
+	if end < w.since {
+		end = w.since
+	}
+	if err := w.flush(end); err != nil {
+		return err
+	}
+	w.chunk("IEND", nil)
+	if w.err != nil {
+		return w.err
+	}
+	// Now that the number of frames is known, fill it in.
+	if _, w.err = w.w.Seek(w.actlAt, io.SeekStart); w.err != nil {
+		return w.err
+	}
+	w.chunk("acTL", actl(w.frames))
+	return w.err
+}
+
+// flush writes the pending frame, shown until end.
+func (w *APNGWriter) flush(end time.Duration) error {
+	if w.pending == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := w.enc.Encode(&buf, w.pending.SubImage(w.changed)); err != nil {
+		return err
+	}
+	header, data, err := splitPNG(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if w.frames == 0 {
+		// The first frame is the default image, which viewers without APNG support show, and sets the size and colour type of the rest.
+		_, w.err = io.WriteString(w.w, pngSignature)
+		w.chunk("IHDR", header)
+		if w.err == nil {
+			w.actlAt, w.err = w.w.Seek(0, io.SeekCurrent)
+		}
+		w.chunk("acTL", actl(0))
+	}
+	w.chunk("fcTL", w.fctl(end-w.since))
+	if w.frames == 0 {
+		w.chunk("IDAT", data)
+	} else {
+		fdat := make([]byte, 4+len(data))
+		binary.BigEndian.PutUint32(fdat, w.seq)
+		w.seq++
+		copy(fdat[4:], data)
+		w.chunk("fdAT", fdat)
+	}
+	w.frames++
+	w.pending = nil
+	return w.err
+}
+
+// fctl returns the body of the frame control chunk for the pending frame, shown for delay.
+func (w *APNGWriter) fctl(delay time.Duration) []byte {
+	b := make([]byte, 26)
+	binary.BigEndian.PutUint32(b[0:], w.seq)
+	w.seq++
+	binary.BigEndian.PutUint32(b[4:], uint32(w.changed.Dx()))
+	binary.BigEndian.PutUint32(b[8:], uint32(w.changed.Dy()))
+	binary.BigEndian.PutUint32(b[12:], uint32(w.changed.Min.X))
+	binary.BigEndian.PutUint32(b[16:], uint32(w.changed.Min.Y))
+	// Delays are fractions of 16-bit numbers, so those too long to count in milliseconds are counted in seconds.
+	num, den := delay.Milliseconds(), int64(1000)
+	if num > 0xffff {
+		num, den = int64(delay.Round(time.Second)/time.Second), 1
+		if num > 0xffff {
+			num = 0xffff
+		}
+	}
+	binary.BigEndian.PutUint16(b[20:], uint16(num))
+	binary.BigEndian.PutUint16(b[22:], uint16(den))
+	// b[24] and b[25], the dispose and blend ops, are 0: leave the frame in place, replacing what was under it.
+	return b
+}
+
+// actl returns the body of the animation control chunk for frames frames, played once.
+func actl(frames uint32) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b, frames)
+	return b
+}
+
+func (w *APNGWriter) chunk(chunkType string, data []byte) {
+	if w.err != nil {
+		return
+	}
+	b := make([]byte, 8+len(data)+4)
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	copy(b[4:], chunkType)
+	copy(b[8:], data)
+	binary.BigEndian.PutUint32(b[8+len(data):], crc32.ChecksumIEEE(b[4:8+len(data)]))
+	_, w.err = w.w.Write(b)
+}
+
+// splitPNG returns the body of the IHDR chunk of a PNG file, and the image data in its IDAT chunks.
+func splitPNG(b []byte) (header, data []byte, err error) {
+	if !bytes.HasPrefix(b, []byte(pngSignature)) {
+		return nil, nil, errors.New("missing PNG signature")
+	}
+	b = b[len(pngSignature):]
+	for len(b) >= 12 {
+		length := int(binary.BigEndian.Uint32(b))
+		if 12+length > len(b) {
+			break
+		}
+		body := b[8 : 8+length]
+		switch string(b[4:8]) {
+		case "IHDR":
+			header = body
+		case "IDAT":
+			data = append(data, body...)
+		}
+		b = b[12+length:]
+	}
+	if header == nil || data == nil {
+		return nil, nil, errors.New("PNG is missing its header or image data")
+	}
+	return header, data, nil
+}
+
+// diff returns the smallest rectangle that holds every pixel that differs between a and b, which are the same size.
+func diff(a, b *image.RGBA) image.Rectangle {
+	r := image.Rectangle{}
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		rowA := a.Pix[a.PixOffset(a.Rect.Min.X, y):a.PixOffset(a.Rect.Max.X, y)]
+		rowB := b.Pix[b.PixOffset(b.Rect.Min.X, y):b.PixOffset(b.Rect.Max.X, y)]
+		if bytes.Equal(rowA, rowB) {
+			continue
+		}
+		first, last := 0, len(rowA)/4-1
+		for first < last && bytes.Equal(rowA[4*first:4*first+4], rowB[4*first:4*first+4]) {
+			first++
+		}
+		for last > first && bytes.Equal(rowA[4*last:4*last+4], rowB[4*last:4*last+4]) {
+			last--
+		}
+		r = r.Union(image.Rect(a.Rect.Min.X+first, y, a.Rect.Min.X+last+1, y+1))
+	}
+	return r
+}
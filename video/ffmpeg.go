@@ -0,0 +1,117 @@
+package video
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// ffmpegWriter pipes frames to ffmpeg as raw video at a constant frame rate, repeating each frame for as long as it's shown.
+type ffmpegWriter struct {
+	path    string
+	fps     float64
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  bytes.Buffer
+	frame   *image.RGBA   // The last frame, which is written again until the next
+	first   time.Duration // When the first frame was shown
+	written int64         // Frames written so far
+	err     error         // Why ffmpeg failed, once it has
+}
+
+func newFFmpegWriter(path string, fps float64) (*ffmpegWriter, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, fmt.Errorf("writing %s needs ffmpeg, which wasn't found (%v); write a .png or .apng file for animated PNG instead", path, err)
+	}
+	return &ffmpegWriter{path: path, fps: fps}, nil
+}
+
+// start runs ffmpeg for frames of size.
+func (w *ffmpegWriter) start(size image.Point) error {
+	w.cmd = exec.Command("ffmpeg", "-loglevel", "error", "-y",
+		"-f", "rawvideo", "-pix_fmt", "rgba", "-s", fmt.Sprintf("%dx%d", size.X, size.Y), "-framerate", strconv.FormatFloat(w.fps, 'g', -1, 64), "-i", "-",
+		// Most codecs need even dimensions for yuv420p, which is what players support best.
+		"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2", "-pix_fmt", "yuv420p", w.path)
+	w.cmd.Stderr = &w.stderr
+	var err error
+	if w.stdin, err = w.cmd.StdinPipe(); err != nil {
+		return err
+	}
+	if err := w.cmd.Start(); err != nil {
+		return fmt.Errorf("run ffmpeg: %w", err)
+	}
+	return nil
+}
+
+func (w *ffmpegWriter) WriteFrame(img image.Image, at time.Duration) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.frame == nil {
+		if err := w.start(img.Bounds().Size()); err != nil {
+			return err
+		}
+		w.frame, w.first = image.NewRGBA(img.Bounds().Sub(img.Bounds().Min)), at
+	} else if err := w.repeat(at); err != nil {
+		return err
+	}
+	canvas(w.frame, img)
+	return nil
+}
+
+// repeat writes the last frame as many times as it takes to fill the video until until.
+func (w *ffmpegWriter) repeat(until time.Duration) error {
+	total := int64(math.Round((until - w.first).Seconds() * w.fps))
+	for ; w.written < total; w.written++ {
+		if _, err := w.stdin.Write(w.frame.Pix); err != nil {
+			return w.fail(err)
+		}
+	}
+	return nil
+}
+
+func (w *ffmpegWriter) Close(end time.Duration) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.frame == nil {
+		return errors.New("no frames were written")
+	}
+	// Even a video that ends at once shows its last frame.
+	if min := w.first + time.Duration(float64(time.Second)/w.fps); w.written == 0 && end < min {
+		end = min
+	}
+	if err := w.repeat(end); err != nil {
+		return err
+	}
+	// Closing stdin tells ffmpeg that the video is over.
+	return w.wait(w.stdin.Close())
+}
+
+// fail stops ffmpeg after err, returning it along with anything ffmpeg said about it.
+func (w *ffmpegWriter) fail(err error) error {
+	w.stdin.Close()
+	return w.wait(err)
+}
+
+// wait waits for ffmpeg to exit, returning the first of err and the reason it failed, if either is non-nil, along with anything it said.
+func (w *ffmpegWriter) wait(err error) error {
+	if werr := w.cmd.Wait(); err == nil {
+		err = werr
+	}
+	if err == nil {
+		return nil
+	}
+	if msg := bytes.TrimSpace(w.stderr.Bytes()); len(msg) > 0 {
+		w.err = fmt.Errorf("ffmpeg: %v: %s", err, msg)
+	} else {
+		w.err = fmt.Errorf("ffmpeg: %w", err)
+	}
+	return w.err
+}
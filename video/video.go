@@ -0,0 +1,76 @@
+// Package video encodes sequences of frames, such as a session's framebuffer as it's updated, into animations for sharing: animated PNG, which it writes itself, or any format that ffmpeg can write, with ffmpeg.
+package video
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Writer encodes frames shown at increasing times. Videos start with the first frame, whenever it's shown. Every frame is the size of the first; larger ones are cropped, and smaller ones are padded with black.
+type Writer interface {
+	// WriteFrame adds img, shown from at, measured from the start of the video, until the next frame. It doesn't keep img.
+	WriteFrame(img image.Image, at time.Duration) error
+
+	// Close finishes the video, showing the last frame until end.
+	Close(end time.Duration) error
+}
+
+// Options configures Create.
+type Options struct {
+	// FPS is the frame rate of formats written with ffmpeg, which repeat frames to keep to it. If 0, 10 is used. Animated PNG shows each frame for exactly as long as it's given.
+	FPS float64
+}
+
+// Create returns a Writer to a new file at path, as animated PNG if path ends in .png or .apng, or with ffmpeg in the format that it chooses for path's extension otherwise.
+func Create(path string, opts *Options) (Writer, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.FPS == 0 {
+		o.FPS = 10
+	}
+	if o.FPS < 0 {
+		return nil, fmt.Errorf("frame rate must be positive, but was %v", o.FPS)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".apng":
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return &fileWriter{NewAPNGWriter(f), f}, nil
+	case "":
+		return nil, errors.New("can't tell which format to write without a file extension")
+	}
+	return newFFmpegWriter(path, o.FPS)
+}
+
+// fileWriter closes the file that its Writer writes to.
+type fileWriter struct {
+	Writer
+	f *os.File
+}
+
+func (w *fileWriter) Close(end time.Duration) error {
+	err := w.Writer.Close(end)
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// canvas copies img onto dst, cropping or padding it, and makes it opaque, so that each frame is encoded alike.
+func canvas(dst *image.RGBA, img image.Image) {
+	draw.Draw(dst, dst.Rect, image.Black, image.ZP, draw.Src)
+	draw.Draw(dst, dst.Rect, img, img.Bounds().Min, draw.Src)
+	for i := 3; i < len(dst.Pix); i += 4 {
+		dst.Pix[i] = 0xff
+	}
+}
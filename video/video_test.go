@@ -0,0 +1,125 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type chunk struct {
+	chunkType string
+	data      []byte
+}
+
+func readChunks(t *testing.T, b []byte) []chunk {
+	t.Helper()
+	if !bytes.HasPrefix(b, []byte(pngSignature)) {
+		t.Fatal("missing PNG signature")
+	}
+	b = b[len(pngSignature):]
+	var chunks []chunk
+	for len(b) > 0 {
+		length := int(binary.BigEndian.Uint32(b))
+		chunks = append(chunks, chunk{string(b[4:8]), b[8 : 8+length]})
+		b = b[12+length:]
+	}
+	return chunks
+}
+
+func solid(r image.Rectangle, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(r)
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestAPNG(t *testing.T) {
+	dir, err := ioutil.TempDir("", "video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.apng")
+	w, err := Create(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	red, blue := color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff}
+	frame := solid(image.Rect(0, 0, 8, 6), red)
+	if err := w.WriteFrame(frame, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing changes, so this only lengthens the first frame.
+	if err := w.WriteFrame(frame, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	frame.SetRGBA(2, 3, blue)
+	frame.SetRGBA(4, 1, blue)
+	if err := w.WriteFrame(frame, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(3 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	var fctls [][]byte
+	for _, c := range readChunks(t, b) {
+		types = append(types, c.chunkType)
+		switch c.chunkType {
+		case "acTL":
+			if frames := binary.BigEndian.Uint32(c.data); frames != 2 {
+				t.Errorf("expected acTL to count 2 frames, but it counted %d", frames)
+			}
+		case "fcTL":
+			fctls = append(fctls, c.data)
+		}
+	}
+	if want := []string{"IHDR", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "IEND"}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("expected chunks %v, but got %v", want, types)
+	}
+	for i, want := range []struct {
+		r          image.Rectangle
+		num, den   uint16
+		sequenceNo uint32
+	}{
+		{image.Rect(0, 0, 8, 6), 1000, 1000, 0},
+		{image.Rect(2, 1, 5, 4), 2000, 1000, 1},
+	} {
+		fctl := fctls[i]
+		be := binary.BigEndian
+		r := image.Rect(int(be.Uint32(fctl[12:])), int(be.Uint32(fctl[16:])), int(be.Uint32(fctl[12:])+be.Uint32(fctl[4:])), int(be.Uint32(fctl[16:])+be.Uint32(fctl[8:])))
+		if r != want.r || be.Uint16(fctl[20:]) != want.num || be.Uint16(fctl[22:]) != want.den || be.Uint32(fctl) != want.sequenceNo {
+			t.Errorf("expected frame %d to be %v for %d/%d s, numbered %d, but got %v for %d/%d s, numbered %d", i, want.r, want.num, want.den, want.sequenceNo, r, be.Uint16(fctl[20:]), be.Uint16(fctl[22:]), be.Uint32(fctl))
+		}
+	}
+
+	// Viewers without APNG support show the first frame.
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.RGBAModel.Convert(img.At(2, 3)); got != red {
+		t.Errorf("expected the first frame to be %v, but got %v", red, got)
+	}
+}
+
+func TestCreateUnknownFormat(t *testing.T) {
+	if _, err := Create(filepath.Join(os.TempDir(), "session"), nil); err == nil {
+		t.Error("expected an error for a path without an extension")
+	}
+}
@@ -0,0 +1,113 @@
+package vncserver
+
+import (
+	"image"
+)
+
+// everything covers any framebuffer, whose coordinates are 16 bits.
+var everything = image.Rect(0, 0, 1<<16, 1<<16)
+
+// maxDamageRects is how many separate rectangles of damage a session keeps before merging them into one.
+const maxDamageRects = 16
+
+// desktopChanged records what changed after the desktop handled an event, for every client that shares it.
+func (s *session) desktopChanged() {
+	s.mu.Lock()
+	damage := changes(s.desktop)
+	s.mu.Unlock()
+	if s.srv != nil {
+		s.srv.damage(damage)
+	} else {
+		s.addDamage(damage...)
+	}
+}
+
+// changes returns what changed in desktop since it was last asked. Desktops that aren't Damagers may have changed anywhere. The desktop's lock must be held.
+func changes(desktop Desktop) []image.Rectangle {
+	if d, ok := desktop.(Damager); ok {
+		return d.Damage()
+	}
+	return []image.Rectangle{everything}
+}
+
+// addDamage records that rects changed since the client was last sent them, waking the session if it's waiting for a change.
+func (s *session) addDamage(rects ...image.Rectangle) {
+	s.damageMu.Lock()
+	changed := false
+	for _, r := range rects {
+		if r.Empty() {
+			continue
+		}
+		changed = true
+		// Merge overlapping rectangles, so that no pixel is encoded twice.
+		for i := 0; i < len(s.damage); {
+			if s.damage[i].Overlaps(r) {
+				r = r.Union(s.damage[i])
+				s.damage = append(s.damage[:i], s.damage[i+1:]...)
+				i = 0
+			} else {
+				i++
+			}
+		}
+		s.damage = append(s.damage, r)
+	}
+	if len(s.damage) > maxDamageRects {
+		var bounds image.Rectangle
+		for _, d := range s.damage {
+			bounds = bounds.Union(d)
+		}
+		s.damage = []image.Rectangle{bounds}
+	}
+	s.damageMu.Unlock()
+	if changed {
+		s.notify()
+	}
+}
+
+// takeDamage returns the damage in r, which is about to be sent, keeping only what's left of the rest of the damage in screen.
+func (s *session) takeDamage(r, screen image.Rectangle) []image.Rectangle {
+	s.damageMu.Lock()
+	defer s.damageMu.Unlock()
+	var taken, left []image.Rectangle
+	for _, d := range s.damage {
+		d = d.Intersect(screen)
+		if in := d.Intersect(r); !in.Empty() {
+			taken = append(taken, in)
+		}
+		for _, rest := range subtractRect(d, r) {
+			if !rest.Empty() {
+				left = append(left, rest)
+			}
+		}
+	}
+	s.damage = left
+	return taken
+}
+
+func (s *session) damaged(r image.Rectangle) bool {
+	s.damageMu.Lock()
+	defer s.damageMu.Unlock()
+	for _, d := range s.damage {
+		if d.Overlaps(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify wakes sendUpdates.
+func (s *session) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// updateDue reports whether the client should be sent an update for r now, rather than waiting for something to change.
+func (s *session) updateDue(r image.Rectangle) bool {
+	s.mu.Lock()
+	width, height := s.desktop.Size()
+	cursorChanged := s.cursorChanged()
+	s.mu.Unlock()
+	return s.damaged(r.Intersect(image.Rect(0, 0, width, height))) || s.pendingDesktopSize != nil || cursorChanged
+}
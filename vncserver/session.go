@@ -0,0 +1,675 @@
+package vncserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncfreethumb/extension"
+	"github.com/alltom/vncfreethumb/rfb"
+	"image"
+	"net"
+	"sync"
+	"time"
+)
+
+// session is the state of one connection after the handshake.
+type session struct {
+	opts Options
+	srv  *Server  // nil if the desktop is the session's own
+	conn net.Conn // before the handshake wrapped it
+
+	// stateMu is held while handling a message or sending updates, which happen on separate goroutines.
+	stateMu sync.Mutex
+
+	// viewOnly discards the client's input, along with anything else that would change the desktop or its host, such as resizes, uploads, and xvp.
+	viewOnly bool
+	c        *rfb.Conn
+	hooks    rfb.Hooks
+	desktop  Desktop
+	mover    MoveTracker // nil unless desktop is a Mover
+	mu       *sync.Mutex // held while calling desktop
+
+	pixelFormat         rfb.PixelFormat
+	preferred           []extension.Encoder // the client's encodings that the server supports, most preferred first; empty for raw
+	encoders            map[uint32]extension.Encoder
+	copyRect            bool   // whether the client accepts CopyRect
+	cursorEncoding      uint32 // a cursor pseudo-encoding, if the client draws the cursor itself, or 0
+	sentCursor          *Cursor
+	width, height       int  // the framebuffer size that the client was last told
+	extendedDesktopSize bool // whether the client may resize the framebuffer
+	pendingDesktopSize  *rfb.ExtendedDesktopSize
+	screenID            uint32          // chosen by the client in SetDesktopSize
+	continuousUpdates   bool            // whether the client may enable continuous updates
+	continuousRegion    image.Rectangle // empty unless continuous updates are enabled
+	fences              bool            // whether the client supports fences
+	fencesInFlight      int             // fence requests the client hasn't answered
+	syncFence           *rfb.FenceMessage
+	xvp                 bool   // whether the client has been offered xvp
+	extendedClipboard   bool   // whether the client supports the Extended Clipboard
+	clipboard           string // the text the client last copied
+	transfers           rfb.FileTransfer
+	ended               error // why the server ended the session, such as ErrDisplaced; guarded by srv.sessionsMu
+
+	// The update requests the client is waiting on, merged into one.
+	requested          image.Rectangle
+	hasRequest         bool
+	requestIncremental bool
+	requestedAt        time.Time
+	sentAt             time.Time // when the last update was sent
+
+	damageMu sync.Mutex
+	damage   []image.Rectangle // what changed since the client was last sent it, which don't overlap
+	wake     chan struct{}     // signalled when an update may be due
+}
+
+// serveConn runs a session with srv's desktop, or if srv is nil, with one from newDesktop.
+func serveConn(ctx context.Context, conn net.Conn, srv *Server, newDesktop func() (Desktop, error), opts *Options) error {
+	s := &session{
+		srv: srv, conn: conn, pixelFormat: rfb.PixelFormatRGBA8888BigEndian, encoders: make(map[uint32]extension.Encoder),
+		damage: []image.Rectangle{everything}, wake: make(chan struct{}, 1),
+	}
+	if srv != nil {
+		s.mu = &srv.mu
+		newDesktop = func() (Desktop, error) { return srv.desktop, nil }
+	} else {
+		s.mu = &sync.Mutex{}
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	s.hooks = s.opts.Hooks
+	if s.hooks == nil {
+		s.hooks = rfb.NopHooks{}
+	}
+	stats := &rfb.Stats{}
+	s.hooks = rfb.JoinHooks(s.hooks, stats)
+	if s.opts.Stats != nil {
+		s.hooks = rfb.JoinHooks(s.hooks, s.opts.Stats)
+	}
+	ctx, end := s.hooks.Connection(ctx, conn.RemoteAddr().String())
+	err := s.serve(ctx, conn, newDesktop, stats)
+	end(err)
+	return err
+}
+
+func (s *session) serve(ctx context.Context, conn net.Conn, newDesktop func() (Desktop, error), stats *rfb.Stats) error {
+	timeout := s.opts.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	handshakeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	handshake, err := rfb.ServerHandshake(handshakeCtx, conn, rfb.ServerHandshakeOptions{
+		Security: s.opts.Security,
+		Hooks:    s.hooks,
+		Init: func(clientInit rfb.ClientInitialisationMessage) (rfb.ServerInitialisationMessage, error) {
+			if s.srv != nil {
+				if err := s.srv.join(s, clientInit.Shared); err != nil {
+					return rfb.ServerInitialisationMessage{}, err
+				}
+			}
+			var err error
+			if s.desktop, err = newDesktop(); err != nil {
+				return rfb.ServerInitialisationMessage{}, fmt.Errorf("create desktop: %w", err)
+			}
+			s.mu.Lock()
+			s.width, s.height = s.desktop.Size()
+			if desktop, ok := s.desktop.(Mover); ok {
+				s.mover = desktop.NewMoveTracker()
+			}
+			s.mu.Unlock()
+			return rfb.ServerInitialisationMessage{
+				FramebufferWidth:  uint16(s.width),
+				FramebufferHeight: uint16(s.height),
+				PixelFormat:       s.pixelFormat,
+				Name:              s.opts.Name,
+			}, nil
+		},
+	})
+	if s.srv != nil {
+		defer s.srv.leave(s)
+	}
+	if err != nil {
+		return &HandshakeError{err}
+	}
+	conn = handshake.Conn
+	s.viewOnly = s.opts.ViewOnly || handshake.ViewOnly
+	if s.viewOnly {
+		s.opts.Files, s.opts.XVPHandler = nil, nil
+	}
+
+	if s.opts.Files != nil {
+		s.transfers.Handler = s.opts.Files
+		defer s.transfers.Close()
+	}
+	// Server.Shutdown may write to the connection as soon as it's set.
+	s.stateMu.Lock()
+	s.c = rfb.NewConn(conn, s.pixelFormat)
+	s.c.SetStrict(s.opts.Strict)
+	if s.opts.Stats != nil {
+		s.c.SetStats(stats, s.opts.Stats)
+	} else {
+		s.c.SetStats(stats)
+	}
+	s.stateMu.Unlock()
+	defer func() { s.opts.logf("%s: %s", conn.RemoteAddr(), stats.Snapshot()) }()
+
+	done := make(chan struct{})
+	updateErr := make(chan error, 1)
+	go func() {
+		err := s.sendUpdates(ctx, done)
+		updateErr <- err
+		if err != nil {
+			// Stop Receive.
+			conn.SetReadDeadline(time.Now())
+		}
+	}()
+	defer func() {
+		close(done)
+		// Don't wait on a write to a client that stopped reading.
+		conn.SetWriteDeadline(time.Now())
+		<-updateErr
+	}()
+
+	// stopped returns why the session was made to stop, if it was, such as by a deadline that stopped Receive.
+	stopped := func() error {
+		if s.srv != nil {
+			if ended := s.srv.ended(s); ended != nil {
+				return ended
+			}
+		}
+		select {
+		case err := <-updateErr:
+			// Put it back for the deferred wait.
+			updateErr <- err
+			return err
+		default:
+		}
+		return nil
+	}
+	for {
+		if s.opts.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.opts.IdleTimeout))
+			// The session may have been stopped before the deadline was replaced.
+			if err := stopped(); err != nil {
+				return err
+			}
+		}
+		msg, err := s.c.Receive()
+		if err != nil {
+			if err := stopped(); err != nil {
+				return err
+			}
+			var netErr net.Error
+			if s.opts.IdleTimeout > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+				return fmt.Errorf("%w after %v", ErrIdle, s.opts.IdleTimeout)
+			}
+			return err
+		}
+		s.stateMu.Lock()
+		err = s.dispatch(ctx, msg)
+		s.stateMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dispatch handles msg, then sends the fence response that was waiting on it, if any.
+func (s *session) dispatch(ctx context.Context, msg rfb.ClientMessage) error {
+	pendingFence := s.syncFence
+	s.syncFence = nil
+	msgCtx, end := s.hooks.DispatchMessage(ctx, rfb.MessageName(msg))
+	err := s.handle(msgCtx, msg)
+	end(err)
+	if err != nil {
+		return err
+	}
+	if pendingFence != nil {
+		return s.writeFence(pendingFence)
+	}
+	return nil
+}
+
+// sendUpdate writes a FramebufferUpdate for the region r, along with any pending pseudo-encoding rectangles. If incremental is true, only the parts of r that changed are sent; otherwise the client may have lost its framebuffer, so all of r is sent and nothing is copied from it.
+func (s *session) sendUpdate(ctx context.Context, r image.Rectangle, incremental bool) error {
+	// The desktop must not change between rendering and encoding, since the image may be reused.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The region may predate a resize, by this client or another.
+	width, height := s.desktop.Size()
+	screen := image.Rect(0, 0, width, height)
+	r = r.Intersect(screen)
+	// Whatever changes from now on will need to be sent next time.
+	regions := s.takeDamage(r, screen)
+
+	if s.extendedDesktopSize && s.pendingDesktopSize == nil && (width != s.width || height != s.height) {
+		s.pendingDesktopSize = s.desktopSize(rfb.DesktopSizeReasonOtherClient, rfb.DesktopSizeStatusOK, width, height)
+	}
+	var pseudo []*rfb.FramebufferUpdateRect
+	if s.pendingDesktopSize != nil {
+		rect, err := s.pendingDesktopSize.Rect()
+		if err != nil {
+			return fmt.Errorf("encode ExtendedDesktopSize: %w", err)
+		}
+		pseudo = append(pseudo, rect)
+		s.width, s.height = int(s.pendingDesktopSize.Width), int(s.pendingDesktopSize.Height)
+		s.pendingDesktopSize = nil
+		// Clients may not keep their framebuffer's contents through a resize.
+		incremental = false
+	}
+	if s.cursorChanged() {
+		c := s.desktop.(CursorDesktop).Cursor()
+		rect := rfb.NewXCursorRect(c.Image, c.Hotspot)
+		if s.cursorEncoding == rfb.EncodingTypeCursor {
+			var err error
+			if rect, err = rfb.NewCursorRect(c.Image, c.Hotspot, s.pixelFormat); err != nil {
+				return fmt.Errorf("encode cursor: %w", err)
+			}
+		}
+		pseudo = append(pseudo, rect)
+		s.sentCursor = c
+	}
+	if !incremental {
+		regions = []image.Rectangle{r}
+	}
+
+	s.sentAt = time.Now()
+	end := s.hooks.EncodeFrame(ctx, r)
+	n, err := writeFramebufferUpdate(s.c, s.pixelFormat, s.preferred, regions, pseudo, func(bounds image.Rectangle) (image.Image, []*rfb.FramebufferUpdateRect) {
+		img := s.desktop.Render(bounds)
+		if s.mover == nil {
+			return img, nil
+		}
+		// Moves must be called for every frame to keep track of what the client has. A non-incremental request means the client may not have it.
+		if moves := s.mover.Moves(r); s.copyRect && incremental {
+			return img, moves
+		}
+		return img, nil
+	})
+	end(n, err)
+	return err
+}
+
+// cursorChanged reports whether the client draws the cursor and hasn't been sent its current shape. s.mu must be held.
+func (s *session) cursorChanged() bool {
+	desktop, ok := s.desktop.(CursorDesktop)
+	if !ok || s.cursorEncoding == 0 {
+		return false
+	}
+	c := desktop.Cursor()
+	return c != nil && c != s.sentCursor
+}
+
+func (s *session) writeFence(m *rfb.FenceMessage) error {
+	if err := s.c.Send(m); err != nil {
+		return err
+	}
+	if m.Flags&rfb.FenceRequest != 0 {
+		s.fencesInFlight++
+	}
+	return nil
+}
+
+// sendUpdates sends updates as they come due, until done is closed. The handlers only record what the client asked for and what changed, then wake it.
+func (s *session) sendUpdates(ctx context.Context, done <-chan struct{}) error {
+	for {
+		select {
+		case <-s.wake:
+		case <-done:
+			return nil
+		}
+		// Whatever comes due while waiting goes out together.
+		if s.opts.MaxFPS > 0 {
+			if wait := time.Duration(float64(time.Second)/s.opts.MaxFPS) - time.Since(s.sentAt); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-done:
+					return nil
+				}
+			}
+		}
+		s.stateMu.Lock()
+		err := s.update(ctx)
+		s.stateMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// update answers the client's update request if it's due, and sends a continuous update if any of its region changed.
+//
+// Incremental requests wait for their region to change, since answering them right away would have clients poll as fast as the link allows. If they time out, they're answered with an empty update: the server hears of every change, through Damager and Server.Update, so there's nothing to resend.
+//
+// If the client supports fences, each continuous update is followed by one, and further updates wait until the client answers. That keeps a slow client from falling ever further behind.
+func (s *session) update(ctx context.Context) error {
+	if s.hasRequest {
+		due := !s.requestIncremental || s.updateDue(s.requested)
+		if !due && time.Since(s.requestedAt) >= s.incrementalTimeout() {
+			due = true
+		}
+		if due {
+			s.hasRequest = false
+			if err := s.sendUpdate(ctx, s.requested, s.requestIncremental); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.continuousRegion.Empty() || (s.fences && s.fencesInFlight > 0) || !s.updateDue(s.continuousRegion) {
+		return nil
+	}
+	if err := s.sendUpdate(ctx, s.continuousRegion, true); err != nil {
+		return err
+	}
+	if s.fences {
+		return s.writeFence(&rfb.FenceMessage{Flags: rfb.FenceRequest | rfb.FenceBlockBefore})
+	}
+	return nil
+}
+
+// request records an update request, merging it with any the client is already waiting on.
+func (s *session) request(r image.Rectangle, incremental bool) {
+	if s.hasRequest {
+		s.requested = s.requested.Union(r)
+		s.requestIncremental = s.requestIncremental && incremental
+	} else {
+		s.requested, s.requestIncremental, s.hasRequest = r, incremental, true
+	}
+	s.requestedAt = time.Now()
+	if incremental {
+		time.AfterFunc(s.incrementalTimeout(), s.notify)
+	}
+	s.notify()
+}
+
+func (s *session) incrementalTimeout() time.Duration {
+	if s.opts.IncrementalTimeout == 0 {
+		return DefaultIncrementalTimeout
+	}
+	return s.opts.IncrementalTimeout
+}
+
+// shutdown ends the session for Server.Shutdown, once any update or message in progress is done, telling the client reason if it isn't empty. The server has already set s.ended.
+func (s *session) shutdown(reason string) {
+	// Don't wait long on a client that stopped reading.
+	s.conn.SetWriteDeadline(time.Now().Add(shutdownWriteTimeout))
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.c != nil && reason != "" {
+		if err := s.c.Send(&rfb.ServerCutTextMessage{Text: reason}); err != nil {
+			s.opts.logf("%s: couldn't send shutdown reason: %v", s.conn.RemoteAddr(), err)
+		}
+	}
+	// Stop Receive, leaving the connection for the caller of ServeConn to close.
+	s.conn.SetReadDeadline(time.Now())
+}
+
+func (s *session) endContinuousUpdates() error {
+	return s.c.Send(&rfb.EndOfContinuousUpdatesMessage{})
+}
+
+func (s *session) writeXVP(code uint8) error {
+	return s.c.Send(&rfb.XVPMessage{Version: rfb.XVPVersion, Code: code})
+}
+
+func (s *session) writeClipboard(extended *rfb.ExtendedClipboard) error {
+	return s.c.Send(&rfb.ServerCutTextMessage{Extended: extended})
+}
+
+// desktopSize returns an ExtendedDesktopSize describing a framebuffer of the given size, which is always a single screen.
+func (s *session) desktopSize(reason, status uint16, width, height int) *rfb.ExtendedDesktopSize {
+	return &rfb.ExtendedDesktopSize{
+		Reason: reason, Status: status,
+		Width: uint16(width), Height: uint16(height),
+		Screens: []rfb.Screen{{ID: s.screenID, Width: uint16(width), Height: uint16(height)}},
+	}
+}
+
+func (s *session) setClipboard(text string) {
+	s.clipboard = text
+	if s.viewOnly {
+		return
+	}
+	s.mu.Lock()
+	s.desktop.HandleCutText(text)
+	s.mu.Unlock()
+	s.desktopChanged()
+}
+
+func (s *session) handle(ctx context.Context, msg rfb.ClientMessage) error {
+	switch m := msg.(type) {
+	case *rfb.SetPixelFormatMessage:
+		// Receive has validated it.
+		if m.PixelFormat.Equal(s.pixelFormat) {
+			return nil
+		}
+		s.pixelFormat = m.PixelFormat
+		s.sentCursor = nil
+		s.addDamage(everything)
+		if !s.pixelFormat.TrueColor {
+			// The server chooses the colours, and rendering uses the same map.
+			if err := s.c.Send(rfb.DefaultColourMap().Entries()); err != nil {
+				return err
+			}
+		}
+
+	case *rfb.FixColourMapEntriesMessage:
+		// Ignore, since the server chooses the colours.
+
+	case *rfb.SetEncodingsMessage:
+		return s.setEncodings(m)
+
+	case *rfb.FramebufferUpdateRequestMessage:
+		s.request(image.Rect(int(m.X), int(m.Y), int(m.X)+int(m.Width), int(m.Y)+int(m.Height)), m.Incremental)
+
+	case *rfb.KeyEventMessage:
+		if s.viewOnly {
+			return nil
+		}
+		s.mu.Lock()
+		s.desktop.HandleKey(*m)
+		s.mu.Unlock()
+		s.desktopChanged()
+
+	case *rfb.PointerEventMessage:
+		if s.viewOnly {
+			return nil
+		}
+		s.mu.Lock()
+		s.desktop.HandlePointer(*m)
+		s.mu.Unlock()
+		s.desktopChanged()
+
+	case *rfb.ClientCutTextMessage:
+		if m.Extended == nil {
+			if len(m.Text) <= maxClipboardText {
+				s.setClipboard(m.Text)
+			}
+			return nil
+		}
+		if !s.extendedClipboard {
+			return nil
+		}
+		// Only text is supported.
+		switch c := m.Extended; {
+		case c.Flags&rfb.ClipboardCaps != 0:
+			// The client's caps need no reply.
+		case c.Flags&rfb.ClipboardRequest != 0 && c.Flags&rfb.ClipboardText != 0:
+			return s.writeClipboard(rfb.NewClipboardProvideText(s.clipboard))
+		case c.Flags&rfb.ClipboardPeek != 0:
+			return s.writeClipboard(&rfb.ExtendedClipboard{Flags: rfb.ClipboardNotify | rfb.ClipboardText})
+		case c.Flags&rfb.ClipboardNotify != 0 && c.Flags&rfb.ClipboardText != 0:
+			return s.writeClipboard(&rfb.ExtendedClipboard{Flags: rfb.ClipboardRequest | rfb.ClipboardText})
+		case c.Flags&rfb.ClipboardProvide != 0:
+			if text, ok := c.Text(); ok && len(text) <= maxClipboardText {
+				s.setClipboard(text)
+			}
+		}
+
+	case *rfb.FileTransferMessage:
+		if s.opts.Files == nil {
+			return nil
+		}
+		if err := s.transfers.Handle(m, func(reply *rfb.FileTransferMessage) error {
+			return s.c.Send(reply)
+		}); err != nil {
+			return fmt.Errorf("handle FileTransfer: %w", err)
+		}
+
+	case *rfb.EnableContinuousUpdatesMessage:
+		if !s.continuousUpdates {
+			return nil
+		}
+		if !m.Enable {
+			s.continuousRegion = image.ZR
+			return s.endContinuousUpdates()
+		}
+		s.continuousRegion = image.Rect(int(m.X), int(m.Y), int(m.X)+int(m.Width), int(m.Y)+int(m.Height))
+		s.notify()
+
+	case *rfb.FenceMessage:
+		if m.Flags&rfb.FenceRequest == 0 {
+			// The client answered one of ours.
+			if s.fencesInFlight > 0 {
+				s.fencesInFlight--
+			}
+			s.notify()
+			return nil
+		}
+		// Messages are handled one at a time, so BlockBefore and BlockAfter hold already.
+		response := &rfb.FenceMessage{Flags: m.Flags & (rfb.FenceBlockBefore | rfb.FenceBlockAfter | rfb.FenceSyncNext), Data: m.Data}
+		if m.Flags&rfb.FenceSyncNext != 0 {
+			s.syncFence = response
+			return nil
+		}
+		return s.writeFence(response)
+
+	case *rfb.XVPMessage:
+		if !s.xvp {
+			return nil
+		}
+		if m.Version != rfb.XVPVersion || m.Code < rfb.XVPShutdown || m.Code > rfb.XVPReset {
+			return s.writeXVP(rfb.XVPFail)
+		}
+		if err := s.opts.XVPHandler(m.Code); err != nil {
+			s.opts.logf("xvp operation %d failed: %v", m.Code, err)
+			return s.writeXVP(rfb.XVPFail)
+		}
+
+	case *rfb.SetDesktopSizeMessage:
+		if !s.extendedDesktopSize {
+			return nil
+		}
+		status := rfb.DesktopSizeStatusOK
+		switch {
+		case m.Width == 0 || m.Height == 0 || len(m.Screens) == 0:
+			status = rfb.DesktopSizeStatusInvalidLayout
+		case m.Width > maxDesktopSize || m.Height > maxDesktopSize:
+			status = rfb.DesktopSizeStatusOutOfResources
+		default:
+			desktop, ok := s.desktop.(Resizer)
+			if !ok || s.viewOnly {
+				status = rfb.DesktopSizeStatusProhibited
+				break
+			}
+			// There's only ever one screen, which covers the framebuffer.
+			s.mu.Lock()
+			desktop.Resize(int(m.Width), int(m.Height))
+			s.mu.Unlock()
+			s.desktopChanged()
+			s.screenID = m.Screens[0].ID
+		}
+		s.mu.Lock()
+		width, height := s.desktop.Size()
+		s.mu.Unlock()
+		s.pendingDesktopSize = s.desktopSize(rfb.DesktopSizeReasonClient, status, width, height)
+		s.notify()
+	}
+	return nil
+}
+
+// setEncodings chooses how to encode updates from the client's encoding types, which are in order of preference.
+func (s *session) setEncodings(m *rfb.SetEncodingsMessage) error {
+	// Encoders are kept for the life of the connection because their state, such as compression streams, is shared with the client.
+	s.preferred = nil
+	s.copyRect, s.cursorEncoding, s.sentCursor = false, 0, nil
+	for _, encodingType := range m.EncodingTypes {
+		switch encodingType {
+		case rfb.EncodingTypeCopyRectangle:
+			s.copyRect = true
+		case rfb.EncodingTypeCursor:
+			s.cursorEncoding = encodingType
+		case rfb.EncodingTypeXCursor:
+			// Use XCursor only if the client doesn't also support RichCursor.
+			if s.cursorEncoding == 0 {
+				s.cursorEncoding = encodingType
+			}
+		case rfb.EncodingTypeContinuousUpdates:
+			// Telling the client that continuous updates have ended is how it learns that they're supported.
+			if !s.continuousUpdates {
+				s.continuousUpdates = true
+				if err := s.endContinuousUpdates(); err != nil {
+					return err
+				}
+			}
+		case rfb.EncodingTypeFence:
+			// Sending a fence request is how the client learns that fences are supported.
+			if !s.fences {
+				s.fences = true
+				if err := s.writeFence(&rfb.FenceMessage{Flags: rfb.FenceRequest}); err != nil {
+					return err
+				}
+			}
+		case rfb.EncodingTypeXVP:
+			if !s.xvp && s.opts.XVPHandler != nil {
+				s.xvp = true
+				if err := s.writeXVP(rfb.XVPInit); err != nil {
+					return err
+				}
+			}
+		case rfb.EncodingTypeExtendedClipboard:
+			if !s.extendedClipboard {
+				s.extendedClipboard = true
+				if err := s.writeClipboard(&rfb.ExtendedClipboard{
+					Flags: rfb.ClipboardCaps | rfb.ClipboardRequest | rfb.ClipboardPeek | rfb.ClipboardNotify | rfb.ClipboardProvide | rfb.ClipboardText,
+					Sizes: []uint32{maxClipboardText},
+				}); err != nil {
+					return err
+				}
+			}
+		case rfb.EncodingTypeExtendedDesktopSize:
+			// Telling the client the screen layout is what lets it send SetDesktopSize.
+			if !s.extendedDesktopSize {
+				s.extendedDesktopSize = true
+				s.mu.Lock()
+				width, height := s.desktop.Size()
+				s.mu.Unlock()
+				s.pendingDesktopSize = s.desktopSize(rfb.DesktopSizeReasonServer, rfb.DesktopSizeStatusOK, width, height)
+			}
+		}
+	}
+	settings := m.Settings()
+	for _, encodingType := range m.EncodingTypes {
+		if encodingType == rfb.EncodingTypeRaw {
+			// The client prefers raw to anything listed after it.
+			break
+		}
+		e, ok := s.encoders[encodingType]
+		if !ok {
+			newEncoder, ok := s.opts.Encoders[encodingType]
+			if !ok {
+				continue
+			}
+			e = newEncoder()
+			s.encoders[encodingType] = e
+		}
+		if e, ok := e.(extension.ConfigurableEncoder); ok {
+			e.Configure(settings)
+		}
+		s.preferred = append(s.preferred, e)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package vncserver
+
+import (
+	"fmt"
+	"github.com/alltom/vncfreethumb/extension"
+	"github.com/alltom/vncfreethumb/rfb"
+	"image"
+	"image/draw"
+)
+
+// buffers recycles the pixel buffers of framebuffer updates, which are allocated for every frame.
+var buffers rfb.BufferPool
+
+// writeFramebufferUpdate renders the bounds of regions with render and writes them as a FramebufferUpdate, returning the number of bytes written. The pseudo-encoding rectangles in pseudo, such as cursor shapes, are sent first, then the CopyRect rectangles returned by render, and the rest of regions is encoded as in encodeRegion. If regions is empty, nothing is rendered.
+func writeFramebufferUpdate(c *rfb.Conn, pixelFormat rfb.PixelFormat, encoders []extension.Encoder, regions []image.Rectangle, pseudo []*rfb.FramebufferUpdateRect, render func(bounds image.Rectangle) (image.Image, []*rfb.FramebufferUpdateRect)) (int, error) {
+	var update rfb.FramebufferUpdateMessage
+	update.Rectangles = append(update.Rectangles, pseudo...)
+
+	var bounds image.Rectangle
+	for _, region := range regions {
+		bounds = bounds.Union(region)
+	}
+	if !bounds.Empty() {
+		img, copies := render(bounds)
+		if !bounds.In(img.Bounds()) {
+			// Whatever the desktop didn't draw is black.
+			pix := buffers.Get(4 * bounds.Dx() * bounds.Dy())
+			defer buffers.Put(pix)
+			for i := range pix {
+				pix[i] = 0
+			}
+			rgba := &image.RGBA{Pix: pix, Stride: 4 * bounds.Dx(), Rect: bounds}
+			draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+			img = rgba
+		}
+
+		update.Rectangles = append(update.Rectangles, copies...)
+		for _, rect := range copies {
+			var remaining []image.Rectangle
+			for _, region := range regions {
+				remaining = append(remaining, subtractRect(region, rect.Bounds())...)
+			}
+			regions = remaining
+		}
+		for _, region := range regions {
+			if region.Empty() {
+				continue
+			}
+			rects, img2, err := encodeRegion(img, pixelFormat, encoders, region)
+			if err != nil {
+				return 0, err
+			}
+			// Raw rectangles share img2's pixels, so it can't be reused until they're written.
+			defer img2.Release()
+			update.Rectangles = append(update.Rectangles, rects...)
+		}
+	}
+
+	if err := c.Send(&update); err != nil {
+		return 0, err
+	}
+	n := 4
+	for _, rect := range update.Rectangles {
+		n += 12 + len(rect.PixelData)
+	}
+	return n, nil
+}
+
+// encodeRegion encodes the part of img in r with the first of encoders that suits it, or raw if none do. It also returns the pooled image that it encoded, which the caller releases once the rectangles are written.
+func encodeRegion(img image.Image, pixelFormat rfb.PixelFormat, encoders []extension.Encoder, r image.Rectangle) ([]*rfb.FramebufferUpdateRect, *rfb.PixelFormatImage, error) {
+	img2, err := buffers.NewPixelFormatImage(pixelFormat, r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create PixelFormatImage: %w", err)
+	}
+	if err := img2.CopyFromImage(img, r.Min); err != nil {
+		img2.Release()
+		return nil, nil, fmt.Errorf("serialize image: %w", err)
+	}
+
+	for _, encoder := range encoders {
+		if e, ok := encoder.(extension.SelectiveEncoder); ok && !e.Suits(img2) {
+			continue
+		}
+		rects, err := encoder.Encode(img2)
+		if err != nil {
+			img2.Release()
+			return nil, nil, fmt.Errorf("encode image with encoding type %d: %w", encoder.EncodingType(), err)
+		}
+		return rects, img2, nil
+	}
+	return []*rfb.FramebufferUpdateRect{
+		{
+			X: uint16(r.Min.X), Y: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+			EncodingType: rfb.EncodingTypeRaw, PixelData: img2.Pix,
+		},
+	}, img2, nil
+}
+
+// subtractRect returns non-overlapping rectangles that together cover the part of r outside of hole.
+func subtractRect(r, hole image.Rectangle) []image.Rectangle {
+	hole = hole.Intersect(r)
+	if hole.Empty() {
+		return []image.Rectangle{r}
+	}
+	var rects []image.Rectangle
+	for _, rect := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, hole.Min.Y),       // above
+		image.Rect(r.Min.X, hole.Max.Y, r.Max.X, r.Max.Y),       // below
+		image.Rect(r.Min.X, hole.Min.Y, hole.Min.X, hole.Max.Y), // left
+		image.Rect(hole.Max.X, hole.Min.Y, r.Max.X, hole.Max.Y), // right
+	} {
+		if !rect.Empty() {
+			rects = append(rects, rect)
+		}
+	}
+	return rects
+}
@@ -0,0 +1,357 @@
+/*
+Package vncserver serves an application over VNC. The application implements Desktop, drawing the framebuffer and handling input, and the package takes care of the rest of the RFB protocol, in the manner of net/http:
+
+	ln, err := net.Listen("tcp", "127.0.0.1:5900")
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(vncserver.Serve(ln, myDesktop{}, nil))
+
+Every client shares the desktop, each with its own pixel format and encodings, unless one asks for exclusive access; see SharePolicy. ServeConn serves a separate desktop to each client instead.
+
+Clients are sent updates whenever the desktop changes, which is assumed to be only in response to their input unless the application makes changes through Server.Update.
+
+Desktops may also implement Damager, Mover, Resizer, and CursorDesktop to send only what changed, support CopyRect and client-initiated resizes, and have clients draw the pointer.
+*/
+package vncserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncfreethumb/extension"
+	"github.com/alltom/vncfreethumb/rfb"
+	"image"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Desktop is an application served over VNC. Calls are never concurrent, even when several clients share the desktop.
+type Desktop interface {
+	// Size returns the size of the framebuffer.
+	Size() (width, height int)
+
+	// Render draws the part of the framebuffer in region. The image is used before the next call to Render, so it may be reused.
+	Render(region image.Rectangle) image.Image
+
+	HandleKey(event rfb.KeyEventMessage)
+	HandlePointer(event rfb.PointerEventMessage)
+
+	// HandleCutText is called with text that the client copied.
+	HandleCutText(text string)
+}
+
+// Mover is a Desktop that can tell which parts of the framebuffer moved. Each client gets its own MoveTracker.
+type Mover interface {
+	Desktop
+	NewMoveTracker() MoveTracker
+}
+
+// MoveTracker follows what one client has of the framebuffer. Moves is called after every Render for the client, with the region that the update brings up to date, which may be larger than the one rendered if only part of it changed. It returns CopyRect rectangles for the parts of that region that can be copied from what the client had.
+type MoveTracker interface {
+	Moves(region image.Rectangle) []*rfb.FramebufferUpdateRect
+}
+
+// Damager is a Desktop that can tell which parts of the framebuffer changed. Damage is called after the desktop handles each event, and returns what changed since the last call, so that clients are only sent those parts. Desktops that aren't Damagers are assumed to change everywhere with each event.
+type Damager interface {
+	Desktop
+	Damage() []image.Rectangle
+}
+
+// Resizer is a Desktop whose framebuffer clients may resize.
+type Resizer interface {
+	Desktop
+	Resize(width, height int)
+}
+
+// CursorDesktop is a Desktop with a pointer shape, for clients that draw the cursor themselves. The cursor is sent again whenever Cursor returns a different pointer.
+type CursorDesktop interface {
+	Desktop
+	Cursor() *Cursor
+}
+
+// Cursor is a pointer shape.
+type Cursor struct {
+	Image   image.Image
+	Hotspot image.Point
+}
+
+// Options configures how desktops are served.
+type Options struct {
+	// Name is the desktop's name, which clients may show as a title.
+	Name string
+
+	// Security authenticates clients. If nil, only SecurityTypeNone is offered.
+	Security *rfb.SecurityHandlers
+
+	// Hooks observes each connection. If nil, rfb.NopHooks is used.
+	Hooks rfb.Hooks
+
+	// Stats, if set, collects statistics across every connection, in addition to those that each connection logs when it ends.
+	Stats *rfb.Stats
+
+	// HandshakeTimeout is how long clients have to finish the handshake, including any password prompt. If zero, DefaultHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+
+	// Encoders creates the encoders offered to clients, by encoding type. Raw is always available.
+	Encoders map[uint32]func() extension.Encoder
+
+	// XVPHandler, if set, carries out xvp power-control operations requested by clients.
+	XVPHandler func(code uint8) error
+
+	// Files, if set, is offered to clients through UltraVNC file transfer.
+	Files rfb.FileHandler
+
+	// Strict disconnects clients that send any message longer than rfb.StrictMaxMessageLength.
+	Strict bool
+
+	// ErrorLog logs failed connections and per-connection statistics. If nil, the log package's standard logger is used.
+	ErrorLog *log.Logger
+
+	// IdleTimeout, if set, disconnects clients that send nothing for this long with ErrIdle, such as those whose hosts went away without closing the connection. Viewers that only watch send update requests at least every IncrementalTimeout, but those that enabled continuous updates may send nothing at all.
+	IdleTimeout time.Duration
+
+	// IncrementalTimeout is how long an incremental update request may wait for its region to change before it's answered anyway, with an update that has no rectangles, for clients that give up on requests that go unanswered. If zero, DefaultIncrementalTimeout is used.
+	IncrementalTimeout time.Duration
+
+	// MaxFPS caps how many updates each client is sent per second. Updates that come due sooner wait, and go out together with whatever else changes in the meantime. If zero, updates are sent as soon as they're due.
+	MaxFPS float64
+
+	// ViewOnly discards input from every client, so that they can only watch. Clients that authenticate with a view-only password, as marked by rfb.ViewOnly, are always view-only.
+	ViewOnly bool
+
+	// SharePolicy decides what a Server does when a client asks for exclusive access by clearing the Shared flag of ClientInitialisation.
+	SharePolicy SharePolicy
+}
+
+// SharePolicy is what a Server does with clients that don't ask to share the desktop.
+type SharePolicy int
+
+const (
+	// DisconnectOthers disconnects the other clients, as the RFB spec suggests, which end with ErrDisplaced.
+	DisconnectOthers SharePolicy = iota
+
+	// RefuseExclusive refuses the client if others are connected.
+	RefuseExclusive
+
+	// AlwaysShare leaves the other clients connected anyway.
+	AlwaysShare
+)
+
+// ErrDisplaced is what sessions end with when another client takes exclusive access to the desktop.
+var ErrDisplaced = errors.New("another client took exclusive access")
+
+// ErrIdle is what sessions end with when the client sends nothing for Options.IdleTimeout.
+var ErrIdle = errors.New("client idle")
+
+// ErrServerClosed is what Server.Serve and sessions end with after Server.Shutdown.
+var ErrServerClosed = errors.New("server shut down")
+
+// shutdownWriteTimeout is how long Server.Shutdown waits on each client to accept the rest of an update and the shutdown reason.
+const shutdownWriteTimeout = 5 * time.Second
+
+// DefaultHandshakeTimeout is the HandshakeTimeout used if Options doesn't set one.
+const DefaultHandshakeTimeout = 2 * time.Minute
+
+// DefaultIncrementalTimeout is the IncrementalTimeout used if Options doesn't set one.
+const DefaultIncrementalTimeout = time.Second
+
+// maxClipboardText is the most clipboard text, in bytes, that the server keeps from clients. Longer text is ignored.
+const maxClipboardText = 1 << 20
+
+// maxDesktopSize is the largest framebuffer width or height that clients may ask for.
+const maxDesktopSize = 4096
+
+// HandshakeError is what sessions end with if the client didn't finish the handshake.
+type HandshakeError struct {
+	Err error
+}
+
+func (e *HandshakeError) Error() string {
+	return "handshake: " + e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// Server serves one Desktop to any number of clients at once.
+type Server struct {
+	desktop Desktop
+	opts    *Options
+	mu      sync.Mutex // held while calling desktop
+
+	sessionsMu sync.Mutex
+	sessions   map[*session]bool
+	listeners  map[net.Listener]bool
+	closed     bool
+	running    sync.WaitGroup // sessions that have joined
+}
+
+// NewServer returns a Server for desktop. opts may be nil.
+func NewServer(desktop Desktop, opts *Options) *Server {
+	return &Server{desktop: desktop, opts: opts, sessions: make(map[*session]bool), listeners: make(map[net.Listener]bool)}
+}
+
+// Serve accepts connections on ln and serves the desktop to each of them, until Accept fails or the server shuts down, in which case it returns ErrServerClosed. It closes each connection when its session ends.
+func (srv *Server) Serve(ln net.Listener) error {
+	srv.sessionsMu.Lock()
+	if srv.closed {
+		srv.sessionsMu.Unlock()
+		return ErrServerClosed
+	}
+	srv.listeners[ln] = true
+	srv.sessionsMu.Unlock()
+	defer func() {
+		srv.sessionsMu.Lock()
+		delete(srv.listeners, ln)
+		srv.sessionsMu.Unlock()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			srv.sessionsMu.Lock()
+			closed := srv.closed
+			srv.sessionsMu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go func() {
+			err := srv.ServeConn(context.Background(), conn)
+			srv.opts.logConnError(conn, err)
+			if err := conn.Close(); err != nil {
+				srv.opts.logf("couldn't close connection: %v", err)
+			}
+		}()
+	}
+}
+
+// ServeConn runs a session on conn, alongside any others, returning when it ends. It doesn't close conn.
+func (srv *Server) ServeConn(ctx context.Context, conn net.Conn) error {
+	return serveConn(ctx, conn, srv, nil, srv.opts)
+}
+
+// Update calls f, which may change the desktop, while no other Desktop method is running, then sends what changed to the clients that are waiting for it. It's how the desktop changes other than in response to clients.
+func (srv *Server) Update(f func()) {
+	srv.mu.Lock()
+	f()
+	damage := changes(srv.desktop)
+	srv.mu.Unlock()
+	srv.damage(damage)
+}
+
+// join adds s to the sessions, applying the SharePolicy if it wasn't shared.
+func (srv *Server) join(s *session, shared bool) error {
+	srv.sessionsMu.Lock()
+	defer srv.sessionsMu.Unlock()
+	if srv.closed {
+		return ErrServerClosed
+	}
+	if !shared {
+		switch s.opts.SharePolicy {
+		case DisconnectOthers:
+			for other := range srv.sessions {
+				other.ended = ErrDisplaced
+				other.conn.Close()
+			}
+		case RefuseExclusive:
+			if len(srv.sessions) > 0 {
+				return fmt.Errorf("client asked for exclusive access, but %d others are connected", len(srv.sessions))
+			}
+		}
+	}
+	srv.sessions[s] = true
+	srv.running.Add(1)
+	return nil
+}
+
+func (srv *Server) leave(s *session) {
+	srv.sessionsMu.Lock()
+	defer srv.sessionsMu.Unlock()
+	if srv.sessions[s] {
+		delete(srv.sessions, s)
+		srv.running.Done()
+	}
+}
+
+// damage records that rects changed for every session.
+func (srv *Server) damage(rects []image.Rectangle) {
+	srv.sessionsMu.Lock()
+	defer srv.sessionsMu.Unlock()
+	for s := range srv.sessions {
+		s.addDamage(rects...)
+	}
+}
+
+func (srv *Server) ended(s *session) error {
+	srv.sessionsMu.Lock()
+	defer srv.sessionsMu.Unlock()
+	return s.ended
+}
+
+// Shutdown stops the server: it closes the listeners passed to Serve, refuses new clients, and ends every session once it's done with the update or message in progress, telling each client reason first if it isn't empty. Since RFB has no way to say why the server disconnected, the reason is sent as clipboard text. Shutdown returns once every session has ended, or with ctx's error if ctx is done first.
+func (srv *Server) Shutdown(ctx context.Context, reason string) error {
+	srv.sessionsMu.Lock()
+	srv.closed = true
+	for ln := range srv.listeners {
+		if err := ln.Close(); err != nil {
+			srv.opts.logf("couldn't close listener: %v", err)
+		}
+	}
+	var sessions []*session
+	for s := range srv.sessions {
+		s.ended = ErrServerClosed
+		sessions = append(sessions, s)
+	}
+	srv.sessionsMu.Unlock()
+
+	for _, s := range sessions {
+		go s.shutdown(reason)
+	}
+	done := make(chan struct{})
+	go func() {
+		srv.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Serve serves desktop to the clients that connect to ln, as in Server.Serve.
+func Serve(ln net.Listener, desktop Desktop, opts *Options) error {
+	return NewServer(desktop, opts).Serve(ln)
+}
+
+// ServeConn runs a session on conn with a desktop of its own, returning when it ends. It doesn't close conn. newDesktop is called once the client has authenticated, so that a desktop can be set up only for clients that get that far.
+func ServeConn(ctx context.Context, conn net.Conn, newDesktop func() (Desktop, error), opts *Options) error {
+	return serveConn(ctx, conn, nil, newDesktop, opts)
+}
+
+func (opts *Options) logf(format string, args ...interface{}) {
+	if opts != nil && opts.ErrorLog != nil {
+		opts.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (opts *Options) logConnError(conn net.Conn, err error) {
+	var protocolErr *rfb.ProtocolError
+	switch {
+	case errors.Is(err, ErrDisplaced), errors.Is(err, ErrServerClosed), errors.Is(err, ErrIdle):
+		opts.logf("%s: %v", conn.RemoteAddr(), err)
+	case errors.As(err, &protocolErr):
+		opts.logf("%s: client broke the protocol: %v", conn.RemoteAddr(), err)
+	case err != nil:
+		opts.logf("%s: serve failed: %v", conn.RemoteAddr(), err)
+	}
+}
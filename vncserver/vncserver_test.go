@@ -0,0 +1,515 @@
+package vncserver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/alltom/vncfreethumb/extension"
+	"github.com/alltom/vncfreethumb/rfb"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testDesktop is a solid colour, and records the events it receives.
+type testDesktop struct {
+	color  color.RGBA
+	keys   []rfb.KeyEventMessage
+	clicks []rfb.PointerEventMessage
+	text   string
+}
+
+func (d *testDesktop) Size() (width, height int) { return 4, 3 }
+
+func (d *testDesktop) Render(r image.Rectangle) image.Image {
+	return image.NewUniform(d.color)
+}
+
+func (d *testDesktop) HandleKey(event rfb.KeyEventMessage) {
+	d.keys = append(d.keys, event)
+	d.color.R++
+}
+
+func (d *testDesktop) HandlePointer(event rfb.PointerEventMessage) {
+	d.clicks = append(d.clicks, event)
+}
+
+func (d *testDesktop) HandleCutText(text string) { d.text = text }
+
+// testClient is the client side of a session.
+type testClient struct {
+	t           *testing.T
+	conn        net.Conn
+	serverInit  rfb.ServerInitialisationMessage
+	pixelFormat rfb.PixelFormat
+	done        chan error // receives the session's error
+}
+
+// connect runs serve on one end of a pipe and handshakes on the other, asking to share the desktop.
+func connect(t *testing.T, serve func(conn net.Conn) error) *testClient {
+	t.Helper()
+	c, err := dial(t, true, serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func dial(t *testing.T, shared bool, serve func(conn net.Conn) error) (*testClient, error) {
+	server, client := net.Pipe()
+	c := &testClient{t: t, conn: client, done: make(chan error, 1)}
+	go func() {
+		c.done <- serve(server)
+		server.Close()
+	}()
+	handshake, err := rfb.ClientHandshake(context.Background(), client, rfb.ClientHandshakeOptions{Shared: shared})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	c.serverInit = handshake.ServerInit
+	c.pixelFormat = handshake.ServerInit.PixelFormat
+	return c, nil
+}
+
+func (c *testClient) send(messages ...interface {
+	Write(w io.Writer, bo binary.ByteOrder) error
+}) {
+	c.t.Helper()
+	for _, m := range messages {
+		if err := m.Write(c.conn, binary.BigEndian); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// update requests the whole framebuffer and returns the colour of its first pixel.
+func (c *testClient) update() color.Color {
+	c.t.Helper()
+	img, err := c.updateRect().Decode(c.pixelFormat, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return color.RGBAModel.Convert(img.At(0, 0))
+}
+
+// updateRect requests the whole framebuffer and returns the rectangle that covers it.
+func (c *testClient) updateRect() *rfb.FramebufferUpdateRect {
+	c.t.Helper()
+	c.send(&rfb.FramebufferUpdateRequestMessage{Width: 4, Height: 3})
+	m, err := rfb.ReadServerMessage(c.conn, binary.BigEndian, c.pixelFormat, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	u, ok := m.(*rfb.FramebufferUpdateMessage)
+	if !ok || len(u.Rectangles) != 1 {
+		c.t.Fatalf("expected a FramebufferUpdate with one rectangle, but got %v", m)
+	}
+	if rect := u.Rectangles[0]; rect.Bounds() != image.Rect(0, 0, 4, 3) {
+		c.t.Fatalf("expected the whole framebuffer, but got %v", rect.Bounds())
+	}
+	return u.Rectangles[0]
+}
+
+func TestServeConn(t *testing.T) {
+	d := &testDesktop{color: color.RGBA{0x10, 0x20, 0x30, 0xff}}
+	c := connect(t, func(conn net.Conn) error {
+		return ServeConn(context.Background(), conn, func() (Desktop, error) { return d, nil }, &Options{Name: "test"})
+	})
+	defer c.conn.Close()
+	if init := c.serverInit; init.FramebufferWidth != 4 || init.FramebufferHeight != 3 || init.Name != "test" {
+		t.Fatalf("expected a 4x3 desktop named test, but got %v", init)
+	}
+
+	if got, want := c.update(), (color.RGBA{0x10, 0x20, 0x30, 0xff}); got != want {
+		t.Errorf("expected %v, but got %v", want, got)
+	}
+	key := rfb.KeyEventMessage{Pressed: true, KeySym: 'a'}
+	pointer := rfb.PointerEventMessage{ButtonMask: 1, X: 2, Y: 1}
+	c.send(&key, &pointer, &rfb.ClientCutTextMessage{Text: "copied"})
+	if got, want := c.update(), (color.RGBA{0x11, 0x20, 0x30, 0xff}); got != want {
+		t.Errorf("expected %v after a key press, but got %v", want, got)
+	}
+	if !reflect.DeepEqual(d.keys, []rfb.KeyEventMessage{key}) || !reflect.DeepEqual(d.clicks, []rfb.PointerEventMessage{pointer}) || d.text != "copied" {
+		t.Errorf("expected the desktop to receive %v, %v, and \"copied\", but it got %v, %v, and %q", key, pointer, d.keys, d.clicks, d.text)
+	}
+
+	c.conn.Close()
+	var handshakeErr *HandshakeError
+	if err := <-c.done; err == nil || errors.As(err, &handshakeErr) {
+		t.Errorf("expected the session to fail once the client disconnected, but got %v", err)
+	}
+}
+
+func TestServeConnViewOnly(t *testing.T) {
+	d := &testDesktop{}
+	c := connect(t, func(conn net.Conn) error {
+		return ServeConn(context.Background(), conn, func() (Desktop, error) { return d, nil }, &Options{ViewOnly: true})
+	})
+	defer c.conn.Close()
+	c.send(&rfb.KeyEventMessage{Pressed: true, KeySym: 'a'}, &rfb.PointerEventMessage{ButtonMask: 1}, &rfb.ClientCutTextMessage{Text: "copied"})
+	c.update()
+	if len(d.keys) != 0 || len(d.clicks) != 0 || d.text != "" {
+		t.Errorf("expected a view-only client's input to be discarded, but the desktop got %v, %v, and %q", d.keys, d.clicks, d.text)
+	}
+}
+
+func TestServeConnColourMap(t *testing.T) {
+	d := &testDesktop{color: color.RGBA{0x10, 0x20, 0x30, 0xff}}
+	c := connect(t, func(conn net.Conn) error {
+		return ServeConn(context.Background(), conn, func() (Desktop, error) { return d, nil }, nil)
+	})
+	defer c.conn.Close()
+	c.pixelFormat = rfb.PixelFormat{BitsPerPixel: 8, BitDepth: 8}
+	c.send(&rfb.SetPixelFormatMessage{PixelFormat: c.pixelFormat})
+	m, err := rfb.ReadServerMessage(c.conn, binary.BigEndian, c.pixelFormat, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, ok := m.(*rfb.SetColourMapEntriesMessage); !ok || len(entries.Colours) != 256 {
+		t.Fatalf("expected SetColourMapEntries with 256 colours, but got %v", m)
+	}
+	colours := rfb.DefaultColourMap()
+	if got, want := c.update(), color.RGBAModel.Convert(colours.Colours[colours.Index(d.color)]); got != want {
+		t.Errorf("expected the nearest colour in the map, %v, but got %v", want, got)
+	}
+}
+
+type hextileEncoder struct{}
+
+func (hextileEncoder) EncodingType() uint32 { return rfb.EncodingTypeHextile }
+
+func (hextileEncoder) Encode(img *rfb.PixelFormatImage) ([]*rfb.FramebufferUpdateRect, error) {
+	r := img.Bounds()
+	return []*rfb.FramebufferUpdateRect{{
+		X: uint16(r.Min.X), Y: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+		EncodingType: rfb.EncodingTypeHextile, PixelData: rfb.EncodeHextile(img),
+	}}, nil
+}
+
+// unsuitableEncoder suits no rectangles.
+type unsuitableEncoder struct{ hextileEncoder }
+
+func (unsuitableEncoder) EncodingType() uint32                 { return rfb.EncodingTypeRRE }
+func (unsuitableEncoder) Suits(img *rfb.PixelFormatImage) bool { return false }
+
+func TestServeConnChoosesEncoding(t *testing.T) {
+	c := connect(t, func(conn net.Conn) error {
+		return ServeConn(context.Background(), conn, func() (Desktop, error) { return &testDesktop{}, nil }, &Options{
+			Encoders: map[uint32]func() extension.Encoder{
+				rfb.EncodingTypeHextile: func() extension.Encoder { return hextileEncoder{} },
+				rfb.EncodingTypeRRE:     func() extension.Encoder { return unsuitableEncoder{} },
+			},
+		})
+	})
+	defer c.conn.Close()
+	for _, test := range []struct {
+		encodingTypes []uint32
+		want          uint32
+	}{
+		{nil, rfb.EncodingTypeRaw},
+		{[]uint32{rfb.EncodingTypeHextile}, rfb.EncodingTypeHextile},
+		{[]uint32{rfb.EncodingTypeTight, rfb.EncodingTypeHextile}, rfb.EncodingTypeHextile},
+		{[]uint32{rfb.EncodingTypeRaw, rfb.EncodingTypeHextile}, rfb.EncodingTypeRaw},
+		{[]uint32{rfb.EncodingTypeRRE, rfb.EncodingTypeHextile}, rfb.EncodingTypeHextile},
+		{[]uint32{rfb.EncodingTypeRRE}, rfb.EncodingTypeRaw},
+	} {
+		c.send(&rfb.SetEncodingsMessage{EncodingTypes: test.encodingTypes})
+		if got := c.updateRect().EncodingType; got != test.want {
+			t.Errorf("with encodings %v, expected encoding type %d, but got %d", test.encodingTypes, test.want, got)
+		}
+	}
+}
+
+func TestServeConnDefersIncrementalUpdates(t *testing.T) {
+	d := &testDesktop{}
+	c := connect(t, func(conn net.Conn) error {
+		return ServeConn(context.Background(), conn, func() (Desktop, error) { return d, nil }, &Options{IncrementalTimeout: time.Hour})
+	})
+	defer c.conn.Close()
+	c.update()
+
+	updates := make(chan color.Color)
+	go func() {
+		m, err := rfb.ReadServerMessage(c.conn, binary.BigEndian, c.pixelFormat, nil)
+		if err != nil {
+			close(updates)
+			return
+		}
+		u := m.(*rfb.FramebufferUpdateMessage)
+		img, _ := u.Rectangles[0].Decode(c.pixelFormat, nil)
+		updates <- color.RGBAModel.Convert(img.At(0, 0))
+	}()
+	c.send(&rfb.FramebufferUpdateRequestMessage{Incremental: true, Width: 4, Height: 3})
+	select {
+	case got := <-updates:
+		t.Fatalf("expected the incremental update to wait for a change, but got %v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.send(&rfb.KeyEventMessage{Pressed: true, KeySym: 'a'})
+	if got, want := <-updates, (color.RGBA{0x01, 0, 0, 0xff}); got != want {
+		t.Errorf("expected %v after a key press, but got %v", want, got)
+	}
+}
+
+func TestServeConnIncrementalTimeout(t *testing.T) {
+	c := connect(t, func(conn net.Conn) error {
+		return ServeConn(context.Background(), conn, func() (Desktop, error) { return &testDesktop{}, nil }, &Options{IncrementalTimeout: 10 * time.Millisecond})
+	})
+	defer c.conn.Close()
+	c.update()
+	c.send(&rfb.FramebufferUpdateRequestMessage{Incremental: true, Width: 4, Height: 3})
+	m, err := rfb.ReadServerMessage(c.conn, binary.BigEndian, c.pixelFormat, nil)
+	if err != nil {
+		t.Fatalf("expected an update once the incremental request timed out, but got %v", err)
+	}
+	// Nothing changed, so nothing is resent.
+	if u, ok := m.(*rfb.FramebufferUpdateMessage); !ok || len(u.Rectangles) != 0 {
+		t.Errorf("expected an empty update for the idle request, but got %+v", m)
+	}
+}
+
+func TestServeConnIdleTimeout(t *testing.T) {
+	c := connect(t, func(conn net.Conn) error {
+		return ServeConn(context.Background(), conn, func() (Desktop, error) { return &testDesktop{}, nil }, &Options{IdleTimeout: 50 * time.Millisecond})
+	})
+	defer c.conn.Close()
+	// Messages keep the session alive past the timeout.
+	for i := 0; i < 3; i++ {
+		c.update()
+		time.Sleep(30 * time.Millisecond)
+	}
+	if err := <-c.done; !errors.Is(err, ErrIdle) {
+		t.Errorf("expected the idle client to be disconnected with ErrIdle, but got %v", err)
+	}
+}
+
+// damageDesktop only changes in the pixel at (1, 1).
+type damageDesktop struct{ testDesktop }
+
+func (d *damageDesktop) Damage() []image.Rectangle { return []image.Rectangle{image.Rect(1, 1, 2, 2)} }
+
+func TestServeConnSendsDamage(t *testing.T) {
+	c := connect(t, func(conn net.Conn) error {
+		return ServeConn(context.Background(), conn, func() (Desktop, error) { return &damageDesktop{}, nil }, nil)
+	})
+	defer c.conn.Close()
+	c.update()
+	c.send(&rfb.KeyEventMessage{Pressed: true, KeySym: 'a'}, &rfb.FramebufferUpdateRequestMessage{Incremental: true, Width: 4, Height: 3})
+	m, err := rfb.ReadServerMessage(c.conn, binary.BigEndian, c.pixelFormat, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := m.(*rfb.FramebufferUpdateMessage); !ok || len(u.Rectangles) != 1 || u.Rectangles[0].Bounds() != image.Rect(1, 1, 2, 2) {
+		t.Errorf("expected an update of just the damaged pixel, but got %v", m)
+	}
+}
+
+func TestServeConnMaxFPS(t *testing.T) {
+	c := connect(t, func(conn net.Conn) error {
+		return ServeConn(context.Background(), conn, func() (Desktop, error) { return &testDesktop{}, nil }, &Options{MaxFPS: 10})
+	})
+	defer c.conn.Close()
+	c.update()
+	start := time.Now()
+	c.update()
+	c.update()
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected two updates at 10 FPS to take at least 200ms, but they took %v", elapsed)
+	}
+}
+
+func TestServeConnContinuousUpdates(t *testing.T) {
+	c := connect(t, func(conn net.Conn) error {
+		return ServeConn(context.Background(), conn, func() (Desktop, error) { return &testDesktop{}, nil }, nil)
+	})
+	defer c.conn.Close()
+	c.send(&rfb.SetEncodingsMessage{EncodingTypes: []uint32{rfb.EncodingTypeContinuousUpdates}})
+	if m, err := rfb.ReadServerMessage(c.conn, binary.BigEndian, c.pixelFormat, nil); err != nil {
+		t.Fatal(err)
+	} else if _, ok := m.(*rfb.EndOfContinuousUpdatesMessage); !ok {
+		t.Fatalf("expected EndOfContinuousUpdates, but got %v", m)
+	}
+	c.update()
+
+	c.send(&rfb.EnableContinuousUpdatesMessage{Enable: true, Width: 4, Height: 3}, &rfb.KeyEventMessage{Pressed: true, KeySym: 'a'})
+	m, err := rfb.ReadServerMessage(c.conn, binary.BigEndian, c.pixelFormat, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*rfb.FramebufferUpdateMessage); !ok {
+		t.Errorf("expected a key press to push an update, but got %v", m)
+	}
+}
+
+func TestServerShared(t *testing.T) {
+	d := &testDesktop{color: color.RGBA{0x10, 0x20, 0x30, 0xff}}
+	srv := NewServer(d, nil)
+	serve := func(conn net.Conn) error { return srv.ServeConn(context.Background(), conn) }
+	a, b := connect(t, serve), connect(t, serve)
+	defer a.conn.Close()
+	defer b.conn.Close()
+
+	// b uses its own pixel format.
+	b.pixelFormat = rfb.PixelFormatRGB565
+	b.send(&rfb.SetPixelFormatMessage{PixelFormat: b.pixelFormat})
+	before := b.update()
+	a.send(&rfb.KeyEventMessage{Pressed: true, KeySym: 'a'})
+	if got, want := a.update(), (color.RGBA{0x11, 0x20, 0x30, 0xff}); got != want {
+		t.Errorf("expected %v after a key press, but got %v", want, got)
+	}
+	if after := b.update(); after == before {
+		t.Errorf("expected b to see a's key press, but it still sees %v", after)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	stats := &rfb.Stats{}
+	srv := NewServer(&testDesktop{}, &Options{Stats: stats})
+	serve := func(conn net.Conn) error { return srv.ServeConn(context.Background(), conn) }
+	a, b := connect(t, serve), connect(t, serve)
+	defer a.conn.Close()
+	defer b.conn.Close()
+	a.update()
+	b.update()
+
+	snapshot := stats.Snapshot()
+	if n := snapshot.Sent["FramebufferUpdate"].Count; n != 2 {
+		t.Errorf("expected both clients' updates to be counted, but got %d", n)
+	}
+	if n := snapshot.Received["FramebufferUpdateRequest"].Count; n != 2 {
+		t.Errorf("expected both clients' requests to be counted, but got %d", n)
+	}
+}
+
+func TestServerUpdate(t *testing.T) {
+	d := &testDesktop{}
+	srv := NewServer(d, &Options{IncrementalTimeout: time.Hour})
+	c := connect(t, func(conn net.Conn) error { return srv.ServeConn(context.Background(), conn) })
+	defer c.conn.Close()
+	c.update()
+
+	c.send(&rfb.FramebufferUpdateRequestMessage{Incremental: true, Width: 4, Height: 3})
+	srv.Update(func() { d.color = color.RGBA{0x40, 0x50, 0x60, 0xff} })
+	m, err := rfb.ReadServerMessage(c.conn, binary.BigEndian, c.pixelFormat, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := m.(*rfb.FramebufferUpdateMessage).Rectangles[0].Decode(c.pixelFormat, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := color.RGBAModel.Convert(img.At(0, 0)), (color.RGBA{0x40, 0x50, 0x60, 0xff}); got != want {
+		t.Errorf("expected the waiting request to be answered with %v, but got %v", want, got)
+	}
+}
+
+func TestServerSharePolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy             SharePolicy
+		displaced, refused bool
+	}{
+		{DisconnectOthers, true, false},
+		{RefuseExclusive, false, true},
+		{AlwaysShare, false, false},
+	} {
+		srv := NewServer(&testDesktop{}, &Options{SharePolicy: test.policy})
+		serve := func(conn net.Conn) error { return srv.ServeConn(context.Background(), conn) }
+		shared := connect(t, serve)
+		exclusive, err := dial(t, false, serve)
+		if refused := err != nil; refused != test.refused {
+			t.Errorf("with policy %d, expected refused to be %v, but the handshake returned %v", test.policy, test.refused, err)
+		}
+		if exclusive != nil {
+			exclusive.update()
+			exclusive.conn.Close()
+		}
+
+		if test.displaced {
+			if err := <-shared.done; !errors.Is(err, ErrDisplaced) {
+				t.Errorf("with policy %d, expected the shared client to be displaced, but its session ended with %v", test.policy, err)
+			}
+		} else {
+			shared.update()
+		}
+		shared.conn.Close()
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv := NewServer(&testDesktop{}, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+	c := connect(t, func(conn net.Conn) error { return srv.ServeConn(context.Background(), conn) })
+	defer c.conn.Close()
+	c.update()
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background(), "bye") }()
+	m, err := rfb.ReadServerMessage(c.conn, binary.BigEndian, c.pixelFormat, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cut, ok := m.(*rfb.ServerCutTextMessage); !ok || cut.Text != "bye" {
+		t.Errorf("expected the shutdown reason as ServerCutText, but got %v", m)
+	}
+	if err := <-c.done; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("expected the session to end with ErrServerClosed, but got %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("expected Shutdown to succeed, but got %v", err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("expected Serve to return ErrServerClosed, but got %v", err)
+	}
+	if _, err := dial(t, true, func(conn net.Conn) error { return srv.ServeConn(context.Background(), conn) }); err == nil {
+		t.Error("expected a client to be refused after Shutdown, but it connected")
+	}
+}
+
+func TestSubtractRect(t *testing.T) {
+	r := image.Rect(0, 0, 10, 10)
+	for _, test := range []struct {
+		hole image.Rectangle
+		area int
+	}{
+		{image.Rect(20, 20, 30, 30), 100},
+		{image.Rect(2, 2, 5, 5), 91},
+		{image.Rect(-5, -5, 5, 5), 75},
+		{r, 0},
+	} {
+		area := 0
+		for _, rect := range subtractRect(r, test.hole) {
+			if rect.Overlaps(test.hole) || !rect.In(r) {
+				t.Errorf("subtractRect(%v, %v) returned %v, which isn't outside the hole", r, test.hole, rect)
+			}
+			area += rect.Dx() * rect.Dy()
+		}
+		if area != test.area {
+			t.Errorf("subtractRect(%v, %v) covers %d pixels, but expected %d", r, test.hole, area, test.area)
+		}
+	}
+}
+
+func TestTakeDamage(t *testing.T) {
+	s := &session{wake: make(chan struct{}, 1)}
+	s.addDamage(image.Rect(0, 0, 2, 2), image.Rect(1, 1, 3, 3), image.Rect(8, 8, 9, 9))
+	if want := []image.Rectangle{image.Rect(0, 0, 3, 3), image.Rect(8, 8, 9, 9)}; !reflect.DeepEqual(s.damage, want) {
+		t.Errorf("expected overlapping damage to merge into %v, but got %v", want, s.damage)
+	}
+	screen := image.Rect(0, 0, 10, 10)
+	if got, want := s.takeDamage(image.Rect(0, 0, 5, 5), screen), []image.Rectangle{image.Rect(0, 0, 3, 3)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected to take %v, but got %v", want, got)
+	}
+	if got, want := s.takeDamage(screen, screen), []image.Rectangle{image.Rect(8, 8, 9, 9)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the damage outside the first region to be left, %v, but got %v", want, got)
+	}
+}